@@ -2,44 +2,90 @@ package app
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/health"
+	"github.com/ayo-ajayi/bloggy/logger"
 )
 
 type App struct {
-	server *http.Server
+	server          *http.Server
+	metrics         *http.Server
+	health          *health.Checker
+	drainDelay      time.Duration
+	shutdownTimeout time.Duration
+	log             *logger.Logger
 }
 
-func NewApp(addr string, handler http.Handler) *App {
+// NewApp serves handler on cfg.Addr. On shutdown readiness fails for
+// cfg.DrainDelay, giving load balancers time to notice, before the server
+// stops accepting connections.
+func NewApp(cfg config.ServerConfig, handler http.Handler, health *health.Checker, log *logger.Logger) *App {
 	return &App{
 		server: &http.Server{
-			Addr:    addr,
+			Addr:    cfg.Addr,
 			Handler: handler,
 		},
+		health:          health,
+		drainDelay:      cfg.DrainDelay,
+		shutdownTimeout: cfg.ShutdownTimeout,
+		log:             log,
+	}
+}
+
+// ServeMetrics serves handler on its own listener at addr alongside the main
+// server, so metrics can be bound to a private interface.
+func (a *App) ServeMetrics(addr string, handler http.Handler) {
+	a.metrics = &http.Server{
+		Addr:    addr,
+		Handler: handler,
 	}
 }
 
+// Start serves until SIGINT or SIGTERM, then fails readiness, waits for the
+// drain delay and shuts down, returning once in-flight requests finish.
 func (a *App) Start() {
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		stop := make(chan os.Signal, 1)
 		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 		<-stop
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		a.log.Info("draining before shutdown", "delay", a.drainDelay.String())
+		a.health.Drain()
+		time.Sleep(a.drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
 		defer cancel()
 		if err := a.server.Shutdown(ctx); err != nil {
-			log.Println("Server shutdown error: ", err)
+			a.log.Error("server shutdown error", "error", err)
 			return
 		}
-		log.Println("Server stopped gracefully")
+		if a.metrics != nil {
+			if err := a.metrics.Shutdown(ctx); err != nil {
+				a.log.Error("metrics server shutdown error", "error", err)
+			}
+		}
+		a.log.Info("server stopped gracefully")
 	}()
-	log.Println("Blog Server is running🎉🎉. Press Ctrl+C to stop")
-	if err := a.server.ListenAndServe(); err != nil {
-		log.Println("Server error: ", err)
+	if a.metrics != nil {
+		go func() {
+			a.log.Info("metrics server is running", "addr", a.metrics.Addr)
+			if err := a.metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				a.log.Error("metrics server error", "error", err)
+			}
+		}()
+	}
+	a.log.Info("Blog Server is running🎉🎉. Press Ctrl+C to stop", "addr", a.server.Addr)
+	if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		a.log.Error("server error", "error", err)
 		return
 	}
+	<-stopped
 }
@@ -0,0 +1,394 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/app"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+type testServer struct {
+	t        *testing.T
+	router   http.Handler
+	deps     *app.Deps
+	services *app.Services
+	clock    *clock.Manual
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.AccessTokenSecret = "test-secret"
+	clk := clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	deps := app.NewMemoryDeps(cfg, clk, nil)
+	return &testServer{
+		t:        t,
+		router:   app.New(cfg, deps),
+		deps:     deps,
+		services: app.NewServices(cfg, deps, nil),
+		clock:    clk,
+	}
+}
+
+// login creates a user with role and returns an access token for them.
+func (s *testServer) login(id string, role user.Role) string {
+	s.t.Helper()
+	ctx := context.Background()
+	u := &user.User{ID: id, Name: id, Email: id + "@example.com", Role: role, CreatedAt: s.clock.Now()}
+	if _, err := s.deps.Users.CreateUser(ctx, u); err != nil {
+		s.t.Fatal(err)
+	}
+	td, err := s.services.Users.GenerateAccessToken(id, false)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := s.services.Users.SaveAccessToken(ctx, id, td); err != nil {
+		s.t.Fatal(err)
+	}
+	return td.AccessToken
+}
+
+// do sends a request with an optional bearer token and JSON body and
+// decodes a JSON response into out when it is not nil.
+func (s *testServer) do(method, path, token string, body, out interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if out != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decoding %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+func (s *testServer) expect(w *httptest.ResponseRecorder, status int) {
+	s.t.Helper()
+	if w.Code != status {
+		s.t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+type post struct {
+	Id        string    `json:"id"`
+	Title     string    `json:"title"`
+	Slug      string    `json:"slug"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(s.do(http.MethodGet, "/healthz", "", nil, nil), http.StatusOK)
+	s.expect(s.do(http.MethodGet, "/readyz", "", nil, nil), http.StatusOK)
+}
+
+func TestUnknownRouteIsProblem(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do(http.MethodGet, "/no-such-route", "", nil, nil)
+	s.expect(w, http.StatusNotFound)
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want application/problem+json", ct)
+	}
+}
+
+func TestPostLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	author := s.login("author1", user.Author)
+	reader := s.login("reader1", user.Reader)
+	created := s.clock.Now()
+
+	s.expect(s.do(http.MethodPost, "/blog", reader, map[string]interface{}{
+		"title": "Nope", "description": "d", "content": "c",
+	}, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodPost, "/blog", author, map[string]interface{}{
+		"title": "Hello World", "description": "d", "content": "c", "tags": []string{"go"},
+	}, nil), http.StatusOK)
+
+	var got struct{ Data post }
+	s.expect(s.do(http.MethodGet, "/blog/slug/hello-world", "", nil, &got), http.StatusOK)
+	p := got.Data
+	if p.Title != "Hello World" || len(p.Tags) != 1 || !p.CreatedAt.Equal(created) {
+		t.Fatalf("created post = %+v, want title Hello World, tags [go], created at %v", p, created)
+	}
+
+	s.clock.Advance(time.Hour)
+	s.expect(s.do(http.MethodPut, "/blog/"+p.Id, author, map[string]interface{}{
+		"title": "Hello Again", "description": "d", "content": "c2", "tags": []string{},
+	}, nil), http.StatusOK)
+	var updated struct{ Data post }
+	s.expect(s.do(http.MethodGet, "/blog/"+p.Id, "", nil, &updated), http.StatusOK)
+	if u := updated.Data; u.Slug != "hello-again" || len(u.Tags) != 0 || !u.UpdatedAt.Equal(created.Add(time.Hour)) {
+		t.Errorf("updated post = %+v, want slug hello-again, no tags, updated an hour later", u)
+	}
+
+	s.expect(s.do(http.MethodPost, "/comment", reader, map[string]string{"blog_post_id": p.Id, "content": "nice"}, nil), http.StatusOK)
+	var comments struct {
+		Data []struct {
+			Content   string    `json:"content"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+	}
+	s.expect(s.do(http.MethodGet, "/comments/"+p.Id, "", nil, &comments), http.StatusOK)
+	if len(comments.Data) != 1 || comments.Data[0].Content != "nice" || !comments.Data[0].CreatedAt.Equal(s.clock.Now()) {
+		t.Errorf("comments = %+v, want one nice comment made now", comments.Data)
+	}
+
+	s.expect(s.do(http.MethodDelete, "/blog/"+p.Id, reader, nil, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, "/blog/"+p.Id, author, nil, nil), http.StatusOK)
+	s.expect(s.do(http.MethodGet, "/blog/"+p.Id, "", nil, nil), http.StatusNotFound)
+}
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestServer(t)
+	s.login("author1", user.Author)
+	_, key, err := s.services.Users.CreateAPIKey(context.Background(), "ci", "author1", []user.Scope{user.ScopePostsWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expect(s.do(http.MethodPost, "/blog", key, map[string]interface{}{
+		"title": "From CI", "description": "d", "content": "c",
+	}, nil), http.StatusOK)
+	s.expect(s.do(http.MethodGet, "/profile", key, nil, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodPost, "/comment", key, map[string]string{"blog_post_id": "x", "content": "c"}, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodPost, "/blog", "bgy_not-a-key", map[string]interface{}{
+		"title": "Nope", "description": "d", "content": "c",
+	}, nil), http.StatusUnauthorized)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	reader := s.login("reader1", user.Reader)
+	s.login("editor1", user.Editor)
+	key := func(scopes ...user.Scope) string {
+		_, k, err := s.services.Users.CreateAPIKey(ctx, "ci", "editor1", scopes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	posts, moderate := key(user.ScopePostsWrite), key(user.ScopeCommentsModerate)
+	body := map[string]interface{}{"title": "Scoped", "description": "d", "content": "c"}
+
+	// Allowed scope.
+	s.expect(s.do(http.MethodPost, "/blog", posts, body, nil), http.StatusOK)
+	// Missing scope.
+	s.expect(s.do(http.MethodPost, "/blog", moderate, body, nil), http.StatusForbidden)
+	// Session-only routes.
+	s.expect(s.do(http.MethodPut, "/authors/me", posts, map[string]string{"slug": "ci"}, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodPost, "/mfa/totp/enroll", posts, nil, nil), http.StatusForbidden)
+
+	var got struct{ Data post }
+	s.expect(s.do(http.MethodGet, "/blog/slug/scoped", "", nil, &got), http.StatusOK)
+	s.expect(s.do(http.MethodPost, "/comment", reader, map[string]string{"blog_post_id": got.Data.Id, "content": "hi"}, nil), http.StatusOK)
+	var comments struct {
+		Data []struct {
+			Id string `json:"id"`
+		}
+	}
+	s.expect(s.do(http.MethodGet, "/comments/"+got.Data.Id, "", nil, &comments), http.StatusOK)
+	if len(comments.Data) != 1 {
+		t.Fatalf("comments = %+v, want one", comments.Data)
+	}
+	id := comments.Data[0].Id
+	s.expect(s.do(http.MethodDelete, "/comment/"+id, moderate, nil, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, "/moderation/comment/"+id, posts, nil, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, "/moderation/comment/"+id, moderate, nil, nil), http.StatusOK)
+}
+
+func TestDeleteComment(t *testing.T) {
+	s := newTestServer(t)
+	author := s.login("author1", user.Author)
+	reader := s.login("reader1", user.Reader)
+	other := s.login("reader2", user.Reader)
+	s.expect(s.do(http.MethodPost, "/blog", author, map[string]interface{}{"title": "Post", "description": "d", "content": "c"}, nil), http.StatusOK)
+	var got struct{ Data post }
+	s.expect(s.do(http.MethodGet, "/blog/slug/post", "", nil, &got), http.StatusOK)
+	s.expect(s.do(http.MethodPost, "/comment", reader, map[string]string{"blog_post_id": got.Data.Id, "content": "hi"}, nil), http.StatusOK)
+	var comments struct {
+		Data []struct {
+			Id string `json:"id"`
+		}
+	}
+	s.expect(s.do(http.MethodGet, "/comments/"+got.Data.Id, "", nil, &comments), http.StatusOK)
+	id := comments.Data[0].Id
+
+	s.expect(s.do(http.MethodDelete, "/comment/"+id, "", nil, nil), http.StatusUnauthorized)
+	s.expect(s.do(http.MethodDelete, "/comment/"+id, other, nil, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, "/comment/"+id, reader, nil, nil), http.StatusOK)
+}
+
+// inbox keeps the last email sent to it.
+type inbox struct{ body string }
+
+func (i *inbox) SendMail(ctx context.Context, to, subject, body string) error {
+	i.body = body
+	return nil
+}
+
+func TestEmailLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(s.do(http.MethodPost, "/login/email", "", map[string]string{"email": "a@example.com"}, nil), http.StatusNotFound)
+
+	cfg := config.Default()
+	cfg.Auth.AccessTokenSecret = "test-secret"
+	cfg.Auth.EmailLoginSecret = "test-email-secret"
+	cfg.Auth.EmailLoginURL = "https://blog.example.com/login/email"
+	mail := &inbox{}
+	s.router = app.New(cfg, app.NewMemoryDeps(cfg, s.clock, mail))
+	s.expect(s.do(http.MethodPost, "/login/email", "", map[string]string{"email": "a@example.com"}, nil), http.StatusOK)
+	i := strings.Index(mail.body, "?token=")
+	if i < 0 {
+		t.Fatalf("no login link in %q", mail.body)
+	}
+	token := strings.Fields(mail.body[i+len("?token="):])[0]
+	var got struct {
+		Data struct {
+			AccessToken string `json:"access_token"`
+		}
+	}
+	s.expect(s.do(http.MethodGet, "/login/email/verify?token="+token, "", nil, &got), http.StatusOK)
+	if got.Data.AccessToken == "" {
+		t.Error("no access token after following the link")
+	}
+	s.expect(s.do(http.MethodGet, "/login/email/verify?token="+token, "", nil, nil), http.StatusUnauthorized)
+}
+
+func TestFrontendAlongsideAPI(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.AccessTokenSecret = "test-secret"
+	cfg.Site.Serve = true
+	s := newTestServer(t)
+	s.router = app.New(cfg, s.deps)
+	for path, want := range map[string]string{
+		"/site/":         "text/html; charset=utf-8",
+		"/site/missing/": "text/html; charset=utf-8",
+		"/blog":          "application/json",
+		"/missing":       "application/problem+json",
+	} {
+		w := s.do(http.MethodGet, path, "", nil, nil)
+		if ct := w.Header().Get("Content-Type"); ct != want {
+			t.Errorf("GET %s: Content-Type = %q, want %q", path, ct, want)
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 30; i++ {
+		if w := s.do(http.MethodGet, "/search?q=go", "", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("search %d: status %d", i, w.Code)
+		}
+	}
+	w := s.do(http.MethodGet, "/search?q=go", "", nil, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("31st search: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Signed-in users are counted on their own and admins not at all.
+	admin, reader := s.login("admin", user.Admin), s.login("reader", user.Reader)
+	for i := 0; i < 10; i++ {
+		if w := s.do(http.MethodPost, "/comment", reader, map[string]string{}, nil); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("comment %d was limited", i)
+		}
+	}
+	if w := s.do(http.MethodPost, "/comment", reader, map[string]string{}, nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("11th comment: status %d, want 429", w.Code)
+	}
+	for i := 0; i < 11; i++ {
+		if w := s.do(http.MethodPost, "/comment", admin, map[string]string{}, nil); w.Code == http.StatusTooManyRequests || w.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("admin comment %d was limited", i)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	s := newTestServer(t)
+	author, reader := s.login("author1", user.Author), s.login("reader1", user.Reader)
+	ids := map[string]string{}
+	for _, title := range []string{"Cats", "Dogs"} {
+		s.expect(s.do(http.MethodPost, "/blog", author, map[string]interface{}{
+			"title": title, "description": "d", "content": "c",
+		}, nil), http.StatusOK)
+		var got struct{ Data post }
+		s.expect(s.do(http.MethodGet, "/blog/slug/"+strings.ToLower(title), "", nil, &got), http.StatusOK)
+		ids[title] = got.Data.Id
+	}
+	paths := []string{"/blog", "/blog/" + ids["Cats"], "/blog/slug/cats", "/blog/" + ids["Dogs"], "/blog/slug/dogs"}
+	cacheState := func() map[string]string {
+		state := map[string]string{}
+		for _, path := range paths {
+			w := s.do(http.MethodGet, path, "", nil, nil)
+			s.expect(w, http.StatusOK)
+			state[path] = w.Header().Get("X-Cache")
+		}
+		return state
+	}
+	s.clock.Advance(time.Second)
+	cacheState()
+	for path, state := range cacheState() {
+		if state != "HIT" {
+			t.Errorf("%s: X-Cache %s on the second request", path, state)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/blog/"+ids["Cats"], nil)
+	req.Header.Set("If-None-Match", s.do(http.MethodGet, "/blog/"+ids["Cats"], "", nil, nil).Header().Get("ETag"))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status %d, want 304", w.Code)
+	}
+
+	// A like drops the post and the listings, not the other posts.
+	s.clock.Advance(time.Second)
+	s.expect(s.do(http.MethodPost, "/like-unlike-post", reader, map[string]string{"id": ids["Cats"], "option": "like"}, nil), http.StatusOK)
+	s.clock.Advance(time.Second)
+	want := map[string]string{
+		"/blog": "MISS", "/blog/" + ids["Cats"]: "MISS", "/blog/slug/cats": "MISS",
+		"/blog/" + ids["Dogs"]: "HIT", "/blog/slug/dogs": "HIT",
+	}
+	for path, state := range cacheState() {
+		if state != want[path] {
+			t.Errorf("after a like %s: X-Cache %s, want %s", path, state, want[path])
+		}
+	}
+
+	// Editing a post drops every post response.
+	s.expect(s.do(http.MethodPut, "/blog/"+ids["Dogs"], author, map[string]interface{}{
+		"title": "Dogs", "description": "d", "content": "c2",
+	}, nil), http.StatusOK)
+	s.clock.Advance(time.Second)
+	for path, state := range cacheState() {
+		if state != "MISS" {
+			t.Errorf("after an edit %s: X-Cache %s, want MISS", path, state)
+		}
+	}
+}
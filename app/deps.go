@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/cache"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/ayo-ajayi/bloggy/health"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/ayo-ajayi/bloggy/media"
+	"github.com/ayo-ajayi/bloggy/metrics"
+	"github.com/ayo-ajayi/bloggy/ratelimit"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/ayo-ajayi/bloggy/webhook"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type TokenManager interface {
+	user.TokenMgr
+	user.MiddlewareTokenManager
+	CountActiveTokens(ctx context.Context) (int64, error)
+}
+
+type APIKeyManager interface {
+	user.APIKeys
+	user.MiddlewareAPIKeyManager
+}
+
+// Deps are the collaborators New wires into the HTTP API. Logger, Metrics
+// and Health may be nil; Clock defaults to the wall clock, and RateLimits
+// and Cache to in-memory stores. EmailLogin is nil when email login is off.
+type Deps struct {
+	Posts      blog.BlogRepository
+	Users      user.UserRepository
+	Tokens     TokenManager
+	LoginCodes user.LoginCodes
+	EmailLogin user.EmailLogin
+	APIKeys    APIKeyManager
+	Media      media.MediaRepository
+	MediaStore media.MediaStore
+	RateLimits ratelimit.Store
+	Cache      cache.Store
+	Webhooks   webhook.WebhookRepository
+	Clock      clock.Clock
+	Logger     *logger.Logger
+	Metrics    *metrics.Metrics
+	Health     *health.Checker
+
+	// Database is the MongoDB database behind the repositories, or nil for
+	// in-memory dependencies.
+	Database *mongo.Database
+}
+
+// newEmailLogin returns the magic link manager, or nil when SMTP or the link
+// URL is not configured. Validate makes sure links then have their own secret.
+func newEmailLogin(cfg *config.Config, collection db.Collection, clk clock.Clock) user.EmailLogin {
+	if cfg.SMTP.Addr == "" || cfg.Auth.EmailLoginURL == "" {
+		return nil
+	}
+	mailer := user.NewSMTPMailer(cfg.SMTP.Addr, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From)
+	return user.NewEmailLoginManager(cfg.Auth.EmailLoginSecret, cfg.Auth.EmailLoginTTL, cfg.Auth.EmailLoginURL, collection, mailer, clk)
+}
+
+// newMediaStore returns the storage backend cfg selects.
+func newMediaStore(cfg *config.Config, clk clock.Clock) (media.MediaStore, error) {
+	switch backend := cfg.Media.MediaBackend(); backend {
+	case "local":
+		return media.NewLocalStore(cfg.Media.Dir, cfg.Media.PublicURL)
+	case "s3":
+		return media.NewS3Store(media.S3Config{
+			Endpoint:  cfg.Media.S3.Endpoint,
+			Region:    cfg.Media.S3.Region,
+			Bucket:    cfg.Media.S3.Bucket,
+			AccessKey: cfg.Media.S3.AccessKeyID,
+			SecretKey: cfg.Media.S3.SecretAccessKey,
+			PathStyle: cfg.Media.S3.PathStyle,
+			PublicURL: cfg.Media.S3.PublicURL,
+		}, clk)
+	case "cloudinary":
+		return media.NewCloudinaryStore(cfg.Media.CloudinaryURI, cfg.Media.Folder)
+	default:
+		return nil, fmt.Errorf("unknown media backend %q", backend)
+	}
+}
+
+// NewMongoDeps connects to MongoDB, sets up the media store and returns
+// production dependencies. It does not create indexes; see NewMigrator.
+func NewMongoDeps(ctx context.Context, cfg *config.Config, l *logger.Logger, m *metrics.Metrics, h *health.Checker) (*Deps, error) {
+	client, err := db.MongoClient(ctx, cfg.MongoDB.URI, m.CommandMonitor())
+	if err != nil {
+		return nil, err
+	}
+	clk := clock.System{}
+	mediaStore, err := newMediaStore(cfg, clk)
+	if err != nil {
+		return nil, err
+	}
+	database := client.Database(cfg.MongoDB.Database)
+	postCollection := database.Collection("posts")
+	commentCollection := database.Collection("comments")
+	tokenCollection := database.Collection("tokens")
+	loginCodeCollection := database.Collection("login_codes")
+	emailLoginCollection := database.Collection("email_logins")
+	apiKeyCollection := database.Collection("api_keys")
+	userCollection := database.Collection("users")
+	mediaCollection := database.Collection("media")
+	webhookCollection := database.Collection("webhooks")
+	deliveryCollection := database.Collection("webhook_deliveries")
+	var rateLimits ratelimit.Store = ratelimit.NewMemoryStore()
+	if strings.ToLower(cfg.RateLimit.Backend) == "mongodb" {
+		rateLimits = ratelimit.NewMongoStore(database.Collection("rate_limits"))
+	}
+	var responseCache cache.Store = cache.NewMemoryStore(cfg.Cache.TTL, cfg.Cache.MaxEntries, clk)
+	if strings.ToLower(cfg.Cache.Backend) == "mongodb" {
+		responseCache = cache.NewMongoStore(database.Collection("cache"), cfg.Cache.TTL, clk)
+	}
+	h.Add("mongodb", func(ctx context.Context) error { return client.Ping(ctx, nil) })
+
+	return &Deps{
+		Posts:      blog.NewBlogRepo(postCollection, commentCollection),
+		Users:      user.NewUserRepo(userCollection),
+		Tokens:     user.NewTokenManager(cfg.Auth.AccessTokenSecret, cfg.Auth.AccessTokenTTL, tokenCollection, clk),
+		LoginCodes: user.NewLoginCodeStore(loginCodeCollection, cfg.Auth.LoginCodeTTL, clk),
+		EmailLogin: newEmailLogin(cfg, emailLoginCollection, clk),
+		APIKeys:    user.NewAPIKeyManager(apiKeyCollection, clk),
+		Media:      media.NewMediaRepo(mediaCollection),
+		MediaStore: mediaStore,
+		RateLimits: rateLimits,
+		Cache:      responseCache,
+		Webhooks:   webhook.NewWebhookRepo(webhookCollection, deliveryCollection),
+		Clock:      clk,
+		Logger:     l,
+		Metrics:    m,
+		Health:     h,
+		Database:   database,
+	}, nil
+}
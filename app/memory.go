@@ -0,0 +1,39 @@
+package app
+
+import (
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/cache"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/ayo-ajayi/bloggy/media"
+	"github.com/ayo-ajayi/bloggy/ratelimit"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/ayo-ajayi/bloggy/webhook"
+)
+
+// NewMemoryDeps returns dependencies backed by in-memory collections and an
+// in-memory media store, for running the whole API under httptest without
+// MongoDB. mailer receives login emails; email login is off when it is nil.
+func NewMemoryDeps(cfg *config.Config, clk clock.Clock, mailer user.Mailer) *Deps {
+	clk = clock.OrSystem(clk)
+	database := db.NewMemoryDatabase()
+	var emailLogin user.EmailLogin
+	if mailer != nil {
+		emailLogin = user.NewEmailLoginManager(cfg.Auth.EmailLoginSecret, cfg.Auth.EmailLoginTTL, cfg.Auth.EmailLoginURL, database.Collection("email_logins"), mailer, clk)
+	}
+	return &Deps{
+		Posts:      blog.NewBlogRepo(database.Collection("posts"), database.Collection("comments")),
+		Users:      user.NewUserRepo(database.Collection("users")),
+		Tokens:     user.NewTokenManager(cfg.Auth.AccessTokenSecret, cfg.Auth.AccessTokenTTL, database.Collection("tokens"), clk),
+		LoginCodes: user.NewLoginCodeStore(database.Collection("login_codes"), cfg.Auth.LoginCodeTTL, clk),
+		EmailLogin: emailLogin,
+		APIKeys:    user.NewAPIKeyManager(database.Collection("api_keys"), clk),
+		Media:      media.NewMediaRepo(database.Collection("media")),
+		MediaStore: media.NewMemoryStore(cfg.Media.PublicURL),
+		RateLimits: ratelimit.NewMemoryStore(),
+		Cache:      cache.NewMemoryStore(cfg.Cache.TTL, cfg.Cache.MaxEntries, clk),
+		Webhooks:   webhook.NewWebhookRepo(database.Collection("webhooks"), database.Collection("webhook_deliveries")),
+		Clock:      clk,
+	}
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/cache"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/health"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/ayo-ajayi/bloggy/media"
+	"github.com/ayo-ajayi/bloggy/migrate"
+	"github.com/ayo-ajayi/bloggy/ratelimit"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/ayo-ajayi/bloggy/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migrations is the ordered schema history. Append new migrations with the
+// next version; never renumber or edit one that has shipped.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "posts_text_index",
+		Up:      createIndex("posts", blog.InitSearchIndex),
+		Down:    dropIndexes("posts", "text_index"),
+	},
+	{
+		Version: 2,
+		Name:    "users_author_slug_index",
+		Up:      createIndex("users", user.InitAuthorSlugIndex),
+		Down:    dropIndexes("users", "author_slug_index"),
+	},
+	{
+		Version: 3,
+		Name:    "api_keys_hash_index",
+		Up:      createIndex("api_keys", user.InitAPIKeyIndex),
+		Down:    dropIndexes("api_keys", "api_key_hash_index"),
+	},
+	{
+		Version: 4,
+		Name:    "token_expiry_indexes",
+		Up: createIndex("tokens", user.InitTokenExpiryIndex).
+			then(createIndex("login_codes", user.InitTokenExpiryIndex)).
+			then(createIndex("email_logins", user.InitTokenExpiryIndex)),
+		Down: dropIndexes("tokens", "expires_at_1").
+			then(dropIndexes("login_codes", "expires_at_1")).
+			then(dropIndexes("email_logins", "expires_at_1")),
+	},
+	{
+		Version: 5,
+		Name:    "media_indexes",
+		Up:      createIndex("media", media.InitMediaIndexes),
+		Down:    dropIndexes("media", "media_key_index", "media_url_index", "media_references_index"),
+	},
+	{
+		Version: 6,
+		Name:    "rate_limits_expiry_index",
+		Up:      createIndex("rate_limits", ratelimit.InitExpiryIndex),
+		Down:    dropIndexes("rate_limits", "expires_at_1"),
+	},
+	{
+		Version: 7,
+		Name:    "cache_indexes",
+		Up:      createIndex("cache", cache.InitCacheIndexes),
+		Down:    dropIndexes("cache", "expires_at_1", "tags_1"),
+	},
+	{
+		Version: 8,
+		Name:    "webhook_delivery_indexes",
+		Up:      createIndex("webhook_deliveries", webhook.InitDeliveryIndexes),
+		Down:    dropIndexes("webhook_deliveries", "delivery_subscription_index", "delivery_due_index"),
+	},
+}
+
+type step func(ctx context.Context, database *mongo.Database) error
+
+func (s step) then(next step) step {
+	return func(ctx context.Context, database *mongo.Database) error {
+		if err := s(ctx, database); err != nil {
+			return err
+		}
+		return next(ctx, database)
+	}
+}
+
+func createIndex(collection string, create func(context.Context, *mongo.Collection) error) step {
+	return func(ctx context.Context, database *mongo.Database) error {
+		return create(ctx, database.Collection(collection))
+	}
+}
+
+func dropIndexes(collection string, names ...string) step {
+	return func(ctx context.Context, database *mongo.Database) error {
+		for _, name := range names {
+			if err := dropIndex(ctx, database.Collection(collection), name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// NewMigrator returns a Migrator over the application's migrations.
+func NewMigrator(database *mongo.Database, clk clock.Clock) *migrate.Migrator {
+	return migrate.New(database, Migrations, migrate.DefaultLockTTL, clk)
+}
+
+// RebuildSearchIndex drops and recreates the full-text index on posts.
+func RebuildSearchIndex(ctx context.Context, database *mongo.Database) error {
+	posts := database.Collection("posts")
+	if err := dropIndex(ctx, posts, "text_index"); err != nil {
+		return err
+	}
+	return blog.InitSearchIndex(ctx, posts)
+}
+
+func indexNames(ctx context.Context, collection *mongo.Collection) (map[string]bool, error) {
+	cur, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var specs []bson.M
+	if err := cur.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if name, ok := spec["name"].(string); ok {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	names, err := indexNames(ctx, collection)
+	if err != nil || !names[name] {
+		return err
+	}
+	_, err = collection.Indexes().DropOne(ctx, name)
+	return err
+}
+
+// MigrateInBackground applies pending migrations without blocking startup,
+// so the liveness probe answers meanwhile; the "migrations" readiness check
+// fails until they are done. When another replica holds the lock it waits
+// for that replica to finish rather than failing.
+func MigrateInBackground(migrator *migrate.Migrator, l *logger.Logger, h *health.Checker) {
+	status := &health.Status{}
+	h.Add("migrations", status.Check)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), migrate.DefaultLockTTL)
+		defer cancel()
+		for {
+			applied, err := migrator.Up(ctx, 0)
+			for _, m := range applied {
+				l.Info("migration applied", "migration", m.String())
+			}
+			if errors.Is(err, migrate.ErrLocked) {
+				l.Info("waiting for migrations running elsewhere", "error", err)
+				select {
+				case <-time.After(5 * time.Second):
+					continue
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			}
+			if err != nil {
+				l.Error("migrations failed", "error", err)
+			}
+			status.Done(err)
+			return
+		}
+	}()
+}
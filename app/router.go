@@ -2,79 +2,357 @@ package app
 
 import (
 	"context"
-	"log"
-	"os"
+	"log/slog"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/backup"
 	"github.com/ayo-ajayi/bloggy/blog"
-	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/ayo-ajayi/bloggy/cache"
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/health"
+	"github.com/ayo-ajayi/bloggy/importer"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/ayo-ajayi/bloggy/media"
+	"github.com/ayo-ajayi/bloggy/metrics"
+	"github.com/ayo-ajayi/bloggy/ratelimit"
+	"github.com/ayo-ajayi/bloggy/site"
+	"github.com/ayo-ajayi/bloggy/theme"
 	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/ayo-ajayi/bloggy/web"
+	"github.com/ayo-ajayi/bloggy/webhook"
 	"github.com/gin-gonic/gin"
-	cors "github.com/rs/cors/wrapper/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func BlogRouter() *gin.Engine {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	client, err := db.MongoClient(ctx, os.Getenv("MONGODB_URI"))
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	postCollection := client.Database("bloggy").Collection("posts")
-	commentCollection := client.Database("bloggy").Collection("comments")
-	tokenCollection := client.Database("bloggy").Collection("tokens")
-	accessTokenSecret := os.Getenv("ACCESS_TOKEN_SECRET")
-	accessTokenValidaityInHours := int64(24)
-	tokenManager := user.NewTokenManager(accessTokenSecret, accessTokenValidaityInHours, tokenCollection)
-	blogController := blog.NewBlogController(blog.NewBlogService(blog.NewBlogRepo(postCollection, commentCollection)))
-	userRepo := user.NewUserRepo(client.Database("bloggy").Collection("users"))
-	cloudinary, err := user.NewMediaCloudManager(os.Getenv("CLOUDINARY_URI"), "bloggy")
-	if err != nil {
-		log.Fatal(err.Error())
+type Services struct {
+	Users    *user.UserService
+	Blog     *blog.BlogService
+	Backup   *backup.BackupService
+	Importer *importer.Importer
+	Static   *site.Exporter
+	Media    *media.MediaService
+	// Cache is nil when response caching is off, and Webhooks when
+	// webhooks are.
+	Cache    *cache.Cache
+	Webhooks *webhook.WebhookService
+}
+
+// NewServices builds the services on top of deps; the HTTP API and the CLI
+// share them. recorder may be nil.
+func NewServices(cfg *config.Config, deps *Deps, recorder interface {
+	user.Recorder
+	blog.Recorder
+}) *Services {
+	var responseCache *cache.Cache
+	var invalidator blog.Invalidator
+	if cfg.Cache.Enabled {
+		store := deps.Cache
+		if store == nil {
+			store = cache.NewMemoryStore(cfg.Cache.TTL, cfg.Cache.MaxEntries, deps.Clock)
+		}
+		responseCache = cache.New(store, cfg.Cache.MaxAge, deps.Logger, deps.Clock)
+		invalidator = responseCache
+	}
+	var webhooks *webhook.WebhookService
+	var notifier blog.Notifier
+	if cfg.Webhooks.Enabled && deps.Webhooks != nil {
+		webhooks = webhook.NewWebhookService(deps.Webhooks, NewWebhookDispatcher(cfg, deps), append(blog.Events, user.Events...), deps.Logger, deps.Clock)
+		notifier = webhooks
+	}
+	users := user.NewUserService(deps.Users, deps.Tokens, deps.LoginCodes, deps.EmailLogin, deps.APIKeys, recorder, invalidator, notifier, user.ServiceConfig{
+		GoogleClientID:             cfg.Google.ClientID,
+		GoogleClientSecret:         cfg.Google.ClientSecret,
+		GoogleRedirectURL:          cfg.Google.RedirectURL,
+		SessionSecret:              cfg.Auth.SessionSecret,
+		AdminEmail:                 cfg.Auth.AdminEmail,
+		PostLoginRedirectURL:       cfg.Auth.PostLoginRedirectURL,
+		PostLoginRedirectAllowlist: cfg.Auth.PostLoginRedirectAllowlist,
+		CookieAuth:                 cfg.Security.CookieAuth,
+		Clock:                      deps.Clock,
+	})
+	mediaService := media.NewMediaService(deps.Media, deps.MediaStore, media.Policy{
+		MaxSize:      int64(cfg.Media.MaxUploadMB) << 20,
+		AllowedTypes: cfg.Media.AllowedTypes,
+	}, deps.Clock)
+	blogService := blog.NewBlogService(deps.Posts, users, recorder, mediaService, invalidator, notifier, deps.Clock)
+	return &Services{
+		Users:    users,
+		Blog:     blogService,
+		Backup:   backup.NewBackupService(deps.Posts, deps.Users, mediaService, deps.Clock),
+		Importer: importer.NewImporter(deps.Posts, deps.Users, mediaService, deps.Clock),
+		Static:   site.NewExporter(blogService, SiteInfo(cfg), cfg.Site.Theme, cfg.Site.ThemeDir, deps.Clock),
+		Media:    mediaService,
+		Cache:    responseCache,
+		Webhooks: webhooks,
+	}
+}
+
+// NewWebhookDispatcher returns the dispatcher that sends webhook
+// deliveries from deps.Webhooks. Its Run retries failed deliveries and
+// should be running on at least one replica.
+func NewWebhookDispatcher(cfg *config.Config, deps *Deps) *webhook.Dispatcher {
+	allowed, err := webhook.ParseNetworks(cfg.Webhooks.AllowedNetworks)
+	if err != nil && deps.Logger != nil {
+		deps.Logger.Error("ignoring webhook allowed networks", "error", err)
+	}
+	return webhook.NewDispatcher(deps.Webhooks, webhook.Options{
+		Timeout:         cfg.Webhooks.Timeout,
+		MaxAttempts:     cfg.Webhooks.MaxAttempts,
+		RetryBackoff:    cfg.Webhooks.RetryBackoff,
+		PollInterval:    cfg.Webhooks.PollInterval,
+		AllowedNetworks: allowed,
+	}, deps.Logger, deps.Clock)
+}
+
+// PurgeCache drops every cached response. Restores and imports write
+// through the repositories, so they call it when they are done.
+func (s *Services) PurgeCache(ctx context.Context) {
+	if s.Cache != nil {
+		s.Cache.Purge(ctx)
+	}
+}
+
+// SiteInfo is the blog-wide information themes render.
+func SiteInfo(cfg *config.Config) theme.Site {
+	return theme.Site{Title: cfg.Site.Title, Description: cfg.Site.Description, BaseURL: cfg.Site.BaseURL}
+}
+
+// New builds the HTTP API on top of deps. It does no I/O of its own, so it
+// can be used with NewMemoryDeps in tests.
+func New(cfg *config.Config, deps *Deps) *gin.Engine {
+	l, m, h := deps.Logger, deps.Metrics, deps.Health
+	if l == nil {
+		l = &logger.Logger{Logger: slog.Default()}
+	}
+	if m == nil {
+		m = metrics.New()
 	}
-	userController := user.NewUserController(user.NewUserService(userRepo, tokenManager), cloudinary)
-	middleware := user.NewMiddleware(accessTokenSecret, userRepo, tokenManager)
-	if err := blog.InitSearchIndex(ctx, postCollection); err != nil {
-		log.Fatal(err.Error())
+	if h == nil {
+		h = health.New(2 * time.Second)
 	}
-	if err := user.InitTokenExpiryIndex(ctx, tokenCollection); err != nil {
-		log.Fatal(err.Error())
+	services := NewServices(cfg, deps, m)
+	userController := user.NewUserController(services.Users, services.Media)
+	blogController := blog.NewBlogController(services.Blog)
+	backupController := backup.NewBackupController(services.Backup)
+	staticController := site.NewStaticController(services.Static, deps.Clock)
+	mediaController := media.NewMediaController(services.Media)
+	middleware := user.NewMiddleware(cfg.Auth.AccessTokenSecret, deps.Users, deps.Tokens, deps.APIKeys, cfg.Auth.AdminMFARequired, cfg.Security.CookieAuth, deps.Clock)
+	if pinger, ok := deps.MediaStore.(interface{ Ping(context.Context) error }); ok {
+		h.Add("media", pinger.Ping)
 	}
-	r := gin.Default()
-	r.Use(func(c *gin.Context) {
+	m.RegisterActiveSessions(deps.Tokens.CountActiveTokens)
+	limit := newRateLimits(cfg, deps, l)
+	cached := func(tags func(c *gin.Context) []string) gin.HandlerFunc {
+		if services.Cache == nil {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return services.Cache.Handler(tags)
+	}
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		l.Error("ignoring trusted proxies", "error", err)
+	}
+	r.Use(logger.Middleware(l), m.Middleware(), gin.Recovery(), apperrors.ErrorHandler(), securityHeaders(cfg), newCORS(cfg))
+	// api holds the JSON routes; downloads, media and the HTML frontend set
+	// their own content types.
+	api := r.Group("", func(c *gin.Context) {
 		c.Header("Content-Type", "application/json")
 		c.Next()
-	}, cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-	}))
-
-	r.NoRoute(func(ctx *gin.Context) { ctx.JSON(404, gin.H{"error": "endpoint not found"}) })
-	r.GET("/", func(ctx *gin.Context) { ctx.JSON(200, gin.H{"message": "welcome to bloggy"}) })
-	r.POST("/blog", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), blogController.CreateBlogPost)
-	r.GET("/blog", blogController.GetBlogPosts)
-	r.GET("/blog/:id", blogController.GetBlogPostByID)
-	r.GET("/blog/slug/:slug", blogController.GetBlogPostBySlug)
-	r.PUT("/blog/:id", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), blogController.UpdateBlogPost)
-	r.DELETE("/blog/:id", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), blogController.DeleteBlogPost)
-	r.GET("/search", blogController.Search)
-	r.GET("/login", userController.Login)
-	r.GET("/callback", userController.Callback)
-	r.GET("/profile", middleware.Authentication(), userController.Profile)
-	r.GET("/users", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.GetUsers)
-	r.DELETE("/logout", middleware.Authentication(), userController.Logout)
-	r.POST("/like-unlike-post", middleware.Authentication(), blogController.LikeOrUnlikePost)
-	r.POST("/like-unlike-comment", middleware.Authentication(), blogController.LikeOrUnlikeComment)
-	r.POST("/comment", middleware.Authentication(), blogController.PostComment)
-	r.PUT("/comment/:id", middleware.Authentication(), blogController.UpdateComment)
-	r.GET("/comments/:postId", blogController.GetComments)
-	r.GET("/comment/:id", blogController.GetComment)
-	r.PUT("/about", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.UpdateAboutMe)
-	r.GET("/about", userController.GetAboutMe)
-	r.POST("/subscribe", middleware.Authentication(), userController.SubscribeToMailingList)
-	r.DELETE("/unsubscribe", middleware.Authentication(), userController.UnSubscribeFromMailingList)
-	r.GET("/mailing-list", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.GetMailingList)
+	})
+
+	frontend := newFrontend(cfg, services, l)
+	r.NoRoute(func(ctx *gin.Context) {
+		if frontend != nil && underPath(ctx.Request.URL.Path, cfg.Site.Path) {
+			frontend.NotFound(ctx)
+			return
+		}
+		ctx.Error(apperrors.NotFound(apperrors.CodeNotFound, "endpoint not found", nil))
+	})
+	// Without a dedicated METRICS_ADDR listener, /metrics is only served on
+	// the public router when a METRICS_TOKEN protects it.
+	if cfg.Metrics.Token != "" && cfg.Metrics.Addr == "" {
+		r.GET("/metrics", gin.WrapH(m.Handler(cfg.Metrics.Token)))
+	}
+	api.GET("/healthz", h.Liveness())
+	api.GET("/readyz", h.Readiness())
+	api.GET("/", func(ctx *gin.Context) { ctx.JSON(200, gin.H{"message": "welcome to bloggy"}) })
+	api.POST("/blog", middleware.Authentication(), middleware.RequirePermission(user.PermCreatePost), blogController.CreateBlogPost)
+	api.GET("/blog", cached(listTags), blogController.GetBlogPosts)
+	api.GET("/blog/:id", cached(postByIDTags), blogController.GetBlogPostByID)
+	api.GET("/blog/slug/:slug", cached(postBySlugTags), blogController.GetBlogPostBySlug)
+	api.PUT("/blog/:id", middleware.Authentication(), middleware.RequirePermission(user.PermEditOwnPost, user.PermEditAnyPost), blogController.UpdateBlogPost)
+	api.DELETE("/blog/:id", middleware.Authentication(), middleware.RequirePermission(user.PermDeleteOwnPost, user.PermDeleteAnyPost), blogController.DeleteBlogPost)
+	api.GET("/search", limit("search"), blogController.Search)
+	api.POST("/media", middleware.Authentication(), middleware.RequirePermission(user.PermCreatePost), limit("upload"), mediaController.Upload)
+	api.GET("/media", middleware.Authentication(), middleware.RequirePermission(user.PermCreatePost), mediaController.List)
+	r.GET("/media/:id", limit("render"), mediaController.Render)
+	api.DELETE("/media/:id", middleware.Authentication(), middleware.RequirePermission(user.PermDeleteOwnPost, user.PermDeleteAnyPost), mediaController.Delete)
+	api.GET("/authors", blogController.GetAuthors)
+	api.GET("/authors/:slug", blogController.GetAuthorBySlug)
+	api.PUT("/authors/me", middleware.Authentication(), middleware.RequireSession(), middleware.RequirePermission(user.PermCreatePost), userController.UpdateAuthorProfile)
+	api.GET("/login", userController.Login)
+	api.GET("/callback", userController.Callback)
+	api.POST("/login/exchange", userController.ExchangeLoginCode)
+	if deps.EmailLogin != nil {
+		api.POST("/login/email", userController.RequestEmailLogin)
+		api.GET("/login/email/verify", userController.VerifyEmailLogin)
+	}
+	api.GET("/profile", middleware.Authentication(), middleware.RequireSession(), userController.Profile)
+	api.POST("/mfa/totp/enroll", middleware.Authentication(), middleware.RequireSession(), userController.EnrollTOTP)
+	api.POST("/mfa/totp/confirm", middleware.Authentication(), middleware.RequireSession(), userController.ConfirmTOTP)
+	api.DELETE("/mfa/totp", middleware.Authentication(), middleware.RequireSession(), userController.DisableTOTP)
+	api.POST("/mfa/verify", middleware.Authentication(), middleware.RequireSession(), userController.StepUpMFA)
+	api.GET("/users", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.GetUsers)
+	api.PUT("/users/:id/role", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.AssignRole)
+	api.POST("/api-keys", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.CreateAPIKey)
+	api.GET("/api-keys", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.ListAPIKeys)
+	api.DELETE("/api-keys/:id", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.RevokeAPIKey)
+	api.GET("/roles", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.GetRoles)
+	api.DELETE("/logout", middleware.Authentication(), middleware.RequireSession(), userController.Logout)
+	api.POST("/like-unlike-post", middleware.Authentication(), middleware.RequireSession(), limit("like"), blogController.LikeOrUnlikePost)
+	api.POST("/like-unlike-comment", middleware.Authentication(), middleware.RequireSession(), limit("like"), blogController.LikeOrUnlikeComment)
+	api.POST("/comment", middleware.Authentication(), middleware.RequireSession(), limit("comment"), blogController.PostComment)
+	api.PUT("/comment/:id", middleware.Authentication(), middleware.RequireSession(), blogController.UpdateComment)
+	api.DELETE("/comment/:id", middleware.Authentication(), middleware.RequireSession(), middleware.LoadRole(), blogController.DeleteComment)
+	api.DELETE("/moderation/comment/:id", middleware.Authentication(), middleware.RequirePermission(user.PermModerateComments), blogController.DeleteComment)
+	api.GET("/comments/:postId", cached(commentTags), blogController.GetComments)
+	api.GET("/comment/:id", blogController.GetComment)
+	api.PUT("/about", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.UpdateAboutMe)
+	api.GET("/about", cached(cache.Tags(user.TagAbout)), userController.GetAboutMe)
+	api.POST("/subscribe", middleware.Authentication(), middleware.RequireSession(), userController.SubscribeToMailingList)
+	api.DELETE("/unsubscribe", middleware.Authentication(), middleware.RequireSession(), userController.UnSubscribeFromMailingList)
+	api.GET("/mailing-list", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), userController.GetMailingList)
+	r.GET("/admin/backup", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), backupController.Backup)
+	api.POST("/admin/restore", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), func(c *gin.Context) {
+		c.Next()
+		services.PurgeCache(c)
+	}, backupController.Restore)
+	r.POST("/admin/static-export", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}), staticController.Export)
+	if services.Webhooks != nil {
+		webhookController := webhook.NewWebhookController(services.Webhooks)
+		hooks := api.Group("/webhooks", middleware.Authentication(), middleware.Authorization([]user.Role{user.Admin}))
+		hooks.POST("", webhookController.Create)
+		hooks.GET("", webhookController.List)
+		hooks.GET("/:id", webhookController.Get)
+		hooks.PUT("/:id", webhookController.Update)
+		hooks.DELETE("/:id", webhookController.Delete)
+		hooks.POST("/:id/ping", webhookController.Ping)
+		hooks.GET("/:id/deliveries", webhookController.Deliveries)
+		hooks.POST("/:id/deliveries/:deliveryId/redeliver", webhookController.Redeliver)
+	}
+	if cfg.Media.MediaBackend() == "local" {
+		r.GET(mediaPath(cfg.Media.PublicURL)+"/*key", mediaController.Serve)
+	}
+	if frontend != nil {
+		html := r.Group(cfg.Site.Path)
+		html.GET("/", frontend.Home)
+		html.GET("/page/:n/", frontend.Home)
+		html.GET("/posts/:slug/", frontend.Post)
+		html.GET("/tags/:tag/", frontend.Tag)
+		html.GET("/tags/:tag/page/:n/", frontend.Tag)
+		html.GET("/authors/:slug/", frontend.Author)
+		html.GET("/authors/:slug/page/:n/", frontend.Author)
+		html.GET("/search/", limit("search"), frontend.Search)
+		html.GET("/about/", frontend.About)
+		html.GET("/assets/*file", frontend.Asset)
+		html.GET("/feed.xml", frontend.RSS)
+		html.GET("/atom.xml", frontend.Atom)
+		html.GET("/sitemap.xml", frontend.Sitemap)
+	}
 	return r
 }
+
+// newFrontend builds the HTML frontend when cfg.Site.Serve is set. A theme
+// that fails to load is logged and leaves the API running without it.
+func newFrontend(cfg *config.Config, services *Services, l *logger.Logger) *web.FrontendController {
+	if !cfg.Site.Serve {
+		return nil
+	}
+	th, err := theme.Load(cfg.Site.Theme, cfg.Site.ThemeDir)
+	if err != nil {
+		l.Error("HTML frontend disabled", "error", err)
+		return nil
+	}
+	return web.NewFrontendController(services.Blog, services.Users, th, SiteInfo(cfg), cfg.Site.Path)
+}
+
+func listTags(c *gin.Context) []string {
+	return []string{blog.TagPosts, blog.TagPostLists, user.TagAuthors}
+}
+
+func postByIDTags(c *gin.Context) []string {
+	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
+	return []string{blog.TagPosts, blog.PostTag(id), user.TagAuthors}
+}
+
+func postBySlugTags(c *gin.Context) []string {
+	return []string{blog.TagPosts, blog.PostSlugTag(c.Param("slug")), user.TagAuthors}
+}
+
+func commentTags(c *gin.Context) []string {
+	id, _ := primitive.ObjectIDFromHex(c.Param("postId"))
+	return []string{blog.CommentsTag(id)}
+}
+
+// newRateLimits returns the middleware enforcing the named rate limit
+// policy. Policies that are not configured, or all of them when rate
+// limiting is off, let every request through.
+func newRateLimits(cfg *config.Config, deps *Deps, l *logger.Logger) func(policy string) gin.HandlerFunc {
+	pass := func(c *gin.Context) { c.Next() }
+	if !cfg.RateLimit.Enabled {
+		return func(string) gin.HandlerFunc { return pass }
+	}
+	rates, err := cfg.RateLimit.Rates()
+	if err != nil {
+		l.Error("rate limiting disabled", "error", err)
+		return func(string) gin.HandlerFunc { return pass }
+	}
+	store := deps.RateLimits
+	if store == nil {
+		store = ratelimit.NewMemoryStore()
+	}
+	var exempt func(c *gin.Context) bool
+	if cfg.RateLimit.ExemptAdmins {
+		exempt = func(c *gin.Context) bool {
+			if role, ok := c.Get("role"); ok {
+				return role == user.Admin
+			}
+			id := c.GetString("user_id")
+			if id == "" {
+				return false
+			}
+			u, err := deps.Users.GetUser(c, bson.M{"_id": id})
+			return err == nil && u.Role == user.Admin
+		}
+	}
+	limiter, err := ratelimit.NewLimiter(store, cfg.RateLimit.Allowlist, exempt, l, deps.Clock)
+	if err != nil {
+		l.Error("rate limiting disabled", "error", err)
+		return func(string) gin.HandlerFunc { return pass }
+	}
+	return func(policy string) gin.HandlerFunc {
+		rate, ok := rates[policy]
+		if !ok {
+			return pass
+		}
+		return limiter.Limit(ratelimit.Policy{Name: policy, Limit: rate.Limit, Period: rate.Period})
+	}
+}
+
+// mediaPath is the route local uploads are served under; publicURL may be
+// a path or an absolute URL.
+func mediaPath(publicURL string) string {
+	if u, err := url.Parse(publicURL); err == nil {
+		publicURL = u.Path
+	}
+	return "/" + strings.Trim(publicURL, "/")
+}
+
+func underPath(p, prefix string) bool {
+	prefix = strings.TrimRight(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/gin-gonic/gin"
+	cors "github.com/rs/cors/wrapper/gin"
+)
+
+// apiContentSecurityPolicy is sent with everything but HTML pages: JSON
+// never needs to load resources or be framed.
+const apiContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+
+// securityHeaders sets the headers browsers use to contain a response.
+// Handlers may override them, as media downloads do.
+func securityHeaders(cfg *config.Config) gin.HandlerFunc {
+	var hsts string
+	if cfg.Security.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int(cfg.Security.HSTSMaxAge.Seconds()))
+	}
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		if cfg.Security.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", cfg.Security.ReferrerPolicy)
+		}
+		if hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		csp := apiContentSecurityPolicy
+		if cfg.Site.Serve && underPath(c.Request.URL.Path, cfg.Site.Path) {
+			csp = cfg.Security.ContentSecurityPolicy
+		}
+		if csp != "" {
+			h.Set("Content-Security-Policy", csp)
+		}
+		c.Next()
+	}
+}
+
+// newCORS allows the configured origins. Credentials are only allowed with
+// an explicit list, never with "*".
+func newCORS(cfg *config.Config) gin.HandlerFunc {
+	origins := cfg.Security.CORSAllowedOrigins
+	return cors.New(cors.Options{
+		AllowedOrigins:   origins,
+		AllowCredentials: len(origins) > 0 && !slices.Contains(origins, "*"),
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"*"},
+		ExposedHeaders: []string{
+			logger.RequestIDHeader, "ETag", "Retry-After",
+			"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+		},
+	})
+}
@@ -0,0 +1,141 @@
+package app_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/app"
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+func testConfig(change func(cfg *config.Config)) *config.Config {
+	cfg := config.Default()
+	cfg.Auth.AccessTokenSecret = "test-secret"
+	change(cfg)
+	return cfg
+}
+
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	s := newTestServer(t)
+	s.router = app.New(testConfig(func(cfg *config.Config) { cfg.Site.Serve = true }), s.deps)
+	api := serve(s.router, httptest.NewRequest(http.MethodGet, "/blog", nil))
+	for h, want := range map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Strict-Transport-Security": "max-age=31536000",
+		"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+	} {
+		if got := api.Header().Get(h); got != want {
+			t.Errorf("API %s = %q, want %q", h, got, want)
+		}
+	}
+	page := serve(s.router, httptest.NewRequest(http.MethodGet, "/site/", nil))
+	if got := page.Header().Get("Content-Security-Policy"); got != config.Default().Security.ContentSecurityPolicy {
+		t.Errorf("page Content-Security-Policy = %q, want the configured one", got)
+	}
+
+	s.router = app.New(testConfig(func(cfg *config.Config) { cfg.Security.HSTSMaxAge = 0 }), s.deps)
+	if got := serve(s.router, httptest.NewRequest(http.MethodGet, "/blog", nil)).Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Strict-Transport-Security = %q with HSTS off", got)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	s := newTestServer(t)
+	preflight := func(origin string) http.Header {
+		req := httptest.NewRequest(http.MethodOptions, "/comment", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		return serve(s.router, req).Header()
+	}
+
+	s.router = app.New(testConfig(func(cfg *config.Config) {
+		cfg.Security.CORSAllowedOrigins = []string{"https://app.example.com"}
+	}), s.deps)
+	h := preflight("https://app.example.com")
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" || h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("listed origin: %v", h)
+	}
+	if h := preflight("https://evil.example.com"); h.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("unlisted origin allowed: %v", h)
+	}
+
+	// A wildcard never comes with credentials.
+	s.router = app.New(testConfig(func(cfg *config.Config) {}), s.deps)
+	h = preflight("https://evil.example.com")
+	if h.Get("Access-Control-Allow-Origin") != "*" || h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("wildcard: %v", h)
+	}
+}
+
+func TestCookieAuth(t *testing.T) {
+	s := newTestServer(t)
+	cfg := testConfig(func(cfg *config.Config) { cfg.Security.CookieAuth = true })
+	s.router = app.New(cfg, s.deps)
+	services := app.NewServices(cfg, s.deps, nil)
+	s.login("reader", user.Reader)
+	td, err := services.Users.GenerateAccessToken("reader", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.Users.SaveAccessToken(context.Background(), "reader", td); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	services.Users.SetAuthCookies(c, td)
+	cookies := map[string]*http.Cookie{}
+	for _, ck := range w.Result().Cookies() {
+		cookies[ck.Name] = ck
+	}
+	access, csrf := cookies[user.AccessTokenCookie], cookies[user.CSRFCookie]
+	if access == nil || csrf == nil {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	if !access.HttpOnly || !access.Secure || access.SameSite != http.SameSiteLaxMode || csrf.HttpOnly {
+		t.Errorf("access cookie %+v, csrf cookie %+v", access, csrf)
+	}
+
+	send := func(method, path, csrfHeader string, withCookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for _, ck := range withCookies {
+			req.AddCookie(ck)
+		}
+		if csrfHeader != "" {
+			req.Header.Set(user.CSRFHeader, csrfHeader)
+		}
+		return serve(s.router, req)
+	}
+	if w := send(http.MethodGet, "/profile", "", access); w.Code != http.StatusOK {
+		t.Errorf("GET with the cookie: status %d", w.Code)
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"no header":         send(http.MethodPost, "/comment", "", access, csrf),
+		"header not cookie": send(http.MethodPost, "/comment", csrf.Value, access),
+		"wrong token":       send(http.MethodPost, "/comment", "forged", access, &http.Cookie{Name: user.CSRFCookie, Value: "forged"}),
+	} {
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", name, w.Code)
+		}
+	}
+	if w := send(http.MethodPost, "/comment", csrf.Value, access, csrf); w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized {
+		t.Errorf("matching CSRF token: status %d", w.Code)
+	}
+
+	// Without cookie auth the cookie is ignored.
+	s.router = app.New(testConfig(func(cfg *config.Config) {}), s.deps)
+	if w := send(http.MethodGet, "/profile", "", access); w.Code != http.StatusUnauthorized {
+		t.Errorf("cookie auth off: status %d, want 401", w.Code)
+	}
+}
@@ -1,10 +1,28 @@
 package apperrors
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Stable, machine-readable error codes shared across packages. Domain
+// packages define their own more specific codes alongside these.
+const (
+	CodeInternal     = "internal_error"
+	CodeNotFound     = "not_found"
+	CodeInvalidID    = "invalid_id"
+	CodeValidation   = "validation_failed"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeConflict     = "conflict"
+	CodeRateLimited  = "rate_limited"
 )
 
 type AppError struct {
+	Code        string `json:"code"`
 	UserMessage string `json:"message"`
 	StatusCode  int    `json:"status_code"`
 	InternalErr error  `json:"-"`
@@ -12,12 +30,81 @@ type AppError struct {
 
 func NewError(userMessage string, statusCode int, internalErr error) *AppError {
 	return &AppError{
+		Code:        defaultCode(statusCode),
+		UserMessage: userMessage,
+		StatusCode:  statusCode,
+		InternalErr: internalErr,
+	}
+}
+
+func New(code, userMessage string, statusCode int, internalErr error) *AppError {
+	return &AppError{
+		Code:        code,
 		UserMessage: userMessage,
 		StatusCode:  statusCode,
 		InternalErr: internalErr,
 	}
 }
 
+func BadRequest(code, userMessage string, internalErr error) *AppError {
+	return New(code, userMessage, http.StatusBadRequest, internalErr)
+}
+
+func Unauthorized(code, userMessage string, internalErr error) *AppError {
+	return New(code, userMessage, http.StatusUnauthorized, internalErr)
+}
+
+func Forbidden(code, userMessage string, internalErr error) *AppError {
+	return New(code, userMessage, http.StatusForbidden, internalErr)
+}
+
+func NotFound(code, userMessage string, internalErr error) *AppError {
+	return New(code, userMessage, http.StatusNotFound, internalErr)
+}
+
+func Conflict(code, userMessage string, internalErr error) *AppError {
+	return New(code, userMessage, http.StatusConflict, internalErr)
+}
+
+func TooManyRequests(code, userMessage string, internalErr error) *AppError {
+	return New(code, userMessage, http.StatusTooManyRequests, internalErr)
+}
+
+func Internal(internalErr error) *AppError {
+	return New(CodeInternal, "internal server error", http.StatusInternalServerError, internalErr)
+}
+
+// NotFoundOr maps a missing document to a 404 with the given code. Errors
+// that are already an AppError pass through and anything else becomes a 500.
+func NotFoundOr(err error, code, userMessage string) error {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return err
+	}
+	if err == mongo.ErrNoDocuments {
+		return NotFound(code, userMessage, err)
+	}
+	return Internal(err)
+}
+
+func defaultCode(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return CodeValidation
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	}
+	return CodeInternal
+}
+
 func (e *AppError) Error() string {
 	if e.InternalErr != nil {
 		return fmt.Sprintf("%s: %v", e.UserMessage, e.InternalErr)
@@ -31,6 +118,7 @@ func (e *AppError) Unwrap() error {
 
 func (e *AppError) Sanitize() *AppError {
 	return &AppError{
+		Code:        e.Code,
 		UserMessage: e.UserMessage,
 		StatusCode:  e.StatusCode,
 	}
@@ -0,0 +1,59 @@
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func NewProblem(c *gin.Context, appErr *AppError) *Problem {
+	safe := appErr.Sanitize()
+	return &Problem{
+		Type:      "urn:bloggy:problem:" + safe.Code,
+		Title:     http.StatusText(safe.StatusCode),
+		Status:    safe.StatusCode,
+		Detail:    safe.UserMessage,
+		Instance:  c.Request.URL.Path,
+		Code:      safe.Code,
+		RequestID: c.GetString("request_id"),
+	}
+}
+
+// WriteProblem writes appErr as application/problem+json.
+func WriteProblem(c *gin.Context, appErr *AppError) {
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(appErr.StatusCode, NewProblem(c, appErr))
+}
+
+// ErrorHandler renders the last error attached with c.Error as a problem
+// response. Errors that are not an AppError become a generic 500 so internal
+// details never reach the client; the original error stays in c.Errors for
+// the request logger.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		var appErr *AppError
+		if !errors.As(err, &appErr) {
+			appErr = Internal(err)
+		}
+		WriteProblem(c, appErr)
+	}
+}
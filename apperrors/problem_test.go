@@ -0,0 +1,95 @@
+package apperrors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func serve(handler gin.HandlerFunc) (*httptest.ResponseRecorder, Problem) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("request_id", "req-1") }, ErrorHandler())
+	r.GET("/thing", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/thing", nil))
+	var p Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	return w, p
+}
+
+func TestErrorHandlerWritesProblem(t *testing.T) {
+	w, p := serve(func(c *gin.Context) {
+		c.Error(NotFound("post_not_found", "blog post not found", errors.New("mongo: no documents")))
+	})
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != ProblemContentType {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := Problem{
+		Type:      "urn:bloggy:problem:post_not_found",
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "blog post not found",
+		Instance:  "/thing",
+		Code:      "post_not_found",
+		RequestID: "req-1",
+	}
+	if p != want {
+		t.Errorf("problem = %+v, want %+v", p, want)
+	}
+}
+
+func TestErrorHandlerHidesInternalErrors(t *testing.T) {
+	w, p := serve(func(c *gin.Context) {
+		c.Error(errors.New("dial tcp 10.0.0.5:27017: connection refused"))
+	})
+	if w.Code != http.StatusInternalServerError || p.Code != CodeInternal {
+		t.Errorf("status %d, code %q; want a generic 500", w.Code, p.Code)
+	}
+	if strings.Contains(w.Body.String(), "10.0.0.5") {
+		t.Errorf("body leaks the internal error: %s", w.Body)
+	}
+}
+
+func TestErrorHandlerLeavesWrittenResponses(t *testing.T) {
+	w, _ := serve(func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		c.Error(BadRequest(CodeValidation, "ignored", nil))
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("status %d, want the handler's 200", w.Code)
+	}
+}
+
+func TestNotFoundOr(t *testing.T) {
+	var appErr *AppError
+	if err := NotFoundOr(mongo.ErrNoDocuments, "user_not_found", "user not found"); !errors.As(err, &appErr) || appErr.StatusCode != http.StatusNotFound || appErr.Code != "user_not_found" {
+		t.Errorf("missing document = %v, want a 404", err)
+	}
+	forbidden := Forbidden(CodeForbidden, "no", nil)
+	if err := NotFoundOr(forbidden, "user_not_found", "user not found"); err != forbidden {
+		t.Errorf("app error = %v, want it passed through", err)
+	}
+	if err := NotFoundOr(errors.New("boom"), "user_not_found", "user not found"); !errors.As(err, &appErr) || appErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("other error = %v, want a 500", err)
+	}
+}
+
+func TestNewErrorDefaultsCode(t *testing.T) {
+	for status, code := range map[int]string{
+		http.StatusBadRequest:          CodeValidation,
+		http.StatusConflict:            CodeConflict,
+		http.StatusTooManyRequests:     CodeRateLimited,
+		http.StatusInternalServerError: CodeInternal,
+	} {
+		if got := NewError("x", status, nil).Code; got != code {
+			t.Errorf("code for %d = %q, want %q", status, got, code)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/user"
+)
+
+const (
+	// Format identifies bloggy archives in the manifest.
+	Format = "bloggy-backup"
+	// Version is the archive layout written by this build. Restore accepts
+	// this version and older ones.
+	Version = 1
+
+	manifestFile    = "manifest.json"
+	usersFile       = "users.jsonl"
+	postsFile       = "posts.jsonl"
+	commentsFile    = "comments.jsonl"
+	aboutFile       = "about.jsonl"
+	subscribersFile = "subscribers.jsonl"
+	mediaDir        = "media/"
+)
+
+// MaxEntrySize and MaxContentSize cap how much a restore unpacks from one
+// archive entry and from the whole archive, since archives are read into
+// memory and gzip can expand a small upload enormously.
+const (
+	MaxEntrySize   = 256 << 20
+	MaxContentSize = 1 << 30
+)
+
+// Manifest describes an archive. It is written first so readers can reject
+// an unknown format before reading the rest.
+type Manifest struct {
+	Format       string         `json:"format"`
+	Version      int            `json:"version"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Counts       map[string]int `json:"counts"`
+	Media        []MediaEntry   `json:"media,omitempty"`
+	MissingMedia []string       `json:"missing_media,omitempty"`
+}
+
+// MediaEntry maps a media URL referenced by the content to its copy in the
+// archive.
+type MediaEntry struct {
+	URL  string `json:"url"`
+	Path string `json:"path"`
+}
+
+// The records below are the archive's own types so that the format does not
+// change when the API's JSON representation does. IDs are only meaningful
+// within the archive; restore assigns new ones where needed.
+
+type userRecord struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Email      string              `json:"email"`
+	GoogleId   string              `json:"google_id,omitempty"`
+	IsVerified bool                `json:"is_verified"`
+	Role       user.Role           `json:"role"`
+	Picture    string              `json:"picture,omitempty"`
+	Profile    *user.AuthorProfile `json:"profile,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+type postRecord struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Slug        string    `json:"slug"`
+	AuthorId    string    `json:"author_id,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Content     string    `json:"content"`
+	CoverImage  string    `json:"cover_image,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Likes       []string  `json:"likes,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	SourceId    string    `json:"source_id,omitempty"`
+}
+
+type commentRecord struct {
+	ID         string    `json:"id"`
+	PostId     string    `json:"post_id"`
+	ParentId   string    `json:"parent_id,omitempty"`
+	AuthorId   string    `json:"author_id,omitempty"`
+	AuthorName string    `json:"author_name,omitempty"`
+	Content    string    `json:"content"`
+	Likes      []string  `json:"likes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	SourceId   string    `json:"source_id,omitempty"`
+}
+
+type aboutRecord struct {
+	UserId         string `json:"user_id"`
+	AboutMe        string `json:"about_me"`
+	ProfilePicture string `json:"profile_picture,omitempty"`
+}
+
+type subscriberRecord struct {
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// archive is the decoded content of a backup.
+type archive struct {
+	manifest    Manifest
+	users       []userRecord
+	posts       []postRecord
+	comments    []commentRecord
+	about       []aboutRecord
+	subscribers []subscriberRecord
+	// media holds the media read from an archive and spooled the temporary
+	// files of the media Export writes, both by archive path.
+	media   map[string][]byte
+	spooled map[string]string
+}
+
+func encodeJSONL[T any](records []T) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeJSONL[T any](name string, data []byte) ([]T, error) {
+	var out []T
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r T
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", name, line, err)
+		}
+		out = append(out, r)
+	}
+	return out, scanner.Err()
+}
+
+func (a *archive) write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: a.manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	manifest, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add(manifestFile, manifest); err != nil {
+		return err
+	}
+	files := []struct {
+		name   string
+		encode func() ([]byte, error)
+	}{
+		{usersFile, func() ([]byte, error) { return encodeJSONL(a.users) }},
+		{postsFile, func() ([]byte, error) { return encodeJSONL(a.posts) }},
+		{commentsFile, func() ([]byte, error) { return encodeJSONL(a.comments) }},
+		{aboutFile, func() ([]byte, error) { return encodeJSONL(a.about) }},
+		{subscribersFile, func() ([]byte, error) { return encodeJSONL(a.subscribers) }},
+	}
+	for _, f := range files {
+		data, err := f.encode()
+		if err != nil {
+			return err
+		}
+		if err := add(f.name, data); err != nil {
+			return err
+		}
+	}
+	for _, m := range a.manifest.Media {
+		if err := addFile(tw, m.Path, a.spooled[m.Path], a.manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addFile copies the file at src into tw as name without reading it into
+// memory.
+func addFile(tw *tar.Writer, name, src string, modTime time.Time) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+var errNotArchive = errors.New("not a bloggy backup archive")
+
+func readArchive(r io.Reader) (*archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotArchive, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	a := &archive{media: map[string][]byte{}}
+	seenManifest := false
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errNotArchive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		data, err := io.ReadAll(io.LimitReader(tr, MaxEntrySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxEntrySize {
+			return nil, fmt.Errorf("archive entry %s is larger than %d MB", name, MaxEntrySize>>20)
+		}
+		if total += int64(len(data)); total > MaxContentSize {
+			return nil, fmt.Errorf("archive unpacks to more than %d MB", MaxContentSize>>20)
+		}
+		if !seenManifest && name != manifestFile {
+			return nil, fmt.Errorf("%w: %s must be the first entry", errNotArchive, manifestFile)
+		}
+		switch {
+		case name == manifestFile:
+			if err := json.Unmarshal(data, &a.manifest); err != nil {
+				return nil, fmt.Errorf("%w: %v", errNotArchive, err)
+			}
+			if a.manifest.Format != Format {
+				return nil, errNotArchive
+			}
+			if a.manifest.Version < 1 || a.manifest.Version > Version {
+				return nil, fmt.Errorf("archive version %d is not supported (this build reads up to %d)", a.manifest.Version, Version)
+			}
+			seenManifest = true
+		case name == usersFile:
+			a.users, err = decodeJSONL[userRecord](name, data)
+		case name == postsFile:
+			a.posts, err = decodeJSONL[postRecord](name, data)
+		case name == commentsFile:
+			a.comments, err = decodeJSONL[commentRecord](name, data)
+		case name == aboutFile:
+			a.about, err = decodeJSONL[aboutRecord](name, data)
+		case name == subscribersFile:
+			a.subscribers, err = decodeJSONL[subscriberRecord](name, data)
+		case strings.HasPrefix(name, mediaDir):
+			a.media[name] = data
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !seenManifest {
+		return nil, errNotArchive
+	}
+	return a, nil
+}
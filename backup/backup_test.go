@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/ayo-ajayi/bloggy/media"
+	"github.com/ayo-ajayi/bloggy/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type site struct {
+	posts   *blog.BlogRepo
+	users   *user.UserRepo
+	library *media.MediaService
+	service *BackupService
+	upload  string
+}
+
+// newSite returns an empty site whose uploads are served from host.
+func newSite(host string) *site {
+	database := db.NewMemoryDatabase()
+	clk := clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := &site{
+		posts:   blog.NewBlogRepo(database.Collection("posts"), database.Collection("comments")),
+		users:   user.NewUserRepo(database.Collection("users")),
+		library: media.NewMediaService(media.NewMediaRepo(database.Collection("media")), media.NewMemoryStore("https://"+host+"/media"), media.Policy{}, clk),
+	}
+	s.service = NewBackupService(s.posts, s.users, s.library, clk)
+	return s
+}
+
+// seed fills s with an author, a reader, an about-me entry, a subscriber and
+// a post with a comment and a reply.
+func (s *site) seed(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if s.upload, err = s.library.UploadFile(ctx, &buf, "cat.png"); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*user.User{
+		{ID: "author", Name: "Ada", Email: "ada@example.com", Role: user.Author, Profile: &user.AuthorProfile{Slug: "ada", Avatar: s.upload}},
+		{ID: "reader", Name: "Bob", Email: "bob@example.com", Role: user.Reader},
+	} {
+		if _, err := s.users.CreateUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.users.CreateAboutMe(ctx, bson.M{"_id": aboutId("author"), "about_me": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.CreateMailingList(ctx, bson.M{"name": mailingListName, "subscribers": []user.Subscriber{{Email: "sub@example.com", Name: "Sub"}}}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.posts.CreateBlogPost(ctx, &blog.BlogPost{
+		Title: "Cats", Slug: "cats", AuthorId: "author", CoverImage: s.upload,
+		Content:   "![cat](" + s.upload + ") ![internal](http://169.254.169.254/latest/secret.png)",
+		Likes:     []blog.Like{{UserId: "reader"}},
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postId := res.InsertedID.(primitive.ObjectID)
+	c, err := s.posts.PostComment(ctx, &blog.Comment{AuthorId: "reader", BlogPostId: postId, Content: "meow", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.posts.PostComment(ctx, &blog.Comment{AuthorId: "author", BlogPostId: postId, ParentId: c.InsertedID.(primitive.ObjectID), Content: "purr", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *site) export(t *testing.T) ([]byte, *Manifest) {
+	t.Helper()
+	var buf bytes.Buffer
+	manifest, err := s.service.Export(context.Background(), &buf, ExportOptions{IncludeMedia: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), manifest
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newSite("old.example.com")
+	src.seed(t)
+	archive, manifest := src.export(t)
+
+	if len(manifest.Media) != 1 || manifest.Media[0].URL != src.upload {
+		t.Errorf("archived media = %+v, want only the upload", manifest.Media)
+	}
+	if len(manifest.MissingMedia) != 1 || !strings.Contains(manifest.MissingMedia[0], "169.254.169.254") {
+		t.Errorf("missing media = %v, want the internal URL", manifest.MissingMedia)
+	}
+
+	dst := newSite("new.example.com")
+	report, err := dst.service.Restore(ctx, bytes.NewReader(archive), RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Report{
+		Users:       Counts{Created: 2},
+		Posts:       Counts{Created: 1},
+		Comments:    Counts{Created: 2},
+		About:       Counts{Created: 1},
+		Subscribers: Counts{Created: 1},
+		Media:       Counts{Created: 1},
+	}
+	if report.Users != want.Users || report.Posts != want.Posts || report.Comments != want.Comments ||
+		report.About != want.About || report.Subscribers != want.Subscribers || report.Media != want.Media {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	post, err := dst.posts.GetBlogPost(ctx, bson.M{"slug": "cats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(post.Content, src.upload) || !strings.Contains(post.Content, "https://new.example.com/media/") {
+		t.Errorf("content = %q, want the upload re-hosted", post.Content)
+	}
+	if !strings.Contains(post.Content, "169.254.169.254") {
+		t.Errorf("content = %q, want the unarchived URL kept", post.Content)
+	}
+	if !strings.HasPrefix(post.CoverImage, "https://new.example.com/media/") {
+		t.Errorf("cover = %q, want it re-hosted", post.CoverImage)
+	}
+	uploads, _, err := dst.library.List(ctx, media.ListQuery{Page: 1, PerPage: 10})
+	if err != nil || len(uploads) != 1 || len(uploads[0].References) != 1 || uploads[0].References[0] != blog.MediaRef(post.Id) {
+		t.Errorf("uploads = %+v, %v; want one used by the restored post", uploads, err)
+	}
+	if len(post.Likes) != 1 || post.Likes[0].UserId != "reader" {
+		t.Errorf("likes = %+v", post.Likes)
+	}
+	comments, err := dst.posts.GetComments(ctx, bson.M{"blog_post_id": post.Id})
+	if err != nil || len(comments) != 2 {
+		t.Fatalf("comments = %v, %v", comments, err)
+	}
+	byContent := map[string]*blog.Comment{}
+	for _, c := range comments {
+		byContent[c.Content] = c
+	}
+	if byContent["purr"].ParentId != byContent["meow"].Id {
+		t.Error("the reply lost its parent")
+	}
+	author, err := dst.users.GetUser(ctx, bson.M{"_id": "author"})
+	if err != nil || author.Profile == nil || author.Profile.Slug != "ada" || !strings.HasPrefix(author.Profile.Avatar, "https://new.example.com/media/") {
+		t.Errorf("author = %+v, %v; want slug ada and a re-hosted avatar", author, err)
+	}
+	list, err := dst.users.GetMailingList(ctx, bson.M{"name": mailingListName})
+	if err != nil || len(list.Subscribers) != 1 {
+		t.Errorf("mailing list = %+v, %v", list, err)
+	}
+}
+
+func TestRestoreConflictPolicies(t *testing.T) {
+	ctx := context.Background()
+	s := newSite("blog.example.com")
+	s.seed(t)
+	archive, _ := s.export(t)
+	restore := func(policy ConflictPolicy) *Report {
+		t.Helper()
+		report, err := s.service.Restore(ctx, bytes.NewReader(archive), RestoreOptions{Conflict: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	commentCount := func() int {
+		comments, err := s.posts.GetComments(ctx, bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(comments)
+	}
+
+	r := restore(Skip)
+	if r.Users.Skipped != 2 || r.Posts.Skipped != 1 || r.Comments.Skipped != 2 || r.About.Skipped != 1 || r.Subscribers.Skipped != 1 {
+		t.Errorf("skip report = %+v", r)
+	}
+	if n := commentCount(); n != 2 {
+		t.Errorf("%d comments after skip, want 2", n)
+	}
+
+	if _, err := s.posts.UpdateBlogPost(ctx, bson.M{"slug": "cats"}, bson.M{"$set": bson.M{"title": "Edited"}}); err != nil {
+		t.Fatal(err)
+	}
+	r = restore(Overwrite)
+	if r.Users.Updated != 2 || r.Posts.Updated != 1 || r.Comments.Created != 2 || r.About.Updated != 1 {
+		t.Errorf("overwrite report = %+v", r)
+	}
+	post, err := s.posts.GetBlogPost(ctx, bson.M{"slug": "cats"})
+	if err != nil || post.Title != "Cats" {
+		t.Errorf("post after overwrite = %+v, %v; want the archived title", post, err)
+	}
+	if n := commentCount(); n != 2 {
+		t.Errorf("%d comments after overwrite, want the archived 2", n)
+	}
+
+	r = restore(Rename)
+	if r.Posts.Renamed != 1 || r.Users.Skipped != 2 || r.Comments.Created != 2 {
+		t.Errorf("rename report = %+v", r)
+	}
+	if _, err := s.posts.GetBlogPost(ctx, bson.M{"slug": "cats-2"}); err != nil {
+		t.Errorf("renamed post: %v", err)
+	}
+	if n := commentCount(); n != 4 {
+		t.Errorf("%d comments after rename, want 4", n)
+	}
+}
+
+func tarGz(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f[0], Mode: 0o644, Size: int64(len(f[1]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"not gzip":         []byte("hello"),
+		"manifest missing": tarGz(t, [2]string{postsFile, "{}"}),
+		"manifest second":  tarGz(t, [2]string{postsFile, ""}, [2]string{manifestFile, `{"format":"bloggy-backup","version":1}`}),
+		"other format":     tarGz(t, [2]string{manifestFile, `{"format":"other","version":1}`}),
+		"newer version":    tarGz(t, [2]string{manifestFile, `{"format":"bloggy-backup","version":99}`}),
+	} {
+		_, err := newSite("blog.example.com").service.Restore(context.Background(), bytes.NewReader(data), RestoreOptions{})
+		var appErr *apperrors.AppError
+		if !errors.As(err, &appErr) || appErr.Code != CodeInvalidArchive {
+			t.Errorf("%s: err = %v, want %s", name, err, CodeInvalidArchive)
+		}
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for in, want := range map[string]ConflictPolicy{"": Skip, "SKIP": Skip, "overwrite": Overwrite, "rename": Rename} {
+		if got, err := ParseConflictPolicy(in); err != nil || got != want {
+			t.Errorf("ParseConflictPolicy(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseConflictPolicy("merge"); err == nil {
+		t.Error("an unknown policy was accepted")
+	}
+}
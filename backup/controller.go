@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// MaxArchiveSize caps restore uploads.
+const MaxArchiveSize = 512 << 20
+
+type BackupController struct {
+	service BackupServices
+}
+
+type BackupServices interface {
+	Filename() string
+	Export(ctx context.Context, w io.Writer, opts ExportOptions) (*Manifest, error)
+	Restore(ctx context.Context, r io.Reader, opts RestoreOptions) (*Report, error)
+}
+
+func NewBackupController(service BackupServices) *BackupController {
+	return &BackupController{service}
+}
+
+// Backup downloads an archive of all content. Media is included unless
+// ?media=false.
+func (bc *BackupController) Backup(c *gin.Context) {
+	includeMedia := true
+	if v := c.Query("media"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.Error(apperrors.BadRequest(apperrors.CodeValidation, "media must be true or false", err))
+			return
+		}
+		includeMedia = b
+	}
+	// Export reads everything before its first write, so until then a
+	// failure can still be reported as an error response.
+	w := &attachment{c: c, name: bc.service.Filename()}
+	manifest, err := bc.service.Export(c, w, ExportOptions{IncludeMedia: includeMedia})
+	if err != nil {
+		if manifest != nil {
+			logger.FromContext(c).Error("backup failed", "manifest", manifest, "written", c.Writer.Written())
+		}
+		c.Error(apperrors.Internal(err))
+		return
+	}
+}
+
+// attachment writes the download headers before the first byte of the
+// archive.
+type attachment struct {
+	c    *gin.Context
+	name string
+}
+
+func (a *attachment) Write(p []byte) (int, error) {
+	if !a.c.Writer.Written() {
+		a.c.Header("Content-Type", "application/gzip")
+		a.c.Header("Content-Disposition", `attachment; filename="`+a.name+`"`)
+		a.c.Status(http.StatusOK)
+	}
+	return a.c.Writer.Write(p)
+}
+
+// Restore loads an archive sent either as the "archive" multipart file or
+// as the raw request body. ?conflict picks the ConflictPolicy.
+func (bc *BackupController) Restore(c *gin.Context) {
+	policy, err := ParseConflictPolicy(c.Query("conflict"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxArchiveSize)
+	var body io.Reader = c.Request.Body
+	if file, err := c.FormFile("archive"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.Error(apperrors.BadRequest(CodeInvalidArchive, "could not read archive", err))
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	report, err := bc.service.Restore(c, body, RestoreOptions{Conflict: policy})
+	if err != nil {
+		// Content restored before the failure stays, so say what it was.
+		if report != nil {
+			logger.FromContext(c).Error("restore failed", "report", report)
+		}
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/gin-gonic/gin"
+)
+
+// exporter writes body, then fails with err.
+type exporter struct {
+	BackupServices
+	body string
+	err  error
+}
+
+func (e exporter) Filename() string { return "bloggy-test.tar.gz" }
+
+func (e exporter) Export(ctx context.Context, w io.Writer, opts ExportOptions) (*Manifest, error) {
+	if e.body != "" {
+		if _, err := io.WriteString(w, e.body); err != nil {
+			return nil, err
+		}
+	}
+	return &Manifest{Format: Format}, e.err
+}
+
+func backup(service BackupServices) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(apperrors.ErrorHandler())
+	r.GET("/backup", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.Next()
+	}, NewBackupController(service).Backup)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/backup", nil))
+	return w
+}
+
+func TestBackupStreamsTheArchive(t *testing.T) {
+	w := backup(exporter{body: "archive bytes"})
+	if w.Code != http.StatusOK || w.Body.String() != "archive bytes" {
+		t.Fatalf("response = %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="bloggy-test.tar.gz"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+}
+
+func TestBackupFailureBeforeWritingIsAProblem(t *testing.T) {
+	w := backup(exporter{err: errors.New("database down")})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("a failed backup was sent as an attachment: %q", cd)
+	}
+}
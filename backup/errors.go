@@ -0,0 +1,6 @@
+package backup
+
+const (
+	CodeInvalidArchive        = "invalid_archive"
+	CodeInvalidConflictPolicy = "invalid_conflict_policy"
+)
@@ -0,0 +1,446 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ConflictPolicy says what Restore does with content that already exists:
+// a post with the same slug, a user with the same email, or an about-me
+// entry for the same user.
+type ConflictPolicy string
+
+const (
+	// Skip keeps the existing content and ignores the archived copy.
+	Skip ConflictPolicy = "skip"
+	// Overwrite replaces the existing content with the archived copy.
+	Overwrite ConflictPolicy = "overwrite"
+	// Rename restores posts under a free slug such as my-post-2. Users and
+	// about-me entries cannot be renamed and are skipped.
+	Rename ConflictPolicy = "rename"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(strings.ToLower(s)); p {
+	case Skip, Overwrite, Rename:
+		return p, nil
+	case "":
+		return Skip, nil
+	}
+	return "", apperrors.BadRequest(CodeInvalidConflictPolicy, "conflict policy must be skip, overwrite or rename", nil)
+}
+
+type RestoreOptions struct {
+	Conflict ConflictPolicy
+}
+
+// Counts tallies what Restore did with one kind of content.
+type Counts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Renamed int `json:"renamed"`
+	Skipped int `json:"skipped"`
+}
+
+type Report struct {
+	Users       Counts   `json:"users"`
+	Posts       Counts   `json:"posts"`
+	Comments    Counts   `json:"comments"`
+	About       Counts   `json:"about"`
+	Subscribers Counts   `json:"subscribers"`
+	Media       Counts   `json:"media"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+func (r *Report) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// restore holds the ID and URL mappings built up while restoring.
+type restore struct {
+	*BackupService
+	policy     ConflictPolicy
+	report     *Report
+	userIds    map[string]string
+	postIds    map[string]primitive.ObjectID
+	commentIds map[string]primitive.ObjectID
+	mediaURLs  map[string]string
+}
+
+// Restore loads an archive written by Export. Archive IDs are remapped to
+// new ones, except that a user keeps their ID when it is free. It stops at
+// the first storage error; content restored before it stays.
+func (s *BackupService) Restore(ctx context.Context, r io.Reader, opts RestoreOptions) (*Report, error) {
+	if opts.Conflict == "" {
+		opts.Conflict = Skip
+	}
+	a, err := readArchive(r)
+	if err != nil {
+		if errors.Is(err, errNotArchive) {
+			return nil, apperrors.BadRequest(CodeInvalidArchive, "not a bloggy backup archive", err)
+		}
+		return nil, apperrors.BadRequest(CodeInvalidArchive, err.Error(), err)
+	}
+	rs := &restore{
+		BackupService: s,
+		policy:        opts.Conflict,
+		report:        &Report{},
+		userIds:       map[string]string{},
+		postIds:       map[string]primitive.ObjectID{},
+		commentIds:    map[string]primitive.ObjectID{},
+		mediaURLs:     map[string]string{},
+	}
+	steps := []func(context.Context, *archive) error{
+		rs.restoreMedia, rs.restoreUsers, rs.restoreAbout, rs.restorePosts, rs.restoreComments, rs.restoreSubscribers,
+	}
+	for _, step := range steps {
+		if err := step(ctx, a); err != nil {
+			return rs.report, apperrors.Internal(err)
+		}
+	}
+	return rs.report, nil
+}
+
+func (rs *restore) userId(archived string) string {
+	if id, ok := rs.userIds[archived]; ok {
+		return id
+	}
+	return archived
+}
+
+// rewriteMedia points media URLs at their re-uploaded copies.
+func (rs *restore) rewriteMedia(s string) string {
+	for old, new := range rs.mediaURLs {
+		s = strings.ReplaceAll(s, old, new)
+	}
+	return s
+}
+
+func (rs *restore) restoreMedia(ctx context.Context, a *archive) error {
+	for _, m := range a.manifest.Media {
+		data, ok := a.media[m.Path]
+		if !ok {
+			rs.report.warn("media %s is listed but missing from the archive", m.Path)
+			rs.report.Media.Skipped++
+			continue
+		}
+		if rs.library == nil {
+			rs.report.Media.Skipped++
+			continue
+		}
+		u, err := rs.library.UploadFile(ctx, bytes.NewReader(data), path.Base(m.Path))
+		if err != nil {
+			rs.report.warn("uploading %s: %v", m.URL, err)
+			rs.report.Media.Skipped++
+			continue
+		}
+		rs.mediaURLs[m.URL] = u
+		rs.report.Media.Created++
+	}
+	return nil
+}
+
+func (rs *restore) restoreUsers(ctx context.Context, a *archive) error {
+	for _, r := range a.users {
+		if r.Profile != nil {
+			profile := *r.Profile
+			profile.Avatar = rs.rewriteMedia(profile.Avatar)
+			r.Profile = &profile
+		}
+		r.Picture = rs.rewriteMedia(r.Picture)
+		existing, err := rs.users.GetUser(ctx, emailFilter(r.Email))
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if existing != nil {
+			rs.userIds[r.ID] = existing.ID
+			if rs.policy != Overwrite {
+				rs.report.Users.Skipped++
+				continue
+			}
+			set := bson.M{"name": r.Name, "role": r.Role, "picture": r.Picture, "is_verified": r.IsVerified, "updated_at": r.UpdatedAt}
+			if r.Profile != nil {
+				if r.Profile.Slug, err = rs.freeAuthorSlug(ctx, r.Profile.Slug, existing.ID); err != nil {
+					return err
+				}
+				set["profile"] = r.Profile
+			}
+			if _, err := rs.users.UpdateUser(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": set}); err != nil {
+				return err
+			}
+			rs.report.Users.Updated++
+			continue
+		}
+		id := r.ID
+		taken, err := rs.users.IsExists(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if taken || id == "" {
+			id = uuid.New().String()
+		}
+		u := &user.User{
+			ID:         id,
+			Name:       r.Name,
+			Email:      r.Email,
+			GoogleId:   r.GoogleId,
+			IsVerified: r.IsVerified,
+			Role:       r.Role,
+			Picture:    r.Picture,
+			Profile:    r.Profile,
+			CreatedAt:  r.CreatedAt,
+			UpdatedAt:  r.UpdatedAt,
+		}
+		if u.Profile != nil {
+			if u.Profile.Slug, err = rs.freeAuthorSlug(ctx, u.Profile.Slug, id); err != nil {
+				return err
+			}
+		}
+		if _, err := rs.users.CreateUser(ctx, u); err != nil {
+			return err
+		}
+		rs.userIds[r.ID] = id
+		rs.report.Users.Created++
+	}
+	return nil
+}
+
+// freeAuthorSlug returns slug, or slug with a numeric suffix when another
+// user already has it. Author slugs are unique.
+func (rs *restore) freeAuthorSlug(ctx context.Context, slug, userId string) (string, error) {
+	if slug == "" {
+		return slug, nil
+	}
+	return freeSlug(slug, func(candidate string) (bool, error) {
+		return rs.users.IsExists(ctx, bson.M{"profile.slug": candidate, "_id": bson.M{"$ne": userId}})
+	})
+}
+
+func freeSlug(slug string, taken func(string) (bool, error)) (string, error) {
+	for i := 1; ; i++ {
+		candidate := slug
+		if i > 1 {
+			candidate = slug + "-" + strconv.Itoa(i)
+		}
+		used, err := taken(candidate)
+		if err != nil || !used {
+			return candidate, err
+		}
+	}
+}
+
+func (rs *restore) restoreAbout(ctx context.Context, a *archive) error {
+	for _, r := range a.about {
+		id := aboutId(rs.userId(r.UserId))
+		picture := rs.rewriteMedia(r.ProfilePicture)
+		exists, err := rs.users.IsExists(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		switch {
+		case !exists:
+			if _, err := rs.users.CreateAboutMe(ctx, bson.M{"_id": id, "about_me": r.AboutMe, "profile_picture": picture, "updated_at": rs.clock.Now()}); err != nil {
+				return err
+			}
+			rs.report.About.Created++
+		case rs.policy == Overwrite:
+			if _, err := rs.users.UpdateAboutMe(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"about_me": r.AboutMe, "profile_picture": picture, "updated_at": rs.clock.Now()}}); err != nil {
+				return err
+			}
+			rs.report.About.Updated++
+		default:
+			rs.report.About.Skipped++
+		}
+	}
+	return nil
+}
+
+func (rs *restore) restorePosts(ctx context.Context, a *archive) error {
+	for _, r := range a.posts {
+		post := &blog.BlogPost{
+			Title:       r.Title,
+			Slug:        r.Slug,
+			AuthorId:    rs.userId(r.AuthorId),
+			Description: rs.rewriteMedia(r.Description),
+			Content:     rs.rewriteMedia(r.Content),
+			CoverImage:  rs.rewriteMedia(r.CoverImage),
+			Tags:        r.Tags,
+			Likes:       likesOf(r.Likes, rs.userId),
+			CreatedAt:   r.CreatedAt,
+			UpdatedAt:   r.UpdatedAt,
+			SourceId:    r.SourceId,
+		}
+		existing, err := rs.posts.GetBlogPost(ctx, bson.M{"slug": r.Slug})
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if existing != nil {
+			switch rs.policy {
+			case Skip:
+				rs.report.Posts.Skipped++
+				continue
+			case Overwrite:
+				if err := rs.overwritePost(ctx, existing.Id, post); err != nil {
+					return err
+				}
+				if err := rs.setMediaReferences(ctx, existing.Id, post); err != nil {
+					return err
+				}
+				rs.postIds[r.ID] = existing.Id
+				rs.report.Posts.Updated++
+				continue
+			case Rename:
+				post.Slug, err = freeSlug(r.Slug, func(candidate string) (bool, error) {
+					_, err := rs.posts.GetBlogPost(ctx, bson.M{"slug": candidate})
+					if err == mongo.ErrNoDocuments {
+						return false, nil
+					}
+					return err == nil, err
+				})
+				if err != nil {
+					return err
+				}
+				rs.report.Posts.Renamed++
+			}
+		}
+		res, err := rs.posts.CreateBlogPost(ctx, post)
+		if err != nil {
+			return err
+		}
+		id, _ := res.InsertedID.(primitive.ObjectID)
+		rs.postIds[r.ID] = id
+		if err := rs.setMediaReferences(ctx, id, post); err != nil {
+			return err
+		}
+		if existing == nil {
+			rs.report.Posts.Created++
+		}
+	}
+	return nil
+}
+
+// setMediaReferences records the uploads a restored post uses.
+func (rs *restore) setMediaReferences(ctx context.Context, id primitive.ObjectID, post *blog.BlogPost) error {
+	if rs.library == nil {
+		return nil
+	}
+	return rs.library.SetReferences(ctx, blog.MediaRef(id), blog.MediaURLs(post)...)
+}
+
+// overwritePost replaces an existing post and drops its comments; the
+// archived comments take their place.
+func (rs *restore) overwritePost(ctx context.Context, id primitive.ObjectID, post *blog.BlogPost) error {
+	set := bson.M{
+		"title": post.Title, "description": post.Description, "content": post.Content, "cover_image": post.CoverImage,
+		"author_id": post.AuthorId, "tags": post.Tags, "likes": post.Likes, "created_at": post.CreatedAt, "updated_at": post.UpdatedAt,
+	}
+	if _, err := rs.posts.UpdateBlogPost(ctx, bson.M{"_id": id}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+	comments, err := rs.posts.GetComments(ctx, bson.M{"blog_post_id": id})
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if _, err := rs.posts.DeleteComment(ctx, bson.M{"_id": c.Id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rs *restore) restoreComments(ctx context.Context, a *archive) error {
+	pending := a.comments
+	for len(pending) > 0 {
+		var next []commentRecord
+		for _, r := range pending {
+			postId, ok := rs.postIds[r.PostId]
+			if !ok {
+				// The post was skipped, so its comments are too.
+				rs.report.Comments.Skipped++
+				continue
+			}
+			parentId, ok := rs.commentIds[r.ParentId]
+			if r.ParentId != "" && !ok {
+				next = append(next, r)
+				continue
+			}
+			comment := &blog.Comment{
+				AuthorId:   rs.userId(r.AuthorId),
+				AuthorName: r.AuthorName,
+				BlogPostId: postId,
+				ParentId:   parentId,
+				Content:    r.Content,
+				Likes:      likesOf(r.Likes, rs.userId),
+				CreatedAt:  r.CreatedAt,
+				UpdatedAt:  r.UpdatedAt,
+				SourceId:   r.SourceId,
+			}
+			res, err := rs.posts.PostComment(ctx, comment)
+			if err != nil {
+				return err
+			}
+			rs.commentIds[r.ID], _ = res.InsertedID.(primitive.ObjectID)
+			rs.report.Comments.Created++
+		}
+		if len(next) == len(pending) {
+			rs.report.warn("%d comment(s) reply to comments missing from the archive", len(next))
+			rs.report.Comments.Skipped += len(next)
+			break
+		}
+		pending = next
+	}
+	return nil
+}
+
+// restoreSubscribers merges the archived mailing list into the existing one
+// by email.
+func (rs *restore) restoreSubscribers(ctx context.Context, a *archive) error {
+	if len(a.subscribers) == 0 {
+		return nil
+	}
+	list, err := rs.users.GetMailingList(ctx, bson.M{"name": mailingListName})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	existing := map[string]bool{}
+	if list != nil {
+		for _, sub := range list.Subscribers {
+			existing[strings.ToLower(sub.Email)] = true
+		}
+	}
+	var added []user.Subscriber
+	for _, r := range a.subscribers {
+		key := strings.ToLower(r.Email)
+		if existing[key] {
+			rs.report.Subscribers.Skipped++
+			continue
+		}
+		existing[key] = true
+		added = append(added, user.Subscriber(r))
+	}
+	rs.report.Subscribers.Created = len(added)
+	switch {
+	case len(added) == 0:
+		return nil
+	case list == nil:
+		_, err = rs.users.CreateMailingList(ctx, bson.M{"name": mailingListName, "subscribers": added})
+	default:
+		_, err = rs.users.UpdateMailingList(ctx, bson.M{"name": mailingListName}, bson.M{"$push": bson.M{"subscribers": bson.M{"$each": added}}})
+	}
+	return err
+}
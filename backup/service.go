@@ -0,0 +1,304 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxMediaSize caps each media file copied into an archive.
+const maxMediaSize = 25 << 20
+
+// MediaLibrary is where the blog's own uploads live. Export copies media
+// from it, and restore re-hosts archived media in it and records which
+// posts use it.
+type MediaLibrary interface {
+	UploadFile(ctx context.Context, r io.Reader, name string) (string, error)
+	// OpenURL opens the upload published at url, failing for URLs the
+	// library does not hold.
+	OpenURL(ctx context.Context, url string) (io.ReadCloser, string, error)
+	SetReferences(ctx context.Context, ref string, urls ...string) error
+}
+
+type BackupService struct {
+	posts   blog.BlogRepository
+	users   user.UserRepository
+	library MediaLibrary
+	clock   clock.Clock
+}
+
+// NewBackupService returns a service over the blog and user repositories.
+// library may be nil, in which case no media is archived and restored content
+// keeps its original media URLs.
+func NewBackupService(posts blog.BlogRepository, users user.UserRepository, library MediaLibrary, clk clock.Clock) *BackupService {
+	return &BackupService{
+		posts:   posts,
+		users:   users,
+		library: library,
+		clock:   clock.OrSystem(clk),
+	}
+}
+
+type ExportOptions struct {
+	// IncludeMedia copies the uploads the content references into the
+	// archive. Other images are never fetched, so they are listed in the
+	// manifest as missing along with uploads that cannot be read.
+	IncludeMedia bool
+}
+
+// userFilter matches user accounts; the users collection also holds the
+// about-me documents and the mailing list.
+var userFilter = bson.M{"email": bson.M{"$exists": true}}
+
+const mailingListName = "mailing_list"
+
+func aboutId(userId string) string {
+	return "profile_picture" + userId
+}
+
+// Filename is the suggested name of an archive written now.
+func (s *BackupService) Filename() string {
+	return "bloggy-" + s.clock.Now().UTC().Format("20060102-150405") + ".tar.gz"
+}
+
+// Export streams every user, post, comment, about-me entry and subscriber to
+// w as a gzipped tar archive and returns its manifest. Media is spooled to a
+// temporary directory first, so nothing is written to w until all content
+// has been read. On failure it returns the manifest as far as it got.
+func (s *BackupService) Export(ctx context.Context, w io.Writer, opts ExportOptions) (*Manifest, error) {
+	a, err := s.collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IncludeMedia {
+		dir, err := os.MkdirTemp("", "bloggy-backup-")
+		if err != nil {
+			return &a.manifest, err
+		}
+		defer os.RemoveAll(dir)
+		if err := s.spoolMedia(ctx, a, dir); err != nil {
+			return &a.manifest, err
+		}
+	}
+	return &a.manifest, a.write(w)
+}
+
+func (s *BackupService) collect(ctx context.Context) (*archive, error) {
+	a := &archive{
+		manifest: Manifest{Format: Format, Version: Version, CreatedAt: s.clock.Now().UTC()},
+		spooled:  map[string]string{},
+	}
+	users, err := s.users.GetUsers(ctx, userFilter)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		a.users = append(a.users, userRecord{
+			ID:         u.ID,
+			Name:       u.Name,
+			Email:      u.Email,
+			GoogleId:   u.GoogleId,
+			IsVerified: u.IsVerified,
+			Role:       u.Role,
+			Picture:    u.Picture,
+			Profile:    u.Profile,
+			CreatedAt:  u.CreatedAt,
+			UpdatedAt:  u.UpdatedAt,
+		})
+		about, err := s.users.GetAboutMe(ctx, bson.M{"_id": aboutId(u.ID)})
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.about = append(a.about, aboutRecord{UserId: u.ID, AboutMe: about.AboutMe, ProfilePicture: about.ProfilePicture})
+	}
+
+	posts, err := s.posts.GetBlogPosts(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		a.posts = append(a.posts, postRecord{
+			ID:          p.Id.Hex(),
+			Title:       p.Title,
+			Slug:        p.Slug,
+			AuthorId:    p.AuthorId,
+			Description: p.Description,
+			Content:     p.Content,
+			CoverImage:  p.CoverImage,
+			Tags:        p.Tags,
+			Likes:       likeIds(p.Likes),
+			CreatedAt:   p.CreatedAt,
+			UpdatedAt:   p.UpdatedAt,
+			SourceId:    p.SourceId,
+		})
+	}
+
+	comments, err := s.posts.GetComments(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	// Oldest first, so replies follow the comments they answer.
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].CreatedAt.Before(comments[j].CreatedAt) })
+	for _, c := range comments {
+		r := commentRecord{
+			ID:         c.Id.Hex(),
+			PostId:     c.BlogPostId.Hex(),
+			AuthorId:   c.AuthorId,
+			AuthorName: c.AuthorName,
+			Content:    c.Content,
+			Likes:      likeIds(c.Likes),
+			CreatedAt:  c.CreatedAt,
+			UpdatedAt:  c.UpdatedAt,
+			SourceId:   c.SourceId,
+		}
+		if !c.ParentId.IsZero() {
+			r.ParentId = c.ParentId.Hex()
+		}
+		a.comments = append(a.comments, r)
+	}
+
+	list, err := s.users.GetMailingList(ctx, bson.M{"name": mailingListName})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if list != nil {
+		for _, sub := range list.Subscribers {
+			a.subscribers = append(a.subscribers, subscriberRecord(sub))
+		}
+	}
+
+	a.manifest.Counts = map[string]int{
+		"users":       len(a.users),
+		"posts":       len(a.posts),
+		"comments":    len(a.comments),
+		"about":       len(a.about),
+		"subscribers": len(a.subscribers),
+	}
+	return a, nil
+}
+
+func likeIds(likes []blog.Like) []string {
+	var ids []string
+	for _, l := range likes {
+		ids = append(ids, l.UserId)
+	}
+	return ids
+}
+
+func likesOf(ids []string, mapId func(string) string) []blog.Like {
+	var likes []blog.Like
+	for _, id := range ids {
+		likes = append(likes, blog.Like{UserId: mapId(id)})
+	}
+	return likes
+}
+
+var imageURL = regexp.MustCompile(`(?i)https?://[^\s"'()<>\[\]]+\.(?:png|jpe?g|gif|webp|svg|avif)`)
+
+// mediaURLs lists the distinct media URLs the archive's content refers to.
+func (a *archive) mediaURLs() []string {
+	seen := map[string]bool{}
+	var urls []string
+	add := func(u string) {
+		if u != "" && !seen[u] && (strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "/")) {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, u := range a.users {
+		add(u.Picture)
+		if u.Profile != nil {
+			add(u.Profile.Avatar)
+		}
+	}
+	for _, ab := range a.about {
+		add(ab.ProfilePicture)
+	}
+	for _, p := range a.posts {
+		add(p.CoverImage)
+		for _, u := range imageURL.FindAllString(p.Description+"\n"+p.Content, -1) {
+			add(u)
+		}
+	}
+	return urls
+}
+
+// spoolMedia copies the uploads the content references into files under
+// dir, one at a time, and lists them in the manifest.
+func (s *BackupService) spoolMedia(ctx context.Context, a *archive, dir string) error {
+	for _, u := range a.mediaURLs() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sum := sha256.Sum256([]byte(u))
+		file := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+		contentType, err := s.copyMedia(ctx, u, file)
+		if err != nil {
+			a.manifest.MissingMedia = append(a.manifest.MissingMedia, u)
+			continue
+		}
+		name := mediaDir + hex.EncodeToString(sum[:8]) + mediaExt(u, contentType)
+		a.spooled[name] = file
+		a.manifest.Media = append(a.manifest.Media, MediaEntry{URL: u, Path: name})
+	}
+	return nil
+}
+
+// copyMedia writes the upload at u to file and returns its content type.
+// URLs the library does not hold are never fetched, since post content can
+// point anywhere, including at internal services.
+func (s *BackupService) copyMedia(ctx context.Context, u, file string) (string, error) {
+	if s.library == nil {
+		return "", fmt.Errorf("%s is not a media upload", u)
+	}
+	rc, contentType, err := s.library.OpenURL(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(rc, maxMediaSize+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > maxMediaSize {
+		err = fmt.Errorf("%s: larger than %d bytes", u, maxMediaSize)
+	}
+	return contentType, err
+}
+
+func mediaExt(u, contentType string) string {
+	if ext := path.Ext(strings.SplitN(u, "?", 2)[0]); len(ext) > 1 && len(ext) <= 5 {
+		return strings.ToLower(ext)
+	}
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func emailFilter(email string) bson.M {
+	return bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(email) + "$", Options: "i"}}
+}
@@ -2,7 +2,11 @@ package blog
 
 import (
 	"context"
+	"net/url"
+	"strings"
 
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/user"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -27,30 +31,67 @@ type BlogServices interface {
 	DeleteComment(ctx context.Context, idStr string) error
 	LikeOrUnlikePost(ctx context.Context, postIdStr, userId string, opt PostOption) error
 	LikeOrUnlikeComment(ctx context.Context, commentIdStr, userId string, opt CommnentOption) error
+
+	GetAuthors(ctx context.Context) ([]*user.Byline, error)
+	GetAuthorBySlug(ctx context.Context, slug string) (*user.Byline, []*BlogPost, error)
 }
 
 func NewBlogController(service BlogServices) *BlogController {
 	return &BlogController{service}
 }
 
+// canModify reports whether the current user may change a resource owned by
+// ownerId, either through anyPerm or through ownPerm as its owner.
+func canModify(c *gin.Context, ownerId string, anyPerm, ownPerm user.Permission) bool {
+	if user.HasPermission(c, anyPerm) {
+		return true
+	}
+	return ownerId != "" && ownerId == c.GetString("user_id") && user.HasPermission(c, ownPerm)
+}
+
+// validImageURL accepts an empty URL, an absolute http(s) URL or a path
+// such as a local upload's.
+func validImageURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(u.Path, "/")
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 func (controller *BlogController) CreateBlogPost(c *gin.Context) {
 	req := struct {
-		Title       string `json:"title" binding:"required"`
-		Content     string `json:"content" binding:"required"`
-		Description string `json:"description" binding:"required"`
+		Title       string   `json:"title" binding:"required"`
+		Content     string   `json:"content" binding:"required"`
+		Description string   `json:"description" binding:"required"`
+		CoverImage  string   `json:"cover_image"`
+		Tags        []string `json:"tags"`
 	}{}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	if !validImageURL(req.CoverImage) {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "cover_image must be an http(s) URL or a path", nil))
 		return
 	}
 	bp := &BlogPost{
 		Title:       req.Title,
+		AuthorId:    c.GetString("user_id"),
 		Content:     req.Content,
 		Description: req.Description,
+		CoverImage:  req.CoverImage,
+		Tags:        req.Tags,
 	}
 
 	if err := controller.service.CreateBlogPost(c, bp); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Blog post created successfully"})
@@ -59,7 +100,7 @@ func (controller *BlogController) CreateBlogPost(c *gin.Context) {
 func (controller *BlogController) GetBlogPosts(c *gin.Context) {
 	posts, err := controller.service.GetBlogPosts(c)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": posts})
@@ -68,12 +109,12 @@ func (controller *BlogController) GetBlogPosts(c *gin.Context) {
 func (controller *BlogController) Search(c *gin.Context) {
 	q := c.Query("q")
 	if q == "" {
-		c.JSON(400, gin.H{"error": gin.H{"message": "query param is required"}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "query param is required", nil))
 		return
 	}
 	bp, err := controller.service.SearchBlogPosts(c, q)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": bp})
@@ -83,7 +124,7 @@ func (controller *BlogController) GetBlogPostByID(c *gin.Context) {
 	id := c.Param("id")
 	post, err := controller.service.GetBlogPostByID(c, id)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": post})
@@ -92,7 +133,7 @@ func (controller *BlogController) GetBlogPostBySlug(c *gin.Context) {
 	slug := c.Param("slug")
 	post, err := controller.service.GetBlogPostBySlug(c, slug)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": post})
@@ -102,23 +143,40 @@ func (controller *BlogController) UpdateBlogPost(c *gin.Context) {
 	id := c.Param("id")
 	post, err := controller.service.GetBlogPostByID(c, id)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
+		return
+	}
+	if !canModify(c, post.AuthorId, user.PermEditAnyPost, user.PermEditOwnPost) {
+		c.Error(apperrors.Forbidden(apperrors.CodeForbidden, "you can only edit your own posts", nil))
 		return
 	}
 	req := struct {
 		Title       string `json:"title" binding:"required"`
 		Content     string `json:"content" binding:"required"`
 		Description string `json:"description" binding:"required"`
+		// CoverImage is kept when omitted and removed when empty.
+		CoverImage *string  `json:"cover_image"`
+		Tags       []string `json:"tags"`
 	}{}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
 		return
 	}
+	if req.CoverImage != nil {
+		if !validImageURL(*req.CoverImage) {
+			c.Error(apperrors.BadRequest(apperrors.CodeValidation, "cover_image must be an http(s) URL or a path", nil))
+			return
+		}
+		post.CoverImage = *req.CoverImage
+	}
 	post.Title = req.Title
 	post.Content = req.Content
 	post.Description = req.Description
+	if req.Tags != nil {
+		post.Tags = req.Tags
+	}
 	if err := controller.service.UpdateBlogPost(c, post); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Blog post updated successfully"})
@@ -126,8 +184,17 @@ func (controller *BlogController) UpdateBlogPost(c *gin.Context) {
 
 func (controller *BlogController) DeleteBlogPost(c *gin.Context) {
 	id := c.Param("id")
+	post, err := controller.service.GetBlogPostByID(c, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !canModify(c, post.AuthorId, user.PermDeleteAnyPost, user.PermDeleteOwnPost) {
+		c.Error(apperrors.Forbidden(apperrors.CodeForbidden, "you can only delete your own posts", nil))
+		return
+	}
 	if err := controller.service.DeleteBlogPost(c, id); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Blog post deleted successfully"})
@@ -136,7 +203,7 @@ func (controller *BlogController) DeleteBlogPost(c *gin.Context) {
 func (controller *BlogController) LikeOrUnlikePost(c *gin.Context) {
 	userid, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	req := struct {
@@ -144,11 +211,11 @@ func (controller *BlogController) LikeOrUnlikePost(c *gin.Context) {
 		Option PostOption `json:"option" binding:"required"`
 	}{}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
 		return
 	}
 	if err := controller.service.LikeOrUnlikePost(c, req.ID, userid.(string), req.Option); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	if req.Option == LikePost {
@@ -161,7 +228,7 @@ func (controller *BlogController) LikeOrUnlikePost(c *gin.Context) {
 func (controller *BlogController) LikeOrUnlikeComment(c *gin.Context) {
 	userid, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	req := struct {
@@ -169,11 +236,11 @@ func (controller *BlogController) LikeOrUnlikeComment(c *gin.Context) {
 		Option CommnentOption `json:"option" binding:"required"`
 	}{}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
 		return
 	}
 	if err := controller.service.LikeOrUnlikeComment(c, req.ID, userid.(string), req.Option); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	if req.Option == LikeComment {
@@ -186,7 +253,7 @@ func (controller *BlogController) LikeOrUnlikeComment(c *gin.Context) {
 func (controller *BlogController) PostComment(c *gin.Context) {
 	userid, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	req := struct {
@@ -195,12 +262,12 @@ func (controller *BlogController) PostComment(c *gin.Context) {
 		Content    string `json:"content" binding:"required"`
 	}{}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
 		return
 	}
 	blogPostId, err := primitive.ObjectIDFromHex(req.BlogPostId)
 	if err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeInvalidID, "invalid id", err))
 		return
 	}
 	comment := &Comment{
@@ -211,14 +278,14 @@ func (controller *BlogController) PostComment(c *gin.Context) {
 	if req.ParentId != "" {
 		parentId, err := primitive.ObjectIDFromHex(req.ParentId)
 		if err != nil {
-			c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+			c.Error(apperrors.BadRequest(apperrors.CodeInvalidID, "invalid id", err))
 			return
 		}
 		comment.ParentId = parentId
 	}
 
 	if err := controller.service.PostComment(c, comment); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Comment posted successfully"})
@@ -228,7 +295,7 @@ func (controller *BlogController) GetComments(c *gin.Context) {
 	postId := c.Param("postId")
 	comments, err := controller.service.GetComments(c, postId)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": comments})
@@ -238,7 +305,7 @@ func (controller *BlogController) GetComment(c *gin.Context) {
 	id := c.Param("id")
 	comment, err := controller.service.GetComment(c, id)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": comment})
@@ -247,20 +314,20 @@ func (controller *BlogController) GetComment(c *gin.Context) {
 func (controller *BlogController) UpdateComment(c *gin.Context) {
 	userid, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	idStr := c.Param("id")
 	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeInvalidID, "invalid id", err))
 		return
 	}
 	req := struct {
 		Content string `json:"content" binding:"required"`
 	}{}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
 		return
 	}
 	comment := &Comment{
@@ -269,8 +336,47 @@ func (controller *BlogController) UpdateComment(c *gin.Context) {
 		Content:  req.Content,
 	}
 	if err := controller.service.UpdateComment(c, comment); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Comment updated successfully"})
 }
+
+// DeleteComment lets authors remove their own comments and moderators remove
+// any comment. API keys only reach it through the moderation route, which
+// checks the comments:moderate scope.
+func (controller *BlogController) DeleteComment(c *gin.Context) {
+	id := c.Param("id")
+	comment, err := controller.service.GetComment(c, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if comment.AuthorId != c.GetString("user_id") && !user.HasPermission(c, user.PermModerateComments) {
+		c.Error(apperrors.Forbidden(apperrors.CodeForbidden, "you can only delete your own comments", nil))
+		return
+	}
+	if err := controller.service.DeleteComment(c, id); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"message": "Comment deleted successfully"})
+}
+
+func (controller *BlogController) GetAuthors(c *gin.Context) {
+	authors, err := controller.service.GetAuthors(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": authors})
+}
+
+func (controller *BlogController) GetAuthorBySlug(c *gin.Context) {
+	author, posts, err := controller.service.GetAuthorBySlug(c, c.Param("slug"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": gin.H{"author": author, "posts": posts}})
+}
@@ -0,0 +1,63 @@
+package blog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+// commentService holds a single comment written by "reader1".
+type commentService struct {
+	BlogServices
+	deleted bool
+}
+
+func (s *commentService) GetComment(ctx context.Context, idStr string) (*Comment, error) {
+	if idStr != "c1" {
+		return nil, errors.New("comment not found")
+	}
+	return &Comment{AuthorId: "reader1", Content: "hi"}, nil
+}
+
+func (s *commentService) DeleteComment(ctx context.Context, idStr string) error {
+	s.deleted = true
+	return nil
+}
+
+func TestDeleteComment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		userId string
+		role   user.Role
+		want   int
+	}{
+		{"owner", "reader1", user.Reader, http.StatusOK},
+		{"other reader", "reader2", user.Reader, http.StatusForbidden},
+		{"author", "author1", user.Author, http.StatusForbidden},
+		{"moderator", "mod1", user.Moderator, http.StatusOK},
+		{"editor", "editor1", user.Editor, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &commentService{}
+			r := gin.New()
+			r.Use(apperrors.ErrorHandler())
+			r.DELETE("/comment/:id", func(c *gin.Context) {
+				c.Set("user_id", tt.userId)
+				c.Set("role", tt.role)
+			}, NewBlogController(service).DeleteComment)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/comment/c1", nil))
+			if w.Code != tt.want || service.deleted != (tt.want == http.StatusOK) {
+				t.Errorf("status %d, deleted %v; want %d", w.Code, service.deleted, tt.want)
+			}
+		})
+	}
+}
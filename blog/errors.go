@@ -0,0 +1,29 @@
+package blog
+
+import (
+	"errors"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+)
+
+const (
+	CodePostNotFound    = "post_not_found"
+	CodeCommentNotFound = "comment_not_found"
+	CodeAuthorNotFound  = "author_not_found"
+	CodeAlreadyLiked    = "already_liked"
+	CodeNotLiked        = "not_liked"
+	CodeInvalidOption   = "invalid_option"
+	CodeSlugTaken       = "slug_taken"
+)
+
+func invalidID(err error) error {
+	return apperrors.BadRequest(apperrors.CodeInvalidID, "invalid id", err)
+}
+
+func internal(err error) error {
+	var appErr *apperrors.AppError
+	if err == nil || errors.As(err, &appErr) {
+		return err
+	}
+	return apperrors.Internal(err)
+}
@@ -3,6 +3,9 @@ package blog
 import (
 	"context"
 	"errors"
+
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/ayo-ajayi/bloggy/user"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,33 +17,43 @@ type BlogPost struct {
 	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Title       string             `json:"title,omitempty" bson:"title,omitempty"`
 	Slug        string             `json:"slug,omitempty" bson:"slug,omitempty"`
+	AuthorId    string             `json:"author_id,omitempty" bson:"author_id,omitempty"`
+	Author      *user.Byline       `json:"author,omitempty" bson:"-"`
 	Description string             `json:"description,omitempty" bson:"description,omitempty"`
 	Content     string             `json:"content,omitempty" bson:"content,omitempty"`
+	CoverImage  string             `json:"cover_image,omitempty" bson:"cover_image,omitempty"`
+	Tags        []string           `json:"tags,omitempty" bson:"tags,omitempty"`
 	Likes       []Like             `json:"likes,omitempty" bson:"likes,omitempty"`
 	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// SourceId identifies an imported post in the system it came from, as
+	// "<system>:<id>", so re-running an import does not duplicate it.
+	SourceId string `json:"-" bson:"source_id,omitempty"`
 }
 
 type Comment struct {
-	Id         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	AuthorId   string             `json:"author_id,omitempty" bson:"author_id,omitempty"`
+	Id       primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	AuthorId string             `json:"author_id,omitempty" bson:"author_id,omitempty"`
+	// AuthorName is kept for imported comments whose author has no account.
+	AuthorName string             `json:"author_name,omitempty" bson:"author_name,omitempty"`
 	BlogPostId primitive.ObjectID `json:"blog_post_id,omitempty" bson:"blog_post_id,omitempty"`
 	ParentId   primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
 	Content    string             `json:"content,omitempty" bson:"content,omitempty"`
 	Likes      []Like             `json:"likes,omitempty" bson:"likes,omitempty"`
 	CreatedAt  time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt  time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	SourceId   string             `json:"-" bson:"source_id,omitempty"`
 }
 type Like struct {
 	UserId string `json:"user_id,omitempty" bson:"user_id,omitempty"`
 }
 
 type BlogRepo struct {
-	blogCollection    *mongo.Collection
-	commentCollection *mongo.Collection
+	blogCollection    db.Collection
+	commentCollection db.Collection
 }
 
-func NewBlogRepo(blogCollection, commentCollection *mongo.Collection) *BlogRepo {
+func NewBlogRepo(blogCollection, commentCollection db.Collection) *BlogRepo {
 	return &BlogRepo{blogCollection, commentCollection}
 }
 
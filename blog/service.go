@@ -2,9 +2,11 @@ package blog
 
 import (
 	"context"
-	"errors"
-	"time"
+	"regexp"
 
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/user"
 	"github.com/gosimple/slug"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,7 +15,94 @@ import (
 )
 
 type BlogService struct {
-	repo BlogRepository
+	repo     BlogRepository
+	authors  AuthorDirectory
+	recorder Recorder
+	media    MediaReferences
+	cache    Invalidator
+	notifier Notifier
+	clock    clock.Clock
+}
+
+// Cache tags of responses built from posts. Every such response carries
+// TagPosts; listings also carry TagPostLists, and single posts PostTag and
+// PostSlugTag, so that likes only drop the responses they show up in.
+const (
+	TagPosts     = "posts"
+	TagPostLists = "posts:lists"
+)
+
+// PostTag is the cache tag of a post fetched by id.
+func PostTag(id primitive.ObjectID) string {
+	return "post:" + id.Hex()
+}
+
+// PostSlugTag is the cache tag of a post fetched by slug.
+func PostSlugTag(slug string) string {
+	return "post-slug:" + slug
+}
+
+// CommentsTag is the cache tag of the comments on a post.
+func CommentsTag(postId primitive.ObjectID) string {
+	return "comments:" + postId.Hex()
+}
+
+// Invalidator drops cached responses when the data behind them changes.
+type Invalidator interface {
+	Invalidate(ctx context.Context, tags ...string)
+}
+
+type noopInvalidator struct{}
+
+func (noopInvalidator) Invalidate(ctx context.Context, tags ...string) {}
+
+// Events sent to the Notifier. Imported posts and comments are not
+// announced.
+const (
+	EventPostPublished  = "post.published"
+	EventPostUpdated    = "post.updated"
+	EventPostDeleted    = "post.deleted"
+	EventCommentCreated = "comment.created"
+	EventPostLiked      = "post.liked"
+	EventCommentLiked   = "comment.liked"
+)
+
+// Events lists every event BlogService sends.
+var Events = []string{EventPostPublished, EventPostUpdated, EventPostDeleted, EventCommentCreated, EventPostLiked, EventCommentLiked}
+
+// Notifier tells systems outside the blog, such as webhook subscribers,
+// about changes.
+type Notifier interface {
+	Notify(ctx context.Context, event string, data interface{})
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(ctx context.Context, event string, data interface{}) {}
+
+// MediaReferences tracks which uploads a post uses, so the media library
+// does not delete them from under it.
+type MediaReferences interface {
+	SetReferences(ctx context.Context, ref string, urls ...string) error
+}
+
+// Recorder receives engagement events, for example to count them in metrics.
+type Recorder interface {
+	PostCreated()
+	CommentPosted()
+	Liked(target, action string)
+}
+
+type noopRecorder struct{}
+
+func (noopRecorder) PostCreated()                {}
+func (noopRecorder) CommentPosted()              {}
+func (noopRecorder) Liked(target, action string) {}
+
+type AuthorDirectory interface {
+	GetAuthors(ctx context.Context) ([]*user.Byline, error)
+	GetAuthorBySlug(ctx context.Context, slug string) (*user.Byline, error)
+	GetAuthorsByIds(ctx context.Context, ids []string) (map[string]*user.Byline, error)
 }
 
 type BlogRepository interface {
@@ -30,32 +119,157 @@ type BlogRepository interface {
 	DeleteComment(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
 }
 
-func NewBlogService(repo BlogRepository) *BlogService {
-	return &BlogService{repo}
+// NewBlogService returns a service over repo. recorder, media, cache and
+// notifier may be nil.
+func NewBlogService(repo BlogRepository, authors AuthorDirectory, recorder Recorder, media MediaReferences, cache Invalidator, notifier Notifier, clk clock.Clock) *BlogService {
+	if recorder == nil {
+		recorder = noopRecorder{}
+	}
+	if cache == nil {
+		cache = noopInvalidator{}
+	}
+	if notifier == nil {
+		notifier = noopNotifier{}
+	}
+	return &BlogService{repo, authors, recorder, media, cache, notifier, clock.OrSystem(clk)}
+}
+
+var imageURL = regexp.MustCompile(`(?i)(?:https?://|/)[^\s"'()<>\[\]]+\.(?:png|jpe?g|gif|webp|avif|svg)`)
+
+// MediaURLs returns the images a post uses: its cover and those linked from
+// its description and content.
+func MediaURLs(post *BlogPost) []string {
+	urls := []string{post.CoverImage}
+	return append(urls, imageURL.FindAllString(post.Description+"\n"+post.Content, -1)...)
+}
+
+// MediaRef is the media reference recorded for the post with id.
+func MediaRef(id primitive.ObjectID) string {
+	return "post:" + id.Hex()
+}
+
+// setMediaReferences records the images a post uses. A nil post releases
+// them all.
+func (service *BlogService) setMediaReferences(ctx context.Context, id primitive.ObjectID, post *BlogPost) error {
+	if service.media == nil {
+		return nil
+	}
+	var urls []string
+	if post != nil {
+		urls = MediaURLs(post)
+	}
+	return internal(service.media.SetReferences(ctx, MediaRef(id), urls...))
+}
+
+// attachBylines fills in the author byline of each post.
+func (service *BlogService) attachBylines(ctx context.Context, posts ...*BlogPost) error {
+	if service.authors == nil {
+		return nil
+	}
+	var ids []string
+	seen := map[string]bool{}
+	for _, p := range posts {
+		if p != nil && p.AuthorId != "" && !seen[p.AuthorId] {
+			seen[p.AuthorId] = true
+			ids = append(ids, p.AuthorId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	authors, err := service.authors.GetAuthorsByIds(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if p != nil {
+			p.Author = authors[p.AuthorId]
+		}
+	}
+	return nil
 }
 
 func (service *BlogService) CreateBlogPost(ctx context.Context, blogPost *BlogPost) error {
 	blogPost.Slug = slug.Make(blogPost.Title)
 	p, err := service.repo.GetBlogPost(ctx, bson.M{"slug": blogPost.Slug})
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-		} else {
-			return err
-		}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return internal(err)
 	}
 	if p != nil {
 		blogPost.Slug = blogPost.Slug + "-" + primitive.NewObjectID().Hex()
 	}
-	blogPost.CreatedAt = time.Now()
-	blogPost.UpdatedAt = time.Now()
-	_, err = service.repo.CreateBlogPost(ctx, blogPost)
-	return err
+	blogPost.CreatedAt = service.clock.Now()
+	blogPost.UpdatedAt = blogPost.CreatedAt
+	res, err := service.repo.CreateBlogPost(ctx, blogPost)
+	if err != nil {
+		return internal(err)
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		blogPost.Id = id
+	}
+	service.cache.Invalidate(ctx, TagPosts)
+	service.recorder.PostCreated()
+	service.notifier.Notify(ctx, EventPostPublished, blogPost)
+	return service.setMediaReferences(ctx, blogPost.Id, blogPost)
+}
+
+// ImportBlogPost stores a post from another system as is, keeping its slug
+// and timestamps when they are set. A post with the same slug is a conflict.
+func (service *BlogService) ImportBlogPost(ctx context.Context, blogPost *BlogPost) error {
+	if blogPost.Slug == "" {
+		blogPost.Slug = slug.Make(blogPost.Title)
+	}
+	_, err := service.repo.GetBlogPost(ctx, bson.M{"slug": blogPost.Slug})
+	if err == nil {
+		return apperrors.Conflict(CodeSlugTaken, "a post with slug "+blogPost.Slug+" already exists", nil)
+	}
+	if err != mongo.ErrNoDocuments {
+		return internal(err)
+	}
+	if blogPost.CreatedAt.IsZero() {
+		blogPost.CreatedAt = service.clock.Now()
+	}
+	if blogPost.UpdatedAt.IsZero() {
+		blogPost.UpdatedAt = blogPost.CreatedAt
+	}
+	res, err := service.repo.CreateBlogPost(ctx, blogPost)
+	if err != nil {
+		return internal(err)
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		blogPost.Id = id
+	}
+	service.cache.Invalidate(ctx, TagPosts)
+	return service.setMediaReferences(ctx, blogPost.Id, blogPost)
+}
+
+// ImportComment stores a comment from another system, keeping its
+// timestamps when they are set.
+func (service *BlogService) ImportComment(ctx context.Context, comment *Comment) error {
+	if comment.CreatedAt.IsZero() {
+		comment.CreatedAt = service.clock.Now()
+	}
+	if comment.UpdatedAt.IsZero() {
+		comment.UpdatedAt = comment.CreatedAt
+	}
+	res, err := service.repo.PostComment(ctx, comment)
+	if err != nil {
+		return internal(err)
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		comment.Id = id
+	}
+	service.cache.Invalidate(ctx, CommentsTag(comment.BlogPostId))
+	return nil
 }
 
 func (service *BlogService) GetBlogPosts(ctx context.Context) ([]*BlogPost, error) {
 	posts, err := service.repo.GetBlogPosts(ctx, bson.M{})
 	if err != nil {
-		return nil, err
+		return nil, internal(err)
+	}
+	if err := service.attachBylines(ctx, posts...); err != nil {
+		return nil, internal(err)
 	}
 	return posts, nil
 }
@@ -63,11 +277,14 @@ func (service *BlogService) GetBlogPosts(ctx context.Context) ([]*BlogPost, erro
 func (service *BlogService) GetBlogPostByID(ctx context.Context, idStr string) (*BlogPost, error) {
 	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
-		return nil, err
+		return nil, invalidID(err)
 	}
 	post, err := service.repo.GetBlogPost(ctx, bson.M{"_id": id})
 	if err != nil {
-		return nil, err
+		return nil, apperrors.NotFoundOr(err, CodePostNotFound, "blog post not found")
+	}
+	if err := service.attachBylines(ctx, post); err != nil {
+		return nil, internal(err)
 	}
 	return post, nil
 }
@@ -75,7 +292,10 @@ func (service *BlogService) GetBlogPostByID(ctx context.Context, idStr string) (
 func (service *BlogService) GetBlogPostBySlug(ctx context.Context, slug string) (*BlogPost, error) {
 	post, err := service.repo.GetBlogPost(ctx, bson.M{"slug": slug})
 	if err != nil {
-		return nil, err
+		return nil, apperrors.NotFoundOr(err, CodePostNotFound, "blog post not found")
+	}
+	if err := service.attachBylines(ctx, post); err != nil {
+		return nil, internal(err)
 	}
 	return post, nil
 }
@@ -83,44 +303,111 @@ func (service *BlogService) GetBlogPostBySlug(ctx context.Context, slug string)
 func (service *BlogService) UpdateBlogPost(ctx context.Context, blogPost *BlogPost) error {
 	oldPost, err := service.repo.GetBlogPost(ctx, bson.M{"_id": blogPost.Id})
 	if err != nil {
-		return err
+		return apperrors.NotFoundOr(err, CodePostNotFound, "blog post not found")
 	}
 	blogPost.CreatedAt = oldPost.CreatedAt
 	blogPost.Likes = oldPost.Likes
-	blogPost.UpdatedAt = time.Now()
+	blogPost.UpdatedAt = service.clock.Now()
 	blogPost.Slug = slug.Make(blogPost.Title)
-	_, err = service.repo.UpdateBlogPost(ctx, bson.M{"_id": blogPost.Id}, bson.M{"$set": blogPost})
-	return err
+	update := bson.M{"$set": blogPost}
+	// $set skips the omitempty fields, so clear them explicitly.
+	unset := bson.M{}
+	if len(blogPost.Tags) == 0 {
+		unset["tags"] = ""
+	}
+	if blogPost.CoverImage == "" {
+		unset["cover_image"] = ""
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if _, err = service.repo.UpdateBlogPost(ctx, bson.M{"_id": blogPost.Id}, update); err != nil {
+		return internal(err)
+	}
+	service.cache.Invalidate(ctx, TagPosts)
+	service.notifier.Notify(ctx, EventPostUpdated, blogPost)
+	return service.setMediaReferences(ctx, blogPost.Id, blogPost)
 }
 
 func (service *BlogService) DeleteBlogPost(ctx context.Context, idStr string) error {
 	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
-		return err
+		return invalidID(err)
+	}
+	res, err := service.repo.DeleteBlogPost(ctx, bson.M{"_id": id})
+	if err != nil {
+		return internal(err)
 	}
-	_, err = service.repo.DeleteBlogPost(ctx, bson.M{"_id": id})
-	return err
+	if res.DeletedCount == 0 {
+		return apperrors.NotFound(CodePostNotFound, "blog post not found", nil)
+	}
+	service.cache.Invalidate(ctx, TagPosts, CommentsTag(id))
+	service.notifier.Notify(ctx, EventPostDeleted, bson.M{"id": id})
+	return service.setMediaReferences(ctx, id, nil)
 }
 
 func (service *BlogService) SearchBlogPosts(ctx context.Context, query string) ([]*BlogPost, error) {
-	return service.repo.SearchBlogPosts(ctx, query)
+	posts, err := service.repo.SearchBlogPosts(ctx, query)
+	if err != nil {
+		return nil, internal(err)
+	}
+	if err := service.attachBylines(ctx, posts...); err != nil {
+		return nil, internal(err)
+	}
+	return posts, nil
+}
+
+func (service *BlogService) GetAuthors(ctx context.Context) ([]*user.Byline, error) {
+	authors, err := service.authors.GetAuthors(ctx)
+	if err != nil {
+		return nil, internal(err)
+	}
+	return authors, nil
+}
+
+// GetAuthorBySlug returns the author's public profile with their posts.
+func (service *BlogService) GetAuthorBySlug(ctx context.Context, slug string) (*user.Byline, []*BlogPost, error) {
+	author, err := service.authors.GetAuthorBySlug(ctx, slug)
+	if err != nil {
+		return nil, nil, apperrors.NotFoundOr(err, CodeAuthorNotFound, "author not found")
+	}
+	posts, err := service.repo.GetBlogPosts(ctx, bson.M{"author_id": author.ID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, nil, internal(err)
+	}
+	for _, p := range posts {
+		p.Author = author
+	}
+	return author, posts, nil
 }
 
 func (service *BlogService) PostComment(ctx context.Context, comment *Comment) error {
-	comment.CreatedAt = time.Now()
-	comment.UpdatedAt = time.Now()
-	_, err := service.repo.PostComment(ctx, comment)
-	return err
+	if _, err := service.repo.GetBlogPost(ctx, bson.M{"_id": comment.BlogPostId}); err != nil {
+		return apperrors.NotFoundOr(err, CodePostNotFound, "blog post not found")
+	}
+	comment.CreatedAt = service.clock.Now()
+	comment.UpdatedAt = comment.CreatedAt
+	res, err := service.repo.PostComment(ctx, comment)
+	if err != nil {
+		return internal(err)
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		comment.Id = id
+	}
+	service.cache.Invalidate(ctx, CommentsTag(comment.BlogPostId))
+	service.recorder.CommentPosted()
+	service.notifier.Notify(ctx, EventCommentCreated, comment)
+	return nil
 }
 
 func (service *BlogService) GetComments(ctx context.Context, postIdStr string) ([]*Comment, error) {
 	postId, err := primitive.ObjectIDFromHex(postIdStr)
 	if err != nil {
-		return nil, err
+		return nil, invalidID(err)
 	}
 	comments, err := service.repo.GetComments(ctx, bson.M{"blog_post_id": postId})
 	if err != nil {
-		return nil, err
+		return nil, internal(err)
 	}
 	return comments, nil
 }
@@ -128,11 +415,11 @@ func (service *BlogService) GetComments(ctx context.Context, postIdStr string) (
 func (service *BlogService) GetComment(ctx context.Context, idStr string) (*Comment, error) {
 	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
-		return nil, err
+		return nil, invalidID(err)
 	}
 	comment, err := service.repo.GetComment(ctx, bson.M{"_id": id})
 	if err != nil {
-		return nil, err
+		return nil, apperrors.NotFoundOr(err, CodeCommentNotFound, "comment not found")
 	}
 	return comment, nil
 }
@@ -140,60 +427,81 @@ func (service *BlogService) GetComment(ctx context.Context, idStr string) (*Comm
 func (service *BlogService) UpdateComment(ctx context.Context, comment *Comment) error {
 	old, err := service.repo.GetComment(ctx, bson.M{"_id": comment.Id, "author_id": comment.AuthorId})
 	if err != nil {
-		return err
+		return apperrors.NotFoundOr(err, CodeCommentNotFound, "comment not found")
 	}
 	comment.CreatedAt = old.CreatedAt
 	comment.BlogPostId = old.BlogPostId
 	comment.ParentId = old.ParentId
 	comment.Likes = old.Likes
-	comment.UpdatedAt = time.Now()
+	comment.UpdatedAt = service.clock.Now()
 
-	_, err = service.repo.UpdateComment(ctx, bson.M{"_id": comment.Id}, bson.M{"$set": comment})
-	return err
+	if _, err = service.repo.UpdateComment(ctx, bson.M{"_id": comment.Id}, bson.M{"$set": comment}); err != nil {
+		return internal(err)
+	}
+	service.cache.Invalidate(ctx, CommentsTag(comment.BlogPostId))
+	return nil
 }
 
 func (service *BlogService) DeleteComment(ctx context.Context, idStr string) error {
 	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
-		return err
+		return invalidID(err)
+	}
+	comment, err := service.repo.GetComment(ctx, bson.M{"_id": id})
+	if err != nil {
+		return apperrors.NotFoundOr(err, CodeCommentNotFound, "comment not found")
+	}
+	res, err := service.repo.DeleteComment(ctx, bson.M{"_id": id})
+	if err != nil {
+		return internal(err)
+	}
+	if res.DeletedCount == 0 {
+		return apperrors.NotFound(CodeCommentNotFound, "comment not found", nil)
 	}
-	_, err = service.repo.DeleteComment(ctx, bson.M{"_id": id})
-	return err
+	service.cache.Invalidate(ctx, CommentsTag(comment.BlogPostId))
+	return nil
 }
 
 func (service *BlogService) LikeOrUnlikePost(ctx context.Context, postIdStr, userId string, opt PostOption) error {
 	postId, err := primitive.ObjectIDFromHex(postIdStr)
+	if err != nil {
+		return invalidID(err)
+	}
+	if opt != LikePost && opt != UnlikePost {
+		return apperrors.BadRequest(CodeInvalidOption, "option must be like or unlike", nil)
+	}
+	post, err := service.repo.GetBlogPost(ctx, bson.M{"_id": postId})
+	if err != nil {
+		return apperrors.NotFoundOr(err, CodePostNotFound, "blog post not found")
+	}
+	if opt == LikePost {
+		err = service.likePost(ctx, post, userId)
+	} else {
+		err = service.unlikePost(ctx, post, userId)
+	}
 	if err != nil {
 		return err
 	}
+	service.cache.Invalidate(ctx, PostTag(post.Id), PostSlugTag(post.Slug), TagPostLists)
+	service.recorder.Liked("post", string(opt))
 	if opt == LikePost {
-		return service.likePost(ctx, postId, userId)
-	} else if opt == UnlikePost {
-		return service.unlikePost(ctx, postId, userId)
+		service.notifier.Notify(ctx, EventPostLiked, bson.M{"post_id": postId, "user_id": userId})
 	}
 	return nil
 }
 
-func (service *BlogService) likePost(ctx context.Context, postId primitive.ObjectID, userId string) error {
-	post, err := service.repo.GetBlogPost(ctx, bson.M{"_id": postId})
-	if err != nil {
-		return err
-	}
+func (service *BlogService) likePost(ctx context.Context, post *BlogPost, userId string) error {
 	for _, like := range post.Likes {
 		if like.UserId == userId {
-			return errors.New("already liked post")
+			return apperrors.Conflict(CodeAlreadyLiked, "already liked post", nil)
 		}
 	}
 	post.Likes = append(post.Likes, Like{UserId: userId})
-	_, err = service.repo.UpdateBlogPost(ctx, bson.M{"_id": postId}, bson.M{"$set": post})
-	return err
+	_, err := service.repo.UpdateBlogPost(ctx, bson.M{"_id": post.Id}, bson.M{"$set": post})
+	return internal(err)
 }
 
-func (service *BlogService) unlikePost(ctx context.Context, postId primitive.ObjectID, userId string) error {
-	post, err := service.repo.GetBlogPost(ctx, bson.M{"_id": postId})
-	if err != nil {
-		return err
-	}
+func (service *BlogService) unlikePost(ctx context.Context, post *BlogPost, userId string) error {
 	currentlylikesPost := false
 	var updatedLikes []Like
 	for _, like := range post.Likes {
@@ -204,11 +512,11 @@ func (service *BlogService) unlikePost(ctx context.Context, postId primitive.Obj
 		}
 	}
 	if !currentlylikesPost {
-		return errors.New("post is not currently liked")
+		return apperrors.Conflict(CodeNotLiked, "post is not currently liked", nil)
 	}
 	post.Likes = updatedLikes
-	_, err = service.repo.UpdateBlogPost(ctx, bson.M{"_id": postId}, bson.M{"$set": bson.M{"likes": post.Likes}})
-	return err
+	_, err := service.repo.UpdateBlogPost(ctx, bson.M{"_id": post.Id}, bson.M{"$set": bson.M{"likes": post.Likes}})
+	return internal(err)
 }
 
 type PostOption string
@@ -224,35 +532,45 @@ const UnlikeComment CommnentOption = "unlike"
 func (service *BlogService) LikeOrUnlikeComment(ctx context.Context, commentIdStr, userId string, opt CommnentOption) error {
 	postId, err := primitive.ObjectIDFromHex(commentIdStr)
 	if err != nil {
-		return err
+		return invalidID(err)
 	}
 	if opt == LikeComment {
-		return service.likeComment(ctx, postId, userId)
+		err = service.likeComment(ctx, postId, userId)
 	} else if opt == UnlikeComment {
-		return service.unlikeComment(ctx, postId, userId)
+		err = service.unlikeComment(ctx, postId, userId)
+	} else {
+		return apperrors.BadRequest(CodeInvalidOption, "option must be like or unlike", nil)
 	}
+	if err != nil {
+		return err
+	}
+	service.recorder.Liked("comment", string(opt))
 	return nil
 }
 
 func (service *BlogService) likeComment(ctx context.Context, commentId primitive.ObjectID, userId string) error {
 	comment, err := service.repo.GetComment(ctx, bson.M{"_id": commentId})
 	if err != nil {
-		return err
+		return apperrors.NotFoundOr(err, CodeCommentNotFound, "comment not found")
 	}
 	for _, like := range comment.Likes {
 		if like.UserId == userId {
-			return errors.New("already liked comment")
+			return apperrors.Conflict(CodeAlreadyLiked, "already liked comment", nil)
 		}
 	}
 	comment.Likes = append(comment.Likes, Like{UserId: userId})
-	_, err = service.repo.UpdateComment(ctx, bson.M{"_id": commentId}, bson.M{"$set": comment})
-	return err
+	if _, err = service.repo.UpdateComment(ctx, bson.M{"_id": commentId}, bson.M{"$set": comment}); err != nil {
+		return internal(err)
+	}
+	service.cache.Invalidate(ctx, CommentsTag(comment.BlogPostId))
+	service.notifier.Notify(ctx, EventCommentLiked, bson.M{"comment_id": commentId, "post_id": comment.BlogPostId, "user_id": userId})
+	return nil
 }
 
 func (service *BlogService) unlikeComment(ctx context.Context, commentId primitive.ObjectID, userId string) error {
 	comment, err := service.repo.GetComment(ctx, bson.M{"_id": commentId})
 	if err != nil {
-		return err
+		return apperrors.NotFoundOr(err, CodeCommentNotFound, "comment not found")
 	}
 	currentlylikesComment := false
 	var updatedLikes []Like
@@ -264,9 +582,12 @@ func (service *BlogService) unlikeComment(ctx context.Context, commentId primiti
 		}
 	}
 	if !currentlylikesComment {
-		return errors.New("comment is not currently liked")
+		return apperrors.Conflict(CodeNotLiked, "comment is not currently liked", nil)
 	}
 	comment.Likes = updatedLikes
-	_, err = service.repo.UpdateComment(ctx, bson.M{"_id": commentId}, bson.M{"$set": bson.M{"likes": comment.Likes}})
-	return err
+	if _, err = service.repo.UpdateComment(ctx, bson.M{"_id": commentId}, bson.M{"$set": bson.M{"likes": comment.Likes}}); err != nil {
+		return internal(err)
+	}
+	service.cache.Invalidate(ctx, CommentsTag(comment.BlogPostId))
+	return nil
 }
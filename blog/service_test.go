@@ -0,0 +1,76 @@
+package blog
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+)
+
+// mediaRefs records the URLs each reference was last set to.
+type mediaRefs map[string][]string
+
+func (m mediaRefs) SetReferences(ctx context.Context, ref string, urls ...string) error {
+	var kept []string
+	for _, u := range urls {
+		if u != "" {
+			kept = append(kept, u)
+		}
+	}
+	sort.Strings(kept)
+	m[ref] = kept
+	return nil
+}
+
+func TestPostMediaReferences(t *testing.T) {
+	ctx := context.Background()
+	refs := mediaRefs{}
+	database := db.NewMemoryDatabase()
+	s := NewBlogService(NewBlogRepo(database.Collection("posts"), database.Collection("comments")), nil, nil, refs, nil, nil,
+		clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+
+	post := &BlogPost{
+		Title:      "Cats",
+		AuthorId:   "ada",
+		CoverImage: "/media/ab/cover.png",
+		Content:    "Look: ![cat](https://cdn.example.com/ab/cat.JPG) and [a link](https://example.com/page)",
+	}
+	if err := s.CreateBlogPost(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	ref := MediaRef(post.Id)
+	if want := []string{"/media/ab/cover.png", "https://cdn.example.com/ab/cat.JPG"}; !reflect.DeepEqual(refs[ref], want) {
+		t.Errorf("after create %s = %v, want %v", ref, refs[ref], want)
+	}
+
+	post.CoverImage = ""
+	post.Content = "No more pictures."
+	if err := s.UpdateBlogPost(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	if len(refs[ref]) != 0 {
+		t.Errorf("after update %s = %v, want none", ref, refs[ref])
+	}
+	got, err := s.GetBlogPostByID(ctx, post.Id.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CoverImage != "" {
+		t.Errorf("cover after clearing = %q", got.CoverImage)
+	}
+
+	post.CoverImage = "/media/ab/new.png"
+	if err := s.UpdateBlogPost(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBlogPost(ctx, post.Id.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if len(refs[ref]) != 0 {
+		t.Errorf("after delete %s = %v, want the images released", ref, refs[ref])
+	}
+}
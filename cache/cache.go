@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Entry is a cached response body.
+type Entry struct {
+	Body        []byte   `bson:"body"`
+	ContentType string   `bson:"content_type"`
+	ETag        string   `bson:"etag"`
+	Tags        []string `bson:"tags"`
+	// Created is when the request that produced the entry started. Stores
+	// drop entries whose tags were invalidated after it, as they may have
+	// been built from data that has since changed.
+	Created time.Time `bson:"created_at"`
+}
+
+// Store keeps entries by key for the TTL it was created with. Get returns
+// nil, nil on a miss.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, e *Entry) error
+	// Invalidate removes every entry carrying one of tags.
+	Invalidate(ctx context.Context, tags ...string) error
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+)
+
+var start = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestStores(t *testing.T) {
+	for name, newStore := range map[string]func(clk clock.Clock) Store{
+		"memory":  func(clk clock.Clock) Store { return NewMemoryStore(time.Minute, 0, clk) },
+		"mongodb": func(clk clock.Clock) Store { return NewMongoStore(db.NewMemoryCollection(), time.Minute, clk) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clk := clock.NewManual(start)
+			s := newStore(clk)
+			get := func(key string) *Entry {
+				t.Helper()
+				e, err := s.Get(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return e
+			}
+			set := func(key string, created time.Time, tags ...string) {
+				t.Helper()
+				if err := s.Set(ctx, key, &Entry{Body: []byte(key), Tags: tags, Created: created}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			set("/blog/1", clk.Now(), "posts", "post:1")
+			set("/blog/2", clk.Now(), "posts", "post:2")
+			set("/about", clk.Now(), "about")
+			if e := get("/blog/1"); e == nil || string(e.Body) != "/blog/1" {
+				t.Fatalf("get = %+v", e)
+			}
+			if e := get("/missing"); e != nil {
+				t.Errorf("miss = %+v, want nil", e)
+			}
+
+			clk.Advance(time.Second)
+			if err := s.Invalidate(ctx, "post:1"); err != nil {
+				t.Fatal(err)
+			}
+			if get("/blog/1") != nil {
+				t.Error("invalidated entry survived")
+			}
+			if get("/blog/2") == nil || get("/about") == nil {
+				t.Error("invalidation dropped entries without the tag")
+			}
+
+			// A response built before the invalidation finished is stale.
+			set("/blog/1", start, "posts", "post:1")
+			if get("/blog/1") != nil {
+				t.Error("entry older than an invalidation of its tag was stored")
+			}
+			set("/blog/1", clk.Now().Add(time.Millisecond), "posts", "post:1")
+			if get("/blog/1") == nil {
+				t.Error("entry newer than the invalidation was refused")
+			}
+
+			clk.Advance(time.Minute)
+			if get("/about") != nil {
+				t.Error("entry outlived its TTL")
+			}
+		})
+	}
+}
+
+func TestMemoryStoreEvicts(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(time.Minute, 2, clock.NewManual(start))
+	for _, key := range []string{"a", "b"} {
+		s.Set(ctx, key, &Entry{Tags: []string{TagAll}, Created: start})
+	}
+	s.Get(ctx, "a")
+	s.Set(ctx, "c", &Entry{Tags: []string{TagAll}, Created: start})
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if e, _ := s.Get(ctx, key); (e != nil) != want {
+			t.Errorf("%s cached = %v, want %v", key, e != nil, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+)
+
+// MemoryStore is an in-process LRU cache whose entries also expire after a
+// TTL. Invalidations only reach the replica they happen on; use MongoStore
+// when the API runs on several.
+type MemoryStore struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	maxEntries  int
+	clock       clock.Clock
+	lru         *list.List
+	items       map[string]*list.Element
+	tags        map[string]map[string]bool
+	invalidated map[string]time.Time
+}
+
+type memoryItem struct {
+	key     string
+	entry   *Entry
+	expires time.Time
+}
+
+func NewMemoryStore(ttl time.Duration, maxEntries int, clk clock.Clock) *MemoryStore {
+	return &MemoryStore{
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		clock:       clock.OrSystem(clk),
+		lru:         list.New(),
+		items:       make(map[string]*list.Element),
+		tags:        make(map[string]map[string]bool),
+		invalidated: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryItem)
+	if !s.clock.Now().Before(item.expires) {
+		s.remove(el)
+		return nil, nil
+	}
+	s.lru.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range e.Tags {
+		if t, ok := s.invalidated[tag]; ok && !t.Before(e.Created) {
+			return nil
+		}
+	}
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	s.items[key] = s.lru.PushFront(&memoryItem{key: key, entry: e, expires: s.clock.Now().Add(s.ttl)})
+	for _, tag := range e.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]bool)
+		}
+		s.tags[tag][key] = true
+	}
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStore) Invalidate(ctx context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for tag, t := range s.invalidated {
+		if now.Sub(t) > s.ttl {
+			delete(s.invalidated, tag)
+		}
+	}
+	for _, tag := range tags {
+		s.invalidated[tag] = now
+		for key := range s.tags[tag] {
+			s.remove(s.items[key])
+		}
+	}
+	return nil
+}
+
+// remove drops el from the list, the index and the tag sets.
+func (s *MemoryStore) remove(el *list.Element) {
+	item := s.lru.Remove(el).(*memoryItem)
+	delete(s.items, item.key)
+	for _, tag := range item.entry.Tags {
+		delete(s.tags[tag], item.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
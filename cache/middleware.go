@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// TagAll is carried by every entry, so invalidating it purges the cache.
+const TagAll = "*"
+
+// Cache serves cached GET responses and invalidates them by tag.
+type Cache struct {
+	store  Store
+	maxAge time.Duration
+	logger *logger.Logger
+	clock  clock.Clock
+}
+
+// New returns a Cache over store. maxAge is how long browsers may reuse a
+// response without revalidating it; zero makes them revalidate every time.
+// l may be nil.
+func New(store Store, maxAge time.Duration, l *logger.Logger, clk clock.Clock) *Cache {
+	if l == nil {
+		l = &logger.Logger{Logger: slog.Default()}
+	}
+	return &Cache{store: store, maxAge: maxAge, logger: l, clock: clock.OrSystem(clk)}
+}
+
+// Invalidate drops the responses tagged with any of tags. A failure is
+// logged; the entries then live until they expire.
+func (c *Cache) Invalidate(ctx context.Context, tags ...string) {
+	if err := c.store.Invalidate(ctx, tags...); err != nil {
+		c.logger.Error("cache invalidation failed", "tags", tags, "error", err)
+	}
+}
+
+// Purge drops every cached response, for when data changes behind the
+// services' back as in restores and imports.
+func (c *Cache) Purge(ctx context.Context) {
+	c.Invalidate(ctx, TagAll)
+}
+
+// Tags returns a tag function for routes whose tags do not depend on the
+// request.
+func Tags(tags ...string) func(c *gin.Context) []string {
+	return func(*gin.Context) []string { return tags }
+}
+
+// Handler caches successful responses of the route it guards under the
+// tags returned by tags, and answers If-None-Match with 304.
+func (c *Cache) Handler(tags func(ctx *gin.Context) []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.Request.URL.Path + "?" + ctx.Request.URL.Query().Encode()
+		e, err := c.store.Get(ctx, key)
+		if err != nil {
+			c.logger.Error("cache read failed", "key", key, "error", err)
+		}
+		if e != nil {
+			ctx.Header("X-Cache", "HIT")
+			c.serve(ctx, e)
+			ctx.Abort()
+			return
+		}
+		started := c.clock.Now()
+		w := &bufferedWriter{ResponseWriter: ctx.Writer, status: http.StatusOK}
+		ctx.Writer = w
+		ctx.Next()
+		ctx.Writer = w.ResponseWriter
+		if w.status != http.StatusOK || len(ctx.Errors) > 0 {
+			w.flush()
+			return
+		}
+		e = &Entry{
+			Body:        w.body.Bytes(),
+			ContentType: w.Header().Get("Content-Type"),
+			ETag:        etag(w.body.Bytes()),
+			Tags:        append([]string{TagAll}, tags(ctx)...),
+			Created:     started,
+		}
+		if err := c.store.Set(ctx, key, e); err != nil {
+			c.logger.Error("cache write failed", "key", key, "error", err)
+		}
+		ctx.Header("X-Cache", "MISS")
+		c.serve(ctx, e)
+	}
+}
+
+func (c *Cache) serve(ctx *gin.Context, e *Entry) {
+	ctx.Header("ETag", e.ETag)
+	if c.maxAge > 0 {
+		ctx.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(c.maxAge.Seconds())))
+	} else {
+		ctx.Header("Cache-Control", "public, no-cache")
+	}
+	if matchesETag(ctx.GetHeader("If-None-Match"), e.ETag) {
+		ctx.Status(http.StatusNotModified)
+		ctx.Writer.WriteHeaderNow()
+		return
+	}
+	ctx.Data(http.StatusOK, e.ContentType, e.Body)
+}
+
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchesETag reports whether an If-None-Match header names tag. Weak
+// validators match too, as If-None-Match uses weak comparison.
+func matchesETag(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds a response back so that its ETag can be computed
+// before anything is sent.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+	w.wroteHeader = true
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.wroteHeader = true
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.wroteHeader = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.wroteHeader
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+// flush sends a response that is not cached as the handler wrote it.
+func (w *bufferedWriter) flush() {
+	if !w.wroteHeader {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clk := clock.NewManual(start)
+	c := New(NewMemoryStore(time.Minute, 0, clk), 5*time.Minute, logger.NewLogger("error", io.Discard), clk)
+	body := "first"
+	calls := 0
+	r := gin.New()
+	r.GET("/posts/:id", c.Handler(func(ctx *gin.Context) []string { return []string{"post:" + ctx.Param("id")} }), func(ctx *gin.Context) {
+		calls++
+		ctx.String(http.StatusOK, body)
+	})
+	r.GET("/broken", c.Handler(Tags("broken")), func(ctx *gin.Context) {
+		calls++
+		ctx.Error(errors.New("boom"))
+		ctx.String(http.StatusInternalServerError, "boom")
+	})
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/posts/1", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "first" || w.Header().Get("X-Cache") != "MISS" || etag == "" {
+		t.Fatalf("miss: %d %q %v", w.Code, w.Body, w.Header())
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	body = "second"
+	if w := get("/posts/1", ""); w.Body.String() != "first" || w.Header().Get("X-Cache") != "HIT" || w.Header().Get("ETag") != etag {
+		t.Errorf("hit: %q %v", w.Body, w.Header())
+	}
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if w := get("/posts/1", header); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: %d %q, want an empty 304", header, w.Code, w.Body)
+		}
+	}
+	if w := get("/posts/1", `"other"`); w.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: status %d", w.Code)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+
+	c.Invalidate(context.Background(), "post:2")
+	if w := get("/posts/1", etag); w.Code != http.StatusNotModified {
+		t.Errorf("another post's invalidation dropped the entry: status %d", w.Code)
+	}
+	clk.Advance(time.Second)
+	c.Invalidate(context.Background(), "post:1")
+	w = get("/posts/1", etag)
+	if w.Code != http.StatusOK || w.Body.String() != "second" || w.Header().Get("ETag") == etag {
+		t.Errorf("after invalidation: %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	clk.Advance(time.Second)
+	c.Purge(context.Background())
+	if w := get("/posts/1", ""); w.Header().Get("X-Cache") != "MISS" {
+		t.Error("purge kept the entry")
+	}
+
+	calls = 0
+	for i := 0; i < 2; i++ {
+		if w := get("/broken", ""); w.Code != http.StatusInternalServerError || w.Body.String() != "boom" {
+			t.Errorf("error response: %d %q", w.Code, w.Body)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("error response was cached")
+	}
+}
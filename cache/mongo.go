@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// invalidationPrefix marks the documents that record when a tag was last
+// invalidated, next to the entries in the same collection.
+const invalidationPrefix = "invalidated:"
+
+// MongoStore shares entries between replicas through a MongoDB collection,
+// so an invalidation on one replica is seen by all of them.
+type MongoStore struct {
+	collection db.Collection
+	ttl        time.Duration
+	clock      clock.Clock
+}
+
+type mongoEntry struct {
+	Entry     `bson:",inline"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func NewMongoStore(collection db.Collection, ttl time.Duration, clk clock.Clock) *MongoStore {
+	return &MongoStore{collection: collection, ttl: ttl, clock: clock.OrSystem(clk)}
+}
+
+// InitCacheIndexes indexes entries by tag and lets MongoDB delete them once
+// they expire.
+func InitCacheIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.M{"tags": 1}},
+	})
+	if err != nil {
+		return errors.New("failed to create cache indexes: " + err.Error())
+	}
+	return nil
+}
+
+func (s *MongoStore) Get(ctx context.Context, key string) (*Entry, error) {
+	var e mongoEntry
+	err := s.collection.FindOne(ctx, bson.M{"_id": key, "expires_at": bson.M{"$gt": s.clock.Now()}}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e.Entry, nil
+}
+
+func (s *MongoStore) Set(ctx context.Context, key string, e *Entry) error {
+	if len(e.Tags) > 0 {
+		ids := make([]string, len(e.Tags))
+		for i, tag := range e.Tags {
+			ids[i] = invalidationPrefix + tag
+		}
+		n, err := s.collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": ids}, "created_at": bson.M{"$gte": e.Created.Truncate(time.Millisecond)}})
+		if err != nil || n > 0 {
+			return err
+		}
+	}
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": key},
+		bson.M{"$set": mongoEntry{Entry: *e, ExpiresAt: s.clock.Now().Add(s.ttl)}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *MongoStore) Invalidate(ctx context.Context, tags ...string) error {
+	now := s.clock.Now()
+	for _, tag := range tags {
+		// The marker outlives any entry created before it.
+		_, err := s.collection.UpdateOne(ctx, bson.M{"_id": invalidationPrefix + tag},
+			bson.M{"$set": bson.M{"created_at": now, "expires_at": now.Add(s.ttl)}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := s.collection.DeleteMany(ctx, bson.M{"tags": bson.M{"$in": tags}})
+	return err
+}
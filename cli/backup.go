@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/backup"
+)
+
+func backupContent(args []string) error {
+	fs := newFlagSet("backup")
+	out := fs.String("o", "", "archive file, default bloggy-<timestamp>.tar.gz")
+	media := fs.Bool("media", true, "copy referenced media into the archive")
+	return withEnv(fs, args, 30*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) > 0 {
+			return errUsage
+		}
+		name := *out
+		if name == "" {
+			name = e.services.Backup.Filename()
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		manifest, err := e.services.Backup.Export(ctx, f, backup.ExportOptions{IncludeMedia: *media})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name)
+			return err
+		}
+		fmt.Printf("wrote %s: %d user(s), %d post(s), %d comment(s), %d subscriber(s), %d media file(s)\n", name,
+			manifest.Counts["users"], manifest.Counts["posts"], manifest.Counts["comments"], manifest.Counts["subscribers"], len(manifest.Media))
+		for _, u := range manifest.MissingMedia {
+			fmt.Fprintln(os.Stderr, "media not archived:", u)
+		}
+		return nil
+	})
+}
+
+func restoreContent(args []string) error {
+	fs := newFlagSet("restore")
+	conflict := fs.String("conflict", "skip", "what to do with existing content: skip, overwrite or rename")
+	return withEnv(fs, args, 30*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) != 1 {
+			return errUsage
+		}
+		policy, err := backup.ParseConflictPolicy(*conflict)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(positional[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		report, err := e.services.Backup.Restore(ctx, f, backup.RestoreOptions{Conflict: policy})
+		e.services.PurgeCache(ctx)
+		if report != nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		}
+		return err
+	})
+}
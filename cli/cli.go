@@ -0,0 +1,155 @@
+// Package cli implements the bloggy command line.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/app"
+	"github.com/ayo-ajayi/bloggy/config"
+	"github.com/ayo-ajayi/bloggy/health"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/ayo-ajayi/bloggy/metrics"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":          {"serve [flags]                       run the HTTP server", serve},
+	"config check":   {"config check [flags]                validate and print the configuration", configCheck},
+	"migrate up":     {"migrate up [-to N] [-dry-run]        apply pending migrations", migrateUp},
+	"migrate down":   {"migrate down [-to N] [-dry-run]      revert the latest migration, or down to N", migrateDown},
+	"migrate status": {"migrate status [flags]              list migrations and when they ran", migrateStatus},
+	"seed":           {"seed [file.json] [flags]            load sample posts and comments", seed},
+	"user promote":   {"user promote <email> -role <role>   change a user's role", userPromote},
+	"token revoke":   {"token revoke -user <email|id>       revoke a user's access tokens", tokenRevoke},
+	"reindex":        {"reindex [flags]                     rebuild the search index", reindex},
+	"export":         {"export [-o file] [flags]            write posts and comments as JSON", exportContent},
+	"import":         {"import <path> [-format f] [-dry-run] import bloggy JSON, WordPress WXR, Ghost JSON or Markdown", importContent},
+	"backup":         {"backup [-o file] [-media=false]      archive all content as tar.gz", backupContent},
+	"restore":        {"restore <file> [-conflict policy]   load a backup archive (skip, overwrite or rename)", restoreContent},
+	"export-static":  {"export-static [-o dir|file.zip]     render the blog as static HTML", exportStatic},
+}
+
+// errUsage reports a malformed command line; Run prints usage for it.
+var errUsage = errors.New("usage")
+
+// Run executes the command named by args and returns the process exit code.
+// With no arguments it serves, as bloggy always has.
+func Run(args []string) int {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	name, cmd, rest, ok := lookup(args)
+	if !ok {
+		if strings.HasPrefix(args[0], "-") {
+			name, cmd, rest = "serve", commands["serve"], args
+		} else {
+			printUsage(os.Stderr)
+			return 2
+		}
+	}
+	if err := cmd.run(rest); err != nil {
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.Is(err, errUsage):
+			fmt.Fprintln(os.Stderr, "usage: bloggy "+cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "bloggy %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func lookup(args []string) (string, command, []string, bool) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return args[0] + " " + args[1], cmd, args[2:], true
+		}
+	}
+	cmd, ok := commands[args[0]]
+	return args[0], cmd, args[1:], ok
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage: bloggy <command> [flags]\n\ncommands:")
+	for _, name := range names {
+		fmt.Fprintln(w, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(w, "\nRun bloggy <command> -h for the configuration flags every command accepts.")
+}
+
+// parse registers the config flags on fs, parses args allowing flags after
+// positional arguments, and loads the configuration.
+func parse(fs *flag.FlagSet, args []string) (*config.Config, []string, error) {
+	loader := config.Bind(fs)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, positional, nil
+}
+
+// env is what a command needs to work against the configured database.
+type env struct {
+	cfg      *config.Config
+	log      *logger.Logger
+	deps     *app.Deps
+	services *app.Services
+}
+
+func connect(ctx context.Context, cfg *config.Config) (*env, error) {
+	l := logger.NewLogger(cfg.Log.Level, os.Stderr)
+	deps, err := app.NewMongoDeps(ctx, cfg, l, metrics.New(), health.New(2*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	return &env{cfg, l, deps, app.NewServices(cfg, deps, nil)}, nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("bloggy "+name, flag.ContinueOnError)
+}
+
+// withEnv parses args with fs, connects and runs fn with a bounded context.
+func withEnv(fs *flag.FlagSet, args []string, timeout time.Duration, fn func(ctx context.Context, e *env, positional []string) error) error {
+	cfg, positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	e, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, e, positional)
+}
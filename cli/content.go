@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/importer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed seed.json
+var sampleContent []byte
+
+const contentVersion = 1
+
+// contentFile is the JSON format shared by seed, export and import. IDs are
+// only used to link comments to their parents within the file.
+type contentFile struct {
+	Version int           `json:"version"`
+	Posts   []contentPost `json:"posts"`
+}
+
+type contentPost struct {
+	ID          string           `json:"id,omitempty"`
+	Title       string           `json:"title"`
+	Slug        string           `json:"slug,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Content     string           `json:"content"`
+	AuthorEmail string           `json:"author_email,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	Comments    []contentComment `json:"comments,omitempty"`
+}
+
+type contentComment struct {
+	ID          string    `json:"id,omitempty"`
+	ParentID    string    `json:"parent_id,omitempty"`
+	AuthorEmail string    `json:"author_email,omitempty"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func seed(args []string) error {
+	return withEnv(newFlagSet("seed"), args, 10*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		data := sampleContent
+		switch len(positional) {
+		case 0:
+		case 1:
+			var err error
+			if data, err = os.ReadFile(positional[0]); err != nil {
+				return err
+			}
+		default:
+			return errUsage
+		}
+		return loadContent(ctx, e, data)
+	})
+}
+
+func importContent(args []string) error {
+	fs := newFlagSet("import")
+	format := fs.String("format", "auto", "source format: auto, bloggy, wordpress, ghost or markdown")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing")
+	return withEnv(fs, args, 30*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) != 1 {
+			return errUsage
+		}
+		name := positional[0]
+		f := *format
+		if f == "auto" {
+			var err error
+			if f, err = detectFormat(name); err != nil {
+				return err
+			}
+		}
+		if f == "bloggy" {
+			if *dryRun {
+				return errors.New("-dry-run is not supported for bloggy JSON files")
+			}
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			return loadContent(ctx, e, data)
+		}
+		src, err := parseSource(f, name)
+		if err != nil {
+			return err
+		}
+		report, err := e.services.Importer.Import(ctx, src, importer.Options{
+			DryRun: *dryRun,
+			Progress: func(p importer.Progress) {
+				fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s\n", p.Done, p.Total, p.Title, p.Action)
+			},
+		})
+		if !*dryRun {
+			e.services.PurgeCache(ctx)
+		}
+		if report != nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		}
+		return err
+	})
+}
+
+// detectFormat guesses the import format: a directory holds Markdown, .xml
+// is a WordPress export, and a JSON file with a "db" or "data" key is a
+// Ghost export rather than a bloggy one.
+func detectFormat(name string) (string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "markdown", nil
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xml", ".wxr":
+		return "wordpress", nil
+	case ".json":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		var keys map[string]json.RawMessage
+		if json.Unmarshal(data, &keys) == nil {
+			if _, ok := keys["db"]; ok {
+				return "ghost", nil
+			}
+			if _, ok := keys["data"]; ok {
+				return "ghost", nil
+			}
+		}
+		return "bloggy", nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s; pass -format", name)
+}
+
+func parseSource(format, name string) (*importer.Source, error) {
+	if format == "markdown" {
+		return importer.ParseMarkdownDir(os.DirFS(name))
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch format {
+	case "wordpress":
+		return importer.ParseWXR(f)
+	case "ghost":
+		return importer.ParseGhost(f)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func exportContent(args []string) error {
+	fs := newFlagSet("export")
+	out := fs.String("o", "-", "output file, - for stdout")
+	return withEnv(fs, args, 30*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) > 0 {
+			return errUsage
+		}
+		file, err := dumpContent(ctx, e)
+		if err != nil {
+			return err
+		}
+		var w io.Writer = os.Stdout
+		if *out != "-" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "exported %d post(s)\n", len(file.Posts))
+		return nil
+	})
+}
+
+func dumpContent(ctx context.Context, e *env) (*contentFile, error) {
+	posts, err := e.services.Blog.GetBlogPosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	emails := map[string]string{}
+	emailOf := func(userId string) string {
+		if userId == "" {
+			return ""
+		}
+		if email, ok := emails[userId]; ok {
+			return email
+		}
+		u, err := e.services.Users.Profile(ctx, userId)
+		if err != nil {
+			e.log.Warn("author not found, exporting without author", "user_id", userId)
+		} else {
+			emails[userId] = u.Email
+		}
+		return emails[userId]
+	}
+	file := &contentFile{Version: contentVersion}
+	for _, post := range posts {
+		comments, err := e.services.Blog.GetComments(ctx, post.Id.Hex())
+		if err != nil {
+			return nil, err
+		}
+		cp := contentPost{
+			ID:          post.Id.Hex(),
+			Title:       post.Title,
+			Slug:        post.Slug,
+			Description: post.Description,
+			Content:     post.Content,
+			AuthorEmail: emailOf(post.AuthorId),
+			CreatedAt:   post.CreatedAt,
+			UpdatedAt:   post.UpdatedAt,
+		}
+		for _, c := range comments {
+			cc := contentComment{
+				ID:          c.Id.Hex(),
+				AuthorEmail: emailOf(c.AuthorId),
+				Content:     c.Content,
+				CreatedAt:   c.CreatedAt,
+				UpdatedAt:   c.UpdatedAt,
+			}
+			if !c.ParentId.IsZero() {
+				cc.ParentID = c.ParentId.Hex()
+			}
+			cp.Comments = append(cp.Comments, cc)
+		}
+		file.Posts = append(file.Posts, cp)
+	}
+	return file, nil
+}
+
+// loadContent creates the posts and comments in data. Posts whose slug is
+// already taken are skipped with their comments, so loading twice is safe.
+func loadContent(ctx context.Context, e *env, data []byte) error {
+	var file contentFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return errors.New("invalid content file: " + err.Error())
+	}
+	if file.Version > contentVersion {
+		return fmt.Errorf("content file version %d is newer than supported version %d", file.Version, contentVersion)
+	}
+	userIds := map[string]string{}
+	userOf := func(email string) string {
+		if email == "" {
+			return ""
+		}
+		if id, ok := userIds[email]; ok {
+			return id
+		}
+		u, err := e.services.Users.GetUserByEmail(ctx, email)
+		if err != nil {
+			e.log.Warn("author not found, loading without author", "email", email)
+		} else {
+			userIds[email] = u.ID
+		}
+		return userIds[email]
+	}
+	var created, skipped, comments int
+	for _, p := range file.Posts {
+		post := &blog.BlogPost{
+			Title:       p.Title,
+			Slug:        p.Slug,
+			Description: p.Description,
+			Content:     p.Content,
+			AuthorId:    userOf(p.AuthorEmail),
+			CreatedAt:   p.CreatedAt,
+			UpdatedAt:   p.UpdatedAt,
+		}
+		if err := e.services.Blog.ImportBlogPost(ctx, post); err != nil {
+			var appErr *apperrors.AppError
+			if errors.As(err, &appErr) && appErr.Code == blog.CodeSlugTaken {
+				skipped++
+				continue
+			}
+			return fmt.Errorf("post %q: %w", p.Title, err)
+		}
+		created++
+		n, err := loadComments(ctx, e, post.Id, p.Comments, userOf)
+		comments += n
+		if err != nil {
+			return fmt.Errorf("comments of %q: %w", p.Title, err)
+		}
+	}
+	fmt.Printf("created %d post(s) and %d comment(s), skipped %d existing post(s)\n", created, comments, skipped)
+	return nil
+}
+
+// loadComments inserts comments so that parents come before replies,
+// mapping the file's IDs to the new ones.
+func loadComments(ctx context.Context, e *env, postId primitive.ObjectID, comments []contentComment, userOf func(string) string) (int, error) {
+	ids := map[string]primitive.ObjectID{}
+	pending := comments
+	for len(pending) > 0 {
+		var next []contentComment
+		for _, c := range pending {
+			parent, hasParent := ids[c.ParentID]
+			if c.ParentID != "" && !hasParent {
+				next = append(next, c)
+				continue
+			}
+			comment := &blog.Comment{
+				AuthorId:   userOf(c.AuthorEmail),
+				BlogPostId: postId,
+				ParentId:   parent,
+				Content:    c.Content,
+				CreatedAt:  c.CreatedAt,
+				UpdatedAt:  c.UpdatedAt,
+			}
+			if err := e.services.Blog.ImportComment(ctx, comment); err != nil {
+				return len(ids), err
+			}
+			if c.ID != "" {
+				ids[c.ID] = comment.Id
+			} else {
+				ids[comment.Id.Hex()] = comment.Id
+			}
+		}
+		if len(next) == len(pending) {
+			return len(ids), fmt.Errorf("%d comment(s) reference a parent that is not in the file", len(next))
+		}
+		pending = next
+	}
+	return len(ids), nil
+}
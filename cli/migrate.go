@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/app"
+	"github.com/ayo-ajayi/bloggy/migrate"
+)
+
+func migrateUp(args []string) error {
+	fs := newFlagSet("migrate up")
+	to := fs.Int("to", 0, "stop after this version, 0 for the latest")
+	dryRun := fs.Bool("dry-run", false, "list the migrations that would run without running them")
+	return withEnv(fs, args, migrate.DefaultLockTTL, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) > 0 {
+			return errUsage
+		}
+		migrator := app.NewMigrator(e.deps.Database, e.deps.Clock)
+		if *dryRun {
+			pending, err := migrator.Pending(ctx, *to)
+			if err != nil {
+				return err
+			}
+			printPlan("apply", pending)
+			return nil
+		}
+		applied, err := migrator.Up(ctx, *to)
+		for _, m := range applied {
+			fmt.Println("applied", m)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	})
+}
+
+func migrateDown(args []string) error {
+	fs := newFlagSet("migrate down")
+	to := fs.Int("to", -1, "revert every migration newer than this version, -1 for only the latest")
+	dryRun := fs.Bool("dry-run", false, "list the migrations that would be reverted without reverting them")
+	return withEnv(fs, args, migrate.DefaultLockTTL, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) > 0 {
+			return errUsage
+		}
+		migrator := app.NewMigrator(e.deps.Database, e.deps.Clock)
+		if *dryRun {
+			rollbacks, err := migrator.Rollbacks(ctx, *to)
+			if err != nil {
+				return err
+			}
+			printPlan("revert", rollbacks)
+			return nil
+		}
+		reverted, err := migrator.Down(ctx, *to)
+		for _, m := range reverted {
+			fmt.Println("reverted", m)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("nothing to revert")
+		}
+		return err
+	})
+}
+
+func migrateStatus(args []string) error {
+	return withEnv(newFlagSet("migrate status"), args, time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		states, err := app.NewMigrator(e.deps.Database, e.deps.Clock).Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-32s %s\n", s.Migration, state)
+		}
+		return nil
+	})
+}
+
+func printPlan(verb string, migrations []migrate.Migration) {
+	if len(migrations) == 0 {
+		fmt.Println("nothing to " + verb)
+		return
+	}
+	for _, m := range migrations {
+		fmt.Printf("would %s %s\n", verb, m)
+	}
+}
+
+func reindex(args []string) error {
+	return withEnv(newFlagSet("reindex"), args, 10*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		if err := app.RebuildSearchIndex(ctx, e.deps.Database); err != nil {
+			return err
+		}
+		fmt.Println("search index rebuilt")
+		return nil
+	})
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/app"
+	"github.com/ayo-ajayi/bloggy/health"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/ayo-ajayi/bloggy/metrics"
+)
+
+func serve(args []string) error {
+	cfg, positional, err := parse(newFlagSet("serve"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return errUsage
+	}
+	l := logger.NewLogger(cfg.Log.Level, os.Stdout)
+	slog.SetDefault(l.Logger)
+	m := metrics.New()
+	h := health.New(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	deps, err := app.NewMongoDeps(ctx, cfg, l, m, h)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if cfg.MongoDB.MigrateOnStart {
+		app.MigrateInBackground(app.NewMigrator(deps.Database, deps.Clock), l, h)
+	}
+	if cfg.Webhooks.Enabled {
+		go app.NewWebhookDispatcher(cfg, deps).Run(context.Background())
+	}
+	server := app.NewApp(cfg.Server, app.New(cfg, deps), h, l)
+	if cfg.Metrics.Addr != "" {
+		server.ServeMetrics(cfg.Metrics.Addr, m.Handler(cfg.Metrics.Token))
+	}
+	server.Start()
+	return nil
+}
+
+// configCheck loads and validates the configuration and prints it with
+// secrets redacted.
+func configCheck(args []string) error {
+	cfg, positional, err := parse(newFlagSet("config check"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return errUsage
+	}
+	fmt.Print(cfg.String())
+	fmt.Println("configuration is valid")
+	return nil
+}
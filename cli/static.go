@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/site"
+)
+
+func exportStatic(args []string) error {
+	fs := newFlagSet("export-static")
+	out := fs.String("o", "public", "output directory, or a file ending in .zip")
+	incremental := fs.Bool("incremental", false, "only re-render posts updated since the last export to the directory")
+	perPage := fs.Int("per-page", site.DefaultPerPage, "posts per index and tag page")
+	return withEnv(fs, args, 30*time.Minute, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) > 0 || *perPage < 1 {
+			return errUsage
+		}
+		var output site.Output
+		isZip := strings.HasSuffix(strings.ToLower(*out), ".zip")
+		if isZip {
+			if *incremental {
+				return site.ErrNotIncremental
+			}
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			output = site.NewZip(f, e.deps.Clock.Now().UTC())
+		} else {
+			dir, err := site.NewDir(*out)
+			if err != nil {
+				return err
+			}
+			output = dir
+		}
+		report, err := e.services.Static.Export(ctx, output, site.Options{PerPage: *perPage, Incremental: *incremental})
+		if cerr := output.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil && isZip {
+			os.Remove(*out)
+		}
+		if report != nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		}
+		return err
+	})
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/user"
+)
+
+func userPromote(args []string) error {
+	fs := newFlagSet("user promote")
+	roleName := fs.String("role", string(user.Admin), "role to assign")
+	return withEnv(fs, args, 30*time.Second, func(ctx context.Context, e *env, positional []string) error {
+		if len(positional) != 1 {
+			return errUsage
+		}
+		role, err := user.ParseRole(*roleName)
+		if err != nil {
+			return err
+		}
+		u, err := e.services.Users.GetUserByEmail(ctx, positional[0])
+		if err != nil {
+			return err
+		}
+		if _, err := e.services.Users.AssignRole(ctx, u.ID, role); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now %s\n", u.Email, role)
+		return nil
+	})
+}
+
+func tokenRevoke(args []string) error {
+	fs := newFlagSet("token revoke")
+	who := fs.String("user", "", "email or id of the user whose tokens to revoke")
+	return withEnv(fs, args, 30*time.Second, func(ctx context.Context, e *env, positional []string) error {
+		if *who == "" || len(positional) > 0 {
+			return errUsage
+		}
+		userId := *who
+		if strings.Contains(userId, "@") {
+			u, err := e.services.Users.GetUserByEmail(ctx, userId)
+			if err != nil {
+				return err
+			}
+			userId = u.ID
+		}
+		n, err := e.services.Users.RevokeTokens(ctx, userId)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("revoked %d token(s)\n", n)
+		return nil
+	})
+}
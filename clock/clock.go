@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Components that check expiry take one so tests can
+// control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the wall clock.
+type System struct{}
+
+func (System) Now() time.Time { return time.Now() }
+
+// Manual is a Clock that only moves when told to.
+type Manual struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Manual) Set(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+// OrSystem returns c, or the wall clock when c is nil.
+func OrSystem(c Clock) Clock {
+	if c == nil {
+		return System{}
+	}
+	return c
+}
@@ -0,0 +1,699 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Log     LogConfig     `yaml:"log"`
+	MongoDB MongoDBConfig `yaml:"mongodb"`
+	Auth    AuthConfig    `yaml:"auth"`
+	Google  GoogleConfig  `yaml:"google"`
+	Media   MediaConfig   `yaml:"media"`
+	SMTP    SMTPConfig    `yaml:"smtp"`
+	Metrics MetricsConfig `yaml:"metrics"`
+	Site    SiteConfig    `yaml:"site"`
+	// RateLimit throttles the endpoints that are expensive or easy to abuse.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Security  SecurityConfig  `yaml:"security"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Webhooks  WebhookConfig   `yaml:"webhooks"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	DrainDelay      time.Duration `yaml:"drain_delay"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies are the IPs and CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header gives the client IP. Empty trusts none.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+type MongoDBConfig struct {
+	URI      string `yaml:"uri"`
+	Database string `yaml:"database"`
+	// MigrateOnStart applies pending schema migrations when serve starts.
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+}
+
+type AuthConfig struct {
+	AccessTokenSecret          string        `yaml:"access_token_secret"`
+	AccessTokenTTL             time.Duration `yaml:"access_token_ttl"`
+	SessionSecret              string        `yaml:"session_secret"`
+	AdminEmail                 string        `yaml:"admin_email"`
+	AdminMFARequired           bool          `yaml:"admin_mfa_required"`
+	LoginCodeTTL               time.Duration `yaml:"login_code_ttl"`
+	EmailLoginTTL              time.Duration `yaml:"email_login_ttl"`
+	EmailLoginURL              string        `yaml:"email_login_url"`
+	EmailLoginSecret           string        `yaml:"email_login_secret"`
+	PostLoginRedirectURL       string        `yaml:"post_login_redirect_url"`
+	PostLoginRedirectAllowlist []string      `yaml:"post_login_redirect_allowlist"`
+}
+
+type GoogleConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"`
+}
+
+// MediaConfig selects where uploads are stored.
+type MediaConfig struct {
+	// Backend is local, s3 or cloudinary. Empty means cloudinary when
+	// CloudinaryURI is set and local otherwise.
+	Backend string `yaml:"backend"`
+	// Dir holds local uploads, which the server serves under PublicURL: a
+	// path, or an absolute URL when the API sits behind a different host.
+	Dir           string        `yaml:"dir"`
+	PublicURL     string        `yaml:"public_url"`
+	CloudinaryURI string        `yaml:"cloudinary_uri"`
+	Folder        string        `yaml:"folder"`
+	S3            S3MediaConfig `yaml:"s3"`
+	// MaxUploadMB caps files uploaded to the media library.
+	MaxUploadMB int `yaml:"max_upload_mb"`
+	// AllowedTypes are the media types the library accepts, as sniffed
+	// from the file contents.
+	AllowedTypes []string `yaml:"allowed_types"`
+}
+
+// S3MediaConfig locates a bucket on S3 or a compatible service like MinIO.
+type S3MediaConfig struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	PathStyle       bool   `yaml:"path_style"`
+	// PublicURL is where objects are served from, such as a CDN; defaults
+	// to the bucket URL.
+	PublicURL string `yaml:"public_url"`
+}
+
+// MediaBackend resolves the storage backend, applying the default.
+func (m MediaConfig) MediaBackend() string {
+	if m.Backend != "" {
+		return strings.ToLower(m.Backend)
+	}
+	if m.CloudinaryURI != "" {
+		return "cloudinary"
+	}
+	return "local"
+}
+
+type SMTPConfig struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+type MetricsConfig struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+// SiteConfig describes the public blog rendered from HTML themes.
+type SiteConfig struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	// BaseURL is the public root of the site. Feeds and the sitemap need
+	// absolute links and are left out without it.
+	BaseURL string `yaml:"base_url"`
+	Theme   string `yaml:"theme"`
+	// ThemeDir holds themes on disk; a file there overrides the built-in
+	// template of the same name.
+	ThemeDir string `yaml:"theme_dir"`
+	// Serve adds the HTML frontend to the server under Path, next to the
+	// JSON API.
+	Serve bool   `yaml:"serve"`
+	Path  string `yaml:"path"`
+}
+
+// SecurityConfig covers what browsers enforce: CORS, security headers and
+// cookie-based sessions.
+type SecurityConfig struct {
+	// CORSAllowedOrigins are the origins allowed to call the API from a
+	// browser. "*" allows any origin, but then without credentials.
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
+	// HSTSMaxAge is sent in Strict-Transport-Security; zero omits it.
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age"`
+	// ContentSecurityPolicy is sent with HTML pages; API responses get a
+	// policy that forbids everything.
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	ReferrerPolicy        string `yaml:"referrer_policy"`
+	// CookieAuth also sets the access token as an HttpOnly cookie at login.
+	// State-changing requests that rely on it must send the csrf_token
+	// cookie back in the X-CSRF-Token header.
+	CookieAuth bool `yaml:"cookie_auth"`
+}
+
+// CacheConfig controls the cache of public read endpoints.
+type CacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is memory, an LRU per replica, or mongodb, which shares
+	// entries and invalidations between replicas.
+	Backend    string        `yaml:"backend"`
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"max_entries"`
+	// MaxAge lets browsers and CDNs reuse a response without asking; zero
+	// makes them revalidate it with its ETag every time.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// WebhookConfig controls how events are delivered to webhook subscribers.
+type WebhookConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed. Retries wait RetryBackoff, doubling after each one.
+	MaxAttempts  int           `yaml:"max_attempts"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// PollInterval is how often due retries are looked for.
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// AllowedNetworks holds IPs and CIDR ranges of internal services that
+	// webhooks may be delivered to. Other loopback, private and link-local
+	// addresses are refused.
+	AllowedNetworks []string `yaml:"allowed_networks"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is memory, which counts per replica, or mongodb, which shares
+	// the counts between replicas.
+	Backend string `yaml:"backend"`
+	// Policies are name=limit/period entries such as search=30/1m. Routes
+	// whose policy is not listed are not limited.
+	Policies []string `yaml:"policies"`
+	// Allowlist holds IPs and CIDR ranges that are never limited.
+	Allowlist []string `yaml:"allowlist"`
+	// ExemptAdmins lets signed-in admins through without limits.
+	ExemptAdmins bool `yaml:"exempt_admins"`
+}
+
+// Rate is a parsed rate limit policy.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Rates parses Policies by name.
+func (r RateLimitConfig) Rates() (map[string]Rate, error) {
+	rates := make(map[string]Rate, len(r.Policies))
+	for _, policy := range r.Policies {
+		name, spec, ok := strings.Cut(policy, "=")
+		limit, period, ok2 := strings.Cut(spec, "/")
+		if !ok || !ok2 || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("rate_limit.policies entry %q is not name=limit/period", policy)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("rate_limit.policies entry %q needs a positive limit", policy)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(period))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("rate_limit.policies entry %q needs a positive period like 1m", policy)
+		}
+		rates[strings.TrimSpace(name)] = Rate{Limit: n, Period: d}
+	}
+	return rates, nil
+}
+
+// Default returns the configuration used for anything not set by a file,
+// the environment or a flag.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			DrainDelay:      5 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Log:     LogConfig{Level: "info"},
+		MongoDB: MongoDBConfig{Database: "bloggy", MigrateOnStart: true},
+		Auth: AuthConfig{
+			AccessTokenTTL: 24 * time.Hour,
+			LoginCodeTTL:   time.Minute,
+			EmailLoginTTL:  15 * time.Minute,
+		},
+		Media: MediaConfig{
+			Dir:          "media",
+			PublicURL:    "/uploads",
+			Folder:       "bloggy",
+			S3:           S3MediaConfig{Region: "us-east-1", PathStyle: true},
+			MaxUploadMB:  10,
+			AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif"},
+		},
+		Site: SiteConfig{Title: "bloggy", Theme: "default", Path: "/site"},
+		Security: SecurityConfig{
+			CORSAllowedOrigins:    []string{"*"},
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			ContentSecurityPolicy: "default-src 'self'; img-src 'self' https: data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+		},
+		Cache: CacheConfig{Enabled: true, Backend: "memory", TTL: 5 * time.Minute, MaxEntries: 1000},
+		Webhooks: WebhookConfig{
+			Enabled:      true,
+			Timeout:      10 * time.Second,
+			MaxAttempts:  8,
+			RetryBackoff: 30 * time.Second,
+			PollInterval: 15 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:      true,
+			Backend:      "memory",
+			Policies:     []string{"search=30/1m", "comment=10/1m", "like=60/1m", "upload=30/1h", "render=300/1m"},
+			ExemptAdmins: true,
+		},
+	}
+}
+
+// setting binds one field to its environment variable and flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	ptr   any
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"SERVER_ADDR", "addr", "HTTP listen address", &c.Server.Addr},
+		{"DRAIN_DELAY", "drain-delay", "time readiness fails before shutdown", &c.Server.DrainDelay},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time allowed for in-flight requests on shutdown", &c.Server.ShutdownTimeout},
+		{"TRUSTED_PROXIES", "trusted-proxies", "comma separated reverse proxy IPs and CIDR ranges", &c.Server.TrustedProxies},
+		{"LOG_LEVEL", "log-level", "debug, info, warn or error", &c.Log.Level},
+		{"MONGODB_URI", "mongodb-uri", "MongoDB connection string", &c.MongoDB.URI},
+		{"MONGODB_DATABASE", "mongodb-database", "MongoDB database name", &c.MongoDB.Database},
+		{"MONGODB_MIGRATE_ON_START", "mongodb-migrate-on-start", "apply pending schema migrations when serving", &c.MongoDB.MigrateOnStart},
+		{"ACCESS_TOKEN_SECRET", "access-token-secret", "secret used to sign access tokens", &c.Auth.AccessTokenSecret},
+		{"ACCESS_TOKEN_TTL", "access-token-ttl", "access token lifetime", &c.Auth.AccessTokenTTL},
+		{"SESSION_SECRET", "session-secret", "secret used to sign the OAuth session cookie", &c.Auth.SessionSecret},
+		{"ADMIN_EMAIL", "admin-email", "email that is made admin on first login", &c.Auth.AdminEmail},
+		{"ADMIN_MFA_REQUIRED", "admin-mfa-required", "require a second factor for admin routes", &c.Auth.AdminMFARequired},
+		{"LOGIN_CODE_TTL", "login-code-ttl", "lifetime of one-time login codes", &c.Auth.LoginCodeTTL},
+		{"EMAIL_LOGIN_TTL", "email-login-ttl", "lifetime of email login links", &c.Auth.EmailLoginTTL},
+		{"EMAIL_LOGIN_URL", "email-login-url", "frontend URL that email login links point to; email login is off when empty", &c.Auth.EmailLoginURL},
+		{"EMAIL_LOGIN_SECRET", "email-login-secret", "secret used to sign email login links", &c.Auth.EmailLoginSecret},
+		{"POST_LOGIN_REDIRECT_URL", "post-login-redirect-url", "default redirect after login", &c.Auth.PostLoginRedirectURL},
+		{"POST_LOGIN_REDIRECT_ALLOWLIST", "post-login-redirect-allowlist", "comma separated origins allowed as login redirects", &c.Auth.PostLoginRedirectAllowlist},
+		{"CLIENT_ID", "google-client-id", "Google OAuth client id", &c.Google.ClientID},
+		{"CLIENT_SECRET", "google-client-secret", "Google OAuth client secret", &c.Google.ClientSecret},
+		{"REDIRECT_URL", "google-redirect-url", "Google OAuth callback URL", &c.Google.RedirectURL},
+		{"MEDIA_BACKEND", "media-backend", "where uploads are stored: local, s3 or cloudinary", &c.Media.Backend},
+		{"MEDIA_DIR", "media-dir", "directory for local uploads", &c.Media.Dir},
+		{"MEDIA_PUBLIC_URL", "media-public-url", "URL path local uploads are served under", &c.Media.PublicURL},
+		{"CLOUDINARY_URI", "cloudinary-uri", "Cloudinary API URL", &c.Media.CloudinaryURI},
+		{"CLOUDINARY_FOLDER", "cloudinary-folder", "Cloudinary folder for uploads", &c.Media.Folder},
+		{"S3_ENDPOINT", "s3-endpoint", "S3 or MinIO endpoint URL", &c.Media.S3.Endpoint},
+		{"S3_REGION", "s3-region", "S3 region", &c.Media.S3.Region},
+		{"S3_BUCKET", "s3-bucket", "S3 bucket for uploads", &c.Media.S3.Bucket},
+		{"S3_ACCESS_KEY_ID", "s3-access-key-id", "S3 access key id", &c.Media.S3.AccessKeyID},
+		{"S3_SECRET_ACCESS_KEY", "s3-secret-access-key", "S3 secret access key", &c.Media.S3.SecretAccessKey},
+		{"S3_PATH_STYLE", "s3-path-style", "address the bucket by path rather than subdomain", &c.Media.S3.PathStyle},
+		{"S3_PUBLIC_URL", "s3-public-url", "base URL uploads are served from, such as a CDN", &c.Media.S3.PublicURL},
+		{"MEDIA_MAX_UPLOAD_MB", "media-max-upload-mb", "largest file the media library accepts, in MB", &c.Media.MaxUploadMB},
+		{"MEDIA_ALLOWED_TYPES", "media-allowed-types", "comma separated media types the library accepts", &c.Media.AllowedTypes},
+		{"SMTP_ADDR", "smtp-addr", "SMTP server host:port; email login is off when empty", &c.SMTP.Addr},
+		{"SMTP_USERNAME", "smtp-username", "SMTP username", &c.SMTP.Username},
+		{"SMTP_PASSWORD", "smtp-password", "SMTP password", &c.SMTP.Password},
+		{"SMTP_FROM", "smtp-from", "sender address for outgoing mail", &c.SMTP.From},
+		{"METRICS_ADDR", "metrics-addr", "separate listen address for /metrics", &c.Metrics.Addr},
+		{"METRICS_TOKEN", "metrics-token", "bearer token required for /metrics", &c.Metrics.Token},
+		{"SITE_TITLE", "site-title", "blog title shown in HTML pages and feeds", &c.Site.Title},
+		{"SITE_DESCRIPTION", "site-description", "blog description shown in HTML pages and feeds", &c.Site.Description},
+		{"SITE_BASE_URL", "site-base-url", "public URL of the blog, used for feeds and the sitemap", &c.Site.BaseURL},
+		{"SITE_THEME", "site-theme", "HTML theme name", &c.Site.Theme},
+		{"SITE_THEME_DIR", "site-theme-dir", "directory of themes that override the built-in ones", &c.Site.ThemeDir},
+		{"SITE_SERVE", "site-serve", "serve the HTML frontend alongside the API", &c.Site.Serve},
+		{"SITE_PATH", "site-path", "path the HTML frontend is served under", &c.Site.Path},
+		{"CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma separated origins allowed to call the API from a browser", &c.Security.CORSAllowedOrigins},
+		{"HSTS_MAX_AGE", "hsts-max-age", "Strict-Transport-Security max age; 0 disables it", &c.Security.HSTSMaxAge},
+		{"CONTENT_SECURITY_POLICY", "content-security-policy", "Content-Security-Policy for HTML pages", &c.Security.ContentSecurityPolicy},
+		{"REFERRER_POLICY", "referrer-policy", "Referrer-Policy header", &c.Security.ReferrerPolicy},
+		{"COOKIE_AUTH", "cookie-auth", "also issue access tokens as cookies, guarded by CSRF tokens", &c.Security.CookieAuth},
+		{"CACHE_ENABLED", "cache-enabled", "cache responses of public read endpoints", &c.Cache.Enabled},
+		{"CACHE_BACKEND", "cache-backend", "where cached responses live: memory or mongodb", &c.Cache.Backend},
+		{"CACHE_TTL", "cache-ttl", "how long a cached response is kept", &c.Cache.TTL},
+		{"CACHE_MAX_ENTRIES", "cache-max-entries", "responses kept by the memory cache", &c.Cache.MaxEntries},
+		{"CACHE_MAX_AGE", "cache-max-age", "how long clients may reuse a response without revalidating", &c.Cache.MaxAge},
+		{"WEBHOOKS_ENABLED", "webhooks-enabled", "deliver events to webhook subscribers", &c.Webhooks.Enabled},
+		{"WEBHOOK_TIMEOUT", "webhook-timeout", "how long a webhook delivery may take", &c.Webhooks.Timeout},
+		{"WEBHOOK_MAX_ATTEMPTS", "webhook-max-attempts", "attempts before a webhook delivery is marked failed", &c.Webhooks.MaxAttempts},
+		{"WEBHOOK_RETRY_BACKOFF", "webhook-retry-backoff", "wait before the first webhook retry, doubled after each one", &c.Webhooks.RetryBackoff},
+		{"WEBHOOK_POLL_INTERVAL", "webhook-poll-interval", "how often due webhook retries are looked for", &c.Webhooks.PollInterval},
+		{"WEBHOOK_ALLOWED_NETWORKS", "webhook-allowed-networks", "comma separated internal IPs and CIDR ranges webhooks may reach", &c.Webhooks.AllowedNetworks},
+		{"RATE_LIMIT_ENABLED", "rate-limit-enabled", "throttle search, comments, likes, uploads and image renders", &c.RateLimit.Enabled},
+		{"RATE_LIMIT_BACKEND", "rate-limit-backend", "where rate limit counts live: memory or mongodb", &c.RateLimit.Backend},
+		{"RATE_LIMIT_POLICIES", "rate-limit-policies", "comma separated name=limit/period policies", &c.RateLimit.Policies},
+		{"RATE_LIMIT_ALLOWLIST", "rate-limit-allowlist", "comma separated IPs and CIDR ranges that are never limited", &c.RateLimit.Allowlist},
+		{"RATE_LIMIT_EXEMPT_ADMINS", "rate-limit-exempt-admins", "let admins through without rate limits", &c.RateLimit.ExemptAdmins},
+	}
+}
+
+func set(ptr any, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported config field type %T", ptr)
+	}
+	return nil
+}
+
+// Loader reads the configuration once its flags have been parsed.
+type Loader struct {
+	path  *string
+	flags []flagValue
+}
+
+type flagValue struct {
+	name  string
+	value string
+}
+
+// Bind registers -config and one flag per setting on fs. Call Load after
+// fs has been parsed.
+func Bind(fs *flag.FlagSet) *Loader {
+	l := &Loader{path: fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")}
+	for _, s := range Default().settings() {
+		s := s
+		fs.Func(s.flag, s.usage+" (env "+s.env+")", func(v string) error {
+			l.flags = append(l.flags, flagValue{s.flag, v})
+			return nil
+		})
+	}
+	return l
+}
+
+// Load builds the configuration from defaults, then the YAML file named by
+// -config or CONFIG_FILE, then the environment, then the parsed flags, and
+// validates the result.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+	if *l.path != "" {
+		if err := cfg.loadFile(*l.path); err != nil {
+			return nil, err
+		}
+	}
+	byFlag := make(map[string]setting)
+	for _, s := range cfg.settings() {
+		byFlag[s.flag] = s
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := set(s.ptr, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, f := range l.flags {
+		if err := set(byFlag[f.name].ptr, f.value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", f.name, err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Load parses args as config flags and loads the configuration.
+func Load(name string, args []string) (*Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	l := Bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return l.Load()
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.New("failed to read config file: " + err.Error())
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return errors.New("failed to parse config file " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+// Validate reports every missing or malformed setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	required := map[string]string{
+		"mongodb.uri":              c.MongoDB.URI,
+		"mongodb.database":         c.MongoDB.Database,
+		"server.addr":              c.Server.Addr,
+		"auth.access_token_secret": c.Auth.AccessTokenSecret,
+		"auth.session_secret":      c.Auth.SessionSecret,
+		"google.client_id":         c.Google.ClientID,
+		"google.client_secret":     c.Google.ClientSecret,
+		"google.redirect_url":      c.Google.RedirectURL,
+	}
+	switch c.Media.MediaBackend() {
+	case "local":
+		required["media.dir"] = c.Media.Dir
+		required["media.public_url"] = c.Media.PublicURL
+	case "s3":
+		required["media.s3.endpoint"] = c.Media.S3.Endpoint
+		required["media.s3.bucket"] = c.Media.S3.Bucket
+		required["media.s3.access_key_id"] = c.Media.S3.AccessKeyID
+		required["media.s3.secret_access_key"] = c.Media.S3.SecretAccessKey
+	case "cloudinary":
+		required["media.cloudinary_uri"] = c.Media.CloudinaryURI
+	default:
+		errs = append(errs, fmt.Errorf("media.backend %q is not one of local, s3, cloudinary", c.Media.Backend))
+	}
+	for _, key := range sortedKeys(required) {
+		if strings.TrimSpace(required[key]) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	positive := map[string]time.Duration{
+		"auth.access_token_ttl":   c.Auth.AccessTokenTTL,
+		"auth.login_code_ttl":     c.Auth.LoginCodeTTL,
+		"auth.email_login_ttl":    c.Auth.EmailLoginTTL,
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+	}
+	for _, key := range sortedKeys(positive) {
+		if positive[key] <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", key))
+		}
+	}
+	if c.Server.DrainDelay < 0 {
+		errs = append(errs, errors.New("server.drain_delay must not be negative"))
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level %q is not one of debug, info, warn, error", c.Log.Level))
+	}
+	if c.Google.RedirectURL != "" && !isAbsoluteURL(c.Google.RedirectURL) {
+		errs = append(errs, errors.New("google.redirect_url must be an absolute URL"))
+	}
+	if c.Auth.PostLoginRedirectURL != "" && !isAbsoluteURL(c.Auth.PostLoginRedirectURL) {
+		errs = append(errs, errors.New("auth.post_login_redirect_url must be an absolute URL"))
+	}
+	if c.Auth.EmailLoginURL != "" && !isAbsoluteURL(c.Auth.EmailLoginURL) {
+		errs = append(errs, errors.New("auth.email_login_url must be an absolute URL"))
+	}
+	for _, origin := range c.Auth.PostLoginRedirectAllowlist {
+		if !isAbsoluteURL(origin) {
+			errs = append(errs, fmt.Errorf("auth.post_login_redirect_allowlist entry %q must be an absolute URL", origin))
+		}
+	}
+	if c.Site.BaseURL != "" && !isAbsoluteURL(c.Site.BaseURL) {
+		errs = append(errs, errors.New("site.base_url must be an absolute URL"))
+	}
+	if c.Site.Serve && (!strings.HasPrefix(c.Site.Path, "/") || strings.TrimRight(c.Site.Path, "/") == "") {
+		errs = append(errs, errors.New("site.path must be a path below / so it does not clash with the API"))
+	}
+	if c.Media.MediaBackend() == "local" && !strings.HasPrefix(c.Media.PublicURL, "/") && !isAbsoluteURL(c.Media.PublicURL) {
+		errs = append(errs, errors.New("media.public_url must be a path starting with / or an absolute URL"))
+	}
+	if c.Media.MaxUploadMB <= 0 || c.Media.MaxUploadMB > 25 {
+		errs = append(errs, errors.New("media.max_upload_mb must be between 1 and 25"))
+	}
+	if len(c.Media.AllowedTypes) == 0 {
+		errs = append(errs, errors.New("media.allowed_types must not be empty"))
+	}
+	if c.Media.S3.Endpoint != "" && !isAbsoluteURL(c.Media.S3.Endpoint) {
+		errs = append(errs, errors.New("media.s3.endpoint must be an absolute URL"))
+	}
+	if c.Site.Theme == "" {
+		errs = append(errs, errors.New("site.theme is required"))
+	}
+	for _, origin := range c.Security.CORSAllowedOrigins {
+		if origin == "*" {
+			if len(c.Security.CORSAllowedOrigins) > 1 {
+				errs = append(errs, errors.New("security.cors_allowed_origins cannot mix * with other origins"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || !isAbsoluteURL(origin) || strings.TrimRight(u.Path, "/") != "" {
+			errs = append(errs, fmt.Errorf("security.cors_allowed_origins entry %q must be * or an origin like https://blog.example.com", origin))
+		}
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("security.hsts_max_age must not be negative"))
+	}
+	switch strings.ToLower(c.Cache.Backend) {
+	case "memory", "mongodb":
+	default:
+		errs = append(errs, fmt.Errorf("cache.backend %q is not one of memory, mongodb", c.Cache.Backend))
+	}
+	if c.Cache.Enabled && c.Cache.TTL <= 0 {
+		errs = append(errs, errors.New("cache.ttl must be positive"))
+	}
+	if c.Cache.MaxEntries < 0 || c.Cache.MaxAge < 0 {
+		errs = append(errs, errors.New("cache.max_entries and cache.max_age must not be negative"))
+	}
+	if c.Webhooks.Enabled && (c.Webhooks.Timeout <= 0 || c.Webhooks.MaxAttempts < 1 || c.Webhooks.RetryBackoff <= 0 || c.Webhooks.PollInterval <= 0) {
+		errs = append(errs, errors.New("webhooks.timeout, webhooks.retry_backoff and webhooks.poll_interval must be positive and webhooks.max_attempts at least 1"))
+	}
+	switch strings.ToLower(c.RateLimit.Backend) {
+	case "memory", "mongodb":
+	default:
+		errs = append(errs, fmt.Errorf("rate_limit.backend %q is not one of memory, mongodb", c.RateLimit.Backend))
+	}
+	if _, err := c.RateLimit.Rates(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, entry := range c.RateLimit.Allowlist {
+		if !isAddressRange(entry) {
+			errs = append(errs, fmt.Errorf("rate_limit.allowlist entry %q must be an IP or CIDR range", entry))
+		}
+	}
+	for _, entry := range c.Webhooks.AllowedNetworks {
+		if !isAddressRange(entry) {
+			errs = append(errs, fmt.Errorf("webhooks.allowed_networks entry %q must be an IP or CIDR range", entry))
+		}
+	}
+	for _, entry := range c.Server.TrustedProxies {
+		if !isAddressRange(entry) {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies entry %q must be an IP or CIDR range", entry))
+		}
+	}
+	if c.SMTP.Addr != "" && c.SMTP.From == "" {
+		errs = append(errs, errors.New("smtp.from is required when smtp.addr is set"))
+	}
+	if c.SMTP.Addr != "" && c.Auth.EmailLoginURL != "" {
+		switch c.Auth.EmailLoginSecret {
+		case "":
+			errs = append(errs, errors.New("auth.email_login_secret is required when email login is on"))
+		case c.Auth.AccessTokenSecret:
+			errs = append(errs, errors.New("auth.email_login_secret must differ from auth.access_token_secret"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func isAddressRange(raw string) bool {
+	if _, _, err := net.ParseCIDR(raw); err == nil {
+		return true
+	}
+	return net.ParseIP(raw) != nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Redacted returns a copy that is safe to print: secrets are masked and
+// credentials are stripped from connection URLs.
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Auth.PostLoginRedirectAllowlist = append([]string(nil), c.Auth.PostLoginRedirectAllowlist...)
+	out.MongoDB.URI = redactURL(c.MongoDB.URI)
+	out.Media.CloudinaryURI = redactURL(c.Media.CloudinaryURI)
+	for _, secret := range []*string{
+		&out.Auth.AccessTokenSecret,
+		&out.Auth.SessionSecret,
+		&out.Auth.EmailLoginSecret,
+		&out.Google.ClientSecret,
+		&out.SMTP.Password,
+		&out.Metrics.Token,
+		&out.Media.S3.SecretAccessKey,
+	} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return &out
+}
+
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// String renders the redacted configuration as YAML.
+func (c *Config) String() string {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -3,12 +3,19 @@ package db
 import (
 	"context"
 
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func MongoClient(ctx context.Context, connectionUri string) (*mongo.Client, error) {
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connectionUri))
+// MongoClient connects to connectionUri. monitor, when non-nil, is notified of
+// every command the driver issues.
+func MongoClient(ctx context.Context, connectionUri string, monitor *event.CommandMonitor) (*mongo.Client, error) {
+	opts := options.Client().ApplyURI(connectionUri)
+	if monitor != nil {
+		opts.SetMonitor(monitor)
+	}
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
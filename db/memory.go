@@ -0,0 +1,440 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryCollection is an in-process Collection for tests and local runs
+// without MongoDB. It understands the query and update operators the
+// repositories use: $and, $or, $nor, $eq, $ne, $gt, $gte, $lt, $lte, $in,
+// $nin, $exists, $not and $regex in filters, and $set, $unset, $inc, $push,
+// $addToSet and $pull in updates. Anything else is reported as an error
+// rather than silently ignored. TTL indexes are not emulated.
+type MemoryCollection struct {
+	mu   sync.Mutex
+	docs []bson.M
+}
+
+func NewMemoryCollection() *MemoryCollection {
+	return &MemoryCollection{}
+}
+
+// MemoryDatabase hands out named in-memory collections, creating them on
+// first use.
+type MemoryDatabase struct {
+	mu          sync.Mutex
+	collections map[string]*MemoryCollection
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{collections: make(map[string]*MemoryCollection)}
+}
+
+func (d *MemoryDatabase) Collection(name string) *MemoryCollection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.collections[name]
+	if !ok {
+		c = NewMemoryCollection()
+		d.collections[name] = c
+	}
+	return c
+}
+
+var _ Collection = (*MemoryCollection)(nil)
+
+func duplicateKeyError(id interface{}) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: fmt.Sprintf("E11000 duplicate key error: _id %v", id),
+	}}}
+}
+
+// errorResult returns a SingleResult that fails with err. The driver needs a
+// non-nil document even when the result carries an error.
+func errorResult(err error) *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+}
+
+func (m *MemoryCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.docs {
+		if equal(existing["_id"], doc["_id"]) {
+			return nil, duplicateKeyError(doc["_id"])
+		}
+	}
+	m.docs = append(m.docs, doc)
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+func (m *MemoryCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	var sortSpec interface{}
+	var skip int64
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Sort != nil {
+			sortSpec = o.Sort
+		}
+		if o.Skip != nil {
+			skip = *o.Skip
+		}
+	}
+	m.mu.Lock()
+	docs, err := m.query(filter, sortSpec, skip, 1)
+	m.mu.Unlock()
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(docs) == 0 {
+		return errorResult(mongo.ErrNoDocuments)
+	}
+	return mongo.NewSingleResultFromDocument(docs[0], nil, nil)
+}
+
+func (m *MemoryCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	var sortSpec interface{}
+	var skip, limit int64
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Sort != nil {
+			sortSpec = o.Sort
+		}
+		if o.Skip != nil {
+			skip = *o.Skip
+		}
+		if o.Limit != nil {
+			limit = *o.Limit
+		}
+	}
+	m.mu.Lock()
+	docs, err := m.query(filter, sortSpec, skip, limit)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+func (m *MemoryCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	upsert := false
+	for _, o := range opts {
+		if o != nil && o.Upsert != nil {
+			upsert = *o.Upsert
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, doc := range m.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		updated := cloneDocument(doc)
+		if err := applyUpdate(updated, u); err != nil {
+			return nil, err
+		}
+		m.docs[i] = updated
+		modified := int64(0)
+		if !equal(doc, updated) {
+			modified = 1
+		}
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: modified}, nil
+	}
+	if !upsert {
+		return &mongo.UpdateResult{}, nil
+	}
+	doc := bson.M{}
+	for k, v := range f {
+		if !strings.HasPrefix(k, "$") && !isOperatorDocument(v) {
+			setPath(doc, k, v)
+		}
+	}
+	if err := applyUpdate(doc, u); err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	for _, existing := range m.docs {
+		if equal(existing["_id"], doc["_id"]) {
+			return nil, duplicateKeyError(doc["_id"])
+		}
+	}
+	m.docs = append(m.docs, doc)
+	return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: doc["_id"]}, nil
+}
+
+func (m *MemoryCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.indexOf(filter)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		return &mongo.DeleteResult{}, nil
+	}
+	m.docs = append(m.docs[:i], m.docs[i+1:]...)
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func (m *MemoryCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var kept []bson.M
+	var deleted int64
+	for _, doc := range m.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			deleted++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	m.docs = kept
+	return &mongo.DeleteResult{DeletedCount: deleted}, nil
+}
+
+func (m *MemoryCollection) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...*options.FindOneAndDeleteOptions) *mongo.SingleResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.indexOf(filter)
+	if err != nil {
+		return errorResult(err)
+	}
+	if i < 0 {
+		return errorResult(mongo.ErrNoDocuments)
+	}
+	doc := m.docs[i]
+	m.docs = append(m.docs[:i], m.docs[i+1:]...)
+	return mongo.NewSingleResultFromDocument(doc, nil, nil)
+}
+
+func (m *MemoryCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	docs, err := m.query(filter, nil, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(docs)), nil
+}
+
+// Documents returns a copy of every stored document, in insertion order.
+func (m *MemoryCollection) Documents() []bson.M {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]bson.M, len(m.docs))
+	for i, doc := range m.docs {
+		out[i] = cloneDocument(doc)
+	}
+	return out
+}
+
+func (m *MemoryCollection) indexOf(filter interface{}) (int, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return -1, err
+	}
+	for i, doc := range m.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func (m *MemoryCollection) query(filter, sortSpec interface{}, skip, limit int64) ([]bson.M, error) {
+	f, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var out []bson.M
+	for _, doc := range m.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, cloneDocument(doc))
+		}
+	}
+	if sortSpec != nil {
+		keys, err := sortKeys(sortSpec)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(out, func(i, j int) bool {
+			for _, k := range keys {
+				c := compareValues(first(lookup(out[i], k.field)), first(lookup(out[j], k.field)))
+				if c != 0 {
+					return c*k.direction < 0
+				}
+			}
+			return false
+		})
+	}
+	if skip > 0 {
+		if skip >= int64(len(out)) {
+			return nil, nil
+		}
+		out = out[skip:]
+	}
+	if limit > 0 && limit < int64(len(out)) {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+type sortKey struct {
+	field     string
+	direction int
+}
+
+func sortKeys(spec interface{}) ([]sortKey, error) {
+	var keys []sortKey
+	switch s := spec.(type) {
+	case bson.D:
+		for _, e := range s {
+			keys = append(keys, sortKey{e.Key, direction(e.Value)})
+		}
+	case bson.M:
+		fields := make([]string, 0, len(s))
+		for k := range s {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		for _, k := range fields {
+			keys = append(keys, sortKey{k, direction(s[k])})
+		}
+	default:
+		return nil, fmt.Errorf("memory collection: unsupported sort specification %T", spec)
+	}
+	return keys, nil
+}
+
+func direction(v interface{}) int {
+	if n, ok := toFloat(v); ok && n < 0 {
+		return -1
+	}
+	return 1
+}
+
+func first(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// toDocument converts any BSON-marshalable value to a bson.M with the types
+// the driver decodes to, so stored values and query values compare alike.
+func toDocument(v interface{}) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return normalizeDocument(doc), nil
+}
+
+func normalizeDocument(doc bson.M) bson.M {
+	for k, v := range doc {
+		doc[k] = normalize(v)
+	}
+	return doc
+}
+
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case primitive.D:
+		m := bson.M{}
+		for _, e := range t {
+			m[e.Key] = normalize(e.Value)
+		}
+		return m
+	case primitive.M:
+		return normalizeDocument(bson.M(t))
+	case primitive.A:
+		for i := range t {
+			t[i] = normalize(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+func cloneDocument(doc bson.M) bson.M {
+	return cloneValue(doc).(bson.M)
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		m := make(bson.M, len(t))
+		for k, e := range t {
+			m[k] = cloneValue(e)
+		}
+		return m
+	case primitive.A:
+		a := make(primitive.A, len(t))
+		for i, e := range t {
+			a[i] = cloneValue(e)
+		}
+		return a
+	}
+	return v
+}
@@ -0,0 +1,453 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func isOperatorDocument(v interface{}) bool {
+	m, ok := v.(bson.M)
+	if !ok || len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether doc satisfies filter.
+func matches(doc, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("memory collection: unsupported query operator %s", key)
+			}
+			values := lookup(doc, key)
+			ok, err = matchCondition(values, cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	clauses, ok := cond.(primitive.A)
+	if !ok {
+		return false, fmt.Errorf("memory collection: %s needs an array", op)
+	}
+	for _, clause := range clauses {
+		sub, ok := clause.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("memory collection: %s clauses must be documents", op)
+		}
+		ok, err := matches(doc, sub)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !ok:
+			return false, nil
+		case op == "$or" && ok:
+			return true, nil
+		case op == "$nor" && ok:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+// lookup resolves a dotted path, descending into arrays of documents the way
+// MongoDB does. It returns every value found.
+func lookup(v interface{}, path string) []interface{} {
+	if path == "" {
+		return []interface{}{v}
+	}
+	head, rest, _ := strings.Cut(path, ".")
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[head]
+		if !ok {
+			return nil
+		}
+		return lookup(child, rest)
+	case primitive.A:
+		if i, err := strconv.Atoi(head); err == nil {
+			if i < 0 || i >= len(t) {
+				return nil
+			}
+			return lookup(t[i], rest)
+		}
+		var out []interface{}
+		for _, e := range t {
+			if _, ok := e.(bson.M); ok {
+				out = append(out, lookup(e, path)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func matchCondition(values []interface{}, cond interface{}) (bool, error) {
+	if isOperatorDocument(cond) {
+		for op, arg := range cond.(bson.M) {
+			ok, err := matchOperator(values, op, arg, cond.(bson.M))
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if re, ok := cond.(primitive.Regex); ok {
+		return anyValue(values, func(v interface{}) bool { return matchRegex(v, re) }), nil
+	}
+	if cond == nil && len(values) == 0 {
+		return true, nil
+	}
+	return anyValue(values, func(v interface{}) bool { return equal(v, cond) }), nil
+}
+
+// anyValue applies pred to each value and, for arrays, to each element.
+func anyValue(values []interface{}, pred func(interface{}) bool) bool {
+	for _, v := range values {
+		if pred(v) {
+			return true
+		}
+		if arr, ok := v.(primitive.A); ok {
+			for _, e := range arr {
+				if pred(e) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchOperator(values []interface{}, op string, arg interface{}, cond bson.M) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchCondition(values, bson.M{"$in": primitive.A{arg}})
+	case "$ne":
+		ok, err := matchCondition(values, bson.M{"$in": primitive.A{arg}})
+		return !ok, err
+	case "$gt", "$gte", "$lt", "$lte":
+		return anyValue(values, func(v interface{}) bool {
+			if !sameKind(v, arg) {
+				return false
+			}
+			c := compareValues(v, arg)
+			switch op {
+			case "$gt":
+				return c > 0
+			case "$gte":
+				return c >= 0
+			case "$lt":
+				return c < 0
+			}
+			return c <= 0
+		}), nil
+	case "$in", "$nin":
+		candidates, ok := arg.(primitive.A)
+		if !ok {
+			return false, fmt.Errorf("memory collection: %s needs an array", op)
+		}
+		found := false
+		for _, c := range candidates {
+			if ok, _ := matchCondition(values, c); ok {
+				found = true
+				break
+			}
+		}
+		if op == "$nin" {
+			return !found, nil
+		}
+		return found, nil
+	case "$exists":
+		want, _ := arg.(bool)
+		return (len(values) > 0) == want, nil
+	case "$not":
+		ok, err := matchCondition(values, arg)
+		return !ok, err
+	case "$regex":
+		re := primitive.Regex{}
+		switch p := arg.(type) {
+		case string:
+			re.Pattern = p
+		case primitive.Regex:
+			re = p
+		default:
+			return false, fmt.Errorf("memory collection: $regex needs a string")
+		}
+		if opts, ok := cond["$options"].(string); ok {
+			re.Options = opts
+		}
+		return anyValue(values, func(v interface{}) bool { return matchRegex(v, re) }), nil
+	case "$options":
+		return true, nil
+	}
+	return false, fmt.Errorf("memory collection: unsupported query operator %s", op)
+}
+
+func matchRegex(v interface{}, re primitive.Regex) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	pattern := re.Pattern
+	var flags string
+	for _, o := range re.Options {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	return compiled.MatchString(s)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func sameKind(a, b interface{}) bool {
+	if _, ok := toFloat(a); ok {
+		_, ok := toFloat(b)
+		return ok
+	}
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
+
+// compareValues orders two values of the same kind. Values of different
+// kinds are ordered by kind so sorting is stable.
+func compareValues(a, b interface{}) int {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	switch x := a.(type) {
+	case nil:
+		if b == nil {
+			return 0
+		}
+		return -1
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0
+			case !x:
+				return -1
+			}
+			return 1
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:])
+		}
+	}
+	if b == nil {
+		return 1
+	}
+	return strings.Compare(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b))
+}
+
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// applyUpdate applies a MongoDB update document to doc in place.
+func applyUpdate(doc, update bson.M) error {
+	if len(update) == 0 {
+		return fmt.Errorf("memory collection: empty update")
+	}
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("memory collection: update must use operators, got %s", op)
+		}
+		for path, v := range fields {
+			if err := applyOperator(doc, op, path, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyOperator(doc bson.M, op, path string, v interface{}) error {
+	switch op {
+	case "$set":
+		setPath(doc, path, v)
+	case "$unset":
+		unsetPath(doc, path)
+	case "$inc":
+		delta, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("memory collection: $inc needs a number")
+		}
+		current := first(lookup(doc, path))
+		switch n := current.(type) {
+		case nil:
+			setPath(doc, path, v)
+		case int32:
+			setPath(doc, path, n+int32(delta))
+		case int64:
+			setPath(doc, path, n+int64(delta))
+		case float64:
+			setPath(doc, path, n+delta)
+		default:
+			return fmt.Errorf("memory collection: cannot $inc %s", path)
+		}
+	case "$push", "$addToSet":
+		arr, err := arrayAt(doc, path)
+		if err != nil {
+			return err
+		}
+		items := primitive.A{v}
+		if m, ok := v.(bson.M); ok {
+			if each, ok := m["$each"].(primitive.A); ok {
+				items = each
+			}
+		}
+		for _, item := range items {
+			if op == "$addToSet" && containsValue(arr, item) {
+				continue
+			}
+			arr = append(arr, item)
+		}
+		setPath(doc, path, arr)
+	case "$pull":
+		arr, err := arrayAt(doc, path)
+		if err != nil {
+			return err
+		}
+		kept := primitive.A{}
+		for _, item := range arr {
+			remove, err := pullMatches(item, v)
+			if err != nil {
+				return err
+			}
+			if !remove {
+				kept = append(kept, item)
+			}
+		}
+		setPath(doc, path, kept)
+	default:
+		return fmt.Errorf("memory collection: unsupported update operator %s", op)
+	}
+	return nil
+}
+
+func pullMatches(item, cond interface{}) (bool, error) {
+	if m, ok := cond.(bson.M); ok && !isOperatorDocument(cond) {
+		sub, ok := item.(bson.M)
+		if !ok {
+			return false, nil
+		}
+		return matches(sub, m)
+	}
+	return matchCondition([]interface{}{item}, cond)
+}
+
+func containsValue(arr primitive.A, v interface{}) bool {
+	for _, e := range arr {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func arrayAt(doc bson.M, path string) (primitive.A, error) {
+	current := first(lookup(doc, path))
+	switch a := current.(type) {
+	case nil:
+		return primitive.A{}, nil
+	case primitive.A:
+		return a, nil
+	}
+	return nil, fmt.Errorf("memory collection: %s is not an array", path)
+}
+
+func setPath(doc bson.M, path string, v interface{}) {
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		doc[head] = v
+		return
+	}
+	child, ok := doc[head].(bson.M)
+	if !ok {
+		child = bson.M{}
+		doc[head] = child
+	}
+	setPath(child, rest, v)
+}
+
+func unsetPath(doc bson.M, path string) {
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		delete(doc, head)
+		return
+	}
+	if child, ok := doc[head].(bson.M); ok {
+		unsetPath(child, rest)
+	}
+}
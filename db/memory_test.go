@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type post struct {
+	Id    string   `bson:"_id"`
+	Title string   `bson:"title"`
+	Views int      `bson:"views"`
+	Tags  []string `bson:"tags,omitempty"`
+	Meta  bson.M   `bson:"meta,omitempty"`
+}
+
+func seed(t *testing.T) *MemoryCollection {
+	t.Helper()
+	c := NewMemoryCollection()
+	for _, p := range []post{
+		{Id: "a", Title: "Alpha", Views: 3, Tags: []string{"go", "db"}, Meta: bson.M{"size": "large"}},
+		{Id: "b", Title: "Beta", Views: 1, Tags: []string{"go"}},
+		{Id: "c", Title: "Gamma", Views: 2},
+	} {
+		if _, err := c.InsertOne(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func ids(t *testing.T, c *MemoryCollection, filter interface{}, opts ...*options.FindOptions) []string {
+	t.Helper()
+	cur, err := c.Find(context.Background(), filter, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var posts []post
+	if err := cur.All(context.Background(), &posts); err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for _, p := range posts {
+		out = append(out, p.Id)
+	}
+	return out
+}
+
+func TestMemoryCollectionFilters(t *testing.T) {
+	c := seed(t)
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   []string
+	}{
+		{"all", bson.M{}, []string{"a", "b", "c"}},
+		{"equality", bson.M{"title": "Beta"}, []string{"b"}},
+		{"array contains", bson.M{"tags": "go"}, []string{"a", "b"}},
+		{"dotted path", bson.M{"meta.size": "large"}, []string{"a"}},
+		{"in", bson.M{"_id": bson.M{"$in": bson.A{"a", "c"}}}, []string{"a", "c"}},
+		{"nin", bson.M{"_id": bson.M{"$nin": bson.A{"a", "c"}}}, []string{"b"}},
+		{"range", bson.M{"views": bson.M{"$gte": 2, "$lt": 3}}, []string{"c"}},
+		{"ne", bson.M{"views": bson.M{"$ne": 3}}, []string{"b", "c"}},
+		{"exists", bson.M{"tags": bson.M{"$exists": false}}, []string{"c"}},
+		{"or", bson.M{"$or": bson.A{bson.M{"_id": "a"}, bson.M{"views": 2}}}, []string{"a", "c"}},
+		{"and", bson.M{"$and": bson.A{bson.M{"tags": "go"}, bson.M{"views": bson.M{"$lte": 1}}}}, []string{"b"}},
+		{"nor", bson.M{"$nor": bson.A{bson.M{"_id": "a"}, bson.M{"_id": "b"}}}, []string{"c"}},
+		{"not", bson.M{"views": bson.M{"$not": bson.M{"$gt": 1}}}, []string{"b"}},
+		{"regex", bson.M{"title": primitive.Regex{Pattern: "^al", Options: "i"}}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(t, c, tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryCollectionUnknownOperator(t *testing.T) {
+	c := seed(t)
+	if _, err := c.Find(context.Background(), bson.M{"views": bson.M{"$near": 1}}); err == nil {
+		t.Error("unknown query operator was accepted")
+	}
+	if _, err := c.UpdateOne(context.Background(), bson.M{"_id": "a"}, bson.M{"$rename": bson.M{"title": "name"}}); err == nil {
+		t.Error("unknown update operator was accepted")
+	}
+}
+
+func TestMemoryCollectionSortSkipLimit(t *testing.T) {
+	c := seed(t)
+	opts := options.Find().SetSort(bson.D{{Key: "views", Value: -1}}).SetSkip(1).SetLimit(1)
+	if got := ids(t, c, bson.M{}, opts); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("got %v, want [c]", got)
+	}
+	var p post
+	err := c.FindOne(context.Background(), bson.M{}, options.FindOne().SetSort(bson.M{"title": -1})).Decode(&p)
+	if err != nil || p.Id != "c" {
+		t.Errorf("FindOne sorted by title desc = %q, %v; want c", p.Id, err)
+	}
+}
+
+func TestMemoryCollectionUpdates(t *testing.T) {
+	ctx := context.Background()
+	c := seed(t)
+	update := bson.M{
+		"$set":      bson.M{"title": "Alpha 2", "meta.size": "small"},
+		"$inc":      bson.M{"views": 2},
+		"$addToSet": bson.M{"tags": "go"},
+		"$push":     bson.M{"history": "edited"},
+	}
+	res, err := c.UpdateOne(ctx, bson.M{"_id": "a"}, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MatchedCount != 1 || res.ModifiedCount != 1 {
+		t.Errorf("matched %d, modified %d; want 1, 1", res.MatchedCount, res.ModifiedCount)
+	}
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": "a"}, bson.M{"$pull": bson.M{"tags": "db"}, "$unset": bson.M{"meta": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	var got bson.M
+	if err := c.FindOne(ctx, bson.M{"_id": "a"}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["title"] != "Alpha 2" || got["views"] != int32(5) {
+		t.Errorf("title %v, views %v; want Alpha 2, 5", got["title"], got["views"])
+	}
+	if tags := got["tags"].(bson.A); len(tags) != 1 || tags[0] != "go" {
+		t.Errorf("tags = %v, want [go]", tags)
+	}
+	if history := got["history"].(bson.A); len(history) != 1 || history[0] != "edited" {
+		t.Errorf("history = %v, want [edited]", history)
+	}
+	if _, ok := got["meta"]; ok {
+		t.Errorf("meta = %v, want it unset", got["meta"])
+	}
+
+	res, err = c.UpdateOne(ctx, bson.M{"_id": "missing"}, bson.M{"$set": bson.M{"views": 1}})
+	if err != nil || res.MatchedCount != 0 {
+		t.Errorf("update of a missing document = %+v, %v; want no match", res, err)
+	}
+}
+
+func TestMemoryCollectionUpsert(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCollection()
+	res, err := c.UpdateOne(ctx, bson.M{"key": "k", "n": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"n": 1}}, options.Update().SetUpsert(true))
+	if err != nil || res.UpsertedCount != 1 {
+		t.Fatalf("upsert = %+v, %v", res, err)
+	}
+	docs := c.Documents()
+	if len(docs) != 1 || docs[0]["key"] != "k" || docs[0]["n"] != int32(1) {
+		t.Errorf("documents = %v, want one {key: k, n: 1}", docs)
+	}
+}
+
+func TestMemoryCollectionDuplicateKey(t *testing.T) {
+	c := seed(t)
+	_, err := c.InsertOne(context.Background(), post{Id: "a"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("err = %v, want a duplicate key error", err)
+	}
+	// An upsert whose filter misses but names a taken _id fails the same way.
+	_, err = c.UpdateOne(context.Background(), bson.M{"_id": "a", "views": 99}, bson.M{"$set": bson.M{"title": "A"}}, options.Update().SetUpsert(true))
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("upsert err = %v, want a duplicate key error", err)
+	}
+}
+
+func TestMemoryCollectionDeletes(t *testing.T) {
+	ctx := context.Background()
+	c := seed(t)
+	for _, id := range []string{"a", "b"} {
+		res, err := c.DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil || res.DeletedCount != 1 {
+			t.Fatalf("DeleteOne(%s) = %+v, %v; want 1 deleted", id, res, err)
+		}
+	}
+	var p post
+	if err := c.FindOneAndDelete(ctx, bson.M{"_id": "c"}).Decode(&p); err != nil || p.Title != "Gamma" {
+		t.Errorf("FindOneAndDelete = %+v, %v", p, err)
+	}
+	if n, err := c.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+		t.Errorf("CountDocuments = %d, %v; want 0", n, err)
+	}
+	if err := c.FindOne(ctx, bson.M{"_id": "c"}).Err(); err != mongo.ErrNoDocuments {
+		t.Errorf("FindOne after delete = %v, want ErrNoDocuments", err)
+	}
+}
+
+func TestMemoryDatabaseCollections(t *testing.T) {
+	d := NewMemoryDatabase()
+	if d.Collection("posts") != d.Collection("posts") {
+		t.Error("Collection returned a new collection for a known name")
+	}
+	if d.Collection("posts") == d.Collection("users") {
+		t.Error("different names share a collection")
+	}
+}
@@ -0,0 +1,163 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check reports whether a dependency is usable. A nil error means healthy.
+type Check func(ctx context.Context) error
+
+var ErrDraining = errors.New("server is shutting down")
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker backs the liveness and readiness probes.
+type Checker struct {
+	timeout  time.Duration
+	mu       sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// New returns a Checker that gives each readiness check at most timeout to
+// answer.
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check under name.
+func (h *Checker) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Drain makes readiness fail from now on so load balancers stop routing new
+// traffic while in-flight requests finish. Liveness is unaffected.
+func (h *Checker) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Checker) Draining() bool {
+	return h.draining.Load()
+}
+
+// Ready runs every check concurrently and reports the outcome of each.
+func (h *Checker) Ready(ctx context.Context) (*Report, bool) {
+	h.mu.RLock()
+	checks := append([]namedCheck(nil), h.checks...)
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	report := &Report{Status: "ok", Checks: make(map[string]CheckResult, len(checks)+1)}
+	ok := true
+	if h.Draining() {
+		report.Checks["draining"] = CheckResult{Status: "fail", Error: ErrDraining.Error()}
+		ok = false
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := nc.check(ctx)
+			result := CheckResult{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				ok = false
+			}
+		}(nc)
+	}
+	wg.Wait()
+	if !ok {
+		report.Status = "unavailable"
+	}
+	return report, ok
+}
+
+// Liveness answers 200 as long as the process can serve HTTP.
+func (h *Checker) Liveness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Readiness answers 200 when every check passes and 503 otherwise, with a
+// per-check breakdown in both cases. Failure details can name hosts and
+// other internals, so they go to the request log instead of the body.
+func (h *Checker) Readiness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, ok := h.Ready(c.Request.Context())
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusServiceUnavailable
+		}
+		for name, result := range report.Checks {
+			if result.Error != "" {
+				c.Error(fmt.Errorf("readiness check %s: %s", name, result.Error))
+				result.Error = ""
+				report.Checks[name] = result
+			}
+		}
+		c.JSON(status, report)
+	}
+}
+
+// Status is a check that fails until Done is called, for one-off startup
+// work such as building indexes.
+type Status struct {
+	mu   sync.RWMutex
+	done bool
+	err  error
+}
+
+var ErrPending = errors.New("not finished yet")
+
+func (s *Status) Done(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.err = err
+}
+
+func (s *Status) Check(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.done {
+		return ErrPending
+	}
+	return s.err
+}
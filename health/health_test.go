@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadinessHidesFailureDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := New(time.Second)
+	h.Add("mongodb", func(ctx context.Context) error { return errors.New("dial tcp 10.0.0.5:27017: connection refused") })
+	h.Add("media", func(ctx context.Context) error { return nil })
+
+	var logged []string
+	r := gin.New()
+	r.GET("/readyz", func(c *gin.Context) {
+		c.Next()
+		for _, e := range c.Errors {
+			logged = append(logged, e.Error())
+		}
+	}, h.Readiness())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "10.0.0.5") {
+		t.Errorf("body leaks the failure detail: %s", w.Body.String())
+	}
+	var report Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "unavailable" || report.Checks["mongodb"].Status != "fail" || report.Checks["media"].Status != "ok" {
+		t.Errorf("report = %+v, want mongodb failing and media ok", report)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "mongodb") || !strings.Contains(logged[0], "10.0.0.5") {
+		t.Errorf("logged errors = %v, want the mongodb failure", logged)
+	}
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	h := New(time.Second)
+	if _, ok := h.Ready(context.Background()); !ok {
+		t.Fatal("a checker without checks is not ready")
+	}
+	h.Drain()
+	if report, ok := h.Ready(context.Background()); ok || report.Checks["draining"].Status != "fail" {
+		t.Errorf("report = %+v, %v; want not ready while draining", report, ok)
+	}
+}
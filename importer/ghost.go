@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+type ghostData struct {
+	Posts []struct {
+		Id            string     `json:"id"`
+		Title         string     `json:"title"`
+		Slug          string     `json:"slug"`
+		Html          string     `json:"html"`
+		Plaintext     string     `json:"plaintext"`
+		CustomExcerpt string     `json:"custom_excerpt"`
+		Status        string     `json:"status"`
+		Type          string     `json:"type"`
+		Page          bool       `json:"page"`
+		AuthorId      string     `json:"author_id"`
+		CreatedAt     time.Time  `json:"created_at"`
+		UpdatedAt     time.Time  `json:"updated_at"`
+		PublishedAt   *time.Time `json:"published_at"`
+	} `json:"posts"`
+	Tags []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"tags"`
+	PostsTags []struct {
+		PostId    string `json:"post_id"`
+		TagId     string `json:"tag_id"`
+		SortOrder int    `json:"sort_order"`
+	} `json:"posts_tags"`
+	Users []struct {
+		Id    string `json:"id"`
+		Email string `json:"email"`
+	} `json:"users"`
+	PostsAuthors []struct {
+		PostId    string `json:"post_id"`
+		AuthorId  string `json:"author_id"`
+		SortOrder int    `json:"sort_order"`
+	} `json:"posts_authors"`
+}
+
+// ParseGhost reads a Ghost JSON export, either the {"db": [...]} wrapper
+// written by the admin UI or a bare {"data": ...}. Ghost does not export
+// comments. Pages and unpublished posts count as skipped; internal tags,
+// whose names start with #, are dropped.
+func ParseGhost(r io.Reader) (*Source, error) {
+	var f struct {
+		DB []struct {
+			Data ghostData `json:"data"`
+		} `json:"db"`
+		Data *ghostData `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid Ghost export: %w", err)
+	}
+	var data ghostData
+	switch {
+	case len(f.DB) > 0:
+		data = f.DB[0].Data
+	case f.Data != nil:
+		data = *f.Data
+	default:
+		return nil, fmt.Errorf("invalid Ghost export: no data")
+	}
+
+	tagNames := map[string]string{}
+	for _, t := range data.Tags {
+		tagNames[t.Id] = t.Name
+	}
+	sort.SliceStable(data.PostsTags, func(i, j int) bool { return data.PostsTags[i].SortOrder < data.PostsTags[j].SortOrder })
+	tags := map[string][]string{}
+	for _, pt := range data.PostsTags {
+		if name := tagNames[pt.TagId]; name != "" && name[0] != '#' {
+			tags[pt.PostId] = append(tags[pt.PostId], name)
+		}
+	}
+	emails := map[string]string{}
+	for _, u := range data.Users {
+		emails[u.Id] = u.Email
+	}
+	// The primary author has the lowest sort order; older exports only
+	// have author_id on the post.
+	authors := map[string]string{}
+	sort.SliceStable(data.PostsAuthors, func(i, j int) bool { return data.PostsAuthors[i].SortOrder < data.PostsAuthors[j].SortOrder })
+	for _, pa := range data.PostsAuthors {
+		if _, ok := authors[pa.PostId]; !ok {
+			authors[pa.PostId] = pa.AuthorId
+		}
+	}
+
+	src := &Source{System: "ghost"}
+	for _, gp := range data.Posts {
+		if gp.Page || (gp.Type != "" && gp.Type != "post") || gp.Status != "published" {
+			src.Skipped++
+			continue
+		}
+		authorId, ok := authors[gp.Id]
+		if !ok {
+			authorId = gp.AuthorId
+		}
+		p := Post{
+			SourceID:    gp.Id,
+			Title:       gp.Title,
+			Slug:        gp.Slug,
+			Description: gp.CustomExcerpt,
+			Content:     gp.Html,
+			Tags:        tags[gp.Id],
+			AuthorEmail: emails[authorId],
+			CreatedAt:   gp.CreatedAt,
+			UpdatedAt:   gp.UpdatedAt,
+		}
+		if p.Content == "" {
+			p.Content = gp.Plaintext
+		}
+		if gp.PublishedAt != nil {
+			p.CreatedAt = *gp.PublishedAt
+		}
+		src.Posts = append(src.Posts, p)
+	}
+	return src, nil
+}
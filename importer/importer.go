@@ -0,0 +1,303 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gosimple/slug"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Post is a post read from another blogging system, before it is mapped to
+// a blog.BlogPost.
+type Post struct {
+	// SourceID is the post's ID in its system. Together with the system
+	// name it keys re-runs, so it must be stable across exports.
+	SourceID string
+	Title    string
+	// Slug is normalised with slug.Make; the title's slug is used when it
+	// is empty.
+	Slug        string
+	Description string
+	Content     string
+	Tags        []string
+	AuthorEmail string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Comments    []Comment
+}
+
+// Comment is a comment on a Post. ParentSourceID refers to another comment
+// of the same post.
+type Comment struct {
+	SourceID       string
+	ParentSourceID string
+	AuthorName     string
+	AuthorEmail    string
+	Content        string
+	CreatedAt      time.Time
+}
+
+// Source is a parsed export from one system.
+type Source struct {
+	// System names the origin, such as "wordpress"; it prefixes source IDs.
+	System string
+	Posts  []Post
+	// Skipped counts entries the parser ignored, such as drafts and pages.
+	Skipped int
+}
+
+type Options struct {
+	// DryRun reports what would be imported without writing anything.
+	DryRun bool
+	// Progress, when set, is called after each post.
+	Progress func(Progress)
+}
+
+type Progress struct {
+	Done   int
+	Total  int
+	Title  string
+	Action string
+}
+
+const (
+	ActionCreated = "created"
+	ActionRenamed = "created with a new slug"
+	ActionExists  = "already imported"
+)
+
+type Report struct {
+	DryRun          bool     `json:"dry_run"`
+	PostsCreated    int      `json:"posts_created"`
+	PostsRenamed    int      `json:"posts_renamed"`
+	PostsExisting   int      `json:"posts_existing"`
+	CommentsCreated int      `json:"comments_created"`
+	CommentsSkipped int      `json:"comments_existing"`
+	SourceSkipped   int      `json:"source_skipped"`
+	Warnings        []string `json:"warnings,omitempty"`
+}
+
+func (r *Report) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// MediaReferences tracks which uploads a post uses, so the media library
+// does not delete them from under it.
+type MediaReferences interface {
+	SetReferences(ctx context.Context, ref string, urls ...string) error
+}
+
+type Importer struct {
+	posts blog.BlogRepository
+	users user.UserRepository
+	media MediaReferences
+	clock clock.Clock
+}
+
+// NewImporter returns an importer into posts and users. media may be nil.
+func NewImporter(posts blog.BlogRepository, users user.UserRepository, media MediaReferences, clk clock.Clock) *Importer {
+	return &Importer{posts: posts, users: users, media: media, clock: clock.OrSystem(clk)}
+}
+
+// run holds the state of one Import call.
+type run struct {
+	*Importer
+	system  string
+	opts    Options
+	report  *Report
+	authors map[string]string
+}
+
+// Import stores the posts and comments of src. Anything already imported
+// from the same system and source ID is left alone, so an interrupted or
+// repeated import can simply be run again. A post whose slug is taken by an
+// unrelated post gets a numeric suffix.
+func (im *Importer) Import(ctx context.Context, src *Source, opts Options) (*Report, error) {
+	r := &run{
+		Importer: im,
+		system:   src.System,
+		opts:     opts,
+		report:   &Report{DryRun: opts.DryRun, SourceSkipped: src.Skipped},
+		authors:  map[string]string{},
+	}
+	for i, p := range src.Posts {
+		action, err := r.importPost(ctx, p)
+		if err != nil {
+			return r.report, fmt.Errorf("post %q: %w", p.Title, err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(Progress{Done: i + 1, Total: len(src.Posts), Title: p.Title, Action: action})
+		}
+	}
+	return r.report, nil
+}
+
+func (r *run) sourceId(id string) string {
+	return r.system + ":" + id
+}
+
+func (r *run) importPost(ctx context.Context, p Post) (string, error) {
+	existing, err := r.posts.GetBlogPost(ctx, bson.M{"source_id": r.sourceId(p.SourceID)})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	if existing != nil {
+		r.report.PostsExisting++
+		return ActionExists, r.importComments(ctx, existing.Id, p.Comments)
+	}
+
+	action := ActionCreated
+	post := &blog.BlogPost{
+		Title:       p.Title,
+		Slug:        slug.Make(p.Slug),
+		AuthorId:    r.authorId(ctx, p.AuthorEmail),
+		Description: p.Description,
+		Content:     p.Content,
+		Tags:        p.Tags,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+		SourceId:    r.sourceId(p.SourceID),
+	}
+	if post.Slug == "" {
+		post.Slug = slug.Make(p.Title)
+	}
+	if post.CreatedAt.IsZero() {
+		post.CreatedAt = r.clock.Now()
+	}
+	if post.UpdatedAt.IsZero() {
+		post.UpdatedAt = post.CreatedAt
+	}
+	free, err := r.freeSlug(ctx, post.Slug)
+	if err != nil {
+		return "", err
+	}
+	if free != post.Slug {
+		r.report.warn("%s: slug %s is taken, using %s", p.Title, post.Slug, free)
+		post.Slug = free
+		action = ActionRenamed
+		r.report.PostsRenamed++
+	}
+	r.report.PostsCreated++
+	if r.opts.DryRun {
+		r.report.CommentsCreated += len(p.Comments)
+		return action, nil
+	}
+	res, err := r.posts.CreateBlogPost(ctx, post)
+	if err != nil {
+		return "", err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	if r.media != nil {
+		if err := r.media.SetReferences(ctx, blog.MediaRef(id), blog.MediaURLs(post)...); err != nil {
+			return "", err
+		}
+	}
+	return action, r.importComments(ctx, id, p.Comments)
+}
+
+func (r *run) freeSlug(ctx context.Context, s string) (string, error) {
+	for i := 1; ; i++ {
+		candidate := s
+		if i > 1 {
+			candidate = s + "-" + strconv.Itoa(i)
+		}
+		_, err := r.posts.GetBlogPost(ctx, bson.M{"slug": candidate})
+		if err == mongo.ErrNoDocuments {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// importComments inserts the comments of one post so that parents come
+// before replies, skipping comments that were imported before.
+func (r *run) importComments(ctx context.Context, postId primitive.ObjectID, comments []Comment) error {
+	ids := map[string]primitive.ObjectID{}
+	pending := comments
+	for len(pending) > 0 {
+		var next []Comment
+		for _, c := range pending {
+			parentId, ok := ids[c.ParentSourceID]
+			if c.ParentSourceID != "" && !ok {
+				next = append(next, c)
+				continue
+			}
+			id, err := r.importComment(ctx, postId, parentId, c)
+			if err != nil {
+				return err
+			}
+			ids[c.SourceID] = id
+		}
+		if len(next) == len(pending) {
+			r.report.warn("%d comment(s) reply to comments that are not in the export and were skipped", len(next))
+			return nil
+		}
+		pending = next
+	}
+	return nil
+}
+
+func (r *run) importComment(ctx context.Context, postId, parentId primitive.ObjectID, c Comment) (primitive.ObjectID, error) {
+	existing, err := r.posts.GetComment(ctx, bson.M{"source_id": r.sourceId(c.SourceID)})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, err
+	}
+	if existing != nil {
+		r.report.CommentsSkipped++
+		return existing.Id, nil
+	}
+	r.report.CommentsCreated++
+	if r.opts.DryRun {
+		// Replies still need a parent to resolve against.
+		return primitive.NewObjectID(), nil
+	}
+	createdAt := c.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = r.clock.Now()
+	}
+	res, err := r.posts.PostComment(ctx, &blog.Comment{
+		AuthorId:   r.authorId(ctx, c.AuthorEmail),
+		AuthorName: c.AuthorName,
+		BlogPostId: postId,
+		ParentId:   parentId,
+		Content:    c.Content,
+		CreatedAt:  createdAt,
+		UpdatedAt:  createdAt,
+		SourceId:   r.sourceId(c.SourceID),
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+// authorId finds the bloggy account with email. Posts and comments by
+// people without an account are imported without one.
+func (r *run) authorId(ctx context.Context, email string) string {
+	if email == "" {
+		return ""
+	}
+	if id, ok := r.authors[email]; ok {
+		return id
+	}
+	filter := bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(email) + "$", Options: "i"}}
+	u, err := r.users.GetUser(ctx, filter)
+	if err == nil {
+		r.authors[email] = u.ID
+	} else {
+		r.authors[email] = ""
+	}
+	return r.authors[email]
+}
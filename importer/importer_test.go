@@ -0,0 +1,370 @@
+package importer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/ayo-ajayi/bloggy/user"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const wxr = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"
+	xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<wp:author><wp:author_login>ada</wp:author_login><wp:author_email>ada@example.com</wp:author_email></wp:author>
+	<item>
+		<title>Hello WordPress</title>
+		<dc:creator>ada</dc:creator>
+		<content:encoded><![CDATA[<p>Body</p>]]></content:encoded>
+		<excerpt:encoded><![CDATA[ Summary ]]></excerpt:encoded>
+		<wp:post_id>7</wp:post_id>
+		<wp:post_date_gmt>2020-05-01 10:00:00</wp:post_date_gmt>
+		<wp:post_modified_gmt>0000-00-00 00:00:00</wp:post_modified_gmt>
+		<wp:post_name>Hello World!</wp:post_name>
+		<wp:status>publish</wp:status>
+		<wp:post_type>post</wp:post_type>
+		<category domain="category" nicename="go">Go</category>
+		<category domain="post_tag" nicename="go">Go</category>
+		<category domain="post_tag" nicename="db">DB</category>
+		<wp:comment>
+			<wp:comment_id>1</wp:comment_id>
+			<wp:comment_author>Bob</wp:comment_author>
+			<wp:comment_date_gmt>2020-05-02 10:00:00</wp:comment_date_gmt>
+			<wp:comment_content>First</wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+			<wp:comment_parent>0</wp:comment_parent>
+			<wp:comment_type>comment</wp:comment_type>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>2</wp:comment_id>
+			<wp:comment_author>Ada</wp:comment_author>
+			<wp:comment_content>Reply</wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+			<wp:comment_parent>1</wp:comment_parent>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>3</wp:comment_id>
+			<wp:comment_content>Buy pills</wp:comment_content>
+			<wp:comment_approved>spam</wp:comment_approved>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>4</wp:comment_id>
+			<wp:comment_content>Linked from elsewhere</wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+			<wp:comment_type>pingback</wp:comment_type>
+		</wp:comment>
+	</item>
+	<item>
+		<title>About</title>
+		<wp:post_id>8</wp:post_id>
+		<wp:status>publish</wp:status>
+		<wp:post_type>page</wp:post_type>
+	</item>
+	<item>
+		<title>Draft</title>
+		<wp:post_id>9</wp:post_id>
+		<wp:status>draft</wp:status>
+		<wp:post_type>post</wp:post_type>
+	</item>
+</channel>
+</rss>`
+
+func TestParseWXR(t *testing.T) {
+	src, err := ParseWXR(strings.NewReader(wxr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.System != "wordpress" || src.Skipped != 2 || len(src.Posts) != 1 {
+		t.Fatalf("source = %s with %d posts, %d skipped; want wordpress, 1, 2", src.System, len(src.Posts), src.Skipped)
+	}
+	p := src.Posts[0]
+	if p.SourceID != "7" || p.Title != "Hello WordPress" || p.Content != "<p>Body</p>" || p.Description != "Summary" || p.AuthorEmail != "ada@example.com" {
+		t.Errorf("post = %+v", p)
+	}
+	if !p.CreatedAt.Equal(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)) || !p.UpdatedAt.IsZero() {
+		t.Errorf("dates = %v, %v; want 2020-05-01 10:00 and unset", p.CreatedAt, p.UpdatedAt)
+	}
+	if !reflect.DeepEqual(p.Tags, []string{"Go", "DB"}) {
+		t.Errorf("tags = %v, want [Go DB]", p.Tags)
+	}
+	if len(p.Comments) != 2 || p.Comments[0].ParentSourceID != "" || p.Comments[1].ParentSourceID != "1" {
+		t.Errorf("comments = %+v, want the approved comment and its reply", p.Comments)
+	}
+}
+
+func TestParseWXRRejectsGarbage(t *testing.T) {
+	if _, err := ParseWXR(strings.NewReader("<rss><channel>")); err == nil {
+		t.Error("a truncated WXR file was accepted")
+	}
+}
+
+const ghost = `{"db": [{"data": {
+	"posts": [
+		{"id": "p1", "title": "Hello Ghost", "slug": "hello-ghost", "html": "<p>Hi</p>", "custom_excerpt": "Short",
+		 "status": "published", "type": "post", "created_at": "2021-01-01T00:00:00.000Z",
+		 "updated_at": "2021-02-01T00:00:00.000Z", "published_at": "2021-01-05T00:00:00.000Z"},
+		{"id": "p2", "title": "Plain", "plaintext": "Just text", "status": "published", "author_id": "u2",
+		 "created_at": "2021-03-01T00:00:00.000Z", "updated_at": "2021-03-01T00:00:00.000Z"},
+		{"id": "p3", "title": "Page", "status": "published", "type": "page",
+		 "created_at": "2021-03-01T00:00:00.000Z", "updated_at": "2021-03-01T00:00:00.000Z"},
+		{"id": "p4", "title": "Draft", "status": "draft",
+		 "created_at": "2021-03-01T00:00:00.000Z", "updated_at": "2021-03-01T00:00:00.000Z"}
+	],
+	"tags": [{"id": "t1", "name": "Go"}, {"id": "t2", "name": "#internal"}, {"id": "t3", "name": "Web"}],
+	"posts_tags": [
+		{"post_id": "p1", "tag_id": "t3", "sort_order": 1},
+		{"post_id": "p1", "tag_id": "t2", "sort_order": 2},
+		{"post_id": "p1", "tag_id": "t1", "sort_order": 0}
+	],
+	"users": [{"id": "u1", "email": "ada@example.com"}, {"id": "u2", "email": "bob@example.com"}],
+	"posts_authors": [
+		{"post_id": "p1", "author_id": "u2", "sort_order": 1},
+		{"post_id": "p1", "author_id": "u1", "sort_order": 0}
+	]
+}}]}`
+
+func TestParseGhost(t *testing.T) {
+	src, err := ParseGhost(strings.NewReader(ghost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.System != "ghost" || src.Skipped != 2 || len(src.Posts) != 2 {
+		t.Fatalf("source = %s with %d posts, %d skipped; want ghost, 2, 2", src.System, len(src.Posts), src.Skipped)
+	}
+	p := src.Posts[0]
+	if p.SourceID != "p1" || p.Content != "<p>Hi</p>" || p.Description != "Short" || p.AuthorEmail != "ada@example.com" {
+		t.Errorf("post = %+v, want the primary author's email", p)
+	}
+	if !p.CreatedAt.Equal(time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("created at = %v, want the publish date", p.CreatedAt)
+	}
+	if !reflect.DeepEqual(p.Tags, []string{"Go", "Web"}) {
+		t.Errorf("tags = %v, want [Go Web] in sort order without internal tags", p.Tags)
+	}
+	if plain := src.Posts[1]; plain.Content != "Just text" || plain.AuthorEmail != "bob@example.com" {
+		t.Errorf("plaintext post = %+v", plain)
+	}
+
+	// A bare data object without the db wrapper works too.
+	bare := `{"data": {"posts": [{"id": "x", "title": "Bare", "status": "published", "created_at": "2021-01-01T00:00:00Z", "updated_at": "2021-01-01T00:00:00Z"}]}}`
+	if src, err := ParseGhost(strings.NewReader(bare)); err != nil || len(src.Posts) != 1 {
+		t.Errorf("bare export = %+v, %v", src, err)
+	}
+	if _, err := ParseGhost(strings.NewReader(`{}`)); err == nil {
+		t.Error("an export without data was accepted")
+	}
+}
+
+func TestParseMarkdownDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"posts/first.md":  {Data: []byte("---\ntitle: First\ndate: 2022-01-02T03:04:05Z\nlastmod: 2022-02-01T00:00:00Z\nsummary: About first\nauthor: ada@example.com\ntags: go, web\ncategories: [notes]\n---\n\n# First\n")},
+		"posts/second.md": {Data: []byte("---\nid: fixed\nslug: custom\n---\nBody\n")},
+		"bare.MD":         {Data: []byte("No front matter")},
+		"draft.md":        {Data: []byte("---\ntitle: Draft\ndraft: true\n---\n")},
+		"notes.txt":       {Data: []byte("ignored")},
+	}
+	src, err := ParseMarkdownDir(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Skipped != 1 || len(src.Posts) != 3 {
+		t.Fatalf("%d posts, %d skipped; want 3, 1", len(src.Posts), src.Skipped)
+	}
+	posts := map[string]Post{}
+	for _, p := range src.Posts {
+		posts[p.SourceID] = p
+	}
+	first := posts["posts/first.md"]
+	if first.Title != "First" || first.Description != "About first" || first.Content != "# First" || first.AuthorEmail != "ada@example.com" {
+		t.Errorf("first = %+v", first)
+	}
+	if !reflect.DeepEqual(first.Tags, []string{"go", "web", "notes"}) {
+		t.Errorf("tags = %v, want [go web notes]", first.Tags)
+	}
+	if !first.UpdatedAt.Equal(time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("updated at = %v, want lastmod", first.UpdatedAt)
+	}
+	if second := posts["fixed"]; second.Slug != "custom" || second.Title != "second" || second.Content != "Body" {
+		t.Errorf("second = %+v", second)
+	}
+	if bare := posts["bare.MD"]; bare.Title != "bare" || bare.Content != "No front matter" {
+		t.Errorf("bare = %+v", bare)
+	}
+
+	if _, err := ParseMarkdownDir(fstest.MapFS{"open.md": {Data: []byte("---\ntitle: x\n")}}); err == nil {
+		t.Error("unclosed front matter was accepted")
+	}
+}
+
+// mediaRefs records the URLs each reference was last set to.
+type mediaRefs map[string][]string
+
+func (m mediaRefs) SetReferences(ctx context.Context, ref string, urls ...string) error {
+	m[ref] = nil
+	for _, u := range urls {
+		if u != "" {
+			m[ref] = append(m[ref], u)
+		}
+	}
+	return nil
+}
+
+type site struct {
+	posts *blog.BlogRepo
+	users *user.UserRepo
+	refs  mediaRefs
+	im    *Importer
+}
+
+func newSite(t *testing.T) *site {
+	t.Helper()
+	database := db.NewMemoryDatabase()
+	s := &site{
+		posts: blog.NewBlogRepo(database.Collection("posts"), database.Collection("comments")),
+		users: user.NewUserRepo(database.Collection("users")),
+		refs:  mediaRefs{},
+	}
+	s.im = NewImporter(s.posts, s.users, s.refs, clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+	if _, err := s.users.CreateUser(context.Background(), &user.User{ID: "ada", Email: "Ada@Example.com", Role: user.Author}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *site) count(t *testing.T) (int, int) {
+	t.Helper()
+	posts, err := s.posts.GetBlogPosts(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments, err := s.posts.GetComments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(posts), len(comments)
+}
+
+func TestImportIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	s := newSite(t)
+	src, err := ParseWXR(strings.NewReader(wxr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress []Progress
+	report, err := s.im.Import(ctx, src, Options{Progress: func(p Progress) { progress = append(progress, p) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PostsCreated != 1 || report.CommentsCreated != 2 || report.SourceSkipped != 2 {
+		t.Errorf("first run = %+v", report)
+	}
+	if len(progress) != 1 || progress[0].Action != ActionCreated || progress[0].Total != 1 {
+		t.Errorf("progress = %+v", progress)
+	}
+	post, err := s.posts.GetBlogPost(ctx, bson.M{"source_id": "wordpress:7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Slug != "hello-world" || post.AuthorId != "ada" || !post.UpdatedAt.Equal(post.CreatedAt) {
+		t.Errorf("post = %+v, want slug hello-world by ada, updated when created", post)
+	}
+	reply, err := s.posts.GetComment(ctx, bson.M{"source_id": "wordpress:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.posts.GetComment(ctx, bson.M{"source_id": "wordpress:1"})
+	if err != nil || reply.ParentId != first.Id || first.AuthorName != "Bob" {
+		t.Errorf("reply parent = %v, first = %+v, %v", reply.ParentId, first, err)
+	}
+
+	report, err = s.im.Import(ctx, src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PostsCreated != 0 || report.PostsExisting != 1 || report.CommentsCreated != 0 || report.CommentsSkipped != 2 {
+		t.Errorf("second run = %+v, want everything already imported", report)
+	}
+	if p, c := s.count(t); p != 1 || c != 2 {
+		t.Errorf("%d posts and %d comments after two runs, want 1 and 2", p, c)
+	}
+}
+
+func TestImportDryRunWritesNothing(t *testing.T) {
+	s := newSite(t)
+	src, err := ParseWXR(strings.NewReader(wxr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.im.Import(context.Background(), src, Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.PostsCreated != 1 || report.CommentsCreated != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	if p, c := s.count(t); p != 0 || c != 0 {
+		t.Errorf("dry run wrote %d posts and %d comments", p, c)
+	}
+}
+
+func TestImportRenamesTakenSlugs(t *testing.T) {
+	ctx := context.Background()
+	s := newSite(t)
+	if _, err := s.posts.CreateBlogPost(ctx, &blog.BlogPost{Title: "Mine", Slug: "hello-ghost"}); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ParseGhost(strings.NewReader(ghost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.im.Import(ctx, src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PostsCreated != 2 || report.PostsRenamed != 1 || len(report.Warnings) != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := s.posts.GetBlogPost(ctx, bson.M{"slug": "hello-ghost-2", "source_id": "ghost:p1"}); err != nil {
+		t.Errorf("renamed post: %v", err)
+	}
+	if _, err := s.posts.GetBlogPost(ctx, bson.M{"slug": "plain"}); err != nil {
+		t.Errorf("post slugged from its title: %v", err)
+	}
+}
+
+func TestImportRecordsMediaReferences(t *testing.T) {
+	ctx := context.Background()
+	s := newSite(t)
+	src, err := ParseMarkdownDir(fstest.MapFS{"cat.md": {Data: []byte("---\ntitle: Cat\n---\n![cat](/media/ab/cat.png)\n")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.im.Import(ctx, src, Options{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.refs) != 0 {
+		t.Errorf("dry run recorded references %v", s.refs)
+	}
+	if _, err := s.im.Import(ctx, src, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	post, err := s.posts.GetBlogPost(ctx, bson.M{"slug": "cat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := s.refs[blog.MediaRef(post.Id)]; !reflect.DeepEqual(urls, []string{"/media/ab/cat.png"}) {
+		t.Errorf("references = %v, want the image", s.refs)
+	}
+}
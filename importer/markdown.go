@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type frontMatter struct {
+	ID          string    `yaml:"id"`
+	Title       string    `yaml:"title"`
+	Slug        string    `yaml:"slug"`
+	Description string    `yaml:"description"`
+	Summary     string    `yaml:"summary"`
+	Date        time.Time `yaml:"date"`
+	Updated     time.Time `yaml:"updated"`
+	Lastmod     time.Time `yaml:"lastmod"`
+	Author      string    `yaml:"author"`
+	Draft       bool      `yaml:"draft"`
+	Tags        tagList   `yaml:"tags"`
+	Categories  tagList   `yaml:"categories"`
+}
+
+// tagList accepts both a YAML list and a comma-separated string.
+type tagList []string
+
+func (t *tagList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		for _, s := range strings.Split(node.Value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*t = append(*t, s)
+			}
+		}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// ParseMarkdownDir reads every .md file under fsys. Each file may start with
+// YAML front matter between --- lines giving title, slug, description (or
+// summary), date, updated (or lastmod), author (an email), tags, categories
+// and draft. The source ID is the front matter id, or else the file path,
+// so keep paths stable between runs. Drafts count as skipped.
+func ParseMarkdownDir(fsys fs.FS) (*Source, error) {
+	src := &Source{System: "markdown"}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(path.Ext(name), ".md") {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		fm, body, err := splitFrontMatter(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if fm.Draft {
+			src.Skipped++
+			return nil
+		}
+		p := Post{
+			SourceID:    fm.ID,
+			Title:       fm.Title,
+			Slug:        fm.Slug,
+			Description: fm.Description,
+			Content:     strings.TrimSpace(string(body)),
+			Tags:        append(fm.Tags, fm.Categories...),
+			AuthorEmail: fm.Author,
+			CreatedAt:   fm.Date,
+			UpdatedAt:   fm.Updated,
+		}
+		if p.SourceID == "" {
+			p.SourceID = name
+		}
+		if p.Title == "" {
+			p.Title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+		}
+		if p.Description == "" {
+			p.Description = fm.Summary
+		}
+		if p.UpdatedAt.IsZero() {
+			p.UpdatedAt = fm.Lastmod
+		}
+		src.Posts = append(src.Posts, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+var frontMatterFence = []byte("---")
+
+func splitFrontMatter(data []byte) (*frontMatter, []byte, error) {
+	fm := &frontMatter{}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !bytes.HasPrefix(data, frontMatterFence) {
+		return fm, data, nil
+	}
+	rest := data[len(frontMatterFence):]
+	end := bytes.Index(rest, []byte("\n---"))
+	if end < 0 {
+		return nil, nil, fmt.Errorf("front matter is not closed with ---")
+	}
+	if err := yaml.Unmarshal(rest[:end], fm); err != nil {
+		return nil, nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+	body := rest[end+len("\n---"):]
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else {
+		body = nil
+	}
+	return fm, body, nil
+}
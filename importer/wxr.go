@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WXR elements are matched by local name because the wp namespace URL
+// changes with the export version (1.0, 1.1, 1.2).
+
+type wxrFile struct {
+	Channel struct {
+		Authors []struct {
+			Login string `xml:"author_login"`
+			Email string `xml:"author_email"`
+		} `xml:"author"`
+		Items []wxrItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type wxrText struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type wxrItem struct {
+	Title      string    `xml:"title"`
+	Creator    string    `xml:"creator"`
+	Encoded    []wxrText `xml:"encoded"`
+	PostId     string    `xml:"post_id"`
+	PostDate   string    `xml:"post_date_gmt"`
+	Modified   string    `xml:"post_modified_gmt"`
+	PostName   string    `xml:"post_name"`
+	Status     string    `xml:"status"`
+	PostType   string    `xml:"post_type"`
+	Categories []struct {
+		Domain string `xml:"domain,attr"`
+		Name   string `xml:",chardata"`
+	} `xml:"category"`
+	Comments []struct {
+		Id       string `xml:"comment_id"`
+		Author   string `xml:"comment_author"`
+		Email    string `xml:"comment_author_email"`
+		Date     string `xml:"comment_date_gmt"`
+		Content  string `xml:"comment_content"`
+		Approved string `xml:"comment_approved"`
+		Parent   string `xml:"comment_parent"`
+		Type     string `xml:"comment_type"`
+	} `xml:"comment"`
+}
+
+// ParseWXR reads a WordPress eXtended RSS export. Only published posts and
+// approved comments are kept; pages, attachments and drafts count as
+// skipped. Categories and tags both become tags.
+func ParseWXR(r io.Reader) (*Source, error) {
+	var f wxrFile
+	if err := xml.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid WXR file: %w", err)
+	}
+	emails := map[string]string{}
+	for _, a := range f.Channel.Authors {
+		emails[a.Login] = a.Email
+	}
+	src := &Source{System: "wordpress"}
+	for _, item := range f.Channel.Items {
+		if item.PostType != "post" || item.Status != "publish" {
+			src.Skipped++
+			continue
+		}
+		p := Post{
+			SourceID:    item.PostId,
+			Title:       item.Title,
+			Slug:        item.PostName,
+			AuthorEmail: emails[item.Creator],
+			CreatedAt:   parseWXRTime(item.PostDate),
+			UpdatedAt:   parseWXRTime(item.Modified),
+		}
+		for _, e := range item.Encoded {
+			// content:encoded holds the body and excerpt:encoded the summary.
+			if strings.Contains(e.XMLName.Space, "/excerpt/") {
+				p.Description = strings.TrimSpace(e.Value)
+			} else {
+				p.Content = e.Value
+			}
+		}
+		seen := map[string]bool{}
+		for _, c := range item.Categories {
+			name := strings.TrimSpace(c.Name)
+			if (c.Domain == "post_tag" || c.Domain == "category") && name != "" && !seen[name] {
+				seen[name] = true
+				p.Tags = append(p.Tags, name)
+			}
+		}
+		for _, c := range item.Comments {
+			if c.Approved != "1" || (c.Type != "" && c.Type != "comment") {
+				continue
+			}
+			parent := c.Parent
+			if parent == "0" {
+				parent = ""
+			}
+			p.Comments = append(p.Comments, Comment{
+				SourceID:       c.Id,
+				ParentSourceID: parent,
+				AuthorName:     c.Author,
+				AuthorEmail:    c.Email,
+				Content:        c.Content,
+				CreatedAt:      parseWXRTime(c.Date),
+			})
+		}
+		src.Posts = append(src.Posts, p)
+	}
+	return src, nil
+}
+
+// parseWXRTime reads WordPress GMT timestamps. Unset dates are exported as
+// 0000-00-00 00:00:00 and yield the zero time.
+func parseWXRTime(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -2,21 +2,50 @@ package logger
 
 import (
 	"errors"
-	"net/http"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
 
 	"github.com/ayo-ajayi/bloggy/apperrors"
-	"github.com/ayo-ajayi/logger"
 	"github.com/gin-gonic/gin"
 )
 
 type Logger struct {
-	*logger.Logger
+	*slog.Logger
 }
 
-func NewLogger() *Logger {
+// NewLogger returns a JSON logger writing to w at the given level
+// ("debug", "info", "warn" or "error"; anything else means info).
+func NewLogger(level string, w io.Writer) *Logger {
+	if w == nil {
+		w = os.Stdout
+	}
 	return &Logger{
-		Logger: logger.NewLogger(logger.DEBUG, false),
+		Logger: slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: ParseLevel(level)})),
+	}
+}
+
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
 	}
+	return slog.LevelInfo
+}
+
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{Logger: l.Logger.With(args...)}
+}
+
+// Fatal logs at error level and exits the process.
+func (l *Logger) Fatal(msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
 }
 
 func SetLoggerInstance(c *gin.Context, log *Logger) {
@@ -35,13 +64,21 @@ func getLoggerInstance(c *gin.Context) *Logger {
 	return log
 }
 
+// FromContext returns the request-scoped logger, or one built on the default
+// slog logger when the request middleware is not installed.
+func FromContext(c *gin.Context) *Logger {
+	if log := getLoggerInstance(c); log != nil {
+		return log
+	}
+	return &Logger{Logger: slog.Default()}
+}
+
 func handleError(c *gin.Context, err error) {
 	var appErr *apperrors.AppError
-	if errors.As(err, &appErr) {
-		c.JSON(appErr.StatusCode, gin.H{"error": appErr.Sanitize().UserMessage})
-		return
+	if !errors.As(err, &appErr) {
+		appErr = apperrors.Internal(err)
 	}
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	apperrors.WriteProblem(c, appErr)
 }
 
 func LogSuccess(c *gin.Context, message string, data interface{}) {
@@ -49,15 +86,11 @@ func LogSuccess(c *gin.Context, message string, data interface{}) {
 	if data != nil {
 		response["data"] = data
 	}
-	if log := getLoggerInstance(c); log != nil {
-		log.Info("success: %s\n", message)
-	}
-	c.JSON(http.StatusOK, response)
+	FromContext(c).Info("success", "message", message)
+	c.JSON(200, response)
 }
 
 func LogError(c *gin.Context, err error) {
-	if log := getLoggerInstance(c); log != nil {
-		log.Error("error: %v\n", err)
-	}
+	FromContext(c).Error("error", "error", Redact(err.Error()))
 	handleError(c, err)
 }
@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+	"X-Api-Key":     true,
+}
+
+var (
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)\S+`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	apiKeyPattern = regexp.MustCompile(`bgy_[A-Za-z0-9_-]+`)
+	paramPattern  = regexp.MustCompile(`(?i)((?:access_token|token|code|state|key)=)[^&\s]+`)
+)
+
+// Redact masks bearer tokens, JWTs, API keys and token-like query
+// parameters in s.
+func Redact(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "${1}[REDACTED]")
+	s = jwtPattern.ReplaceAllString(s, "[REDACTED]")
+	s = apiKeyPattern.ReplaceAllString(s, "[REDACTED]")
+	return paramPattern.ReplaceAllString(s, "${1}[REDACTED]")
+}
+
+// RedactHeaders returns a copy of h that is safe to log.
+func RedactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			out[k] = "[REDACTED]"
+			continue
+		}
+		out[k] = Redact(strings.Join(v, ", "))
+	}
+	return out
+}
+
+// Middleware assigns or propagates X-Request-ID, attaches a request-scoped
+// logger to the context and logs one line per request once it completes.
+func Middleware(base *Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.New().String()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		log := base.With("request_id", id)
+		SetLoggerInstance(c, log)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", route,
+			"path", Redact(c.Request.URL.RequestURI()),
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip", c.ClientIP(),
+		}
+		if userId := c.GetString("user_id"); userId != "" {
+			attrs = append(attrs, "user_id", userId)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", Redact(c.Errors.String()))
+		}
+		if log.Enabled(c, slog.LevelDebug) {
+			attrs = append(attrs, "headers", RedactHeaders(c.Request.Header))
+		}
+		switch {
+		case status >= http.StatusInternalServerError:
+			log.Error("request", attrs...)
+		case status >= http.StatusBadRequest:
+			log.Warn("request", attrs...)
+		default:
+			log.Info("request", attrs...)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"Bearer abc.def":                         "Bearer [REDACTED]",
+		"token eyJhbGciOi.eyJzdWIi.c2ln expired": "token [REDACTED] expired",
+		"key bgy_0123456789abcdef revoked":       "key [REDACTED] revoked",
+		"/callback?code=xyz&state=abc&page=2":    "/callback?code=[REDACTED]&state=[REDACTED]&page=2",
+		"nothing secret here":                    "nothing secret here",
+	} {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	got := RedactHeaders(http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"session=secret"},
+		"Accept":        {"application/json"},
+	})
+	if got["Authorization"] != "[REDACTED]" || got["Cookie"] != "[REDACTED]" || got["Accept"] != "application/json" {
+		t.Errorf("headers = %v", got)
+	}
+}
+
+func serve(t *testing.T, level string, req *http.Request, handler gin.HandlerFunc) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	r := gin.New()
+	r.Use(Middleware(NewLogger(level, &buf)))
+	r.GET("/posts/:id", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log output %q: %v", buf.String(), err)
+	}
+	return w, line
+}
+
+func TestMiddlewarePropagatesRequestID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/posts/1?token=secret", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	w, line := serve(t, "info", req, func(c *gin.Context) {
+		if FromContext(c) == nil || c.GetString("request_id") != "req-42" {
+			t.Error("request logger or id missing from the context")
+		}
+		c.Set("user_id", "u1")
+		c.Status(http.StatusOK)
+	})
+	if w.Header().Get(RequestIDHeader) != "req-42" {
+		t.Errorf("response request id = %q", w.Header().Get(RequestIDHeader))
+	}
+	if line["request_id"] != "req-42" || line["route"] != "/posts/:id" || line["user_id"] != "u1" || line["level"] != "INFO" {
+		t.Errorf("log line = %v", line)
+	}
+	if path, _ := line["path"].(string); strings.Contains(path, "secret") {
+		t.Errorf("path %q was not redacted", path)
+	}
+	if _, ok := line["headers"]; ok {
+		t.Error("headers logged below debug level")
+	}
+}
+
+func TestMiddlewareAssignsRequestID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/posts/1", nil)
+	req.Header.Set(RequestIDHeader, strings.Repeat("x", 200))
+	req.Header.Set("Authorization", "Bearer secret")
+	w, line := serve(t, "debug", req, func(c *gin.Context) {
+		c.Error(errors.New("lookup failed for Bearer secret"))
+		c.Status(http.StatusInternalServerError)
+	})
+	id := w.Header().Get(RequestIDHeader)
+	if len(id) != 36 || line["request_id"] != id {
+		t.Errorf("request id = %q, logged %v; want a fresh uuid", id, line["request_id"])
+	}
+	if line["level"] != "ERROR" {
+		t.Errorf("level = %v, want ERROR for a 500", line["level"])
+	}
+	if headers, _ := line["headers"].(map[string]interface{}); headers["Authorization"] != "[REDACTED]" {
+		t.Errorf("headers = %v", line["headers"])
+	}
+	if e, _ := line["error"].(string); strings.Contains(e, "secret") {
+		t.Errorf("error %q was not redacted", e)
+	}
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 
-	"github.com/ayo-ajayi/bloggy/app"
+	"github.com/ayo-ajayi/bloggy/cli"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	if err := godotenv.Load(".env"); err != nil {
-		log.Println(err)
-		return
+	// .env is a development convenience; deployments set the environment or
+	// use a config file instead.
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "failed to load .env:", err)
+		os.Exit(1)
 	}
-	app := app.NewApp(":8080", app.BlogRouter())
-	app.Start()
+	os.Exit(cli.Run(os.Args[1:]))
 }
@@ -0,0 +1,148 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/cloudinary/cloudinary-go/v2/asset"
+)
+
+// CloudinaryStore keeps media in a Cloudinary folder. Images and videos
+// are stored without their extension, as Cloudinary expects; other files
+// are raw uploads that keep it.
+type CloudinaryStore struct {
+	folder     string
+	cloudinary *cloudinary.Cloudinary
+	client     *http.Client
+}
+
+func NewCloudinaryStore(apiURI, folder string) (*CloudinaryStore, error) {
+	cld, err := cloudinary.NewFromURL(apiURI)
+	if err != nil {
+		return nil, errors.New("failed to init cloudinary: " + err.Error())
+	}
+	return &CloudinaryStore{
+		folder:     folder,
+		cloudinary: cld,
+		client:     &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// resource maps a key to its Cloudinary resource type and public ID.
+func (s *CloudinaryStore) resource(key string) (api.AssetType, string) {
+	id := path.Join(s.folder, key)
+	switch strings.SplitN(ContentType(key), "/", 2)[0] {
+	case "image":
+		return api.Image, strings.TrimSuffix(id, path.Ext(id))
+	case "video", "audio":
+		return api.Video, strings.TrimSuffix(id, path.Ext(id))
+	}
+	return api.File, id
+}
+
+func (s *CloudinaryStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !validKey(key) {
+		return "", ErrNotFound
+	}
+	resourceType, publicID := s.resource(key)
+	res, err := s.cloudinary.Upload.Upload(ctx, bytes.NewReader(data), uploader.UploadParams{
+		PublicID:       publicID,
+		ResourceType:   string(resourceType),
+		UniqueFilename: api.Bool(false),
+		Overwrite:      api.Bool(false),
+	})
+	if err != nil {
+		return "", errors.New("failed to upload file: " + err.Error())
+	}
+	if res.Error.Message != "" {
+		return "", errors.New("failed to upload file: " + res.Error.Message)
+	}
+	return res.SecureURL, nil
+}
+
+func (s *CloudinaryStore) deliveryURL(key string) (string, error) {
+	resourceType, publicID := s.resource(key)
+	var a *asset.Asset
+	var err error
+	switch resourceType {
+	case api.Image:
+		a, err = s.cloudinary.Image(publicID)
+	case api.Video:
+		a, err = s.cloudinary.Video(publicID)
+	default:
+		a, err = s.cloudinary.File(publicID)
+	}
+	if err != nil {
+		return "", err
+	}
+	u, err := a.String()
+	if err != nil {
+		return "", err
+	}
+	// Deliver the original format rather than Cloudinary's default.
+	if resourceType != api.File {
+		u += path.Ext(key)
+	}
+	return u, nil
+}
+
+func (s *CloudinaryStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrNotFound
+	}
+	u, err := s.deliveryURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return res.Body, nil
+}
+
+func (s *CloudinaryStore) Delete(ctx context.Context, key string) error {
+	resourceType, publicID := s.resource(key)
+	res, err := s.cloudinary.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID, ResourceType: string(resourceType)})
+	if err != nil {
+		return errors.New("failed to delete file: " + err.Error())
+	}
+	if res.Error.Message != "" {
+		return errors.New("failed to delete file: " + res.Error.Message)
+	}
+	return nil
+}
+
+// Ping checks that the Cloudinary admin API is reachable with our credentials.
+func (s *CloudinaryStore) Ping(ctx context.Context) error {
+	res, err := s.cloudinary.Admin.Ping(ctx)
+	if err != nil {
+		return errors.New("failed to reach cloudinary: " + err.Error())
+	}
+	if res.Error.Message != "" {
+		return errors.New("failed to reach cloudinary: " + res.Error.Message)
+	}
+	return nil
+}
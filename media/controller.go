@@ -0,0 +1,168 @@
+package media
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+type MediaController struct {
+	service MediaServices
+}
+
+type MediaServices interface {
+	Upload(ctx context.Context, r io.Reader, filename, ownerId string) (*Media, error)
+	List(ctx context.Context, q ListQuery) ([]*Media, int64, error)
+	Get(ctx context.Context, idStr string) (*Media, error)
+	Delete(ctx context.Context, idStr string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, string, error)
+	Render(ctx context.Context, idStr string, t Transform, accept string) (*Rendition, error)
+}
+
+func NewMediaController(service MediaServices) *MediaController {
+	return &MediaController{service}
+}
+
+// Upload adds the multipart "file" field to the library.
+func (mc *MediaController) Upload(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "file is required", err))
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "failed to open file", err))
+		return
+	}
+	defer f.Close()
+	m, err := mc.service.Upload(c, f, file.Filename, c.GetString("user_id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": m, "message": "File uploaded successfully"})
+}
+
+// List pages through the library with ?page=, ?per_page= and ?q= to search
+// file names; ?mine=true keeps the caller's own uploads.
+func (mc *MediaController) List(c *gin.Context) {
+	q := ListQuery{Page: 1, PerPage: DefaultPerPage, Query: c.Query("q")}
+	var err error
+	if v := c.Query("page"); v != "" {
+		if q.Page, err = strconv.Atoi(v); err != nil || q.Page < 1 {
+			c.Error(apperrors.BadRequest(apperrors.CodeValidation, "page must be a positive number", err))
+			return
+		}
+	}
+	if v := c.Query("per_page"); v != "" {
+		if q.PerPage, err = strconv.Atoi(v); err != nil || q.PerPage < 1 || q.PerPage > MaxPerPage {
+			c.Error(apperrors.BadRequest(apperrors.CodeValidation, "per_page must be between 1 and "+strconv.Itoa(MaxPerPage), err))
+			return
+		}
+	}
+	if mine, _ := strconv.ParseBool(c.Query("mine")); mine {
+		q.OwnerId = c.GetString("user_id")
+	}
+	list, total, err := mc.service.List(c, q)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "page": q.Page, "per_page": q.PerPage, "total": total})
+}
+
+// Delete removes a file from the library. Authors may only delete their
+// own uploads.
+func (mc *MediaController) Delete(c *gin.Context) {
+	m, err := mc.service.Get(c, c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !user.HasPermission(c, user.PermDeleteAnyPost) && m.OwnerId != c.GetString("user_id") {
+		c.Error(apperrors.Forbidden(apperrors.CodeForbidden, "you can only delete your own uploads", nil))
+		return
+	}
+	if err := mc.service.Delete(c, c.Param("id")); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+}
+
+// Render serves a file resized with ?w=, ?h= and ?fit=, snapped to Sizes,
+// or to one of the named Variants with ?size=, in the best image format the
+// client accepts.
+func (mc *MediaController) Render(c *gin.Context) {
+	var t Transform
+	if size := c.Query("size"); size != "" {
+		v, ok := Variants[size]
+		if !ok {
+			c.Error(apperrors.BadRequest(apperrors.CodeValidation, "unknown size "+size, nil))
+			return
+		}
+		t = v
+	} else {
+		for _, p := range []struct {
+			name string
+			dst  *int
+		}{{"w", &t.Width}, {"h", &t.Height}} {
+			v := c.Query(p.name)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				c.Error(apperrors.BadRequest(apperrors.CodeValidation, p.name+" must be a number", err))
+				return
+			}
+			*p.dst = n
+		}
+		t.Fit = c.Query("fit")
+		t = t.snapped()
+	}
+	r, err := mc.service.Render(c, c.Param("id"), t, c.GetHeader("Accept"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Vary", "Accept")
+	c.Header("ETag", r.ETag)
+	immutable(c)
+	if c.GetHeader("If-None-Match") == r.ETag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Header("Content-Type", r.ContentType)
+	c.Data(http.StatusOK, r.ContentType, r.Data)
+}
+
+// Serve streams a stored file. Keys are content addressed, so the response
+// never changes and may be cached forever.
+func (mc *MediaController) Serve(c *gin.Context) {
+	rc, contentType, err := mc.service.Open(c, strings.TrimPrefix(c.Param("key"), "/"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	defer rc.Close()
+	c.Header("Content-Type", contentType)
+	immutable(c)
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, rc)
+}
+
+// immutable marks a response that never changes. Uploads are served from
+// the API's origin, so they are also kept from running scripts.
+func immutable(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+}
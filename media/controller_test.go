@@ -0,0 +1,68 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeletePermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		userId string
+		role   user.Role
+		want   int
+	}{
+		{"owner", "ada", user.Author, http.StatusOK},
+		{"other author", "bob", user.Author, http.StatusForbidden},
+		{"editor", "ed", user.Editor, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newService(testPolicy, NewMemoryStore("/media"))
+			m, err := s.Upload(context.Background(), bytes.NewReader(pngData), "a.png", "ada")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := gin.New()
+			r.Use(apperrors.ErrorHandler())
+			r.DELETE("/media/:id", func(c *gin.Context) {
+				c.Set("user_id", tt.userId)
+				c.Set("role", tt.role)
+			}, NewMediaController(s).Delete)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/media/"+m.Id.Hex(), nil))
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestListValidatesPaging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _ := newService(testPolicy, NewMemoryStore("/media"))
+	r := gin.New()
+	r.Use(apperrors.ErrorHandler())
+	r.GET("/media", NewMediaController(s).List)
+	for query, want := range map[string]int{
+		"":                   http.StatusOK,
+		"?page=2&per_page=5": http.StatusOK,
+		"?page=0":            http.StatusBadRequest,
+		"?per_page=1000":     http.StatusBadRequest,
+		"?per_page=x":        http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/media"+query, nil))
+		if w.Code != want {
+			t.Errorf("%q: status %d, want %d", query, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package media
+
+const (
+	CodeMediaNotFound    = "media_not_found"
+	CodeMediaTooLarge    = "media_too_large"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeMediaInUse       = "media_in_use"
+	CodeInvalidImage     = "invalid_image"
+)
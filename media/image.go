@@ -0,0 +1,316 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxDimension caps the width and height of a requested variant.
+	MaxDimension = 2048
+	// maxPixels guards against decompression bombs.
+	maxPixels   = 50_000_000
+	jpegQuality = 82
+)
+
+// Fit modes for resizing into a box.
+const (
+	// FitContain scales the image to fit inside the box.
+	FitContain = "contain"
+	// FitCover scales the image to fill the box and crops the overflow.
+	FitCover = "cover"
+	// FitFill stretches the image to the box.
+	FitFill = "fill"
+)
+
+// Transform describes a variant of an image. A zero Width or Height leaves
+// that side to follow the aspect ratio. Images are never enlarged except by
+// FitFill.
+type Transform struct {
+	Width  int
+	Height int
+	Fit    string
+}
+
+// Variants are the responsive sizes generated for every uploaded image.
+var Variants = map[string]Transform{
+	"thumbnail": {Width: 150, Height: 150, Fit: FitCover},
+	"medium":    {Width: 640, Fit: FitContain},
+	"large":     {Width: 1280, Fit: FitContain},
+}
+
+// Sizes are the widths and heights that ?w= and ?h= snap to, so that public
+// render requests can only ever create a small, fixed set of variants.
+var Sizes = []int{160, 320, 480, 640, 960, 1280, 1920}
+
+// snapped rounds Width and Height up to the nearest of Sizes, or down to the
+// largest. Zero sides stay zero.
+func (t Transform) snapped() Transform {
+	snap := func(n int) int {
+		if n <= 0 {
+			return n
+		}
+		for _, s := range Sizes {
+			if n <= s {
+				return s
+			}
+		}
+		return Sizes[len(Sizes)-1]
+	}
+	t.Width, t.Height = snap(t.Width), snap(t.Height)
+	return t
+}
+
+func (t Transform) Validate() error {
+	if t.Width < 0 || t.Height < 0 || t.Width > MaxDimension || t.Height > MaxDimension {
+		return fmt.Errorf("width and height must be between 0 and %d", MaxDimension)
+	}
+	switch t.Fit {
+	case "", FitContain, FitCover, FitFill:
+	default:
+		return fmt.Errorf("fit must be %s, %s or %s", FitContain, FitCover, FitFill)
+	}
+	return nil
+}
+
+func (t Transform) fit() string {
+	if t.Fit == "" || t.Width == 0 || t.Height == 0 {
+		return FitContain
+	}
+	return t.Fit
+}
+
+func (t Transform) resizes() bool {
+	return t.Width > 0 || t.Height > 0
+}
+
+func (t Transform) String() string {
+	return fmt.Sprintf("w%d-h%d-%s", t.Width, t.Height, t.fit())
+}
+
+// resizable are the formats the pipeline decodes. GIFs are left out of
+// upload-time variants so animations survive.
+var resizable = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true, "image/gif": true}
+
+func decodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image is %dx%d, larger than %d pixels", cfg.Width, cfg.Height, maxPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// resize applies t to img.
+func resize(img image.Image, t Transform) image.Image {
+	if !t.resizes() {
+		return img
+	}
+	b := img.Bounds()
+	sw, sh := float64(b.Dx()), float64(b.Dy())
+	w, h := float64(t.Width), float64(t.Height)
+	src := b
+	var dw, dh float64
+	switch t.fit() {
+	case FitFill:
+		dw, dh = w, h
+	case FitCover:
+		scale := math.Min(math.Max(w/sw, h/sh), 1)
+		cw, ch := math.Min(w/scale, sw), math.Min(h/scale, sh)
+		x0 := b.Min.X + int((sw-cw)/2)
+		y0 := b.Min.Y + int((sh-ch)/2)
+		src = image.Rect(x0, y0, x0+int(math.Round(cw)), y0+int(math.Round(ch)))
+		dw, dh = cw*scale, ch*scale
+	default:
+		scale := 1.0
+		if w > 0 {
+			scale = math.Min(scale, w/sw)
+		}
+		if h > 0 {
+			scale = math.Min(scale, h/sh)
+		}
+		dw, dh = sw*scale, sh*scale
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, int(math.Round(dw))), max(1, int(math.Round(dh)))))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// orient turns img upright according to an EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	swap := orientation >= 5
+	dw, dh := w, h
+	if swap {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// encoder writes an image in one format.
+type encoder func(ctx context.Context, img image.Image) ([]byte, error)
+
+var builtinEncoders = map[string]encoder{
+	"image/jpeg": func(ctx context.Context, img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		return buf.Bytes(), err
+	},
+	"image/png": func(ctx context.Context, img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+		return buf.Bytes(), err
+	},
+	"image/gif": func(ctx context.Context, img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		err := gif.Encode(&buf, img, nil)
+		return buf.Bytes(), err
+	},
+}
+
+// toolEncoders are the modern formats Go cannot write itself. They are
+// produced by the libwebp and libavif command line tools when installed.
+var toolEncoders = map[string][]string{
+	"image/avif": {"avifenc", "-s", "6", "{in}", "{out}"},
+	"image/webp": {"cwebp", "-quiet", "-q", "80", "{in}", "-o", "{out}"},
+}
+
+var (
+	encodersOnce sync.Once
+	encoders     map[string]encoder
+)
+
+// availableEncoders returns the built-in encoders plus those whose tool is
+// on the PATH.
+func availableEncoders() map[string]encoder {
+	encodersOnce.Do(func() {
+		encoders = map[string]encoder{}
+		for t, enc := range builtinEncoders {
+			encoders[t] = enc
+		}
+		for t, args := range toolEncoders {
+			if path, err := exec.LookPath(args[0]); err == nil {
+				encoders[t] = toolEncoder(path, args[1:], extension(t))
+			}
+		}
+	})
+	return encoders
+}
+
+func toolEncoder(path string, args []string, ext string) encoder {
+	return func(ctx context.Context, img image.Image) ([]byte, error) {
+		dir, err := os.MkdirTemp("", "bloggy-encode-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out"+ext)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(in, buf.Bytes(), 0o600); err != nil {
+			return nil, err
+		}
+		argv := make([]string, len(args))
+		for i, a := range args {
+			argv[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(a)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if msg, err := exec.CommandContext(ctx, path, argv...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%s: %v: %s", filepath.Base(path), err, bytes.TrimSpace(msg))
+		}
+		return os.ReadFile(out)
+	}
+}
+
+var errNoEncoder = errors.New("no encoder for format")
+
+func encodeImage(ctx context.Context, img image.Image, mimeType string) ([]byte, error) {
+	enc, ok := availableEncoders()[mimeType]
+	if !ok {
+		return nil, errNoEncoder
+	}
+	return enc(ctx, img)
+}
+
+// negotiateFormat picks the output format for an image of type source:
+// AVIF or WebP when the client accepts it and an encoder is installed,
+// otherwise the source format, or PNG when Go cannot write it.
+func negotiateFormat(accept, source string) string {
+	encs := availableEncoders()
+	for _, t := range []string{"image/avif", "image/webp"} {
+		if _, ok := encs[t]; ok && accepts(accept, t) {
+			return t
+		}
+	}
+	if _, ok := encs[source]; ok {
+		return source
+	}
+	return "image/png"
+}
+
+// accepts reports whether an Accept header explicitly lists mimeType with
+// a non-zero quality.
+func accepts(accept, mimeType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != mimeType {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if q := strings.TrimSpace(f); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
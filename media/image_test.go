@@ -0,0 +1,159 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// encodeJPEG returns a w×h JPEG carrying an EXIF orientation and a comment.
+func encodeJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	segment := func(marker byte, payload []byte) []byte {
+		return binary.BigEndian.AppendUint16([]byte{0xFF, marker}, uint16(len(payload)+2))
+	}
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	comment := []byte("shot at 51.5N 0.1W")
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(append(data, segment(0xE1, exif)...), exif...)
+	data = append(append(data, segment(0xFE, comment)...), comment...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+// withTextChunk inserts a tEXt chunk after the IHDR of a PNG.
+func withTextChunk(data []byte, text string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tEXt"+text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := len(pngSignature) + 12 + 13
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	jpg := encodeJPEG(t, 4, 2, 6)
+	if got := jpegOrientation(jpg); got != 6 {
+		t.Errorf("orientation = %d, want 6", got)
+	}
+	stripped := stripMetadata(jpg, "image/jpeg")
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("51.5N")) {
+		t.Error("JPEG metadata survived")
+	}
+	if _, err := decodeImage(stripped); err != nil {
+		t.Errorf("stripped JPEG no longer decodes: %v", err)
+	}
+
+	tagged := withTextChunk(pngData, "Comment\x00taken at home")
+	if _, err := decodeImage(tagged); err != nil {
+		t.Fatalf("test PNG is invalid: %v", err)
+	}
+	if got := stripMetadata(tagged, "image/png"); !bytes.Equal(got, pngData) {
+		t.Error("PNG text chunk survived")
+	}
+
+	garbage := []byte("\xFF\xD8\xFF\xE1\xFF\xFF")
+	if got := stripMetadata(garbage, "image/jpeg"); !bytes.Equal(got, garbage) {
+		t.Error("a truncated JPEG was changed")
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for _, tc := range []struct {
+		t    Transform
+		w, h int
+	}{
+		{Transform{Width: 100}, 100, 50},
+		{Transform{Height: 50}, 100, 50},
+		{Transform{Width: 100, Height: 100, Fit: FitCover}, 100, 100},
+		{Transform{Width: 100, Height: 100, Fit: FitContain}, 100, 50},
+		{Transform{Width: 100, Height: 100, Fit: FitFill}, 100, 100},
+		{Transform{Width: 1000}, 400, 200},
+	} {
+		if b := resize(img, tc.t).Bounds(); b.Dx() != tc.w || b.Dy() != tc.h {
+			t.Errorf("%s: %dx%d, want %dx%d", tc.t, b.Dx(), b.Dy(), tc.w, tc.h)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{R: 0xff, A: 0xff}
+	img.Set(0, 0, red)
+	// Orientation 6 needs a clockwise turn, which brings the left end of
+	// the row to the top; 8 needs a counter-clockwise one, to the bottom.
+	for orientation, y := range map[int]int{6: 0, 8: 1} {
+		got := orient(img, orientation)
+		if b := got.Bounds(); b.Dx() != 1 || b.Dy() != 2 {
+			t.Fatalf("%d: bounds = %v, want 1x2", orientation, b)
+		}
+		if got.At(0, y) != color.Color(red) {
+			t.Errorf("%d: red pixel not at row %d", orientation, y)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	for in, want := range map[Transform]Transform{
+		{Width: 1}:                {Width: 160},
+		{Width: 161, Height: 480}: {Width: 320, Height: 480},
+		{Width: 5000}:             {Width: 1920},
+		{}:                        {},
+	} {
+		if got := in.snapped(); got != want {
+			t.Errorf("%+v snapped = %+v, want %+v", in, got, want)
+		}
+	}
+	for _, bad := range []Transform{{Width: -1}, {Height: MaxDimension + 1}, {Width: 10, Fit: "stretch"}} {
+		if bad.Validate() == nil {
+			t.Errorf("%+v is valid", bad)
+		}
+	}
+}
+
+// withEncoders makes availableEncoders return the built-in encoders plus
+// fakes for the named formats.
+func withEncoders(t *testing.T, fakes ...string) {
+	t.Helper()
+	availableEncoders()
+	saved := encoders
+	encoders = map[string]encoder{}
+	for k, v := range builtinEncoders {
+		encoders[k] = v
+	}
+	for _, f := range fakes {
+		f := f
+		encoders[f] = func(ctx context.Context, img image.Image) ([]byte, error) {
+			return []byte(f), nil
+		}
+	}
+	t.Cleanup(func() { encoders = saved })
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	withEncoders(t, "image/webp")
+	for _, tc := range []struct{ accept, source, want string }{
+		{"image/avif,image/webp,*/*", "image/jpeg", "image/webp"},
+		{"image/webp;q=0, */*", "image/jpeg", "image/jpeg"},
+		{"*/*", "image/png", "image/png"},
+		{"", "image/tiff", "image/png"},
+	} {
+		if got := negotiateFormat(tc.accept, tc.source); got != tc.want {
+			t.Errorf("negotiateFormat(%q, %s) = %s, want %s", tc.accept, tc.source, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package media
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps media in a directory on disk. The server serves it
+// under publicURL.
+type LocalStore struct {
+	dir       string
+	publicURL string
+}
+
+func NewLocalStore(dir, publicURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{dir: dir, publicURL: strings.TrimRight(publicURL, "/")}, nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	url := s.publicURL + "/" + key
+	if _, err := os.Stat(p); err == nil {
+		return url, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", err
+	}
+	return url, os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
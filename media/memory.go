@@ -0,0 +1,48 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps media in memory, for running the API without a
+// storage backend.
+type MemoryStore struct {
+	mu        sync.Mutex
+	files     map[string][]byte
+	publicURL string
+}
+
+func NewMemoryStore(publicURL string) *MemoryStore {
+	return &MemoryStore{files: map[string][]byte{}, publicURL: strings.TrimRight(publicURL, "/")}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !validKey(key) {
+		return "", ErrNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = append([]byte(nil), data...)
+	return s.publicURL + "/" + key, nil
+}
+
+func (s *MemoryStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, key)
+	return nil
+}
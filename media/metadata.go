@@ -0,0 +1,177 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// stripMetadata removes EXIF, XMP and text metadata, which can carry GPS
+// positions and camera details, from JPEG, PNG and WebP files without
+// re-encoding them. Other formats and files it cannot parse are returned
+// unchanged.
+func stripMetadata(data []byte, mimeType string) []byte {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	}
+	return data
+}
+
+// stripJPEG drops APP1 (EXIF and XMP), APP13 (IPTC) and comment segments.
+// The JFIF header, ICC profiles and Adobe color hints are kept.
+func stripJPEG(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := append(make([]byte, 0, len(data)), data[:2]...)
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return data
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan: the rest is image data.
+			return append(out, data[i:]...)
+		}
+		if marker == 0xFF || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return data
+		}
+		switch marker {
+		case 0xE1, 0xED, 0xFE:
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return data
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops eXIf, text and timestamp chunks.
+func stripPNG(data []byte) []byte {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data
+	}
+	out := append(make([]byte, 0, len(data)), pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return data
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return data
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the
+// extended header.
+func stripWebP(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data
+	}
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return data
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+		if end > len(data) || end < i {
+			return data
+		}
+		switch fourCC := string(data[i : i+4]); fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, 1 to 8, or returns
+// 1 when there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return 1
+		}
+		if seg := data[i+4 : end]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < n; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[off:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[off+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
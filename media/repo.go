@@ -0,0 +1,101 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Media records a stored file. References lists what uses it, as
+// "kind:id" strings such as "about:<user id>".
+type Media struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Key      string             `json:"key" bson:"key"`
+	URL      string             `json:"url" bson:"url"`
+	OwnerId  string             `json:"owner_id" bson:"owner_id"`
+	Filename string             `json:"filename" bson:"filename"`
+	MimeType string             `json:"mime_type" bson:"mime_type"`
+	Size     int64              `json:"size" bson:"size"`
+	Width    int                `json:"width,omitempty" bson:"width,omitempty"`
+	Height   int                `json:"height,omitempty" bson:"height,omitempty"`
+	// Variants maps the names in media.Variants to their URLs.
+	Variants   map[string]string `json:"variants,omitempty" bson:"variants,omitempty"`
+	References []string          `json:"references" bson:"references"`
+	// Derived lists the keys of every variant stored for this file, so
+	// they are deleted with it.
+	Derived   []string  `json:"-" bson:"derived,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+type MediaRepository interface {
+	CreateMedia(ctx context.Context, m *Media) (*mongo.InsertOneResult, error)
+	GetMedia(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*Media, error)
+	GetMediaList(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Media, error)
+	UpdateMedia(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteMedia(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CountMedia(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+}
+
+type MediaRepo struct {
+	collection db.Collection
+}
+
+func NewMediaRepo(collection db.Collection) *MediaRepo {
+	return &MediaRepo{collection}
+}
+
+// InitMediaIndexes makes keys unique and indexes the lookups by URL and
+// by reference.
+func InitMediaIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"key": 1}, Options: options.Index().SetName("media_key_index").SetUnique(true)},
+		{Keys: bson.M{"url": 1}, Options: options.Index().SetName("media_url_index")},
+		{Keys: bson.M{"references": 1}, Options: options.Index().SetName("media_references_index")},
+	})
+	if err != nil {
+		return errors.New("Error creating indexes for media collection: " + err.Error())
+	}
+	return nil
+}
+
+func (repo *MediaRepo) CreateMedia(ctx context.Context, m *Media) (*mongo.InsertOneResult, error) {
+	return repo.collection.InsertOne(ctx, m)
+}
+
+func (repo *MediaRepo) GetMedia(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*Media, error) {
+	var m Media
+	if err := repo.collection.FindOne(ctx, filter, opts...).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (repo *MediaRepo) GetMediaList(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Media, error) {
+	cur, err := repo.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	list := []*Media{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (repo *MediaRepo) UpdateMedia(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return repo.collection.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (repo *MediaRepo) DeleteMedia(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return repo.collection.DeleteOne(ctx, filter, opts...)
+}
+
+func (repo *MediaRepo) CountMedia(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return repo.collection.CountDocuments(ctx, filter, opts...)
+}
@@ -0,0 +1,242 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+)
+
+// S3Config locates a bucket on Amazon S3 or a compatible service such as
+// MinIO.
+type S3Config struct {
+	// Endpoint is the service URL, such as https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as endpoint/bucket rather than as
+	// bucket.endpoint. MinIO needs it.
+	PathStyle bool
+	// PublicURL, when set, is the base URL objects are served from, such as
+	// a CDN in front of the bucket.
+	PublicURL string
+}
+
+// S3Store keeps media in an S3 bucket. Requests are signed with AWS
+// Signature Version 4.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	clock    clock.Clock
+}
+
+func NewS3Store(cfg S3Config, clk clock.Clock) (*S3Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Minute},
+		clock:    clock.OrSystem(clk),
+	}, nil
+}
+
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.RawQuery = ""
+	base := strings.TrimRight(u.Path, "/")
+	if s.cfg.PathStyle {
+		u.Path = base + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	return &u
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !validKey(key) {
+		return "", ErrNotFound
+	}
+	res, err := s.do(ctx, http.MethodPut, s.objectURL(key), data, http.Header{
+		"Content-Type":  {contentType},
+		"Cache-Control": {"public, max-age=31536000, immutable"},
+	})
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if s.cfg.PublicURL != "" {
+		return strings.TrimRight(s.cfg.PublicURL, "/") + "/" + key, nil
+	}
+	return s.objectURL(key).String(), nil
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrNotFound
+	}
+	res, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrNotFound
+	}
+	res, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Ping checks that the bucket is reachable with our credentials.
+func (s *S3Store) Ping(ctx context.Context) error {
+	u := s.objectURL("")
+	if trimmed := strings.TrimSuffix(u.Path, "/"); trimmed != "" {
+		u.Path, u.RawPath = trimmed, escapePath(trimmed)
+	}
+	res, err := s.do(ctx, http.MethodHead, u, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach bucket %s: %w", s.cfg.Bucket, err)
+	}
+	return res.Body.Close()
+}
+
+// do sends a signed request and turns error statuses into errors; a 404
+// becomes ErrNotFound.
+func (s *S3Store) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, u.Path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+const (
+	amzDateFormat = "20060102T150405Z"
+	signAlgorithm = "AWS4-HMAC-SHA256"
+)
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s *S3Store) sign(req *http.Request, body []byte) {
+	now := s.clock.Now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{signAlgorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// escapePath encodes each path segment the way S3 expects: everything but
+// unreserved characters is percent-encoded.
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		var b strings.Builder
+		for _, c := range []byte(seg) {
+			if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
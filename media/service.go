@@ -0,0 +1,454 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxSize caps any stored file, including media restored from backups.
+const MaxSize = 25 << 20
+
+// Policy limits what users may upload to the library.
+type Policy struct {
+	MaxSize      int64
+	AllowedTypes []string
+}
+
+func (p Policy) allows(mimeType string) bool {
+	for _, t := range p.AllowedTypes {
+		if t == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+type MediaService struct {
+	repo   MediaRepository
+	store  MediaStore
+	policy Policy
+	clock  clock.Clock
+}
+
+func NewMediaService(repo MediaRepository, store MediaStore, policy Policy, clk clock.Clock) *MediaService {
+	if policy.MaxSize <= 0 || policy.MaxSize > MaxSize {
+		policy.MaxSize = MaxSize
+	}
+	return &MediaService{repo: repo, store: store, policy: policy, clock: clock.OrSystem(clk)}
+}
+
+// Upload stores the contents of r and records it for ownerId. The contents
+// must fit the policy. Identical content is stored once: uploading it again
+// returns the existing record.
+func (s *MediaService) Upload(ctx context.Context, r io.Reader, filename, ownerId string) (*Media, error) {
+	return s.upload(ctx, r, filename, ownerId, s.policy.MaxSize, s.policy.allows)
+}
+
+func (s *MediaService) upload(ctx context.Context, r io.Reader, filename, ownerId string, maxSize int64, allow func(mimeType string) bool) (*Media, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, apperrors.BadRequest(apperrors.CodeValidation, "failed to read upload", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, apperrors.New(CodeMediaTooLarge, fmt.Sprintf("file is larger than %d MB", maxSize>>20), http.StatusRequestEntityTooLarge, nil)
+	}
+	if len(data) == 0 {
+		return nil, apperrors.BadRequest(apperrors.CodeValidation, "file is empty", nil)
+	}
+	mimeType := detectType(data, filename)
+	if allow != nil && !allow(mimeType) {
+		return nil, apperrors.New(CodeUnsupportedMedia, "unsupported media type "+mimeType, http.StatusUnsupportedMediaType, nil)
+	}
+	var img image.Image
+	if resizable[mimeType] {
+		if data, img, err = prepareImage(ctx, data, mimeType); err != nil {
+			return nil, apperrors.BadRequest(CodeInvalidImage, "file is not a valid image", err)
+		}
+	}
+	key := Key(data, mimeType)
+	if existing, err := s.repo.GetMedia(ctx, bson.M{"key": key}); err == nil {
+		return existing, nil
+	} else if err != mongo.ErrNoDocuments {
+		return nil, apperrors.Internal(err)
+	}
+	url, err := s.store.Put(ctx, key, data, mimeType)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	m := &Media{
+		Key:        key,
+		URL:        url,
+		OwnerId:    ownerId,
+		Filename:   path.Base(filename),
+		MimeType:   mimeType,
+		Size:       int64(len(data)),
+		References: []string{},
+		CreatedAt:  s.clock.Now(),
+	}
+	if img != nil {
+		m.Width, m.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		if err := s.putVariants(ctx, m, img); err != nil {
+			return nil, apperrors.Internal(err)
+		}
+	}
+	res, err := s.repo.CreateMedia(ctx, m)
+	if mongo.IsDuplicateKeyError(err) {
+		// Someone uploaded the same content concurrently.
+		if existing, err := s.repo.GetMedia(ctx, bson.M{"key": key}); err == nil {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		m.Id = id
+	}
+	return m, nil
+}
+
+// prepareImage strips metadata from an uploaded image and decodes it. A
+// JPEG whose EXIF orientation says it is rotated is re-encoded upright,
+// since stripping the orientation would otherwise turn it sideways.
+func prepareImage(ctx context.Context, data []byte, mimeType string) ([]byte, image.Image, error) {
+	orientation := 1
+	if mimeType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	data = stripMetadata(data, mimeType)
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if orientation > 1 {
+		img = orient(img, orientation)
+		if data, err = encodeImage(ctx, img, mimeType); err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, img, nil
+}
+
+// putVariants stores the responsive Variants of a new image. GIFs keep
+// only the original so animations are not lost.
+func (s *MediaService) putVariants(ctx context.Context, m *Media, img image.Image) error {
+	if m.MimeType == "image/gif" {
+		return nil
+	}
+	m.Variants = map[string]string{}
+	format := negotiateFormat("", m.MimeType)
+	for name, t := range Variants {
+		data, err := encodeImage(ctx, resize(img, t), format)
+		if err != nil {
+			return err
+		}
+		key := variantKey(m.Key, t, format)
+		url, err := s.store.Put(ctx, key, data, format)
+		if err != nil {
+			return err
+		}
+		m.Variants[name] = url
+		m.Derived = append(m.Derived, key)
+	}
+	sort.Strings(m.Derived)
+	return nil
+}
+
+// variantKey is where the variant t of the original at key is cached.
+func variantKey(key string, t Transform, format string) string {
+	return "variants/" + strings.TrimSuffix(key, path.Ext(key)) + "-" + t.String() + extension(format)
+}
+
+// Rendition is a stored file or a variant of it, ready to serve.
+type Rendition struct {
+	Data        []byte
+	ContentType string
+	// ETag identifies the bytes; they never change for a given ETag.
+	ETag string
+}
+
+// Render returns the media identified by idStr transformed by t, in the
+// best format the Accept header allows. Variants are cached in the store.
+func (s *MediaService) Render(ctx context.Context, idStr string, t Transform, accept string) (*Rendition, error) {
+	if err := t.Validate(); err != nil {
+		return nil, apperrors.BadRequest(apperrors.CodeValidation, err.Error(), nil)
+	}
+	m, err := s.Get(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	if !resizable[m.MimeType] {
+		if t.resizes() {
+			return nil, apperrors.BadRequest(CodeInvalidImage, "only JPEG, PNG, GIF and WebP images can be resized", nil)
+		}
+		return s.rendition(ctx, m.Key, m.MimeType)
+	}
+	format := negotiateFormat(accept, m.MimeType)
+	if !t.resizes() && (format == m.MimeType || m.MimeType == "image/gif") {
+		return s.rendition(ctx, m.Key, m.MimeType)
+	}
+	key := variantKey(m.Key, t, format)
+	if r, err := s.rendition(ctx, key, format); err == nil {
+		return r, nil
+	}
+	original, err := s.rendition(ctx, m.Key, m.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(original.Data)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	data, err := encodeImage(ctx, resize(img, t), format)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	if _, err := s.store.Put(ctx, key, data, format); err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	if _, err := s.repo.UpdateMedia(ctx, bson.M{"_id": m.Id}, bson.M{"$addToSet": bson.M{"derived": key}}); err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return &Rendition{Data: data, ContentType: format, ETag: etag(key)}, nil
+}
+
+func (s *MediaService) rendition(ctx context.Context, key, contentType string) (*Rendition, error) {
+	rc, err := s.store.Open(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, apperrors.NotFound(CodeMediaNotFound, "media not found", err)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return &Rendition{Data: data, ContentType: contentType, ETag: etag(key)}, nil
+}
+
+func etag(key string) string {
+	return `"` + strings.ReplaceAll(strings.TrimPrefix(key, "variants/"), "/", "-") + `"`
+}
+
+// detectType sniffs the media type of data. Formats the sniffer only knows
+// as generic text or binary fall back to the filename's extension.
+func detectType(data []byte, filename string) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	switch sniffed {
+	case "application/octet-stream", "text/plain", "text/xml":
+		if ext := path.Ext(filename); ext != "" {
+			if t := ContentType(ext); t != "application/octet-stream" {
+				base, _, _ := mime.ParseMediaType(t)
+				return base
+			}
+		}
+	}
+	return sniffed
+}
+
+// UploadImage stores an uploaded image and returns its URL.
+func (s *MediaService) UploadImage(ctx context.Context, file *multipart.FileHeader, ownerId string) (string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return "", apperrors.BadRequest(apperrors.CodeValidation, "failed to open file", err)
+	}
+	defer f.Close()
+	m, err := s.upload(ctx, f, file.Filename, ownerId, s.policy.MaxSize, func(mimeType string) bool {
+		return strings.HasPrefix(mimeType, "image/") && s.policy.allows(mimeType)
+	})
+	if err != nil {
+		return "", err
+	}
+	return m.URL, nil
+}
+
+// UploadFile stores the contents of r and returns its URL. Backup restore
+// uses it to re-host archived media.
+func (s *MediaService) UploadFile(ctx context.Context, r io.Reader, name string) (string, error) {
+	m, err := s.upload(ctx, r, name, "", MaxSize, nil)
+	if err != nil {
+		return "", err
+	}
+	return m.URL, nil
+}
+
+// ListQuery selects a page of the library. Query matches file names.
+type ListQuery struct {
+	Page    int
+	PerPage int
+	Query   string
+	OwnerId string
+}
+
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
+// List returns a page of the library, newest first, and the number of
+// matching files.
+func (s *MediaService) List(ctx context.Context, q ListQuery) ([]*Media, int64, error) {
+	filter := bson.M{}
+	if q.OwnerId != "" {
+		filter["owner_id"] = q.OwnerId
+	}
+	if q.Query != "" {
+		filter["filename"] = primitive.Regex{Pattern: regexp.QuoteMeta(q.Query), Options: "i"}
+	}
+	total, err := s.repo.CountMedia(ctx, filter)
+	if err != nil {
+		return nil, 0, apperrors.Internal(err)
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((q.Page - 1) * q.PerPage)).
+		SetLimit(int64(q.PerPage))
+	list, err := s.repo.GetMediaList(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, apperrors.Internal(err)
+	}
+	return list, total, nil
+}
+
+func (s *MediaService) Get(ctx context.Context, idStr string) (*Media, error) {
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return nil, apperrors.BadRequest(apperrors.CodeInvalidID, "invalid media id", err)
+	}
+	m, err := s.repo.GetMedia(ctx, bson.M{"_id": id})
+	if err == mongo.ErrNoDocuments {
+		return nil, apperrors.NotFound(CodeMediaNotFound, "media not found", err)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return m, nil
+}
+
+// Delete removes a file that nothing references any more.
+func (s *MediaService) Delete(ctx context.Context, idStr string) error {
+	m, err := s.Get(ctx, idStr)
+	if err != nil {
+		return err
+	}
+	if len(m.References) > 0 {
+		return apperrors.Conflict(CodeMediaInUse, "media is still used by "+strings.Join(m.References, ", "), nil)
+	}
+	for _, key := range append(m.Derived, m.Key) {
+		if err := s.store.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+			return apperrors.Internal(err)
+		}
+	}
+	if _, err := s.repo.DeleteMedia(ctx, bson.M{"_id": m.Id}); err != nil {
+		return apperrors.Internal(err)
+	}
+	return nil
+}
+
+// Open returns the contents stored under key along with their media type.
+func (s *MediaService) Open(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	rc, err := s.store.Open(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", apperrors.NotFound(CodeMediaNotFound, "media not found", err)
+	}
+	if err != nil {
+		return nil, "", apperrors.Internal(err)
+	}
+	return rc, ContentType(key), nil
+}
+
+// OpenURL opens the media or variant recorded under url. It returns
+// ErrNotFound for URLs that are not in the library.
+func (s *MediaService) OpenURL(ctx context.Context, url string) (io.ReadCloser, string, error) {
+	m, err := s.repo.GetMedia(ctx, urlFilter(url))
+	if err == mongo.ErrNoDocuments {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	key, contentType := m.Key, m.MimeType
+	for name, u := range m.Variants {
+		if u == url {
+			contentType = negotiateFormat("", m.MimeType)
+			key = variantKey(m.Key, Variants[name], contentType)
+		}
+	}
+	rc, err := s.store.Open(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return rc, contentType, nil
+}
+
+// urlFilter matches the media published at u, either as the original or as
+// one of its Variants.
+func urlFilter(u string) bson.M {
+	or := bson.A{bson.M{"url": u}}
+	for name := range Variants {
+		or = append(or, bson.M{"variants." + name: u})
+	}
+	return bson.M{"$or": or}
+}
+
+// usedBy reports whether urls holds the original or a variant of m.
+func (m *Media) usedBy(urls map[string]bool) bool {
+	if urls[m.URL] {
+		return true
+	}
+	for _, u := range m.Variants {
+		if urls[u] {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReferences records that ref, such as "about:<user id>", uses exactly
+// the media at urls, matching variant URLs to their original. Media it no
+// longer uses drops the reference; URLs outside the library are ignored.
+func (s *MediaService) SetReferences(ctx context.Context, ref string, urls ...string) error {
+	keep := map[string]bool{}
+	for _, u := range urls {
+		if u != "" {
+			keep[u] = true
+		}
+	}
+	current, err := s.repo.GetMediaList(ctx, bson.M{"references": ref})
+	if err != nil {
+		return apperrors.Internal(err)
+	}
+	for _, m := range current {
+		if m.usedBy(keep) {
+			continue
+		}
+		if _, err := s.repo.UpdateMedia(ctx, bson.M{"_id": m.Id}, bson.M{"$pull": bson.M{"references": ref}}); err != nil {
+			return apperrors.Internal(err)
+		}
+	}
+	for u := range keep {
+		if _, err := s.repo.UpdateMedia(ctx, urlFilter(u), bson.M{"$addToSet": bson.M{"references": ref}}); err != nil {
+			return apperrors.Internal(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var pngData = encodePNG(4, 3)
+
+// encodePNG returns a w×h PNG.
+func encodePNG(w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+var testPolicy = Policy{MaxSize: 1 << 20, AllowedTypes: []string{"image/png", "image/svg+xml", "text/plain"}}
+
+func newService(policy Policy, store MediaStore) (*MediaService, *MediaRepo) {
+	repo := NewMediaRepo(db.NewMemoryCollection())
+	return NewMediaService(repo, store, policy, nil), repo
+}
+
+func TestKey(t *testing.T) {
+	k := Key([]byte("hello"), "image/png")
+	if k != "2c/2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824.png" {
+		t.Errorf("Key = %s", k)
+	}
+	if ContentType(k) != "image/png" || ContentType("ab/x.JPG") != "image/jpeg" || ContentType("ab/x") != "application/octet-stream" {
+		t.Error("ContentType does not invert Key")
+	}
+	for _, bad := range []string{"", "/etc/passwd", "../x", "a/../../x", `a\b`} {
+		if validKey(bad) {
+			t.Errorf("validKey(%q) = true", bad)
+		}
+	}
+}
+
+func TestStores(t *testing.T) {
+	local, err := NewLocalStore(filepath.Join(t.TempDir(), "media"), "/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]MediaStore{"local": local, "memory": NewMemoryStore("/media")} {
+		ctx := context.Background()
+		u, err := s.Put(ctx, "ab/abc.png", pngData, "image/png")
+		if err != nil || u != "/media/ab/abc.png" {
+			t.Errorf("%s: put = %q, %v", name, u, err)
+		}
+		rc, err := s.Open(ctx, "ab/abc.png")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(data, pngData) {
+			t.Errorf("%s: read %q", name, data)
+		}
+		if err := s.Delete(ctx, "ab/abc.png"); err != nil {
+			t.Errorf("%s: delete: %v", name, err)
+		}
+		if _, err := s.Open(ctx, "ab/abc.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: open after delete = %v, want ErrNotFound", name, err)
+		}
+		if _, err := s.Put(ctx, "../escape.png", pngData, "image/png"); err == nil {
+			t.Errorf("%s: a key outside the store was accepted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(local.dir), "escape.png")); !os.IsNotExist(err) {
+		t.Error("local store wrote outside its directory")
+	}
+}
+
+func TestUploadDeduplicates(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("/media")
+	s, _ := newService(testPolicy, store)
+	a, err := s.Upload(ctx, bytes.NewReader(pngData), "a.png", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.MimeType != "image/png" || a.Size != int64(len(pngData)) || a.Filename != "a.png" || !strings.HasSuffix(a.Key, ".png") {
+		t.Errorf("media = %+v", a)
+	}
+	b, err := s.Upload(ctx, bytes.NewReader(pngData), "b.png", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Id != a.Id || b.URL != a.URL || len(store.files) != 1+len(Variants) {
+		t.Errorf("identical content stored twice: %+v, %+v", a, b)
+	}
+}
+
+func TestUploadPolicy(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newService(testPolicy, NewMemoryStore("/media"))
+	status := func(err error) int {
+		var appErr *apperrors.AppError
+		if !errors.As(err, &appErr) {
+			return 0
+		}
+		return appErr.StatusCode
+	}
+	for name, tc := range map[string]struct {
+		data   []byte
+		status int
+	}{
+		"empty":        {nil, http.StatusBadRequest},
+		"too large":    {append(append([]byte(nil), pngData...), make([]byte, testPolicy.MaxSize)...), http.StatusRequestEntityTooLarge},
+		"not allowed":  {[]byte("%PDF-1.4 document"), http.StatusUnsupportedMediaType},
+		"just allowed": {append(append([]byte(nil), pngData...), make([]byte, testPolicy.MaxSize-int64(len(pngData)))...), 0},
+	} {
+		_, err := s.Upload(ctx, bytes.NewReader(tc.data), name, "ada")
+		if got := status(err); got != tc.status {
+			t.Errorf("%s: status %d (%v), want %d", name, got, err, tc.status)
+		}
+	}
+	// Restores re-host whatever was archived, whatever the policy says.
+	if _, err := s.UploadFile(ctx, strings.NewReader("%PDF-1.4 document"), "doc.pdf"); err != nil {
+		t.Errorf("UploadFile applied the upload policy: %v", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("/media")
+	s, _ := newService(testPolicy, store)
+	var ids []string
+	for i, name := range []string{"cat.txt", "dog.txt", "Catalog.txt"} {
+		owner := "ada"
+		if i == 1 {
+			owner = "bob"
+		}
+		m, err := s.Upload(ctx, strings.NewReader("file "+name), name, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.Id.Hex())
+	}
+	list, total, err := s.List(ctx, ListQuery{Page: 1, PerPage: 1, Query: "CAT"})
+	if err != nil || total != 2 || len(list) != 1 {
+		t.Errorf("search = %d of %d, %v; want 1 of 2", len(list), total, err)
+	}
+	if list, total, _ := s.List(ctx, ListQuery{Page: 1, PerPage: 10, OwnerId: "bob"}); total != 1 || list[0].Filename != "dog.txt" {
+		t.Errorf("bob's uploads = %v", list)
+	}
+
+	if err := s.SetReferences(ctx, "post:1", "/media/"+mustGet(t, s, ids[0]).Key); err != nil {
+		t.Fatal(err)
+	}
+	var appErr *apperrors.AppError
+	if err := s.Delete(ctx, ids[0]); !errors.As(err, &appErr) || appErr.Code != CodeMediaInUse {
+		t.Errorf("deleting used media = %v, want %s", err, CodeMediaInUse)
+	}
+	if err := s.Delete(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, ids[1]); !errors.As(err, &appErr) || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("get after delete = %v", err)
+	}
+	if len(store.files) != 2 {
+		t.Errorf("%d files stored, want the deleted one gone", len(store.files))
+	}
+}
+
+func mustGet(t *testing.T, s *MediaService, id string) *Media {
+	t.Helper()
+	m, err := s.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDetectTypeFallsBackToExtension(t *testing.T) {
+	if got := detectType([]byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), "logo.svg"); got != "image/svg+xml" {
+		t.Errorf("svg = %s", got)
+	}
+	if got := detectType(pngData, "misnamed.txt"); got != "image/png" {
+		t.Errorf("sniffed type lost to the extension: %s", got)
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newService(testPolicy, NewMemoryStore("https://blog.example.com/media"))
+	m, err := s.Upload(ctx, bytes.NewReader(pngData), "a.png", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, contentType, err := s.OpenURL(ctx, m.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if contentType != "image/png" {
+		t.Errorf("content type = %s", contentType)
+	}
+	for _, u := range []string{"http://169.254.169.254/latest/meta-data", "https://blog.example.com/media/ab/other.png"} {
+		if _, _, err := s.OpenURL(ctx, u); !errors.Is(err, ErrNotFound) {
+			t.Errorf("OpenURL(%s) = %v, want ErrNotFound", u, err)
+		}
+	}
+}
+
+func TestSetReferences(t *testing.T) {
+	ctx := context.Background()
+	s, repo := newService(testPolicy, NewMemoryStore("/media"))
+	a, _ := s.Upload(ctx, bytes.NewReader(pngData), "a.png", "ada")
+	b, _ := s.Upload(ctx, strings.NewReader("plain text"), "b.txt", "ada")
+	refs := func(m *Media) []string {
+		t.Helper()
+		got, err := repo.GetMedia(ctx, bson.M{"_id": m.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.References
+	}
+
+	if err := s.SetReferences(ctx, "about:ada", a.URL, "https://elsewhere.example.com/x.png", ""); err != nil {
+		t.Fatal(err)
+	}
+	if r := refs(a); len(r) != 1 || r[0] != "about:ada" {
+		t.Errorf("a references = %v", r)
+	}
+	if err := s.SetReferences(ctx, "about:ada", b.URL); err != nil {
+		t.Fatal(err)
+	}
+	if len(refs(a)) != 0 || len(refs(b)) != 1 {
+		t.Errorf("references = %v and %v, want them moved to b", refs(a), refs(b))
+	}
+}
+
+func TestUploadImageRejectsOtherTypes(t *testing.T) {
+	s, _ := newService(testPolicy, NewMemoryStore("/media"))
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "notes.txt")
+	part.Write([]byte("just text"))
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	_, header, err := req.FormFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UploadImage(context.Background(), header, "ada")
+	var appErr *apperrors.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("err = %v, want 415", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _ := newService(testPolicy, NewMemoryStore("/media"))
+	m, err := s.Upload(context.Background(), bytes.NewReader(pngData), "a.png", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(apperrors.ErrorHandler())
+	r.GET("/media/*key", NewMediaController(s).Serve)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, m.URL, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), pngData) {
+		t.Errorf("status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Cache-Control"), "immutable") || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("headers = %v", w.Header())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/media/ab/missing.png", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing file: status %d", w.Code)
+	}
+}
+
+func TestUploadImage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("/media")
+	s, _ := newService(Policy{AllowedTypes: []string{"image/jpeg"}}, store)
+	m, err := s.Upload(ctx, bytes.NewReader(encodeJPEG(t, 4, 2, 6)), "sideways.jpg", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 2 || m.Height != 4 {
+		t.Errorf("size = %dx%d, want the image turned upright to 2x4", m.Width, m.Height)
+	}
+	if data := store.files[m.Key]; bytes.Contains(data, []byte("Exif")) || bytes.Contains(data, []byte("51.5N")) {
+		t.Error("stored file kept its metadata")
+	}
+	if len(m.Variants) != len(Variants) || len(m.Derived) != len(Variants) {
+		t.Fatalf("variants = %v, derived = %v", m.Variants, m.Derived)
+	}
+
+	// A variant URL opens the variant and counts as a use of the original.
+	rc, contentType, err := s.OpenURL(ctx, m.Variants["thumbnail"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if contentType != "image/jpeg" {
+		t.Errorf("variant content type = %s", contentType)
+	}
+	if err := s.SetReferences(ctx, "post:1", m.Variants["medium"]); err != nil {
+		t.Fatal(err)
+	}
+	var appErr *apperrors.AppError
+	if err := s.Delete(ctx, m.Id.Hex()); !errors.As(err, &appErr) || appErr.Code != CodeMediaInUse {
+		t.Errorf("deleting media used through a variant = %v", err)
+	}
+	if err := s.SetReferences(ctx, "post:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, m.Id.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("left behind %d files", len(store.files))
+	}
+
+	if _, err := s.Upload(ctx, strings.NewReader("\xFF\xD8\xFF not really"), "broken.jpg", "ada"); !errors.As(err, &appErr) || appErr.Code != CodeInvalidImage {
+		t.Errorf("broken JPEG = %v, want %s", err, CodeInvalidImage)
+	}
+}
+
+func TestRender(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	withEncoders(t, "image/webp")
+	store := NewMemoryStore("/media")
+	s, repo := newService(testPolicy, store)
+	m, err := s.Upload(context.Background(), bytes.NewReader(encodePNG(400, 200)), "wide.png", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(apperrors.ErrorHandler())
+	r.GET("/media/:id", NewMediaController(s).Render)
+	get := func(query, accept, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/media/"+m.Id.Hex()+query, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("?w=100", "image/png", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Vary") != "Accept" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	img, _, err := image.Decode(w.Body)
+	if err != nil || img.Bounds().Dx() != 160 {
+		t.Errorf("rendered %v, %v; want the width snapped to 160", img.Bounds(), err)
+	}
+	etag := w.Header().Get("ETag")
+	if w := get("?w=150", "image/png", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("revalidating a snapped size: status %d", w.Code)
+	}
+	if w := get("?w=100", "image/webp,*/*", etag); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/webp" {
+		t.Errorf("webp client: status %d, type %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := get("?size=thumbnail", "", ""); w.Code != http.StatusOK {
+		t.Errorf("named size: status %d", w.Code)
+	}
+	for _, q := range []string{"?size=huge", "?w=x", "?w=100&fit=stretch"} {
+		if w := get(q, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", q, w.Code)
+		}
+	}
+
+	got, err := repo.GetMedia(context.Background(), bson.M{"_id": m.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Derived) != len(Variants)+2 {
+		t.Errorf("derived = %v, want the upload variants plus the PNG and WebP renders", got.Derived)
+	}
+	if err := s.Delete(context.Background(), m.Id.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("left behind %d files", len(store.files))
+	}
+}
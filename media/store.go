@@ -0,0 +1,81 @@
+// Package media stores uploaded files in a pluggable backend and keeps a
+// record of each in the media collection.
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+)
+
+// ErrNotFound is returned by stores for keys they do not hold.
+var ErrNotFound = errors.New("media not found")
+
+// MediaStore keeps file contents under keys such as "ab/ab12…ef.png".
+type MediaStore interface {
+	// Put stores data under key and returns its public URL. Keys are
+	// content addressed, so putting an existing key may skip the write.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// extensions are the preferred file extensions of common media types;
+// mime.ExtensionsByType varies by system and can answer ".jfif" for JPEG.
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"image/svg+xml":   ".svg",
+	"image/bmp":       ".bmp",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// Key returns the content-addressed key of data: its SHA-256, sharded by
+// the first byte, with an extension for contentType.
+func Key(data []byte, contentType string) string {
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:])
+	return h[:2] + "/" + h + extension(contentType)
+}
+
+func extension(contentType string) string {
+	base, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := extensions[base]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(base); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// ContentType is the media type implied by a key's extension.
+func ContentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	for t, e := range extensions {
+		if e == ext {
+			return t
+		}
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// validKey rejects keys that could escape a store's root.
+func validKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && !strings.Contains(key, "..") && !strings.Contains(key, "\\")
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "bloggy"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	mongoOps     *prometheus.HistogramVec
+
+	postsCreated  prometheus.Counter
+	comments      prometheus.Counter
+	likes         *prometheus.CounterVec
+	logins        *prometheus.CounterVec
+	subscriptions *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "http", Name: "requests_total",
+			Help: "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+			Help:    "HTTP request latency by method and route template.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		mongoOps: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "mongodb", Name: "command_duration_seconds",
+			Help:    "MongoDB command latency by command name and outcome.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"command", "outcome"}),
+		postsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "posts_created_total",
+			Help: "Blog posts created.",
+		}),
+		comments: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "comments_total",
+			Help: "Comments posted.",
+		}),
+		likes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "likes_total",
+			Help: "Likes and unlikes by target (post or comment) and action.",
+		}, []string{"target", "action"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "logins_total",
+			Help: "Successful logins by method.",
+		}, []string{"method"}),
+		subscriptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "subscriptions_total",
+			Help: "Mailing list subscription changes by action.",
+		}, []string{"action"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.mongoOps,
+		m.postsCreated, m.comments, m.likes, m.logins, m.subscriptions,
+	)
+	return m
+}
+
+// RegisterActiveSessions exposes the number of live access tokens, read from
+// count on every scrape.
+func (m *Metrics) RegisterActiveSessions(count func(ctx context.Context) (int64, error)) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "active_sessions",
+		Help: "Access tokens that have not expired or been revoked.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		n, err := count(ctx)
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	}))
+}
+
+// Middleware records request counts and latency per gin route template.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// CommandMonitor times every MongoDB command issued by the driver.
+func (m *Metrics) CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			m.mongoOps.WithLabelValues(e.CommandName, "success").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			m.mongoOps.WithLabelValues(e.CommandName, "failure").Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// Handler serves the registry in the Prometheus text format. When token is
+// non-empty, requests must carry it as a bearer token.
+func (m *Metrics) Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (m *Metrics) PostCreated() { m.postsCreated.Inc() }
+
+func (m *Metrics) CommentPosted() { m.comments.Inc() }
+
+func (m *Metrics) Liked(target, action string) { m.likes.WithLabelValues(target, action).Inc() }
+
+func (m *Metrics) LoggedIn(method string) { m.logins.WithLabelValues(method).Inc() }
+
+func (m *Metrics) Subscription(action string) { m.subscriptions.WithLabelValues(action).Inc() }
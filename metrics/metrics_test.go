@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func scrape(t *testing.T, h http.Handler, auth string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestMiddlewareLabelsByRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New()
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/blog/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for _, path := range []string{"/blog/1", "/blog/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, "/blog/:id", "200")); got != 2 {
+		t.Errorf("requests for /blog/:id = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, "unmatched", "404")); got != 1 {
+		t.Errorf("unmatched requests = %v, want 1", got)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	m := New()
+	m.PostCreated()
+	m.CommentPosted()
+	m.Liked("post", "like")
+	m.LoggedIn("google")
+	m.LoggedIn("google")
+	m.Subscription("subscribe")
+	m.RegisterActiveSessions(func(ctx context.Context) (int64, error) { return 3, nil })
+	_, body := scrape(t, m.Handler(""), "")
+	for _, want := range []string{
+		"bloggy_posts_created_total 1",
+		"bloggy_comments_total 1",
+		`bloggy_likes_total{action="like",target="post"} 1`,
+		`bloggy_logins_total{method="google"} 2`,
+		`bloggy_subscriptions_total{action="subscribe"} 1`,
+		"bloggy_active_sessions 3",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape is missing %q", want)
+		}
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	h := New().Handler("s3cret")
+	if code, _ := scrape(t, h, ""); code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d", code)
+	}
+	if code, _ := scrape(t, h, "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", code)
+	}
+	if code, _ := scrape(t, h, "Bearer s3cret"); code != http.StatusOK {
+		t.Errorf("right token: status %d", code)
+	}
+}
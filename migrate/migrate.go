@@ -0,0 +1,253 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	historyCollection = "schema_migrations"
+	lockCollection    = "schema_migrations_lock"
+	lockId            = "lock"
+)
+
+// DefaultLockTTL bounds how long a crashed process can keep others from
+// migrating.
+const DefaultLockTTL = 15 * time.Minute
+
+var ErrLocked = errors.New("migrations are locked by another process")
+
+// Migration is one versioned schema change. Down may be nil for changes
+// that cannot be undone.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, database *mongo.Database) error
+	Down    func(ctx context.Context, database *mongo.Database) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// State is a migration together with when it was applied, if it was.
+type State struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type record struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+type lock struct {
+	Id        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Migrator applies migrations and records them in schema_migrations. A lock
+// document keeps concurrent replicas from running the same migration.
+type Migrator struct {
+	database   *mongo.Database
+	history    db.Collection
+	locks      db.Collection
+	migrations []Migration
+	owner      string
+	lockTTL    time.Duration
+	clock      clock.Clock
+}
+
+// New sorts migrations by version and panics on duplicate or non-positive
+// versions, which are programming errors.
+func New(database *mongo.Database, migrations []Migration, lockTTL time.Duration, clk clock.Clock) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Up == nil {
+			panic("migrate: invalid migration " + m.String())
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			panic("migrate: duplicate migration version " + strconv.Itoa(m.Version))
+		}
+	}
+	host, _ := os.Hostname()
+	if lockTTL <= 0 {
+		lockTTL = DefaultLockTTL
+	}
+	return &Migrator{
+		database:   database,
+		history:    database.Collection(historyCollection),
+		locks:      database.Collection(lockCollection),
+		migrations: sorted,
+		owner:      fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+		lockTTL:    lockTTL,
+		clock:      clock.OrSystem(clk),
+	}
+}
+
+// Status lists every known migration in version order.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]State, len(m.migrations))
+	for i, mig := range m.migrations {
+		states[i] = State{Migration: mig}
+		if r, ok := applied[mig.Version]; ok {
+			at := r.AppliedAt
+			states[i].AppliedAt = &at
+		}
+	}
+	return states, nil
+}
+
+// Pending returns the migrations Up would apply to reach target; a target
+// of 0 means the latest version.
+func (m *Migrator) Pending(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if target > 0 && mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Rollbacks returns the applied migrations Down would revert to get back to
+// target, newest first. A negative target means only the latest one.
+func (m *Migrator) Rollbacks(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok || mig.Version <= target {
+			continue
+		}
+		out = append(out, mig)
+		if target < 0 {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Up applies pending migrations up to target in order and returns the ones
+// it ran. It stops at the first failure; earlier migrations stay recorded.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func() error {
+		pending, err := m.Pending(ctx, target)
+		if err != nil {
+			return err
+		}
+		for _, mig := range pending {
+			if err := mig.Up(ctx, m.database); err != nil {
+				return fmt.Errorf("migration %s: %w", mig, err)
+			}
+			r := record{Version: mig.Version, Name: mig.Name, AppliedAt: m.clock.Now()}
+			if _, err := m.history.InsertOne(ctx, r); err != nil {
+				return fmt.Errorf("recording migration %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts applied migrations newer than target, newest first. A
+// negative target reverts only the latest one.
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func() error {
+		rollbacks, err := m.Rollbacks(ctx, target)
+		if err != nil {
+			return err
+		}
+		for _, mig := range rollbacks {
+			if mig.Down == nil {
+				return fmt.Errorf("migration %s cannot be reverted", mig)
+			}
+			if err := mig.Down(ctx, m.database); err != nil {
+				return fmt.Errorf("reverting migration %s: %w", mig, err)
+			}
+			if _, err := m.history.DeleteOne(ctx, bson.M{"_id": mig.Version}); err != nil {
+				return fmt.Errorf("recording revert of %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]record, error) {
+	cur, err := m.history.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// withLock runs fn while holding the migration lock. The lock is taken by
+// upserting a single document that only matches when it is free or
+// expired, so a second process gets a duplicate key error instead.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	now := m.clock.Now()
+	filter := bson.M{"_id": lockId, "$or": []bson.M{
+		{"expires_at": bson.M{"$lt": now}},
+		{"owner": m.owner},
+	}}
+	update := bson.M{"$set": bson.M{"owner": m.owner, "expires_at": now.Add(m.lockTTL)}}
+	_, err := m.locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		var held lock
+		if m.locks.FindOne(ctx, bson.M{"_id": lockId}).Decode(&held) == nil {
+			return fmt.Errorf("%w (held by %s until %s)", ErrLocked, held.Owner, held.ExpiresAt.Format(time.RFC3339))
+		}
+		return ErrLocked
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Release with a fresh context so a cancelled run still frees the lock.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m.locks.DeleteOne(ctx, bson.M{"_id": lockId, "owner": m.owner})
+	}()
+	return fn()
+}
@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// store is one in-memory database shared by the migrators of a test, the
+// way replicas share MongoDB.
+type store struct {
+	history, locks *db.MemoryCollection
+	clock          *clock.Manual
+}
+
+func newStore() *store {
+	return &store{db.NewMemoryCollection(), db.NewMemoryCollection(), clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))}
+}
+
+func (s *store) migrator(owner string, migrations ...Migration) *Migrator {
+	return &Migrator{history: s.history, locks: s.locks, migrations: migrations, owner: owner, lockTTL: time.Minute, clock: s.clock}
+}
+
+func step(version int, log *[]int) Migration {
+	return Migration{
+		Version: version,
+		Name:    "step",
+		Up: func(context.Context, *mongo.Database) error {
+			*log = append(*log, version)
+			return nil
+		},
+		Down: func(context.Context, *mongo.Database) error {
+			*log = append(*log, -version)
+			return nil
+		},
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	var log []int
+	m := newStore().migrator("a", step(1, &log), step(2, &log), step(3, &log))
+
+	if done, err := m.Up(ctx, 2); err != nil || len(done) != 2 {
+		t.Fatalf("Up(2) = %v, %v", done, err)
+	}
+	if done, err := m.Up(ctx, 0); err != nil || len(done) != 1 || done[0].Version != 3 {
+		t.Fatalf("Up(0) = %v, %v; want only 3", done, err)
+	}
+	states, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.AppliedAt == nil {
+			t.Errorf("%s is not recorded as applied", s)
+		}
+	}
+	if done, err := m.Down(ctx, -1); err != nil || len(done) != 1 || done[0].Version != 3 {
+		t.Fatalf("Down(-1) = %v, %v; want only 3", done, err)
+	}
+	if _, err := m.Down(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 2, 3, -3, -2, -1}
+	if len(log) != len(want) {
+		t.Fatalf("ran %v, want %v", log, want)
+	}
+	for i := range want {
+		if log[i] != want[i] {
+			t.Fatalf("ran %v, want %v", log, want)
+		}
+	}
+}
+
+func TestUpStopsAtTheFirstFailure(t *testing.T) {
+	ctx := context.Background()
+	var log []int
+	broken := Migration{Version: 2, Name: "broken", Up: func(context.Context, *mongo.Database) error {
+		return errors.New("boom")
+	}}
+	s := newStore()
+	if _, err := s.migrator("a", step(1, &log), broken, step(3, &log)).Up(ctx, 0); err == nil {
+		t.Fatal("Up succeeded past a failing migration")
+	}
+	pending, err := s.migrator("a", step(1, &log), broken, step(3, &log)).Pending(ctx, 0)
+	if err != nil || len(pending) != 2 || pending[0].Version != 2 {
+		t.Errorf("pending = %v, %v; want 2 and 3", pending, err)
+	}
+	// The failed run released the lock.
+	if n, _ := s.locks.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("%d lock(s) left behind", n)
+	}
+}
+
+func TestLockContention(t *testing.T) {
+	ctx := context.Background()
+	s := newStore()
+	var log []int
+	var inner error
+	holder := Migration{Version: 1, Name: "holder", Up: func(context.Context, *mongo.Database) error {
+		// Another replica starts while this one is migrating.
+		_, inner = s.migrator("b", step(2, &log)).Up(ctx, 0)
+		return nil
+	}}
+	if _, err := s.migrator("a", holder).Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(inner, ErrLocked) {
+		t.Fatalf("concurrent Up error = %v, want ErrLocked", inner)
+	}
+	if len(log) != 0 {
+		t.Errorf("the locked out replica ran %v", log)
+	}
+	// Once the first replica is done the lock is free again.
+	if done, err := s.migrator("b", step(2, &log)).Up(ctx, 0); err != nil || len(done) != 1 {
+		t.Errorf("Up after release = %v, %v", done, err)
+	}
+}
+
+func TestExpiredLocksAreTakenOver(t *testing.T) {
+	ctx := context.Background()
+	s := newStore()
+	if _, err := s.locks.InsertOne(ctx, lock{Id: lockId, Owner: "crashed", ExpiresAt: s.clock.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	var log []int
+	if _, err := s.migrator("a", step(1, &log)).Up(ctx, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Up under a live lock = %v, want ErrLocked", err)
+	}
+	s.clock.Advance(2 * time.Minute)
+	if done, err := s.migrator("a", step(1, &log)).Up(ctx, 0); err != nil || len(done) != 1 {
+		t.Errorf("Up after the lock expired = %v, %v", done, err)
+	}
+}
+
+func TestNewRejectsDuplicateVersions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("New accepted two migrations with the same version")
+		}
+	}()
+	var log []int
+	New(nil, []Migration{step(1, &log), step(1, &log)}, 0, nil)
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore forgets buckets that have refilled.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in process. Each replica counts on its own, so
+// use MongoStore when the API runs on several.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, p Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.expiresAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	b, ok := s.buckets[key]
+	if !ok || !now.Before(b.expiresAt) {
+		b.bucket = full(p, now)
+	}
+	next, res := take(b.bucket, p, now)
+	if res.Allowed {
+		s.buckets[key] = memoryBucket{bucket: next, expiresAt: now.Add(res.Reset)}
+	}
+	return res, nil
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// Limiter throttles requests per user when the request is authenticated
+// and per client IP otherwise.
+type Limiter struct {
+	store     Store
+	allowlist []*net.IPNet
+	exempt    func(c *gin.Context) bool
+	logger    *logger.Logger
+	clock     clock.Clock
+}
+
+// NewLimiter returns a Limiter over store. Requests from an address in
+// allowlist, a list of IPs and CIDR ranges, or for which exempt returns
+// true are never limited. exempt may be nil.
+func NewLimiter(store Store, allowlist []string, exempt func(c *gin.Context) bool, l *logger.Logger, clk clock.Clock) (*Limiter, error) {
+	nets, err := ParseAllowlist(allowlist)
+	if err != nil {
+		return nil, err
+	}
+	return &Limiter{store: store, allowlist: nets, exempt: exempt, logger: l, clock: clock.OrSystem(clk)}, nil
+}
+
+// ParseAllowlist parses IPs and CIDR ranges.
+func ParseAllowlist(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, raw := range entries {
+		entry := raw
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", raw)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (l *Limiter) allowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range l.allowlist {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit enforces p. Put it after Authentication so requests are counted
+// per user. If the store fails the request is let through.
+func (l *Limiter) Limit(p Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if l.allowed(ip) || (l.exempt != nil && l.exempt(c)) {
+			c.Next()
+			return
+		}
+		key := p.Name + ":ip:" + ip
+		if id := c.GetString("user_id"); id != "" {
+			key = p.Name + ":user:" + id
+		}
+		res, err := l.store.Take(c, key, p, l.clock.Now())
+		if err != nil {
+			l.logger.Error("rate limiter unavailable", "policy", p.Name, "error", err)
+			c.Next()
+			return
+		}
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, seconds(p.Period)))
+		c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+			c.Error(apperrors.TooManyRequests(apperrors.CodeRateLimited, "too many requests, try again later", nil))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// seconds rounds d up to whole seconds, as the headers expect.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
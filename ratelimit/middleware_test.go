@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"github.com/gin-gonic/gin"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, p Policy, now time.Time) (Result, error) {
+	return Result{}, errors.New("store down")
+}
+
+// newRouter limits GET /search to 2 requests a minute. The X-User header
+// stands in for authentication.
+func newRouter(t *testing.T, store Store, allowlist []string, exempt func(c *gin.Context) bool) (*gin.Engine, *clock.Manual) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	clk := clock.NewManual(start)
+	l, err := NewLimiter(store, allowlist, exempt, logger.NewLogger("error", io.Discard), clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(apperrors.ErrorHandler())
+	r.GET("/search", func(c *gin.Context) {
+		if u := c.GetHeader("X-User"); u != "" {
+			c.Set("user_id", u)
+		}
+	}, l.Limit(Policy{Name: "search", Limit: 2, Period: time.Minute}), func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, clk
+}
+
+func get(r http.Handler, ip, userId string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/search", nil)
+	req.RemoteAddr = net.JoinHostPort(ip, "1234")
+	if userId != "" {
+		req.Header.Set("X-User", userId)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLimit(t *testing.T) {
+	r, clk := newRouter(t, NewMemoryStore(), nil, nil)
+	w := get(r, "192.0.2.1", "")
+	want := map[string]string{"RateLimit-Policy": "2;w=60", "RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "30"}
+	for h, v := range want {
+		if got := w.Header().Get(h); got != v {
+			t.Errorf("%s = %q, want %q", h, got, v)
+		}
+	}
+	get(r, "192.0.2.1", "")
+	w = get(r, "192.0.2.1", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("third request: status %d, headers %v", w.Code, w.Header())
+	}
+
+	// Signed-in users get their own bucket, whatever their address.
+	if w := get(r, "192.0.2.1", "ada"); w.Code != http.StatusOK {
+		t.Errorf("user behind a limited IP: status %d", w.Code)
+	}
+	if w := get(r, "192.0.2.2", ""); w.Code != http.StatusOK {
+		t.Errorf("another IP: status %d", w.Code)
+	}
+
+	clk.Advance(30 * time.Second)
+	if w := get(r, "192.0.2.1", ""); w.Code != http.StatusOK {
+		t.Errorf("after Retry-After: status %d", w.Code)
+	}
+}
+
+func TestLimitExemptions(t *testing.T) {
+	r, _ := newRouter(t, NewMemoryStore(), []string{"10.0.0.0/8", "2001:db8::1"}, func(c *gin.Context) bool {
+		return c.GetString("user_id") == "admin"
+	})
+	for _, tc := range []struct{ ip, user string }{{"10.1.2.3", ""}, {"2001:db8::1", ""}, {"192.0.2.1", "admin"}} {
+		for i := 0; i < 5; i++ {
+			if w := get(r, tc.ip, tc.user); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+				t.Fatalf("%s %s: request %d status %d, headers %v", tc.ip, tc.user, i, w.Code, w.Header())
+			}
+		}
+	}
+	if _, err := NewLimiter(NewMemoryStore(), []string{"not-an-ip"}, nil, nil, nil); err == nil {
+		t.Error("bad allowlist entry accepted")
+	}
+}
+
+func TestLimitFailsOpen(t *testing.T) {
+	r, _ := newRouter(t, failingStore{}, nil, nil)
+	for i := 0; i < 5; i++ {
+		if w := get(r, "192.0.2.1", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+}
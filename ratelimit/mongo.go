@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxAttempts bounds the retries when other replicas update a bucket
+// between our read and write.
+const maxAttempts = 5
+
+var errContended = errors.New("rate limit bucket is contended")
+
+// MongoStore keeps buckets in a MongoDB collection so that every replica
+// shares them. Writes are conditional on a version number, so concurrent
+// requests never hand out the same token twice.
+type MongoStore struct {
+	collection db.Collection
+}
+
+type mongoBucket struct {
+	Key       string    `bson:"_id"`
+	Tokens    float64   `bson:"tokens"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	Version   int64     `bson:"version"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func NewMongoStore(collection db.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+// InitExpiryIndex lets MongoDB delete buckets once they have refilled.
+func InitExpiryIndex(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return errors.New("failed to create rate limit expiry index: " + err.Error())
+	}
+	return nil
+}
+
+func (s *MongoStore) Take(ctx context.Context, key string, p Policy, now time.Time) (Result, error) {
+	// MongoDB keeps milliseconds.
+	now = now.Truncate(time.Millisecond)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var stored mongoBucket
+		err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&stored)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return Result{}, err
+		}
+		b := bucket{Tokens: stored.Tokens, UpdatedAt: stored.UpdatedAt}
+		if err != nil || !now.Before(stored.ExpiresAt) {
+			b = full(p, now)
+		}
+		next, res := take(b, p, now)
+		if !res.Allowed {
+			return res, nil
+		}
+		expiresAt := now.Add(res.Reset)
+		if err == mongo.ErrNoDocuments {
+			_, err = s.collection.InsertOne(ctx, mongoBucket{Key: key, Tokens: next.Tokens, UpdatedAt: next.UpdatedAt, Version: 1, ExpiresAt: expiresAt})
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return res, err
+		}
+		update, err := s.collection.UpdateOne(ctx, bson.M{"_id": key, "version": stored.Version}, bson.M{
+			"$set": bson.M{"tokens": next.Tokens, "updated_at": next.UpdatedAt, "expires_at": expiresAt},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		if update.MatchedCount == 1 {
+			return res, nil
+		}
+	}
+	return Result{}, errContended
+}
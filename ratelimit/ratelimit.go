@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Policy allows Limit requests per Period for each client, refilled
+// continuously, so a client that has been idle can burst up to Limit.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Result is the state of a bucket after a request has been counted.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed; zero
+	// when Allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. Take refills the bucket at key for the time
+// elapsed since it was last used and takes one token from it if it can.
+type Store interface {
+	Take(ctx context.Context, key string, p Policy, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// full is the bucket of a client that has not been seen.
+func full(p Policy, now time.Time) bucket {
+	return bucket{Tokens: float64(p.Limit), UpdatedAt: now}
+}
+
+// take refills b up to now and takes a token from it. The returned bucket
+// only needs saving when the request is allowed.
+func take(b bucket, p Policy, now time.Time) (bucket, Result) {
+	limit := float64(p.Limit)
+	perNano := limit / float64(p.Period)
+	if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens = math.Min(limit, b.Tokens+float64(elapsed)*perNano)
+		b.UpdatedAt = now
+	}
+	res := Result{Limit: p.Limit}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - b.Tokens) / perNano))
+	}
+	res.Remaining = int(b.Tokens)
+	res.Reset = time.Duration(math.Ceil((limit - b.Tokens) / perNano))
+	return b, res
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/db"
+)
+
+var start = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTake(t *testing.T) {
+	p := Policy{Name: "search", Limit: 3, Period: 3 * time.Second}
+	b := full(p, start)
+	var res Result
+	for i := 0; i < 3; i++ {
+		if b, res = take(b, p, start); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: %+v", i, res)
+		}
+	}
+	if res.Reset != 3*time.Second {
+		t.Errorf("reset = %v, want the whole period to refill", res.Reset)
+	}
+	if _, res = take(b, p, start.Add(500*time.Millisecond)); res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("empty bucket: %+v, want a retry after 500ms", res)
+	}
+	// One token refills per second.
+	if b, res = take(b, p, start.Add(time.Second)); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("after a second: %+v", res)
+	}
+	// A long pause refills no more than Limit.
+	if _, res = take(b, p, start.Add(time.Hour)); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("after an hour: %+v, want a full bucket", res)
+	}
+}
+
+func TestStores(t *testing.T) {
+	p := Policy{Name: "like", Limit: 2, Period: time.Minute}
+	for name, s := range map[string]Store{"memory": NewMemoryStore(), "mongodb": NewMongoStore(db.NewMemoryCollection())} {
+		ctx := context.Background()
+		take := func(key string, now time.Time) Result {
+			t.Helper()
+			res, err := s.Take(ctx, key, p, now)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return res
+		}
+		for i := 0; i < 2; i++ {
+			if !take("a", start).Allowed {
+				t.Errorf("%s: request %d refused", name, i)
+			}
+		}
+		if res := take("a", start); res.Allowed || res.RetryAfter != 30*time.Second {
+			t.Errorf("%s: third request = %+v, want a retry after 30s", name, res)
+		}
+		if !take("b", start).Allowed {
+			t.Errorf("%s: keys share a bucket", name)
+		}
+		if res := take("a", start.Add(30*time.Second)); !res.Allowed || res.Remaining != 0 {
+			t.Errorf("%s: after 30s = %+v, want one token back", name, res)
+		}
+		if res := take("a", start.Add(time.Hour)); !res.Allowed || res.Remaining != 1 {
+			t.Errorf("%s: after the bucket expired = %+v, want it full", name, res)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/gin-gonic/gin"
+)
+
+type StaticController struct {
+	exporter StaticExporter
+	clock    clock.Clock
+}
+
+type StaticExporter interface {
+	Export(ctx context.Context, out Output, opts Options) (*Report, error)
+}
+
+func NewStaticController(exporter StaticExporter, clk clock.Clock) *StaticController {
+	return &StaticController{exporter, clock.OrSystem(clk)}
+}
+
+// Export downloads the whole site as a zip. ?per_page sets the number of
+// posts on listing pages.
+func (sc *StaticController) Export(c *gin.Context) {
+	var opts Options
+	if v := c.Query("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.Error(apperrors.BadRequest(apperrors.CodeValidation, "per_page must be a positive number", err))
+			return
+		}
+		opts.PerPage = n
+	}
+	now := sc.clock.Now().UTC()
+	var buf bytes.Buffer
+	out := NewZip(&buf, now)
+	report, err := sc.exporter.Export(c, out, opts)
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		c.Error(apperrors.Internal(err))
+		return
+	}
+	for _, w := range report.Warnings {
+		c.Writer.Header().Add("Warning", `199 bloggy "`+w+`"`)
+	}
+	name := "bloggy-static-" + now.Format("20060102-150405") + ".zip"
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
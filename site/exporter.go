@@ -0,0 +1,487 @@
+// Package site renders the blog as static HTML through a theme.
+package site
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/theme"
+	"github.com/ayo-ajayi/bloggy/user"
+)
+
+// DefaultPerPage is how many posts index and tag pages list when Options
+// does not say.
+const DefaultPerPage = 10
+
+// stateFile records what an export wrote so the next one can be incremental.
+const (
+	stateFile    = ".bloggy-static.json"
+	stateVersion = 1
+)
+
+var ErrNotIncremental = errors.New("incremental exports need a directory output")
+
+// PostSource lists every published post with its byline.
+type PostSource interface {
+	GetBlogPosts(ctx context.Context) ([]*blog.BlogPost, error)
+}
+
+type Exporter struct {
+	posts     PostSource
+	site      theme.Site
+	themeName string
+	themeDir  string
+	clock     clock.Clock
+}
+
+// NewExporter renders with the named theme, loaded from themeDir or the
+// built-in themes on every export so theme edits are picked up.
+func NewExporter(posts PostSource, s theme.Site, themeName, themeDir string, clk clock.Clock) *Exporter {
+	return &Exporter{posts: posts, site: s, themeName: themeName, themeDir: themeDir, clock: clock.OrSystem(clk)}
+}
+
+type Options struct {
+	// PerPage is the number of posts on each listing page.
+	PerPage int
+	// Incremental re-renders only posts whose UpdatedAt changed since the
+	// last export to the same directory. Listings, feeds and the sitemap
+	// are always rendered. Changes to a post's author profile alone are
+	// not noticed.
+	Incremental bool
+}
+
+type Report struct {
+	Incremental    bool     `json:"incremental"`
+	PostsRendered  int      `json:"posts_rendered"`
+	PostsUnchanged int      `json:"posts_unchanged"`
+	PostsRemoved   int      `json:"posts_removed"`
+	Pages          int      `json:"listing_pages"`
+	FilesWritten   int      `json:"files_written"`
+	FilesRemoved   int      `json:"files_removed"`
+	Warnings       []string `json:"warnings,omitempty"`
+}
+
+func (r *Report) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+type state struct {
+	Version     int       `json:"version"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Key changes with the theme and anything else every page depends on.
+	Key   string               `json:"key"`
+	Posts map[string]time.Time `json:"posts"`
+	Files []string             `json:"files"`
+}
+
+// export holds the state of one Export call.
+type export struct {
+	*Exporter
+	out     Output
+	theme   *theme.Theme
+	perPage int
+	report  *Report
+	prev    *state
+	next    *state
+	written map[string]bool
+}
+
+// Export renders every post, paginated index, tag and author pages, the
+// theme's assets and, when the site has a base URL, RSS and Atom feeds and
+// a sitemap into out. It does not close out. Directory outputs also get
+// pages left over from the previous export removed, such as those of
+// deleted posts.
+func (e *Exporter) Export(ctx context.Context, out Output, opts Options) (*Report, error) {
+	th, err := theme.Load(e.themeName, e.themeDir)
+	if err != nil {
+		return nil, err
+	}
+	x := &export{
+		Exporter: e,
+		out:      out,
+		theme:    th,
+		perPage:  opts.PerPage,
+		report:   &Report{},
+		written:  map[string]bool{},
+	}
+	if x.perPage <= 0 {
+		x.perPage = DefaultPerPage
+	}
+	key, err := x.key()
+	if err != nil {
+		return nil, err
+	}
+	x.next = &state{Version: stateVersion, GeneratedAt: e.clock.Now().UTC(), Key: key, Posts: map[string]time.Time{}}
+
+	prior, keeps := out.(rereadable)
+	if opts.Incremental && !keeps {
+		return nil, ErrNotIncremental
+	}
+	if keeps {
+		x.prev = x.readState(prior)
+	}
+	switch {
+	case !opts.Incremental:
+	case x.prev == nil:
+		x.report.warn("no previous export found, rendering every post")
+	case x.prev.Key != key:
+		x.report.warn("theme or site settings changed, rendering every post")
+	default:
+		x.report.Incremental = true
+	}
+
+	posts, err := e.posts.GetBlogPosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	views := Views(posts)
+	tags := CollectTags(views)
+
+	for _, p := range views {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := x.post(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := x.listing("", views, theme.Page{Kind: "index", Description: x.site.Description, Tags: tags}); err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		if err := x.listing(t.Path, Tagged(views, t.Slug), TagPage(x.site, t)); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range authors(views) {
+		if err := x.listing(a.path, a.posts, AuthorPage(a.byline)); err != nil {
+			return nil, err
+		}
+	}
+	if err := x.assets(); err != nil {
+		return nil, err
+	}
+	if err := x.feeds(views); err != nil {
+		return nil, err
+	}
+	if keeps {
+		if err := x.prune(prior); err != nil {
+			return nil, err
+		}
+		if err := x.writeState(); err != nil {
+			return nil, err
+		}
+	}
+	return x.report, nil
+}
+
+// key fingerprints the theme and settings that every page depends on.
+func (x *export) key() (string, error) {
+	fp, err := x.theme.Fingerprint()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s\n%d\n", fp, x.theme.Name, x.site.Title, x.site.Description, x.site.BaseURL, x.perPage)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (x *export) readState(prior rereadable) *state {
+	data, err := prior.ReadFile(stateFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) && !os.IsNotExist(err) {
+			x.report.warn("could not read %s: %v", stateFile, err)
+		}
+		return nil
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil || s.Version != stateVersion {
+		x.report.warn("ignoring unreadable %s", stateFile)
+		return nil
+	}
+	return &s
+}
+
+func (x *export) writeState() error {
+	for name := range x.written {
+		x.next.Files = append(x.next.Files, name)
+	}
+	sort.Strings(x.next.Files)
+	data, err := json.MarshalIndent(x.next, "", "  ")
+	if err != nil {
+		return err
+	}
+	return x.out.WriteFile(stateFile, data)
+}
+
+// prune removes files the previous export wrote that this one did not.
+func (x *export) prune(prior rereadable) error {
+	if x.prev == nil {
+		return nil
+	}
+	for slug := range x.prev.Posts {
+		if _, ok := x.next.Posts[slug]; !ok {
+			x.report.PostsRemoved++
+		}
+	}
+	for _, name := range x.prev.Files {
+		if x.written[name] || name == stateFile || strings.Contains(name, "..") {
+			continue
+		}
+		if err := prior.Remove(name); err != nil {
+			return err
+		}
+		x.report.FilesRemoved++
+	}
+	return nil
+}
+
+func (x *export) write(name string, data []byte) error {
+	if err := x.out.WriteFile(name, data); err != nil {
+		return err
+	}
+	x.written[name] = true
+	x.report.FilesWritten++
+	return nil
+}
+
+// keep marks a file from the previous export as still current.
+func (x *export) keep(name string) {
+	x.written[name] = true
+}
+
+func (x *export) render(page string, data *theme.Page) error {
+	data.Links = theme.Links{Root: relativeRoot(data.Path), IndexFile: true}
+	data.Site = x.site
+	var buf bytes.Buffer
+	if err := x.theme.Render(&buf, page, data); err != nil {
+		return fmt.Errorf("rendering /%s: %w", data.Path, err)
+	}
+	return x.write(data.Path+"index.html", buf.Bytes())
+}
+
+func (x *export) post(p *theme.Post) error {
+	x.next.Posts[p.Slug] = p.UpdatedAt
+	if x.report.Incremental {
+		if last, ok := x.prev.Posts[p.Slug]; ok && last.Equal(p.UpdatedAt) {
+			x.keep(p.Path + "index.html")
+			x.report.PostsUnchanged++
+			return nil
+		}
+	}
+	x.report.PostsRendered++
+	return x.render("post", &theme.Page{
+		Kind:        "post",
+		Title:       p.Title,
+		Description: p.Description,
+		Path:        p.Path,
+		Post:        p,
+		Image:       p.CoverImage,
+	})
+}
+
+// listing renders posts over as many pages as needed: base itself, then
+// base + "page/2/" and so on. Every page starts as a copy of proto.
+func (x *export) listing(base string, posts []*theme.Post, proto theme.Page) error {
+	for n := 1; ; n++ {
+		page, ok := Paginate(base, posts, x.perPage, n, proto)
+		if !ok {
+			return nil
+		}
+		if err := x.render(page.Kind, page); err != nil {
+			return err
+		}
+		x.report.Pages++
+	}
+}
+
+func (x *export) assets() error {
+	names, err := x.theme.AssetNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(x.theme.Assets(), name)
+		if err != nil {
+			return err
+		}
+		if err := x.write("assets/"+name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *export) feeds(posts []*theme.Post) error {
+	if x.site.BaseURL == "" {
+		x.report.warn("site base URL is not set, skipping feeds and sitemap")
+		return nil
+	}
+	rss, err := RSS(x.site, posts)
+	if err != nil {
+		return err
+	}
+	if err := x.write("feed.xml", rss); err != nil {
+		return err
+	}
+	atom, err := Atom(x.site, posts)
+	if err != nil {
+		return err
+	}
+	if err := x.write("atom.xml", atom); err != nil {
+		return err
+	}
+
+	sm, err := Sitemap(x.site, posts)
+	if err != nil {
+		return err
+	}
+	return x.write("sitemap.xml", sm)
+}
+
+// Views maps posts to their theme views, newest first.
+func Views(posts []*blog.BlogPost) []*theme.Post {
+	posts = append([]*blog.BlogPost(nil), posts...)
+	sort.SliceStable(posts, func(i, j int) bool {
+		if !posts[i].CreatedAt.Equal(posts[j].CreatedAt) {
+			return posts[i].CreatedAt.After(posts[j].CreatedAt)
+		}
+		return posts[i].Slug < posts[j].Slug
+	})
+	views := make([]*theme.Post, 0, len(posts))
+	for _, p := range posts {
+		views = append(views, theme.NewPost(p))
+	}
+	return views
+}
+
+// Paginate returns page n of a listing of posts at base: a copy of proto
+// with Path, Posts and Pagination set and the title numbered. The first
+// page always exists, even when empty; ok is false for pages past the last.
+func Paginate(base string, posts []*theme.Post, perPage, n int, proto theme.Page) (*theme.Page, bool) {
+	pages := (len(posts) + perPage - 1) / perPage
+	if pages == 0 {
+		pages = 1
+	}
+	if n < 1 || n > pages {
+		return nil, false
+	}
+	end := n * perPage
+	if end > len(posts) {
+		end = len(posts)
+	}
+	page := &proto
+	page.Path = PagePath(base, n)
+	page.Posts = posts[(n-1)*perPage : end]
+	page.Pagination = &theme.Pagination{Page: n, Pages: pages}
+	if n > 1 {
+		page.Title = strings.TrimSpace(page.Title + " · Page " + strconv.Itoa(n))
+		page.Pagination.PrevPath = PagePath(base, n-1)
+	}
+	if n < pages {
+		page.Pagination.NextPath = PagePath(base, n+1)
+	}
+	return page, true
+}
+
+// TagPage is the prototype of a tag's listing pages.
+func TagPage(s theme.Site, t *theme.Tag) theme.Page {
+	return theme.Page{Kind: "tag", Title: "Posts tagged “" + t.Name + "”", Description: s.Description, Tag: t}
+}
+
+// AuthorPage is the prototype of an author's listing pages.
+func AuthorPage(a *user.Byline) theme.Page {
+	return theme.Page{Kind: "author", Title: a.Name, Description: a.Bio, Author: a, Image: a.Avatar}
+}
+
+// PagePath is the path of page n of a listing at base.
+func PagePath(base string, n int) string {
+	if n <= 1 {
+		return base
+	}
+	return base + "page/" + strconv.Itoa(n) + "/"
+}
+
+// relativeRoot leads from the directory at p back to the site root, so the
+// export works from any location, including straight from disk.
+func relativeRoot(p string) string {
+	return strings.Repeat("../", strings.Count(p, "/"))
+}
+
+// CollectTags counts the posts under each tag, merging names that share a
+// slug, and sorts them by name.
+func CollectTags(posts []*theme.Post) []*theme.Tag {
+	bySlug := map[string]*theme.Tag{}
+	var tags []*theme.Tag
+	for _, p := range posts {
+		seen := map[string]bool{}
+		for _, t := range p.Tags {
+			if t.Slug == "" || seen[t.Slug] {
+				continue
+			}
+			seen[t.Slug] = true
+			tag, ok := bySlug[t.Slug]
+			if !ok {
+				tag = &theme.Tag{Name: t.Name, Slug: t.Slug, Path: t.Path}
+				bySlug[t.Slug] = tag
+				tags = append(tags, tag)
+			}
+			tag.Count++
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name) })
+	return tags
+}
+
+// Tagged filters posts down to those with the tag.
+func Tagged(posts []*theme.Post, tagSlug string) []*theme.Post {
+	var out []*theme.Post
+	for _, p := range posts {
+		for _, t := range p.Tags {
+			if t.Slug == tagSlug {
+				out = append(out, p)
+				break
+			}
+		}
+	}
+	return out
+}
+
+type authorPosts struct {
+	byline *user.Byline
+	path   string
+	posts  []*theme.Post
+}
+
+// authors groups posts by author, skipping authors without a public
+// profile.
+func authors(posts []*theme.Post) []*authorPosts {
+	bySlug := map[string]*authorPosts{}
+	var out []*authorPosts
+	for _, p := range posts {
+		if p.AuthorPath == "" {
+			continue
+		}
+		a, ok := bySlug[p.Author.Slug]
+		if !ok {
+			a = &authorPosts{byline: p.Author, path: p.AuthorPath}
+			bySlug[p.Author.Slug] = a
+			out = append(out, a)
+		}
+		a.posts = append(a.posts, p)
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+package site
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/theme"
+)
+
+type postList []*blog.BlogPost
+
+func (p *postList) GetBlogPosts(ctx context.Context) ([]*blog.BlogPost, error) {
+	return append([]*blog.BlogPost(nil), *p...), nil
+}
+
+func newPost(n int, tags ...string) *blog.BlogPost {
+	at := time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC)
+	return &blog.BlogPost{
+		Title: "Post " + string(rune('A'+n-1)), Slug: "post-" + string(rune('a'+n-1)),
+		Content: "Body " + string(rune('A'+n-1)), Tags: tags, CreatedAt: at, UpdatedAt: at,
+	}
+}
+
+func newExporter(posts *postList, baseURL string) *Exporter {
+	s := theme.Site{Title: "Bloggy", Description: "Notes", BaseURL: baseURL}
+	return NewExporter(posts, s, theme.DefaultName, "", clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+}
+
+func read(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExportPagesTagsAndFeeds(t *testing.T) {
+	posts := &postList{newPost(1, "Go"), newPost(2, "Go", "Web"), newPost(3)}
+	root := t.TempDir()
+	out, err := NewDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := newExporter(posts, "https://blog.example.com").Export(context.Background(), out, Options{PerPage: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PostsRendered != 3 || report.Pages != 4 || len(report.Warnings) != 0 {
+		t.Errorf("report = %+v, want 3 posts and index, index page 2, two tag pages", report)
+	}
+	for _, name := range []string{
+		"posts/post-a/index.html", "posts/post-b/index.html", "posts/post-c/index.html",
+		"page/2/index.html", "tags/go/index.html", "tags/web/index.html",
+		"feed.xml", "atom.xml", "sitemap.xml", stateFile,
+	} {
+		read(t, root, name)
+	}
+	index := read(t, root, "index.html")
+	if !strings.Contains(index, "Post C") || !strings.Contains(index, "Post B") || strings.Contains(index, "Post A") {
+		t.Error("the first index page should list the two newest posts")
+	}
+	if !strings.Contains(index, "page/2/index.html") {
+		t.Error("the index does not link to its second page")
+	}
+	if post := read(t, root, "posts/post-a/index.html"); !strings.Contains(post, "../../") {
+		t.Error("post pages should link relative to the site root")
+	}
+	if sm := read(t, root, "sitemap.xml"); !strings.Contains(sm, "https://blog.example.com/posts/post-b/") {
+		t.Errorf("sitemap = %s", sm)
+	}
+}
+
+func TestExportWithoutBaseURLSkipsFeeds(t *testing.T) {
+	root := t.TempDir()
+	out, _ := NewDir(root)
+	report, err := newExporter(&postList{newPost(1)}, "").Export(context.Background(), out, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Warnings) != 1 {
+		t.Errorf("warnings = %v, want the missing base URL", report.Warnings)
+	}
+	if _, err := os.Stat(filepath.Join(root, "feed.xml")); !os.IsNotExist(err) {
+		t.Errorf("feed written without a base URL: %v", err)
+	}
+}
+
+func TestIncrementalExport(t *testing.T) {
+	ctx := context.Background()
+	posts := &postList{newPost(1, "Go"), newPost(2, "Web")}
+	root := t.TempDir()
+	out, _ := NewDir(root)
+	e := newExporter(posts, "https://blog.example.com")
+
+	report, err := e.Export(ctx, out, Options{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Incremental || report.PostsRendered != 2 || len(report.Warnings) != 1 {
+		t.Errorf("first report = %+v, want a full render with a warning", report)
+	}
+
+	(*posts)[0].UpdatedAt = (*posts)[0].UpdatedAt.Add(time.Hour)
+	*posts = (*posts)[:1]
+	report, err = e.Export(ctx, out, Options{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Incremental || report.PostsRendered != 1 || report.PostsUnchanged != 0 {
+		t.Errorf("second report = %+v, want the edited post re-rendered", report)
+	}
+	for _, gone := range []string{"posts/post-b", "tags/web"} {
+		if _, err := os.Stat(filepath.Join(root, gone)); !os.IsNotExist(err) {
+			t.Errorf("%s was not pruned: %v", gone, err)
+		}
+	}
+
+	report, err = e.Export(ctx, out, Options{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PostsRendered != 0 || report.PostsUnchanged != 1 {
+		t.Errorf("third report = %+v, want nothing re-rendered", report)
+	}
+	read(t, root, "posts/post-a/index.html")
+}
+
+func TestIncrementalNeedsADirectory(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := newExporter(&postList{}, "").Export(context.Background(), NewZip(&buf, time.Time{}), Options{Incremental: true})
+	if err != ErrNotIncremental {
+		t.Errorf("err = %v, want ErrNotIncremental", err)
+	}
+}
+
+func TestZipExport(t *testing.T) {
+	var buf bytes.Buffer
+	out := NewZip(&buf, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if _, err := newExporter(&postList{newPost(1)}, "").Export(context.Background(), out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["index.html"] || !names["posts/post-a/index.html"] || names[stateFile] {
+		t.Errorf("zip entries = %v", names)
+	}
+}
+
+func TestDirRefusesEscapingPaths(t *testing.T) {
+	root := t.TempDir()
+	out, _ := NewDir(filepath.Join(root, "site"))
+	for _, name := range []string{"../evil.html", "/etc/evil", "posts/../../evil"} {
+		if err := out.WriteFile(name, []byte("x")); err == nil {
+			t.Errorf("WriteFile(%q) escaped the output directory", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil.html")); !os.IsNotExist(err) {
+		t.Error("a file was written outside the output directory")
+	}
+}
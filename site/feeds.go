@@ -0,0 +1,208 @@
+package site
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/theme"
+)
+
+// FeedSize is how many of the latest posts the feeds list.
+const FeedSize = 20
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     *atomAuthor    `xml:"author,omitempty"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    atomText       `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// RSS renders posts, newest first, as an RSS 2.0 feed. It needs
+// s.BaseURL.
+func RSS(s theme.Site, posts []*theme.Post) ([]byte, error) {
+	posts = latest(posts)
+	ch := rssChannel{
+		Title:       s.Title,
+		Link:        s.Absolute(""),
+		Description: s.Description,
+		Self:        atomLink{Href: s.Absolute("feed.xml"), Rel: "self", Type: "application/rss+xml"},
+	}
+	if len(posts) > 0 {
+		ch.LastBuildDate = lastUpdate(posts).Format(time.RFC1123Z)
+	}
+	for _, p := range posts {
+		item := rssItem{
+			Title:       p.Title,
+			Link:        s.Absolute(p.Path),
+			GUID:        s.Absolute(p.Path),
+			PubDate:     p.CreatedAt.Format(time.RFC1123Z),
+			Description: string(p.Content),
+		}
+		for _, t := range p.Tags {
+			item.Categories = append(item.Categories, t.Name)
+		}
+		ch.Items = append(ch.Items, item)
+	}
+	return marshalXML(rss{Version: "2.0", Atom: "http://www.w3.org/2005/Atom", Channel: ch})
+}
+
+// Atom renders posts, newest first, as an Atom feed. It needs s.BaseURL.
+func Atom(s theme.Site, posts []*theme.Post) ([]byte, error) {
+	posts = latest(posts)
+	feed := atomFeed{
+		ID:    s.Absolute(""),
+		Title: s.Title,
+		Links: []atomLink{
+			{Href: s.Absolute("atom.xml"), Rel: "self", Type: "application/atom+xml"},
+			{Href: s.Absolute(""), Rel: "alternate", Type: "text/html"},
+		},
+		Author:  atomAuthor{Name: s.Title},
+		Updated: atomTime(lastUpdate(posts)),
+	}
+	for _, p := range posts {
+		entry := atomEntry{
+			ID:        s.Absolute(p.Path),
+			Title:     p.Title,
+			Link:      atomLink{Href: s.Absolute(p.Path), Rel: "alternate", Type: "text/html"},
+			Published: atomTime(p.CreatedAt),
+			Updated:   atomTime(p.UpdatedAt),
+			Content:   atomText{Type: "html", Body: string(p.Content)},
+		}
+		if p.Author != nil {
+			entry.Author = &atomAuthor{Name: p.Author.Name}
+		}
+		if p.Description != "" {
+			entry.Summary = &atomText{Body: p.Description}
+		}
+		for _, t := range p.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t.Name})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalXML(feed)
+}
+
+// SitemapURL is one page listed in a sitemap.
+type SitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+// Sitemap lists the home page, posts, tag and author pages and, when the
+// site is served dynamically, the about page. It needs s.BaseURL.
+func Sitemap(s theme.Site, posts []*theme.Post) ([]byte, error) {
+	sm := sitemap{URLs: []SitemapURL{{Loc: s.Absolute(""), LastMod: sitemapDate(lastUpdate(posts))}}}
+	if s.Dynamic {
+		sm.URLs = append(sm.URLs, SitemapURL{Loc: s.Absolute("about/")})
+	}
+	for _, p := range posts {
+		sm.URLs = append(sm.URLs, SitemapURL{Loc: s.Absolute(p.Path), LastMod: sitemapDate(p.UpdatedAt)})
+	}
+	for _, t := range CollectTags(posts) {
+		sm.URLs = append(sm.URLs, SitemapURL{Loc: s.Absolute(t.Path)})
+	}
+	for _, a := range authors(posts) {
+		sm.URLs = append(sm.URLs, SitemapURL{Loc: s.Absolute(a.path)})
+	}
+	return marshalXML(sm)
+}
+
+func sitemapDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
+
+func latest(posts []*theme.Post) []*theme.Post {
+	if len(posts) > FeedSize {
+		return posts[:FeedSize]
+	}
+	return posts
+}
+
+func lastUpdate(posts []*theme.Post) time.Time {
+	var t time.Time
+	for _, p := range posts {
+		if p.UpdatedAt.After(t) {
+			t = p.UpdatedAt
+		}
+	}
+	return t
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func marshalXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
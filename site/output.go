@@ -0,0 +1,114 @@
+package site
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Output receives the files of an export. Names use forward slashes and are
+// relative to the site root.
+type Output interface {
+	WriteFile(name string, data []byte) error
+	Close() error
+}
+
+// rereadable is implemented by outputs that keep the files of earlier
+// exports, which incremental exports and pruning need.
+type rereadable interface {
+	ReadFile(name string) ([]byte, error)
+	Remove(name string) error
+}
+
+// Dir writes an export into a directory, replacing files in place.
+type Dir struct {
+	root string
+}
+
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{root}, nil
+}
+
+// path resolves name under the root, refusing names that would escape it.
+func (d *Dir) path(name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("export path %q is outside the output directory", name)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+func (d *Dir) WriteFile(name string, data []byte) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// Write then rename so a site being served never shows half a page.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (d *Dir) ReadFile(name string) ([]byte, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// Remove deletes a file and any directories it leaves empty.
+func (d *Dir) Remove(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if p, err := d.path(dir); err != nil || os.Remove(p) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (d *Dir) Close() error {
+	return nil
+}
+
+// Zip writes an export as a zip archive.
+type Zip struct {
+	w        *zip.Writer
+	modified time.Time
+}
+
+// NewZip writes to w; every entry gets the modified time.
+func NewZip(w io.Writer, modified time.Time) *Zip {
+	return &Zip{w: zip.NewWriter(w), modified: modified}
+}
+
+func (z *Zip) WriteFile(name string, data []byte) error {
+	f, err := z.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: z.modified})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (z *Zip) Close() error {
+	return z.w.Close()
+}
@@ -0,0 +1,133 @@
+package theme
+
+import (
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags are the elements kept in HTML post content, with the
+// attributes each may carry besides the global ones.
+var allowedTags = map[string][]string{
+	"a": {"href"}, "abbr": nil, "b": nil, "blockquote": {"cite"}, "br": nil,
+	"caption": nil, "cite": nil, "code": nil, "dd": nil, "del": nil, "div": nil,
+	"dl": nil, "dt": nil, "em": nil, "figcaption": nil, "figure": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil, "hr": nil,
+	"i": nil, "img": {"src", "alt", "width", "height"}, "ins": nil, "kbd": nil,
+	"li": nil, "mark": nil, "ol": {"start"}, "p": nil, "pre": nil, "q": {"cite"},
+	"s": nil, "small": nil, "span": nil, "strong": nil, "sub": nil, "sup": nil,
+	"table": nil, "tbody": nil, "td": {"colspan", "rowspan"}, "tfoot": nil,
+	"th": {"colspan", "rowspan", "scope"}, "thead": nil, "tr": nil, "u": nil,
+	"ul": nil,
+}
+
+var globalAttrs = []string{"class", "title"}
+
+// droppedTags are removed together with everything inside them; other
+// unknown elements are removed but keep their text.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "svg": true, "math": true,
+	"textarea": true, "select": true, "title": true,
+}
+
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+// Sanitize keeps only allowlisted elements, attributes and URL schemes of
+// s, and closes any element s leaves open.
+func Sanitize(s string) string {
+	var b strings.Builder
+	var open []string
+	skip := 0
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return ""
+			}
+			break
+		}
+		tok := z.Token()
+		if droppedTags[tok.Data] && tt != html.TextToken {
+			switch tt {
+			case html.StartTagToken:
+				skip++
+			case html.EndTagToken:
+				if skip > 0 {
+					skip--
+				}
+			}
+			continue
+		}
+		if skip > 0 {
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(tok.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs, ok := allowedTags[tok.Data]
+			if !ok {
+				continue
+			}
+			b.WriteString("<" + tok.Data)
+			for _, a := range tok.Attr {
+				if a.Namespace != "" || !(slices.Contains(attrs, a.Key) || slices.Contains(globalAttrs, a.Key)) {
+					continue
+				}
+				if urlAttrs[a.Key] && !safeURL(a.Val, a.Key == "href") {
+					continue
+				}
+				b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			if tok.Data == "a" {
+				b.WriteString(` rel="nofollow noopener"`)
+			}
+			b.WriteString(">")
+			if !voidTags[tok.Data] {
+				if tt == html.SelfClosingTagToken {
+					b.WriteString("</" + tok.Data + ">")
+				} else {
+					open = append(open, tok.Data)
+				}
+			}
+		case html.EndTagToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// safeURL accepts relative URLs and http and https ones, plus mailto for
+// links.
+func safeURL(raw string, link bool) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https":
+		return true
+	case "mailto":
+		return link
+	}
+	return false
+}
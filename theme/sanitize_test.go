@@ -0,0 +1,51 @@
+package theme
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{`<p onclick="x()" class="lead" style="color:red">Hi</p>`, `<p class="lead">Hi</p>`},
+		{`<a href="https://example.com" target="_blank">x</a>`, `<a href="https://example.com" rel="nofollow noopener">x</a>`},
+		{`<a href="javascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{`<a href=" JaVaScRiPt:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{`<a href="mailto:ada@example.com">x</a>`, `<a href="mailto:ada@example.com" rel="nofollow noopener">x</a>`},
+		{`<img src="mailto:ada@example.com">`, `<img>`},
+		{`<img src="data:image/svg+xml;base64,AAAA" alt="x">`, `<img alt="x">`},
+		{`<img src="/cat.png" onerror="x()">`, `<img src="/cat.png">`},
+		{`<p>a<script>alert(1)</script>b</p>`, `<p>ab</p>`},
+		// Script content is raw text, so the first end tag closes it as in
+		// a browser and the rest is escaped text.
+		{`<div><script><script>alert(1)</script>x<b>y</b></script>after</div>`, `<div>x<b>y</b>after</div>`},
+		{`<div><script>"</script><img src=x onerror=alert(1)>"</script></div>`, `<div><img src="x">&#34;</div>`},
+		{`<svg><g><script>alert(1)</script></g><text>hidden</text></svg>ok`, `ok`},
+		{`<svg><svg onload="x()"></svg>inner</svg>outer`, `outer`},
+		{`<center>kept text</center>`, `kept text`},
+		{`<p><em>unclosed`, `<p><em>unclosed</em></p>`},
+		{`<ul><li>one</ul>`, `<ul><li>one</li></ul>`},
+		{`</p>stray`, `stray`},
+		{`<span title="&quot;><script>">x</span>`, `<span title="&#34;&gt;&lt;script&gt;">x</span>`},
+		{`1 < 2 & 3`, `1 &lt; 2 &amp; 3`},
+	} {
+		if got := Sanitize(tc.in); got != tc.want {
+			t.Errorf("Sanitize(%q)\n got %q\nwant %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestContent(t *testing.T) {
+	if got := string(Content("<p>Hi</p><script>x</script>")); got != "<p>Hi</p>" {
+		t.Errorf("HTML content = %q, want it sanitised", got)
+	}
+	got := string(Content("First <b>line</b>\nsecond\n\n\nNext"))
+	want := "<p>First &lt;b&gt;line&lt;/b&gt;<br>\nsecond</p>\n<p>Next</p>\n"
+	if got != want {
+		t.Errorf("text content = %q, want %q", got, want)
+	}
+	if strings.Contains(string(Content("<img src=x onerror=alert(1)>")), "onerror") {
+		t.Error("event handler kept")
+	}
+}
@@ -0,0 +1,436 @@
+package theme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gosimple/slug"
+)
+
+//go:embed themes
+var builtin embed.FS
+
+// DefaultName is the built-in theme every other theme falls back to.
+const DefaultName = "default"
+
+// Pages are the templates a theme renders. Each is parsed together with
+// layout.html and partials.html.
+var Pages = []string{"index", "post", "tag", "author", "search", "about", "error"}
+
+// Theme is a parsed set of page templates plus static assets.
+type Theme struct {
+	Name      string
+	files     fs.FS
+	templates map[string]*template.Template
+}
+
+// Load parses the named theme. Built-in themes are embedded; when dir is set
+// and contains a directory with the theme's name, files there override the
+// built-in ones, so a theme on disk only needs the templates it changes.
+// Anything missing falls back to the default theme.
+func Load(name, dir string) (*Theme, error) {
+	if name == "" {
+		name = DefaultName
+	}
+	layers := []fs.FS{}
+	if dir != "" {
+		if info, err := os.Stat(path.Join(dir, name)); err == nil && info.IsDir() {
+			layers = append(layers, os.DirFS(path.Join(dir, name)))
+		}
+	}
+	if sub, err := fs.Sub(builtin, "themes/"+name); err == nil && exists(sub, "layout.html") {
+		layers = append(layers, sub)
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("theme %q not found", name)
+	}
+	if name != DefaultName {
+		def, _ := fs.Sub(builtin, "themes/"+DefaultName)
+		layers = append(layers, def)
+	}
+	t := &Theme{Name: name, files: overlay(layers), templates: map[string]*template.Template{}}
+	for _, page := range Pages {
+		tmpl, err := template.New(page).Funcs(funcs).ParseFS(t.files, "layout.html", "partials.html", page+".html")
+		if err != nil {
+			return nil, fmt.Errorf("theme %q: %w", name, err)
+		}
+		t.templates[page] = tmpl
+	}
+	return t, nil
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+// overlay is an fs.FS that serves each file from the first layer that has it.
+type overlay []fs.FS
+
+func (o overlay) Open(name string) (fs.File, error) {
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Render executes page with data.
+func (t *Theme) Render(w io.Writer, page string, data *Page) error {
+	tmpl, ok := t.templates[page]
+	if !ok {
+		return fmt.Errorf("theme %q has no %s page", t.Name, page)
+	}
+	// Render to a buffer so a template error does not leave half a page.
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Assets returns the theme's assets directory, merged across layers.
+func (t *Theme) Assets() fs.FS {
+	sub, _ := fs.Sub(t.files, "assets")
+	return sub
+}
+
+// AssetNames lists the asset files from every layer of the theme.
+func (t *Theme) AssetNames() ([]string, error) {
+	seen := map[string]bool{}
+	var names []string
+	for _, layer := range t.files.(overlay) {
+		err := fs.WalkDir(layer, "assets", func(name string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel := strings.TrimPrefix(name, "assets/")
+			if !seen[rel] {
+				seen[rel] = true
+				names = append(names, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// Fingerprint hashes every file the theme is built from, so callers can
+// tell when output rendered with it is stale.
+func (t *Theme) Fingerprint() (string, error) {
+	h := sha256.New()
+	for _, layer := range t.files.(overlay) {
+		err := fs.WalkDir(layer, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := fs.ReadFile(layer, name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s %d\n", name, len(data))
+			h.Write(data)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Site is the blog-wide information every page gets.
+type Site struct {
+	Title       string
+	Description string
+	BaseURL     string
+	// Dynamic is set when bloggy serves the pages itself, so search and
+	// the about page are available.
+	Dynamic bool
+}
+
+// Links builds URLs relative to the site root. Root is "/" when serving and
+// a relative prefix such as "../../" in static exports, where IndexFile adds
+// index.html to directory links so the pages also work from disk.
+type Links struct {
+	Root      string
+	IndexFile bool
+}
+
+// Link returns the URL of p, a path relative to the site root such as
+// "posts/hello/".
+func (l Links) Link(p string) string {
+	if l.IndexFile && (p == "" || strings.HasSuffix(p, "/")) {
+		p += "index.html"
+	}
+	return l.Root + p
+}
+
+// Absolute returns the URL of p under the site's base URL, or "" when the
+// base URL is not configured.
+func (s Site) Absolute(p string) string {
+	if s.BaseURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + p
+}
+
+// Page is the data every template receives.
+type Page struct {
+	Links
+	Site        Site
+	Kind        string
+	Title       string
+	Description string
+	// Path is the page's location relative to the site root.
+	Path       string
+	Posts      []*Post
+	Post       *Post
+	Tag        *Tag
+	Tags       []*Tag
+	Author     *user.Byline
+	About      *About
+	Query      string
+	Pagination *Pagination
+	// Image is the absolute URL of the picture shared with the page.
+	Image string
+	// Robots, when set, is the content of the robots meta tag.
+	Robots string
+	// Status and Message describe the failure on error pages.
+	Status  int
+	Message string
+}
+
+// Canonical is the page's absolute URL, or "" without a base URL and on
+// error pages.
+func (p *Page) Canonical() string {
+	if p.Kind == "error" {
+		return ""
+	}
+	return p.Site.Absolute(p.Path)
+}
+
+// MetaDescription is the page description, falling back to the site's.
+func (p *Page) MetaDescription() string {
+	if p.Description != "" {
+		return p.Description
+	}
+	return p.Site.Description
+}
+
+// OpenGraphType is the og:type of the page.
+func (p *Page) OpenGraphType() string {
+	switch {
+	case p.Post != nil:
+		return "article"
+	case p.Author != nil:
+		return "profile"
+	}
+	return "website"
+}
+
+// StructuredData is the JSON-LD BlogPosting of a post page, or "" for other
+// pages.
+func (p *Page) StructuredData() (template.JS, error) {
+	post := p.Post
+	if post == nil {
+		return "", nil
+	}
+	type thing struct {
+		Type  string `json:"@type"`
+		Name  string `json:"name"`
+		URL   string `json:"url,omitempty"`
+		Image string `json:"image,omitempty"`
+	}
+	ld := struct {
+		Context          string   `json:"@context"`
+		Type             string   `json:"@type"`
+		Headline         string   `json:"headline"`
+		Description      string   `json:"description,omitempty"`
+		URL              string   `json:"url,omitempty"`
+		MainEntityOfPage string   `json:"mainEntityOfPage,omitempty"`
+		Image            string   `json:"image,omitempty"`
+		DatePublished    string   `json:"datePublished"`
+		DateModified     string   `json:"dateModified"`
+		Author           *thing   `json:"author,omitempty"`
+		Publisher        thing    `json:"publisher"`
+		Keywords         []string `json:"keywords,omitempty"`
+	}{
+		Context:          "https://schema.org",
+		Type:             "BlogPosting",
+		Headline:         post.Title,
+		Description:      post.Description,
+		URL:              p.Canonical(),
+		MainEntityOfPage: p.Canonical(),
+		Image:            p.Image,
+		DatePublished:    post.CreatedAt.UTC().Format(time.RFC3339),
+		DateModified:     post.UpdatedAt.UTC().Format(time.RFC3339),
+		Publisher:        thing{Type: "Organization", Name: p.Site.Title, URL: p.Site.Absolute("")},
+	}
+	if a := post.Author; a != nil {
+		ld.Author = &thing{Type: "Person", Name: a.Name, Image: a.Avatar}
+		if post.AuthorPath != "" {
+			ld.Author.URL = p.Site.Absolute(post.AuthorPath)
+		}
+	}
+	for _, t := range post.Tags {
+		ld.Keywords = append(ld.Keywords, t.Name)
+	}
+	// json.Marshal escapes <, > and &, so the result is safe inside a
+	// script element.
+	data, err := json.Marshal(ld)
+	return template.JS(data), err
+}
+
+type Post struct {
+	Title       string
+	Slug        string
+	Path        string
+	Description string
+	Content     template.HTML
+	CoverImage  string
+	Tags        []*Tag
+	Author      *user.Byline
+	// AuthorPath is where the author's page lives, or "" when the author
+	// has no public profile.
+	AuthorPath string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type Tag struct {
+	Name  string
+	Slug  string
+	Path  string
+	Count int
+}
+
+// About is the blog owner's about page.
+type About struct {
+	Content template.HTML
+	Picture string
+}
+
+type Pagination struct {
+	Page     int
+	Pages    int
+	PrevPath string
+	NextPath string
+}
+
+// PostPath is where a post lives relative to the site root.
+func PostPath(postSlug string) string {
+	return "posts/" + postSlug + "/"
+}
+
+// TagPath is where a tag's listing lives relative to the site root.
+func TagPath(name string) string {
+	return "tags/" + slug.Make(name) + "/"
+}
+
+// AuthorPath is where an author's posts are listed relative to the site
+// root.
+func AuthorPath(authorSlug string) string {
+	return "authors/" + authorSlug + "/"
+}
+
+// NewPost maps a blog post to its view.
+func NewPost(p *blog.BlogPost) *Post {
+	v := &Post{
+		Title:       p.Title,
+		Slug:        p.Slug,
+		Path:        PostPath(p.Slug),
+		Description: p.Description,
+		Content:     Content(p.Content),
+		CoverImage:  p.CoverImage,
+		Author:      p.Author,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+	if p.Author != nil && p.Author.Slug != "" {
+		v.AuthorPath = AuthorPath(p.Author.Slug)
+	}
+	for _, name := range p.Tags {
+		v.Tags = append(v.Tags, &Tag{Name: name, Slug: slug.Make(name), Path: TagPath(name)})
+	}
+	return v
+}
+
+var (
+	looksLikeHTML  = regexp.MustCompile(`^\s*<[a-zA-Z!]`)
+	paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+)
+
+// Content turns stored post content into HTML. Content that starts with a
+// tag, as imported from WordPress or Ghost, is kept as HTML after Sanitize
+// strips anything outside its allowlist; anything else is escaped and split
+// into paragraphs on blank lines.
+func Content(s string) template.HTML {
+	if looksLikeHTML.MatchString(s) {
+		return template.HTML(Sanitize(s))
+	}
+	var b strings.Builder
+	for _, para := range paragraphBreak.Split(strings.TrimSpace(s), -1) {
+		if para == "" {
+			continue
+		}
+		lines := strings.Split(para, "\n")
+		for i, line := range lines {
+			lines[i] = template.HTMLEscapeString(line)
+		}
+		b.WriteString("<p>" + strings.Join(lines, "<br>\n") + "</p>\n")
+	}
+	return template.HTML(b.String())
+}
+
+var funcs = template.FuncMap{
+	"date": func(t time.Time) string {
+		return t.Format("January 2, 2006")
+	},
+	"isoDate": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+	// dict builds a map from key/value pairs so partials can take several
+	// arguments.
+	"dict": func(pairs ...any) (map[string]any, error) {
+		if len(pairs)%2 != 0 {
+			return nil, errors.New("dict needs key/value pairs")
+		}
+		m := make(map[string]any, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			key, ok := pairs[i].(string)
+			if !ok {
+				return nil, errors.New("dict keys must be strings")
+			}
+			m[key] = pairs[i+1]
+		}
+		return m, nil
+	},
+}
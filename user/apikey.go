@@ -0,0 +1,164 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeyPrefix = "bgy_"
+
+type Scope string
+
+const (
+	ScopePostsWrite       Scope = "posts:write"
+	ScopeCommentsModerate Scope = "comments:moderate"
+)
+
+var scopePermissions = map[Scope][]Permission{
+	ScopePostsWrite:       {PermCreatePost, PermEditOwnPost, PermEditAnyPost, PermDeleteOwnPost, PermDeleteAnyPost},
+	ScopeCommentsModerate: {PermModerateComments},
+}
+
+func ParseScope(s string) (Scope, error) {
+	scope := Scope(s)
+	if _, ok := scopePermissions[scope]; !ok {
+		return "", errors.New("unknown scope: " + s)
+	}
+	return scope, nil
+}
+
+func scopesAllow(scopes []Scope, perm Permission) bool {
+	for _, scope := range scopes {
+		for _, p := range scopePermissions[scope] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Hash       string             `json:"-" bson:"hash"`
+	UserId     string             `json:"user_id" bson:"user_id"`
+	Scopes     []Scope            `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type APIKeyManager struct {
+	collection db.Collection
+	clock      clock.Clock
+}
+
+func NewAPIKeyManager(collection db.Collection, clk clock.Clock) *APIKeyManager {
+	return &APIKeyManager{collection, clock.OrSystem(clk)}
+}
+
+func InitAPIKeyIndex(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"hash": 1},
+		Options: options.Index().SetName("api_key_hash_index").SetUnique(true),
+	})
+	if err != nil {
+		return errors.New("Error creating hash index for api key collection: " + err.Error())
+	}
+	return nil
+}
+
+// CreateAPIKey stores a new key and returns it along with the plaintext,
+// which is not kept anywhere.
+func (km *APIKeyManager) CreateAPIKey(ctx context.Context, name, userId string, scopes []Scope, expiresAt *time.Time) (*APIKey, string, error) {
+	secret, err := generateRandomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := apiKeyPrefix + secret
+	key := &APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Prefix:    plaintext[:len(apiKeyPrefix)+6],
+		Hash:      hashAPIKey(plaintext),
+		UserId:    userId,
+		Scopes:    scopes,
+		CreatedAt: km.clock.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if _, err := km.collection.InsertOne(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, plaintext, nil
+}
+
+func (km *APIKeyManager) FindAPIKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	var key APIKey
+	if err := km.collection.FindOne(ctx, bson.M{"hash": hashAPIKey(plaintext)}).Decode(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (km *APIKeyManager) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	cur, err := km.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []*APIKey{}
+	if err := cur.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (km *APIKeyManager) RevokeAPIKey(ctx context.Context, id primitive.ObjectID) error {
+	res, err := km.collection.UpdateOne(ctx, bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"revoked_at": km.clock.Now()}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// TouchAPIKey records when a key was last used, at most once a minute.
+func (km *APIKeyManager) TouchAPIKey(ctx context.Context, key *APIKey) error {
+	now := km.clock.Now()
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < time.Minute {
+		return nil
+	}
+	_, err := km.collection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"last_used_at": now}})
+	return err
+}
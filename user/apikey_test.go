@@ -0,0 +1,91 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// keyStore holds API keys by plaintext.
+type keyStore map[string]*APIKey
+
+func (s keyStore) FindAPIKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	key, ok := s[plaintext]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return key, nil
+}
+
+func (s keyStore) TouchAPIKey(ctx context.Context, key *APIKey) error {
+	return nil
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	past := time.Now().Add(-time.Hour)
+	keys := keyStore{
+		"bgy_posts":    {UserId: "editor1", Scopes: []Scope{ScopePostsWrite}},
+		"bgy_moderate": {UserId: "editor1", Scopes: []Scope{ScopeCommentsModerate}},
+		"bgy_revoked":  {UserId: "editor1", Scopes: []Scope{ScopePostsWrite}, RevokedAt: &past},
+		"bgy_expired":  {UserId: "editor1", Scopes: []Scope{ScopePostsWrite}, ExpiresAt: &past},
+	}
+	m := NewMiddleware("secret", roleRepo{"editor1": {ID: "editor1", Role: Editor}}, nil, keys, false, false, nil)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r := gin.New()
+	r.Use(apperrors.ErrorHandler())
+	r.POST("/blog", m.Authentication(), m.RequirePermission(PermCreatePost), ok)
+	r.PUT("/authors/me", m.Authentication(), m.RequireSession(), m.RequirePermission(PermCreatePost), ok)
+	r.POST("/mfa/totp/enroll", m.Authentication(), m.RequireSession(), ok)
+	r.GET("/users", m.Authentication(), m.Authorization([]Role{Admin, Editor}), ok)
+	r.DELETE("/comment/:id", m.Authentication(), m.RequireSession(), m.LoadRole(), ok)
+	r.DELETE("/moderation/comment/:id", m.Authentication(), m.RequirePermission(PermModerateComments), ok)
+
+	tests := []struct {
+		method, path, key string
+		want              int
+	}{
+		// Allowed scope.
+		{http.MethodPost, "/blog", "bgy_posts", http.StatusOK},
+		{http.MethodDelete, "/moderation/comment/c1", "bgy_moderate", http.StatusOK},
+		// Missing scope.
+		{http.MethodPost, "/blog", "bgy_moderate", http.StatusForbidden},
+		{http.MethodDelete, "/moderation/comment/c1", "bgy_posts", http.StatusForbidden},
+		// Session-only routes.
+		{http.MethodPut, "/authors/me", "bgy_posts", http.StatusForbidden},
+		{http.MethodPost, "/mfa/totp/enroll", "bgy_posts", http.StatusForbidden},
+		{http.MethodGet, "/users", "bgy_posts", http.StatusForbidden},
+		{http.MethodDelete, "/comment/c1", "bgy_moderate", http.StatusForbidden},
+		// Keys that cannot be used.
+		{http.MethodPost, "/blog", "bgy_revoked", http.StatusUnauthorized},
+		{http.MethodPost, "/blog", "bgy_expired", http.StatusUnauthorized},
+		{http.MethodPost, "/blog", "bgy_unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s with %s: status %d, want %d", tt.method, tt.path, tt.key, w.Code, tt.want)
+		}
+	}
+}
+
+func TestHasPermissionNarrowsToScopes(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("role", Editor)
+	if !HasPermission(c, PermModerateComments) {
+		t.Error("an editor session cannot moderate comments")
+	}
+	c.Set("scopes", []Scope{ScopePostsWrite})
+	if HasPermission(c, PermModerateComments) || !HasPermission(c, PermEditAnyPost) {
+		t.Error("a posts:write key is not limited to post permissions")
+	}
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// authorRepo keeps users in memory and understands the filters the author
+// profile methods use.
+type authorRepo struct {
+	UserRepository
+	users []*User
+}
+
+func (r *authorRepo) matches(u *User, filter bson.M) bool {
+	for key, want := range filter {
+		switch key {
+		case "_id":
+			if ne, ok := want.(bson.M); ok {
+				if u.ID == ne["$ne"] {
+					return false
+				}
+			} else if u.ID != want {
+				return false
+			}
+		case "role":
+			in := false
+			for _, role := range want.(bson.M)["$in"].([]Role) {
+				in = in || u.Role == role
+			}
+			if !in {
+				return false
+			}
+		case "profile.slug":
+			if _, ok := want.(bson.M); ok {
+				if u.Profile == nil {
+					return false
+				}
+			} else if u.Profile == nil || u.Profile.Slug != want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *authorRepo) GetUser(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*User, error) {
+	for _, u := range r.users {
+		if r.matches(u, filter.(bson.M)) {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (r *authorRepo) GetUsers(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*User, error) {
+	var users []*User
+	for _, u := range r.users {
+		if r.matches(u, filter.(bson.M)) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (r *authorRepo) IsExists(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (bool, error) {
+	_, err := r.GetUser(ctx, filter)
+	return err == nil, nil
+}
+
+func (r *authorRepo) UpdateUser(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	for _, u := range r.users {
+		if r.matches(u, filter.(bson.M)) {
+			u.Profile = update.(bson.M)["$set"].(bson.M)["profile"].(*AuthorProfile)
+			return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+		}
+	}
+	return &mongo.UpdateResult{}, nil
+}
+
+func TestUpdateAuthorProfileMakesUniqueSlugs(t *testing.T) {
+	ctx := context.Background()
+	us := &UserService{repo: &authorRepo{users: []*User{
+		{ID: "a1", Name: "Ada Lovelace", Role: Author},
+		{ID: "a2", Name: "Ada Lovelace", Role: Author},
+	}}, cache: noopInvalidator{}, clock: clock.System{}}
+	first, err := us.UpdateAuthorProfile(ctx, "a1", &AuthorProfile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := us.UpdateAuthorProfile(ctx, "a2", &AuthorProfile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Slug != "ada-lovelace" || second.Slug != "ada-lovelace-2" {
+		t.Errorf("slugs = %q, %q; want ada-lovelace and ada-lovelace-2", first.Slug, second.Slug)
+	}
+	// Saving again keeps the author's own slug.
+	again, err := us.UpdateAuthorProfile(ctx, "a1", &AuthorProfile{Bio: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Slug != "ada-lovelace" {
+		t.Errorf("slug after resave = %q", again.Slug)
+	}
+}
+
+func TestAuthorPagesNeedAnAuthorRole(t *testing.T) {
+	ctx := context.Background()
+	us := &UserService{repo: &authorRepo{users: []*User{
+		{ID: "a1", Role: Author, Profile: &AuthorProfile{Slug: "ada"}},
+		{ID: "r1", Role: Reader, Profile: &AuthorProfile{Slug: "demoted"}},
+	}}, clock: clock.System{}}
+	if a, err := us.GetAuthorBySlug(ctx, "ada"); err != nil || a.ID != "a1" {
+		t.Errorf("GetAuthorBySlug(ada) = %+v, %v", a, err)
+	}
+	if _, err := us.GetAuthorBySlug(ctx, "demoted"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("GetAuthorBySlug(demoted) error = %v, want not found", err)
+	}
+	authors, err := us.GetAuthors(ctx)
+	if err != nil || len(authors) != 1 || authors[0].ID != "a1" {
+		t.Errorf("GetAuthors = %+v, %v; want only a1", authors, err)
+	}
+}
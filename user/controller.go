@@ -4,7 +4,10 @@ import (
 	"context"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/ayo-ajayi/bloggy/apperrors"
 	"github.com/gin-gonic/gin"
 )
 
@@ -18,11 +21,21 @@ func NewUserController(service UserServices, uploader Uploader) *UserController
 }
 
 type UserServices interface {
-	Login(ctx *gin.Context) (string, error)
-	Callback(ctx *gin.Context) (*GoogleLoginResponse, error)
+	Login(ctx *gin.Context, redirectTo string) (string, error)
+	Callback(ctx *gin.Context) (*GoogleLoginResponse, string, error)
+	IssueLoginCode(ctx context.Context, userId string) (string, error)
+	ExchangeLoginCode(ctx context.Context, code string) (*User, error)
 	SaveAccessToken(ctx context.Context, userId string, td *TokenDetails) error
-	GenerateAccessToken(userId string) (*TokenDetails, error)
-	SaveUser(ctx context.Context, googleLoginResponse *GoogleLoginResponse) error
+	GenerateAccessToken(userId string, mfa bool) (*TokenDetails, error)
+	SetAuthCookies(c *gin.Context, td *TokenDetails)
+	ClearAuthCookies(c *gin.Context)
+	EnrollTOTP(ctx context.Context, userId string) (string, string, error)
+	ConfirmTOTP(ctx context.Context, userId, code string) ([]string, error)
+	VerifyMFA(ctx context.Context, userId, code string) error
+	DisableTOTP(ctx context.Context, userId, code string) error
+	SaveUser(ctx context.Context, googleLoginResponse *GoogleLoginResponse) (*User, error)
+	RequestEmailLogin(ctx context.Context, email, ip string) error
+	VerifyEmailLogin(ctx context.Context, token string) (*User, error)
 	Logout(ctx context.Context, accessUuid string) error
 	Profile(ctx context.Context, userId string) (*User, error)
 	UpdateAboutMe(ctx context.Context, userId, aboutMe, profilePicture string) error
@@ -31,52 +44,130 @@ type UserServices interface {
 	UnSubscribeFromMailingList(ctx context.Context, id string) error
 	GetMailingList(ctx context.Context) (*MailingList, error)
 	GetUsers(ctx context.Context) ([]*User, error)
+	AssignRole(ctx context.Context, userId string, role Role) (*User, error)
+	UpdateAuthorProfile(ctx context.Context, userId string, profile *AuthorProfile) (*Byline, error)
+	CreateAPIKey(ctx context.Context, name, userId string, scopes []Scope, expiresAt *time.Time) (*APIKey, string, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, idStr string) error
 }
 
+// Uploader stores images in the media library and tracks what uses them.
 type Uploader interface {
-	UploadImage(ctx context.Context, file *multipart.FileHeader, collection string) (string, error)
+	UploadImage(ctx context.Context, file *multipart.FileHeader, ownerId string) (string, error)
+	SetReferences(ctx context.Context, ref string, urls ...string) error
 }
 
 func (uc *UserController) Login(c *gin.Context) {
-	url, err := uc.service.Login(c)
+	url, err := uc.service.Login(c, c.Query("redirect_to"))
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
 func (uc *UserController) Callback(c *gin.Context) {
-	content, err := uc.service.Callback(c)
+	content, redirectTo, err := uc.service.Callback(c)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
-	if err := uc.service.SaveUser(c, content); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+	user, err := uc.service.SaveUser(c, content)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	code, err := uc.service.IssueLoginCode(c, user.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	target, err := url.Parse(redirectTo)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	q := target.Query()
+	q.Set("code", code)
+	target.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// ExchangeLoginCode trades the one-time code handed to the frontend on
+// redirect for a new access token.
+func (uc *UserController) ExchangeLoginCode(c *gin.Context) {
+	req := struct {
+		Code string `json:"code" binding:"required"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	user, err := uc.service.ExchangeLoginCode(c, req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	td, err := uc.service.GenerateAccessToken(user.ID, false)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := uc.service.SaveAccessToken(c, user.ID, td); err != nil {
+		c.Error(err)
+		return
+	}
+	uc.service.SetAuthCookies(c, td)
+	lr := &LoginResponse{AccessToken: td.AccessToken, AtExpires: td.AtExpires, User: user}
+	c.JSON(http.StatusOK, gin.H{"data": lr, "message": "Successfully logged in"})
+}
+
+// RequestEmailLogin mails a magic link to the given address. The response is
+// the same whether or not an account exists for it.
+func (uc *UserController) RequestEmailLogin(c *gin.Context) {
+	req := struct {
+		Email string `json:"email" binding:"required"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	if err := uc.service.RequestEmailLogin(c, req.Email, c.ClientIP()); err != nil {
+		c.Error(err)
 		return
 	}
-	td, err := uc.service.GenerateAccessToken(content.ID)
+	c.JSON(200, gin.H{"message": "If the address is valid, a login link has been sent"})
+}
+
+func (uc *UserController) VerifyEmailLogin(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "token is required", nil))
+		return
+	}
+	user, err := uc.service.VerifyEmailLogin(c, token)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
-	if err := uc.service.SaveAccessToken(c, content.ID, td); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+	td, err := uc.service.GenerateAccessToken(user.ID, false)
+	if err != nil {
+		c.Error(err)
 		return
 	}
-	lr := &LoginResponse{
-		AccessToken:         td.AccessToken,
-		AtExpires:           td.AtExpires,
-		GoogleLoginResponse: *content,
+	if err := uc.service.SaveAccessToken(c, user.ID, td); err != nil {
+		c.Error(err)
+		return
 	}
+	uc.service.SetAuthCookies(c, td)
+	lr := &LoginResponse{AccessToken: td.AccessToken, AtExpires: td.AtExpires, User: user}
 	c.JSON(http.StatusOK, gin.H{"data": lr, "message": "Successfully logged in"})
 }
 
 type LoginResponse struct {
 	AccessToken string `json:"access_token"`
 	AtExpires   int64  `json:"at_expires"`
-	GoogleLoginResponse
+	User        *User  `json:"user"`
 }
 
 type AboutMe struct {
@@ -88,22 +179,91 @@ type AboutMe struct {
 func (uc *UserController) Logout(c *gin.Context) {
 	accessUuid := c.GetString("access_uuid")
 	if err := uc.service.Logout(c, accessUuid); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
+	uc.service.ClearAuthCookies(c)
 	c.JSON(200, gin.H{"message": "Successfully logged out"})
 }
 
+func (uc *UserController) EnrollTOTP(c *gin.Context) {
+	secret, uri, err := uc.service.EnrollTOTP(c, c.GetString("user_id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": gin.H{"secret": secret, "provisioning_uri": uri}, "message": "Scan the provisioning uri and confirm with a code"})
+}
+
+func (uc *UserController) ConfirmTOTP(c *gin.Context) {
+	req := struct {
+		Code string `json:"code" binding:"required"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	codes, err := uc.service.ConfirmTOTP(c, c.GetString("user_id"), req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": gin.H{"recovery_codes": codes}, "message": "Two-factor authentication enabled. Store the recovery codes safely, they will not be shown again"})
+}
+
+// StepUpMFA verifies a second factor and replaces the current session with
+// an access token marked as MFA-satisfied.
+func (uc *UserController) StepUpMFA(c *gin.Context) {
+	req := struct {
+		Code string `json:"code" binding:"required"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	userId := c.GetString("user_id")
+	if err := uc.service.VerifyMFA(c, userId, req.Code); err != nil {
+		c.Error(err)
+		return
+	}
+	td, err := uc.service.GenerateAccessToken(userId, true)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := uc.service.SaveAccessToken(c, userId, td); err != nil {
+		c.Error(err)
+		return
+	}
+	uc.service.SetAuthCookies(c, td)
+	c.JSON(200, gin.H{"data": td, "message": "Second factor verified"})
+}
+
+func (uc *UserController) DisableTOTP(c *gin.Context) {
+	req := struct {
+		Code string `json:"code" binding:"required"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	if err := uc.service.DisableTOTP(c, c.GetString("user_id"), req.Code); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
 func (uc *UserController) Profile(c *gin.Context) {
 	userid, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 
 	user, err := uc.service.Profile(c, userid.(string))
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": user})
@@ -112,12 +272,12 @@ func (uc *UserController) Profile(c *gin.Context) {
 func (uc *UserController) UpdateAboutMe(c *gin.Context) {
 	userid, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	err := c.Request.ParseMultipartForm(32 << 20)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid multipart form", err))
 		return
 	}
 	req := struct {
@@ -127,9 +287,9 @@ func (uc *UserController) UpdateAboutMe(c *gin.Context) {
 	req.AboutMe = c.PostForm("about_me")
 	files := c.Request.MultipartForm.File["profile_picture"]
 	if len(files) != 0 {
-		image, err := uc.uploader.UploadImage(c, files[0], "profile_picture")
+		image, err := uc.uploader.UploadImage(c, files[0], userid.(string))
 		if err != nil {
-			c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+			c.Error(err)
 			return
 		}
 		req.ProfilePicture = image
@@ -137,7 +297,11 @@ func (uc *UserController) UpdateAboutMe(c *gin.Context) {
 		req.ProfilePicture = ""
 	}
 	if err := uc.service.UpdateAboutMe(c, userid.(string), req.AboutMe, req.ProfilePicture); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
+		return
+	}
+	if err := uc.uploader.SetReferences(c, "about:"+userid.(string), req.ProfilePicture); err != nil {
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Successfully updated about me"})
@@ -146,7 +310,7 @@ func (uc *UserController) UpdateAboutMe(c *gin.Context) {
 func (uc *UserController) GetAboutMe(c *gin.Context) {
 	aboutMe, err := uc.service.GetAboutMe(c)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": gin.H{
@@ -158,11 +322,11 @@ func (uc *UserController) GetAboutMe(c *gin.Context) {
 func (uc *UserController) SubscribeToMailingList(c *gin.Context) {
 	id, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	if err := uc.service.SubscribeToMailingList(c, id.(string)); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Successfully subscribed to mailing list"})
@@ -171,11 +335,11 @@ func (uc *UserController) SubscribeToMailingList(c *gin.Context) {
 func (uc *UserController) UnSubscribeFromMailingList(c *gin.Context) {
 	id, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(500, gin.H{"error": gin.H{"message": "user not found"}})
+		c.Error(apperrors.Unauthorized(apperrors.CodeUnauthorized, "user not found", nil))
 		return
 	}
 	if err := uc.service.UnSubscribeFromMailingList(c, id.(string)); err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"message": "Successfully unsubscribed from mailing list"})
@@ -184,7 +348,7 @@ func (uc *UserController) UnSubscribeFromMailingList(c *gin.Context) {
 func (uc *UserController) GetMailingList(c *gin.Context) {
 	mailingList, err := uc.service.GetMailingList(c)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": mailingList})
@@ -193,8 +357,106 @@ func (uc *UserController) GetMailingList(c *gin.Context) {
 func (uc *UserController) GetUsers(c *gin.Context) {
 	u, err := uc.service.GetUsers(c)
 	if err != nil {
-		c.JSON(500, gin.H{"error": gin.H{"message": err.Error()}})
+		c.Error(err)
 		return
 	}
 	c.JSON(200, gin.H{"data": u})
 }
+
+func (uc *UserController) AssignRole(c *gin.Context) {
+	req := struct {
+		Role string `json:"role" binding:"required"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	role, err := ParseRole(req.Role)
+	if err != nil {
+		c.Error(apperrors.BadRequest(CodeUnknownRole, err.Error(), err))
+		return
+	}
+	id := c.Param("id")
+	if id == c.GetString("user_id") {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "you cannot change your own role", nil))
+		return
+	}
+	u, err := uc.service.AssignRole(c, id, role)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": u, "message": "Role assigned successfully"})
+}
+
+func (uc *UserController) GetRoles(c *gin.Context) {
+	c.JSON(200, gin.H{"data": RolePermissions()})
+}
+
+func (uc *UserController) UpdateAuthorProfile(c *gin.Context) {
+	req := struct {
+		Slug        string            `json:"slug"`
+		Bio         string            `json:"bio"`
+		Avatar      string            `json:"avatar"`
+		SocialLinks map[string]string `json:"social_links"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	author, err := uc.service.UpdateAuthorProfile(c, c.GetString("user_id"), &AuthorProfile{
+		Slug:        req.Slug,
+		Bio:         req.Bio,
+		Avatar:      req.Avatar,
+		SocialLinks: req.SocialLinks,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": author, "message": "Author profile updated successfully"})
+}
+
+func (uc *UserController) CreateAPIKey(c *gin.Context) {
+	req := struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required,min=1"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	scopes := make([]Scope, 0, len(req.Scopes))
+	for _, s := range req.Scopes {
+		scope, err := ParseScope(s)
+		if err != nil {
+			c.Error(apperrors.BadRequest(CodeUnknownScope, err.Error(), err))
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	key, plaintext, err := uc.service.CreateAPIKey(c, req.Name, c.GetString("user_id"), scopes, req.ExpiresAt)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(201, gin.H{"data": gin.H{"key": plaintext, "api_key": key}, "message": "API key created. Copy it now, it will not be shown again"})
+}
+
+func (uc *UserController) ListAPIKeys(c *gin.Context) {
+	keys, err := uc.service.ListAPIKeys(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"data": keys})
+}
+
+func (uc *UserController) RevokeAPIKey(c *gin.Context) {
+	if err := uc.service.RevokeAPIKey(c, c.Param("id")); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// AccessTokenCookie carries the access token when cookie auth is on.
+	AccessTokenCookie = "access_token"
+	// CSRFCookie holds the token that state-changing requests authenticated
+	// by AccessTokenCookie must echo in CSRFHeader.
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRFToken derives the double-submit token of a session from its access
+// uuid. It cannot be forged without the signing secret and stops working
+// when the session ends.
+func (tm *TokenManager) CSRFToken(accessUuid string) string {
+	mac := hmac.New(sha256.New, []byte(tm.accessTokenSecret))
+	mac.Write([]byte("csrf:" + accessUuid))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SetAuthCookies hands td to the browser in an HttpOnly cookie, and its
+// CSRF token in one scripts can read, when cookie auth is on.
+func (us *UserService) SetAuthCookies(c *gin.Context, td *TokenDetails) {
+	if !us.cookieAuth {
+		return
+	}
+	expires := time.Unix(td.AtExpires, 0)
+	http.SetCookie(c.Writer, authCookie(AccessTokenCookie, td.AccessToken, expires, true))
+	http.SetCookie(c.Writer, authCookie(CSRFCookie, us.tokenMgr.CSRFToken(td.AcessUuid), expires, false))
+}
+
+// ClearAuthCookies removes the cookies set by SetAuthCookies.
+func (us *UserService) ClearAuthCookies(c *gin.Context) {
+	if !us.cookieAuth {
+		return
+	}
+	for _, name := range []string{AccessTokenCookie, CSRFCookie} {
+		cookie := authCookie(name, "", time.Unix(0, 0), name == AccessTokenCookie)
+		cookie.MaxAge = -1
+		http.SetCookie(c.Writer, cookie)
+	}
+}
+
+func authCookie(name, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// checkCSRF enforces the double submit for a request authenticated by
+// cookie: unless the method is safe, the header must repeat the cookie and
+// match the session's token.
+func (m *Middleware) checkCSRF(c *gin.Context, accessUuid string) bool {
+	if safeMethod(c.Request.Method) {
+		return true
+	}
+	header := c.GetHeader(CSRFHeader)
+	cookie, _ := c.Cookie(CSRFCookie)
+	if header == "" ||
+		subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) != 1 ||
+		!hmac.Equal([]byte(header), []byte(m.tokenManager.CSRFToken(accessUuid))) {
+		abortWithError(c, apperrors.Forbidden(CodeInvalidCSRFToken, "missing or invalid csrf token", nil))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package user
+
+import (
+	"context"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrTooManyLoginAttempts = apperrors.TooManyRequests(CodeTooManyLoginAttempts, "too many login attempts, try again later", nil)
+
+func invalidLoginLink(err error) error {
+	return apperrors.Unauthorized(CodeInvalidLoginLink, "invalid or expired login link", err)
+}
+
+type emailLoginToken struct {
+	ID        string    `bson:"_id"`
+	Email     string    `bson:"email"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// EmailLoginManager issues and verifies single-use magic links for
+// passwordless login. Links are signed with their own secret, not the
+// access token secret, so neither kind of token can stand in for the other.
+// Each replica allows 3 links per address and 10 per IP every 15 minutes.
+type EmailLoginManager struct {
+	secret     string
+	validity   time.Duration
+	verifyURL  string
+	collection db.Collection
+	mailer     Mailer
+	clock      clock.Clock
+	perEmail   *attemptLimiter
+	perIP      *attemptLimiter
+}
+
+func NewEmailLoginManager(secret string, validity time.Duration, verifyURL string, collection db.Collection, mailer Mailer, clk clock.Clock) *EmailLoginManager {
+	clk = clock.OrSystem(clk)
+	return &EmailLoginManager{
+		secret:     secret,
+		validity:   validity,
+		verifyURL:  verifyURL,
+		collection: collection,
+		mailer:     mailer,
+		clock:      clk,
+		perEmail:   newAttemptLimiter(3, 15*time.Minute, clk.Now),
+		perIP:      newAttemptLimiter(10, 15*time.Minute, clk.Now),
+	}
+}
+
+func normalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", apperrors.BadRequest(CodeInvalidEmail, "invalid email address", err)
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+func (em *EmailLoginManager) SendLoginLink(ctx context.Context, email, ip string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if !em.perIP.Allow(ip) || !em.perEmail.Allow(email) {
+		return ErrTooManyLoginAttempts
+	}
+	jti := uuid.New().String()
+	expiresAt := em.clock.Now().Add(em.validity)
+	claims := jwt.MapClaims{
+		"purpose": "email_login",
+		"email":   email,
+		"jti":     jti,
+		"exp":     expiresAt.Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(em.secret))
+	if err != nil {
+		return err
+	}
+	if _, err := em.collection.InsertOne(ctx, &emailLoginToken{ID: jti, Email: email, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+	link := em.verifyURL + "?token=" + url.QueryEscape(token)
+	body := "Use the link below to log in to bloggy. It expires in " + em.validity.String() + " and can only be used once.\n\n" + link + "\n\nIf you did not request this, you can ignore this email."
+	return em.mailer.SendMail(ctx, email, "Your bloggy login link", body)
+}
+
+// VerifyLoginToken checks the link signature and consumes it, returning the
+// email address it was issued for.
+func (em *EmailLoginManager) VerifyLoginToken(ctx context.Context, token string) (string, error) {
+	jwtToken, err := ValidateToken(token, em.secret, em.clock.Now)
+	if err != nil {
+		return "", invalidLoginLink(err)
+	}
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid {
+		return "", invalidLoginLink(nil)
+	}
+	if purpose, _ := claims["purpose"].(string); purpose != "email_login" {
+		return "", invalidLoginLink(nil)
+	}
+	jti, _ := claims["jti"].(string)
+	email, _ := claims["email"].(string)
+	if jti == "" || email == "" {
+		return "", invalidLoginLink(nil)
+	}
+	var stored emailLoginToken
+	if err := em.collection.FindOneAndDelete(ctx, bson.M{"_id": jti}).Decode(&stored); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", apperrors.Unauthorized(CodeInvalidLoginLink, "login link has already been used", err)
+		}
+		return "", err
+	}
+	if stored.Email != email {
+		return "", invalidLoginLink(nil)
+	}
+	return email, nil
+}
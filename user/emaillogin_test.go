@@ -0,0 +1,55 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestVerifyLoginTokenRejectsOtherSecrets(t *testing.T) {
+	em := NewEmailLoginManager("email-login-secret", 15*time.Minute, "https://example.com/verify", nil, nil, nil)
+	claims := jwt.MapClaims{
+		"purpose": "email_login",
+		"email":   "ada@example.com",
+		"jti":     "jti",
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	}
+	for name, secret := range map[string]string{"access token secret": "access-token-secret", "no secret": ""} {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := em.VerifyLoginToken(context.Background(), token); err == nil {
+			t.Errorf("%s: link was accepted", name)
+		}
+	}
+}
+
+func TestVerifyLoginTokenRejectsOtherPurposes(t *testing.T) {
+	em := NewEmailLoginManager("email-login-secret", 15*time.Minute, "https://example.com/verify", nil, nil, nil)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email": "ada@example.com",
+		"jti":   "jti",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("email-login-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.VerifyLoginToken(context.Background(), token); err == nil {
+		t.Error("a token without the email_login purpose was accepted")
+	}
+}
+
+func TestSendLoginLinkLimits(t *testing.T) {
+	em := NewEmailLoginManager("email-login-secret", 15*time.Minute, "https://example.com/verify", nil, nil, clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+	// Exhaust the address limit without reaching the collection.
+	for i := 0; i < 3; i++ {
+		em.perEmail.Allow("ada@example.com")
+	}
+	if err := em.SendLoginLink(context.Background(), "Ada@Example.com", "192.0.2.1"); err != ErrTooManyLoginAttempts {
+		t.Errorf("err = %v, want ErrTooManyLoginAttempts", err)
+	}
+}
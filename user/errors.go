@@ -0,0 +1,34 @@
+package user
+
+import "github.com/gin-gonic/gin"
+
+const (
+	CodeUserNotFound          = "user_not_found"
+	CodeAboutNotFound         = "about_not_found"
+	CodeAPIKeyNotFound        = "api_key_not_found"
+	CodeInvalidRedirect       = "invalid_redirect"
+	CodeInvalidOAuthState     = "invalid_oauth_state"
+	CodeOAuthFailed           = "oauth_failed"
+	CodeInvalidLoginCode      = "invalid_login_code"
+	CodeInvalidLoginLink      = "invalid_login_link"
+	CodeAccountLinkRefused    = "account_link_refused"
+	CodeInvalidEmail          = "invalid_email"
+	CodeTooManyLoginAttempts  = "too_many_login_attempts"
+	CodeTOTPAlreadyEnabled    = "totp_already_enabled"
+	CodeTOTPNotEnabled        = "totp_not_enabled"
+	CodeInvalidMFACode        = "invalid_mfa_code"
+	CodeMFARequired           = "mfa_required"
+	CodeMFAEnrollmentRequired = "mfa_enrollment_required"
+	CodeUnknownRole           = "unknown_role"
+	CodeUnknownScope          = "unknown_scope"
+	CodeAlreadySubscribed     = "already_subscribed"
+	CodeNotSubscribed         = "not_subscribed"
+	CodeInvalidToken          = "invalid_token"
+	CodeInvalidCSRFToken      = "invalid_csrf_token"
+	CodeUploadFailed          = "upload_failed"
+)
+
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
@@ -0,0 +1,44 @@
+package user
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter allows at most limit attempts per key within a sliding window.
+// Attempts are counted in process memory, so every replica enforces the
+// limit on its own: with N replicas a key gets up to N times as many.
+type attemptLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	hits   map[string][]time.Time
+}
+
+func newAttemptLimiter(limit int, window time.Duration, now func() time.Time) *attemptLimiter {
+	return &attemptLimiter{limit: limit, window: window, now: now, hits: make(map[string][]time.Time)}
+}
+
+func (l *attemptLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var recent []time.Time
+	for _, t := range l.hits[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+	for k, v := range l.hits {
+		if len(v) == 0 || now.Sub(v[len(v)-1]) >= l.window {
+			delete(l.hits, k)
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newAttemptLimiter(3, 15*time.Minute, func() time.Time { return now })
+	for i := 0; i < 3; i++ {
+		if !l.Allow("ada@example.com") {
+			t.Fatalf("attempt %d was refused", i+1)
+		}
+		now = now.Add(time.Minute)
+	}
+	if l.Allow("ada@example.com") {
+		t.Error("fourth attempt within the window was allowed")
+	}
+	if !l.Allow("bob@example.com") {
+		t.Error("another key shares the limit")
+	}
+
+	// The first attempt leaves the window 15 minutes after it was made.
+	now = time.Date(2024, 3, 1, 12, 15, 0, 0, time.UTC)
+	if !l.Allow("ada@example.com") {
+		t.Error("attempt after the first one expired was refused")
+	}
+	if l.Allow("ada@example.com") {
+		t.Error("only one attempt should have expired")
+	}
+}
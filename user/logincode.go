@@ -0,0 +1,66 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LoginCode is a one-time code that the frontend exchanges for an access
+// token. Only the hash of the code is stored.
+type LoginCode struct {
+	ID        string    `json:"-" bson:"_id"`
+	UserId    string    `json:"user_id" bson:"user_id"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+type LoginCodeStore struct {
+	collection db.Collection
+	validity   time.Duration
+	clock      clock.Clock
+}
+
+func NewLoginCodeStore(collection db.Collection, validity time.Duration, clk clock.Clock) *LoginCodeStore {
+	return &LoginCodeStore{collection, validity, clock.OrSystem(clk)}
+}
+
+func hashLoginCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *LoginCodeStore) IssueLoginCode(ctx context.Context, userId string) (string, error) {
+	code, err := generateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.collection.InsertOne(ctx, &LoginCode{
+		ID:        hashLoginCode(code),
+		UserId:    userId,
+		ExpiresAt: s.clock.Now().Add(s.validity),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ConsumeLoginCode deletes the code and returns the id of the user it was
+// issued for.
+func (s *LoginCodeStore) ConsumeLoginCode(ctx context.Context, code string) (string, error) {
+	var lc LoginCode
+	err := s.collection.FindOneAndDelete(ctx, bson.M{"_id": hashLoginCode(code)}).Decode(&lc)
+	if err != nil {
+		return "", err
+	}
+	if s.clock.Now().After(lc.ExpiresAt) {
+		return "", mongo.ErrNoDocuments
+	}
+	return lc.UserId, nil
+}
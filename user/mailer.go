@@ -0,0 +1,40 @@
+package user
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type Mailer interface {
+	SendMail(ctx context.Context, to, subject, body string) error
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr, from, auth}
+}
+
+func (m *SMTPMailer) SendMail(ctx context.Context, to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -16,62 +19,124 @@ type Middleware struct {
 	accessTokenSecret string
 	userRepo          MiddlewareUserRepo
 	tokenManager      MiddlewareTokenManager
+	apiKeys           MiddlewareAPIKeyManager
+	requireAdminMFA   bool
+	cookieAuth        bool
+	clock             clock.Clock
+}
+
+type MiddlewareAPIKeyManager interface {
+	FindAPIKey(ctx context.Context, plaintext string) (*APIKey, error)
+	TouchAPIKey(ctx context.Context, key *APIKey) error
 }
 
 type MiddlewareTokenManager interface {
 	FindToken(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*AccessDetails, error)
 	ExtractTokenMetadata(token *jwt.Token) (*AccessDetails, error)
+	CSRFToken(accessUuid string) string
 }
 type MiddlewareUserRepo interface {
 	GetUser(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*User, error)
 }
 
-func NewMiddleware(accessTokenSecret string, userRepo MiddlewareUserRepo, tokenManager MiddlewareTokenManager) *Middleware {
-	return &Middleware{accessTokenSecret, userRepo, tokenManager}
+// NewMiddleware returns the auth middleware. With cookieAuth, requests
+// without an Authorization header may carry the access token in
+// AccessTokenCookie instead, subject to a CSRF check.
+func NewMiddleware(accessTokenSecret string, userRepo MiddlewareUserRepo, tokenManager MiddlewareTokenManager, apiKeys MiddlewareAPIKeyManager, requireAdminMFA, cookieAuth bool, clk clock.Clock) *Middleware {
+	return &Middleware{accessTokenSecret, userRepo, tokenManager, apiKeys, requireAdminMFA, cookieAuth, clock.OrSystem(clk)}
 }
 
 func (m *Middleware) Authentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := extractToken(c.Request)
+		fromCookie := false
+		if token == "" && m.cookieAuth {
+			token, _ = c.Cookie(AccessTokenCookie)
+			fromCookie = token != ""
+		}
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": "unauthorized: token is required"}})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized(apperrors.CodeUnauthorized, "unauthorized: token is required", nil))
+			return
+		}
+		if isAPIKey(token) && !fromCookie {
+			m.authenticateAPIKey(c, token)
 			return
 		}
-		jwtToken, err := ValidateToken(token, m.accessTokenSecret)
+		jwtToken, err := ValidateToken(token, m.accessTokenSecret, m.clock.Now)
 		if err != nil {
-			if errors.Is(err, jwt.ErrSignatureInvalid) {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": "unauthorized: " + err.Error()}})
-				c.Abort()
-				return
-			}
 			if errors.Is(err, jwt.ErrTokenExpired) {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"message": "unauthorized: " + err.Error()}})
-				c.Abort()
+				abortWithError(c, apperrors.Unauthorized(CodeInvalidToken, "unauthorized: token has expired", err))
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{"message": err.Error()}})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized(CodeInvalidToken, "unauthorized: invalid token", err))
 			return
 		}
 		td, err := m.tokenManager.ExtractTokenMetadata(jwtToken)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "unauthorized: " + err.Error()}})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized(CodeInvalidToken, "unauthorized: invalid token", err))
 			return
 		}
-		_, err = m.tokenManager.FindToken(c, bson.M{"access_uuid": td.AccessUuid})
+		stored, err := m.tokenManager.FindToken(c, bson.M{"access_uuid": td.AccessUuid})
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "unauthorized: " + err.Error()}})
-			c.Abort()
+			if err == mongo.ErrNoDocuments {
+				abortWithError(c, apperrors.Unauthorized(CodeInvalidToken, "unauthorized: token has been revoked", err))
+				return
+			}
+			abortWithError(c, apperrors.Internal(err))
+			return
+		}
+		if fromCookie && !m.checkCSRF(c, td.AccessUuid) {
 			return
 		}
 		c.Set("access_uuid", td.AccessUuid)
 		c.Set("user_id", td.UserId)
+		c.Set("mfa", td.Mfa && stored.Mfa)
+		c.Next()
+	}
+}
+
+func (m *Middleware) authenticateAPIKey(c *gin.Context, token string) {
+	key, err := m.apiKeys.FindAPIKey(c, token)
+	if err != nil && err != mongo.ErrNoDocuments {
+		abortWithError(c, apperrors.Internal(err))
+		return
+	}
+	if err != nil || !key.Active(m.clock.Now()) {
+		abortWithError(c, apperrors.Unauthorized(CodeInvalidToken, "unauthorized: invalid api key", err))
+		return
+	}
+	if err := m.apiKeys.TouchAPIKey(c, key); err != nil {
+		abortWithError(c, apperrors.Internal(err))
+		return
+	}
+	c.Set("user_id", key.UserId)
+	c.Set("api_key_id", key.ID.Hex())
+	c.Set("scopes", key.Scopes)
+	c.Next()
+}
+
+// RequireSession rejects requests authenticated with an API key. Keys are
+// meant for the content routes that check their scopes; everything else,
+// such as MFA and session management, needs a logged-in user.
+func (m *Middleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsAPIKey(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": gin.H{"message": "api keys cannot acess this resource"}})
+			return
+		}
 		c.Next()
 	}
 }
 
+func requestScopes(c *gin.Context) ([]Scope, bool) {
+	scopes, ok := c.Get("scopes")
+	if !ok {
+		return nil, false
+	}
+	s, ok := scopes.([]Scope)
+	return s, ok
+}
+
 func extractToken(r *http.Request) string {
 	token := r.Header.Get("Authorization")
 	ttoken := strings.Split(token, " ")
@@ -83,12 +148,16 @@ func extractToken(r *http.Request) string {
 
 func (m *Middleware) Authorization(roles []Role) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if IsAPIKey(c) {
+			abortWithError(c, apperrors.Forbidden(apperrors.CodeForbidden, "api keys cannot acess this resource", nil))
+			return
+		}
 		userId := c.MustGet("user_id").(string)
 		user, err := m.userRepo.GetUser(c, bson.M{
 			"_id": userId,
 		})
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": gin.H{"message": err.Error() + ": you are not authorized to acess this resource"}})
+			abortWithError(c, apperrors.Forbidden(apperrors.CodeForbidden, "you are not authorized to acess this resource", err))
 			return
 		}
 		allowed := false
@@ -99,9 +168,74 @@ func (m *Middleware) Authorization(roles []Role) gin.HandlerFunc {
 			}
 		}
 		if !allowed {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": gin.H{"message": "you are not authorized to acess this resource"}})
+			abortWithError(c, apperrors.Forbidden(apperrors.CodeForbidden, "you are not authorized to acess this resource", nil))
+			return
+		}
+		if !m.checkAdminMFA(c, user) {
 			return
 		}
+		c.Set("role", user.Role)
 		c.Next()
 	}
 }
+
+// RequirePermission allows the request if the user's role grants any of perms.
+// Ownership of the target resource is left to the handler.
+func (m *Middleware) RequirePermission(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("user_id").(string)
+		user, err := m.userRepo.GetUser(c, bson.M{
+			"_id": userId,
+		})
+		if err != nil {
+			abortWithError(c, apperrors.Forbidden(apperrors.CodeForbidden, "you are not authorized to acess this resource", err))
+			return
+		}
+		scopes, isKey := requestScopes(c)
+		allowed := false
+		for _, perm := range perms {
+			if user.Role.Can(perm) && (!isKey || scopesAllow(scopes, perm)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			abortWithError(c, apperrors.Forbidden(apperrors.CodeForbidden, "you are not authorized to acess this resource", nil))
+			return
+		}
+		if !isKey && !m.checkAdminMFA(c, user) {
+			return
+		}
+		c.Set("role", user.Role)
+		c.Next()
+	}
+}
+
+// LoadRole records the user's role for handlers that decide permissions
+// themselves. It only rejects requests whose user no longer exists.
+func (m *Middleware) LoadRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := m.userRepo.GetUser(c, bson.M{"_id": c.GetString("user_id")})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": gin.H{"message": err.Error() + ": you are not authorized to acess this resource"}})
+			return
+		}
+		c.Set("role", user.Role)
+		c.Next()
+	}
+}
+
+func (m *Middleware) checkAdminMFA(c *gin.Context, user *User) bool {
+	if user.Role != Admin {
+		return true
+	}
+	if user.TOTP != nil && user.TOTP.Enabled && !c.GetBool("mfa") {
+		abortWithError(c, apperrors.Forbidden(CodeMFARequired, "mfa step-up required", nil))
+		return false
+	}
+	if (user.TOTP == nil || !user.TOTP.Enabled) && m.requireAdminMFA {
+		abortWithError(c, apperrors.Forbidden(CodeMFAEnrollmentRequired, "mfa enrollment required", nil))
+		return false
+	}
+	return true
+}
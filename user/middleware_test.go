@@ -0,0 +1,73 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// roleRepo looks users up by ID in a fixed set.
+type roleRepo map[string]*User
+
+func (r roleRepo) GetUser(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*User, error) {
+	u, ok := r[filter.(bson.M)["_id"].(string)]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return u, nil
+}
+
+func serve(handlers ...gin.HandlerFunc) func(userId string) (int, string) {
+	gin.SetMode(gin.TestMode)
+	return func(userId string) (int, string) {
+		var role Role
+		r := gin.New()
+		r.Use(apperrors.ErrorHandler())
+		chain := append([]gin.HandlerFunc{func(c *gin.Context) { c.Set("user_id", userId) }}, handlers...)
+		chain = append(chain, func(c *gin.Context) {
+			role, _ = c.MustGet("role").(Role)
+			c.Status(http.StatusOK)
+		})
+		r.GET("/", chain...)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code, string(role)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	m := NewMiddleware("secret", roleRepo{
+		"author1": {ID: "author1", Role: Author},
+		"mod1":    {ID: "mod1", Role: Moderator},
+		"editor1": {ID: "editor1", Role: Editor},
+	}, nil, nil, false, false, nil)
+	do := serve(m.RequirePermission(PermEditOwnPost, PermEditAnyPost))
+	for userId, want := range map[string]int{
+		"author1": http.StatusOK,
+		"editor1": http.StatusOK,
+		"mod1":    http.StatusForbidden,
+		"gone":    http.StatusForbidden,
+	} {
+		if got, _ := do(userId); got != want {
+			t.Errorf("%s: status %d, want %d", userId, got, want)
+		}
+	}
+}
+
+func TestLoadRole(t *testing.T) {
+	m := NewMiddleware("secret", roleRepo{"mod1": {ID: "mod1", Role: Moderator}}, nil, nil, false, false, nil)
+	do := serve(m.LoadRole())
+	if code, role := do("mod1"); code != http.StatusOK || role != string(Moderator) {
+		t.Errorf("mod1: status %d, role %q; want 200 and moderator", code, role)
+	}
+	if code, _ := do("gone"); code != http.StatusForbidden {
+		t.Errorf("deleted user: status %d, want 403", code)
+	}
+}
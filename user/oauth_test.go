@@ -0,0 +1,244 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/oauth2"
+)
+
+// fakeGoogle is the token and userinfo endpoint of a Google stand-in. It
+// only issues tokens for the verifier matching challenge and puts nonce in
+// the ID token.
+type fakeGoogle struct {
+	*httptest.Server
+	challenge string
+	nonce     string
+}
+
+func newFakeGoogle(t *testing.T) *fakeGoogle {
+	t.Helper()
+	f := &fakeGoogle{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || codeChallengeS256(r.Form.Get("code_verifier")) != f.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss":   "https://accounts.google.com",
+			"aud":   "client-id",
+			"nonce": f.nonce,
+		}).SignedString([]byte("test"))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "google-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GoogleLoginResponse{ID: "g1", Email: "ada@example.com", VerifiedEmail: true, Name: "Ada"})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newOAuthService(t *testing.T, google *fakeGoogle) *UserService {
+	t.Helper()
+	us := NewUserService(nil, nil, nil, nil, nil, nil, nil, nil, ServiceConfig{
+		GoogleClientID:             "client-id",
+		SessionSecret:              "test-session-secret",
+		PostLoginRedirectAllowlist: []string{"https://app.example.com"},
+	})
+	us.googleOauthConfig.Endpoint = oauth2.Endpoint{AuthURL: google.URL + "/auth", TokenURL: google.URL + "/token"}
+	us.userInfoURL = google.URL + "/userinfo"
+	return us
+}
+
+// login starts a login and returns the authorization URL and the session
+// cookies.
+func login(t *testing.T, us *UserService, redirectTo string) (url.Values, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/login", nil)
+	authURL, err := us.Login(c, redirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query(), w.Result().Cookies()
+}
+
+func callback(us *UserService, state string, cookies []*http.Cookie) (*GoogleLoginResponse, string, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/callback?code=auth-code&state="+url.QueryEscape(state), nil)
+	for _, cookie := range cookies {
+		c.Request.AddCookie(cookie)
+	}
+	return us.Callback(c)
+}
+
+func TestOAuthLogin(t *testing.T) {
+	google := newFakeGoogle(t)
+	us := newOAuthService(t, google)
+	params, cookies := login(t, us, "https://app.example.com/welcome")
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" || params.Get("nonce") == "" {
+		t.Fatalf("authorization parameters = %v, want an S256 challenge and a nonce", params)
+	}
+	google.challenge, google.nonce = params.Get("code_challenge"), params.Get("nonce")
+
+	got, redirectTo, err := callback(us, params.Get("state"), cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "g1" || redirectTo != "https://app.example.com/welcome" {
+		t.Errorf("callback = %+v, %q; want user g1 sent to /welcome", got, redirectTo)
+	}
+}
+
+func TestOAuthCallbackRejectsWrongState(t *testing.T) {
+	google := newFakeGoogle(t)
+	us := newOAuthService(t, google)
+	params, cookies := login(t, us, "https://app.example.com/")
+	google.challenge, google.nonce = params.Get("code_challenge"), params.Get("nonce")
+	if _, _, err := callback(us, "forged", cookies); err == nil {
+		t.Error("callback with a forged state succeeded")
+	}
+}
+
+func TestOAuthCallbackRejectsVerifierMismatch(t *testing.T) {
+	google := newFakeGoogle(t)
+	us := newOAuthService(t, google)
+	first, _ := login(t, us, "https://app.example.com/")
+	second, cookies := login(t, us, "https://app.example.com/")
+	// The provider saw the first login's challenge, but the callback comes
+	// with the second login's session and so its verifier.
+	google.challenge, google.nonce = first.Get("code_challenge"), second.Get("nonce")
+	if _, _, err := callback(us, second.Get("state"), cookies); err == nil {
+		t.Error("callback with a mismatched PKCE verifier succeeded")
+	}
+}
+
+func TestOAuthCallbackRejectsNonceMismatch(t *testing.T) {
+	google := newFakeGoogle(t)
+	us := newOAuthService(t, google)
+	params, cookies := login(t, us, "https://app.example.com/")
+	google.challenge, google.nonce = params.Get("code_challenge"), "replayed-nonce"
+	if _, _, err := callback(us, params.Get("state"), cookies); err == nil {
+		t.Error("callback with an ID token for another nonce succeeded")
+	}
+}
+
+func TestOAuthLoginRejectsRedirectOutsideAllowlist(t *testing.T) {
+	us := newOAuthService(t, newFakeGoogle(t))
+	for _, redirectTo := range []string{
+		"",
+		"https://evil.example.com/",
+		"https://app.example.com.evil.example.com/",
+		"https://app.example.com@evil.example.com/",
+		"http://app.example.com/",
+		"javascript://app.example.com/%0aalert(1)",
+		"/relative",
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/login", nil)
+		if _, err := us.Login(c, redirectTo); err == nil {
+			t.Errorf("Login(%q) succeeded, want it rejected", redirectTo)
+		}
+	}
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// RFC 7636, appendix B.
+	if got := codeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("challenge = %s", got)
+	}
+	v1, _ := generateCodeVerifier()
+	v2, _ := generateCodeVerifier()
+	if len(v1) < 43 || v1 == v2 {
+		t.Errorf("verifiers %q and %q, want distinct and at least 43 characters", v1, v2)
+	}
+}
+
+type loginRecorder struct {
+	noopRecorder
+	logins int
+}
+
+func (r *loginRecorder) LoggedIn(method string) { r.logins++ }
+
+// linkRepo holds the one account SaveUser finds by email.
+type linkRepo struct {
+	UserRepository
+	existing *User
+	updated  bool
+}
+
+func (r *linkRepo) GetUser(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*User, error) {
+	if r.existing == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	u := *r.existing
+	return &u, nil
+}
+
+func (r *linkRepo) UpdateUser(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	r.updated = true
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func TestSaveUserLinksOnlyVerifiedMatchingIdentities(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing User
+		google   GoogleLoginResponse
+		linked   bool
+	}{
+		{"verified email", User{ID: "u1"}, GoogleLoginResponse{ID: "g1", VerifiedEmail: true}, true},
+		{"same identity", User{ID: "u1", GoogleId: "g1"}, GoogleLoginResponse{ID: "g1", VerifiedEmail: true}, true},
+		{"unverified email", User{ID: "u1"}, GoogleLoginResponse{ID: "g1"}, false},
+		{"other identity", User{ID: "u1", GoogleId: "g2"}, GoogleLoginResponse{ID: "g1", VerifiedEmail: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &linkRepo{existing: &tt.existing}
+			recorder := &loginRecorder{}
+			us := &UserService{repo: repo, recorder: recorder, clock: clock.System{}}
+			tt.google.Email = "ada@example.com"
+			u, err := us.SaveUser(context.Background(), &tt.google)
+			if !tt.linked {
+				var appErr *apperrors.AppError
+				if !errors.As(err, &appErr) || appErr.Code != CodeAccountLinkRefused || repo.updated {
+					t.Errorf("SaveUser = %v, updated %v; want the link refused", err, repo.updated)
+				}
+				return
+			}
+			if err != nil || u.ID != "u1" || u.GoogleId != "g1" || recorder.logins != 1 {
+				t.Errorf("SaveUser = %+v, %v with %d logins; want u1 linked to g1 and one login", u, err, recorder.logins)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	PermCreatePost       Permission = "posts:create"
+	PermEditOwnPost      Permission = "posts:edit:own"
+	PermEditAnyPost      Permission = "posts:edit:any"
+	PermDeleteOwnPost    Permission = "posts:delete:own"
+	PermDeleteAnyPost    Permission = "posts:delete:any"
+	PermModerateComments Permission = "comments:moderate"
+	PermManageUsers      Permission = "users:manage"
+	PermEditAbout        Permission = "about:edit"
+	PermReadMailingList  Permission = "mailing-list:read"
+)
+
+var rolePermissions = map[Role][]Permission{
+	Admin: {
+		PermCreatePost, PermEditOwnPost, PermEditAnyPost, PermDeleteOwnPost, PermDeleteAnyPost,
+		PermModerateComments, PermManageUsers, PermEditAbout, PermReadMailingList,
+	},
+	Editor: {
+		PermCreatePost, PermEditOwnPost, PermEditAnyPost, PermDeleteOwnPost, PermDeleteAnyPost,
+		PermModerateComments,
+	},
+	Author:    {PermCreatePost, PermEditOwnPost, PermDeleteOwnPost},
+	Moderator: {PermModerateComments},
+	Reader:    {},
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", errors.New("unknown role: " + s)
+	}
+	return role, nil
+}
+
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+func (r Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions returns the full role to permission matrix.
+func RolePermissions() map[Role][]Permission {
+	matrix := make(map[Role][]Permission, len(rolePermissions))
+	for role, perms := range rolePermissions {
+		matrix[role] = append([]Permission{}, perms...)
+	}
+	return matrix
+}
+
+// HasPermission reports whether the authenticated user's role grants perm,
+// narrowed to the key's scopes for API key requests. It relies on the role
+// set by Authorization, RequirePermission or LoadRole.
+func HasPermission(c *gin.Context, perm Permission) bool {
+	role, ok := c.Get("role")
+	if !ok {
+		return false
+	}
+	r, ok := role.(Role)
+	if !ok || !r.Can(perm) {
+		return false
+	}
+	if scopes, isKey := requestScopes(c); isKey {
+		return scopesAllow(scopes, perm)
+	}
+	return true
+}
+
+// IsAPIKey reports whether the request was authenticated with an API key.
+func IsAPIKey(c *gin.Context) bool {
+	_, isKey := requestScopes(c)
+	return isKey
+}
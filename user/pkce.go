@@ -0,0 +1,85 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+func generateRandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func generateCodeVerifier() (string, error) {
+	return generateRandomString(32)
+}
+
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyIDTokenNonce checks the claims of an ID token received directly from
+// Google's token endpoint over TLS, so the signature is not verified here.
+func verifyIDTokenNonce(idToken, clientId, nonce string) error {
+	if idToken == "" {
+		return errors.New("id token is missing")
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		return errors.New("invalid id token: " + err.Error())
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return errors.New("invalid id token: nonce mismatch")
+	}
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return errors.New("invalid id token: " + err.Error())
+	}
+	if !containsString(aud, clientId) {
+		return errors.New("invalid id token: audience mismatch")
+	}
+	iss, _ := claims.GetIssuer()
+	if !containsString(googleIssuers, iss) {
+		return errors.New("invalid id token: issuer mismatch")
+	}
+	return nil
+}
+
+// validateRedirect returns redirectTo if its origin is in the allowlist.
+func validateRedirect(redirectTo string, allowlist []string) (string, error) {
+	u, err := url.Parse(redirectTo)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", errors.New("invalid redirect url")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", errors.New("invalid redirect url")
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range allowlist {
+		if strings.TrimSuffix(strings.ToLower(allowed), "/") == origin {
+			return u.String(), nil
+		}
+	}
+	return "", errors.New("redirect url is not allowed")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,18 +2,34 @@ package user
 
 import (
 	"context"
+	"errors"
+
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepo struct {
-	collection *mongo.Collection
+	collection db.Collection
 }
 
-func NewUserRepo(collection *mongo.Collection) *UserRepo {
+func NewUserRepo(collection db.Collection) *UserRepo {
 	return &UserRepo{collection}
 }
 
+func InitAuthorSlugIndex(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"profile.slug": 1},
+		Options: options.Index().SetName("author_slug_index").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"profile.slug": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return errors.New("Error creating author slug index for user collection: " + err.Error())
+	}
+	return nil
+}
+
 func (repo *UserRepo) IsExists(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (bool, error) {
 	err := repo.collection.FindOne(ctx, filter, opts...).Err()
 	if err != nil {
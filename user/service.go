@@ -3,16 +3,21 @@ package user
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"net/http"
-	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
+	"github.com/gosimple/slug"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/oauth2"
@@ -24,14 +29,108 @@ type UserService struct {
 	store             *sessions.CookieStore
 	googleOauthConfig *oauth2.Config
 	tokenMgr          TokenMgr
+	loginCodes        LoginCodes
+	emailLogin        EmailLogin
+	apiKeys           APIKeys
+	redirectURL       string
+	redirectAllowlist []string
+	userInfoURL       string
+	adminEmail        string
+	cookieAuth        bool
+	recorder          Recorder
+	cache             Invalidator
+	notifier          Notifier
+	clock             clock.Clock
+}
+
+const googleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// ServiceConfig carries the OAuth and login settings UserService needs.
+type ServiceConfig struct {
+	GoogleClientID             string
+	GoogleClientSecret         string
+	GoogleRedirectURL          string
+	SessionSecret              string
+	AdminEmail                 string
+	PostLoginRedirectURL       string
+	PostLoginRedirectAllowlist []string
+	// CookieAuth hands out access tokens in cookies as well; see
+	// SetAuthCookies.
+	CookieAuth bool
+	Clock      clock.Clock
+}
+
+// Recorder receives account events, for example to count them in metrics.
+type Recorder interface {
+	LoggedIn(method string)
+	Subscription(action string)
+}
+
+type noopRecorder struct{}
+
+func (noopRecorder) LoggedIn(method string)     {}
+func (noopRecorder) Subscription(action string) {}
+
+// Cache tags of the responses built from user data: author bylines, which
+// are embedded in posts, and the about page.
+const (
+	TagAuthors = "authors"
+	TagAbout   = "about"
+)
+
+// Invalidator drops cached responses when the data behind them changes.
+type Invalidator interface {
+	Invalidate(ctx context.Context, tags ...string)
+}
+
+type noopInvalidator struct{}
+
+func (noopInvalidator) Invalidate(ctx context.Context, tags ...string) {}
+
+// Events sent to the Notifier. They carry the user's id and name, never
+// their email.
+const (
+	EventUserCreated      = "user.created"
+	EventUserSubscribed   = "user.subscribed"
+	EventUserUnsubscribed = "user.unsubscribed"
+)
+
+// Events lists every event UserService sends.
+var Events = []string{EventUserCreated, EventUserSubscribed, EventUserUnsubscribed}
+
+// Notifier tells outside systems, such as webhook subscribers, about
+// account changes.
+type Notifier interface {
+	Notify(ctx context.Context, event string, data interface{})
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(ctx context.Context, event string, data interface{}) {}
+
+type LoginCodes interface {
+	IssueLoginCode(ctx context.Context, userId string) (string, error)
+	ConsumeLoginCode(ctx context.Context, code string) (string, error)
+}
+
+type APIKeys interface {
+	CreateAPIKey(ctx context.Context, name, userId string, scopes []Scope, expiresAt *time.Time) (*APIKey, string, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id primitive.ObjectID) error
+}
+
+type EmailLogin interface {
+	SendLoginLink(ctx context.Context, email, ip string) error
+	VerifyLoginToken(ctx context.Context, token string) (string, error)
 }
 
 type TokenMgr interface {
 	SaveToken(ctx context.Context, userId string, td *TokenDetails) error
-	GenerateToken(userId string) (*TokenDetails, error)
+	GenerateToken(userId string, mfa bool) (*TokenDetails, error)
 	FindToken(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*AccessDetails, error)
 	IsExists(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (bool, error)
 	DeleteToken(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) error
+	CSRFToken(accessUuid string) string
 }
 
 type UserRepository interface {
@@ -49,71 +148,164 @@ type UserRepository interface {
 	UpdateMailingList(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 }
 
-func NewUserService(repo UserRepository, tokenMgr TokenMgr) *UserService {
+// NewUserService returns the account service. recorder, cache and notifier
+// may be nil.
+func NewUserService(repo UserRepository, tokenMgr TokenMgr, loginCodes LoginCodes, emailLogin EmailLogin, apiKeys APIKeys, recorder Recorder, cache Invalidator, notifier Notifier, cfg ServiceConfig) *UserService {
+	if recorder == nil {
+		recorder = noopRecorder{}
+	}
+	if cache == nil {
+		cache = noopInvalidator{}
+	}
+	if notifier == nil {
+		notifier = noopNotifier{}
+	}
 	googleOauthConfig := &oauth2.Config{
-		RedirectURL:  os.Getenv("REDIRECT_URL"),
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+		RedirectURL:  cfg.GoogleRedirectURL,
+		ClientID:     cfg.GoogleClientID,
+		ClientSecret: cfg.GoogleClientSecret,
+		Scopes:       []string{"openid", "https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
 		Endpoint:     google.Endpoint,
 	}
-	store := sessions.NewCookieStore([]byte(os.Getenv("SESSION_SECRET")))
-
+	store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+	store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
 	return &UserService{
 		repo:              repo,
 		store:             store,
 		googleOauthConfig: googleOauthConfig,
 		tokenMgr:          tokenMgr,
+		loginCodes:        loginCodes,
+		emailLogin:        emailLogin,
+		apiKeys:           apiKeys,
+		redirectURL:       cfg.PostLoginRedirectURL,
+		redirectAllowlist: cfg.PostLoginRedirectAllowlist,
+		userInfoURL:       googleUserInfoURL,
+		adminEmail:        cfg.AdminEmail,
+		cookieAuth:        cfg.CookieAuth,
+		clock:             clock.OrSystem(cfg.Clock),
+		recorder:          recorder,
+		cache:             cache,
+		notifier:          notifier,
 	}
 }
 func generateRandomState() string {
 	return uuid.New().String()
 }
-func (us *UserService) Login(ctx *gin.Context) (string, error) {
+
+// Login starts the authorization code flow with PKCE (S256) and an OIDC nonce.
+// redirectTo is where the user is sent with a one-time login code after the
+// callback; it falls back to the default post-login redirect when empty.
+func (us *UserService) Login(ctx *gin.Context, redirectTo string) (string, error) {
+	if redirectTo == "" {
+		redirectTo = us.redirectURL
+	}
+	if redirectTo == "" {
+		return "", apperrors.BadRequest(CodeInvalidRedirect, "no post-login redirect url is configured", nil)
+	}
+	redirectTo, err := validateRedirect(redirectTo, us.redirectAllowlist)
+	if err != nil {
+		return "", apperrors.BadRequest(CodeInvalidRedirect, err.Error(), err)
+	}
 	state := generateRandomState()
+	verifier, err := generateCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateRandomString(16)
+	if err != nil {
+		return "", err
+	}
 	session, err := us.store.New(ctx.Request, "session-name")
 	if err != nil {
 		return "", err
 	}
 	session.Values["state"] = state
+	session.Values["code_verifier"] = verifier
+	session.Values["nonce"] = nonce
+	session.Values["redirect_to"] = redirectTo
 	if err = session.Save(ctx.Request, ctx.Writer); err != nil {
 		return "", err
 	}
-	return us.googleOauthConfig.AuthCodeURL(state), nil
+	return us.googleOauthConfig.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", codeChallengeS256(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	), nil
 }
 
-func (us *UserService) Callback(ctx *gin.Context) (*GoogleLoginResponse, error) {
+// Callback completes the authorization code flow and returns the Google user
+// together with the post-login redirect chosen at Login.
+func (us *UserService) Callback(ctx *gin.Context) (*GoogleLoginResponse, string, error) {
 	session, err := us.getSession(ctx)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	retrievedState, ok := session.Values["state"].(string)
 	if !ok || retrievedState != ctx.Request.URL.Query().Get("state") {
-		return nil, errors.New("unable to retrieve state")
+		return nil, "", apperrors.BadRequest(CodeInvalidOAuthState, "unable to retrieve state", nil)
+	}
+	verifier, ok := session.Values["code_verifier"].(string)
+	if !ok || verifier == "" {
+		return nil, "", apperrors.BadRequest(CodeInvalidOAuthState, "unable to retrieve code verifier", nil)
+	}
+	nonce, ok := session.Values["nonce"].(string)
+	if !ok || nonce == "" {
+		return nil, "", apperrors.BadRequest(CodeInvalidOAuthState, "unable to retrieve nonce", nil)
+	}
+	redirectTo, ok := session.Values["redirect_to"].(string)
+	if !ok || redirectTo == "" {
+		return nil, "", apperrors.BadRequest(CodeInvalidOAuthState, "unable to retrieve redirect url", nil)
 	}
 	session.Options.MaxAge = -1
 	if err = session.Save(ctx.Request, ctx.Writer); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	token, err := us.googleOauthConfig.Exchange(ctx, ctx.Request.URL.Query().Get("code"))
+	token, err := us.googleOauthConfig.Exchange(ctx, ctx.Request.URL.Query().Get("code"), oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
-		return nil, err
+		return nil, "", apperrors.Unauthorized(CodeOAuthFailed, "unable to exchange authorization code", err)
+	}
+	idToken, _ := token.Extra("id_token").(string)
+	if err := verifyIDTokenNonce(idToken, us.googleOauthConfig.ClientID, nonce); err != nil {
+		return nil, "", apperrors.Unauthorized(CodeOAuthFailed, "invalid id token", err)
 	}
 	client := us.googleOauthConfig.Client(ctx, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token.AccessToken)
+	resp, err := client.Get(us.userInfoURL + "?access_token=" + token.AccessToken)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("unable to retrieve user info")
+		return nil, "", apperrors.New(CodeOAuthFailed, "unable to retrieve user info", http.StatusBadGateway, nil)
 	}
 
 	googleResponse := &GoogleLoginResponse{}
 	if err := json.NewDecoder(resp.Body).Decode(&googleResponse); err != nil {
+		return nil, "", err
+	}
+	return googleResponse, redirectTo, nil
+}
+
+func (us *UserService) IssueLoginCode(ctx context.Context, userId string) (string, error) {
+	return us.loginCodes.IssueLoginCode(ctx, userId)
+}
+
+// ExchangeLoginCode consumes a one-time login code and returns the user it
+// was issued for. The caller mints the access token.
+func (us *UserService) ExchangeLoginCode(ctx context.Context, code string) (*User, error) {
+	userId, err := us.loginCodes.ConsumeLoginCode(ctx, code)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, apperrors.Unauthorized(CodeInvalidLoginCode, "invalid or expired login code", err)
+		}
 		return nil, err
 	}
-	return googleResponse, nil
+	return us.repo.GetUser(ctx, bson.M{"_id": userId})
 }
 
 func (us *UserService) getSession(ctx *gin.Context) (*sessions.Session, error) {
@@ -131,8 +323,8 @@ type GoogleLoginResponse struct {
 	Locale        string `json:"locale"`
 }
 
-func (us *UserService) GenerateAccessToken(userId string) (*TokenDetails, error) {
-	td, err := us.tokenMgr.GenerateToken(userId)
+func (us *UserService) GenerateAccessToken(userId string, mfa bool) (*TokenDetails, error) {
+	td, err := us.tokenMgr.GenerateToken(userId, mfa)
 	if err != nil {
 		return nil, err
 	}
@@ -152,33 +344,100 @@ func (us *UserService) SaveAccessToken(ctx context.Context, userId string, td *T
 	return us.tokenMgr.SaveToken(ctx, userId, td)
 }
 
-func (us *UserService) SaveUser(ctx context.Context, googleLoginResponse *GoogleLoginResponse) error {
-	role := Admin
-	if googleLoginResponse.Email != os.Getenv("ADMIN_EMAIL") {
-		role = Reader
+func (us *UserService) roleForEmail(email string) Role {
+	if us.adminEmail != "" && strings.EqualFold(email, us.adminEmail) {
+		return Admin
+	}
+	return Reader
+}
+
+func emailFilter(email string) bson.M {
+	return bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(email) + "$", Options: "i"}}
+}
+
+// SaveUser creates the user for a Google login, or links the Google identity
+// to an existing account with the same email, and returns the stored user.
+// Google must have verified the email, and an account already linked to
+// another Google identity is never taken over.
+func (us *UserService) SaveUser(ctx context.Context, googleLoginResponse *GoogleLoginResponse) (*User, error) {
+	existing, err := us.repo.GetUser(ctx, emailFilter(googleLoginResponse.Email))
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if existing != nil {
+		if !googleLoginResponse.VerifiedEmail || (existing.GoogleId != "" && existing.GoogleId != googleLoginResponse.ID) {
+			return nil, apperrors.Forbidden(CodeAccountLinkRefused, "this google account cannot sign in to the existing account for its email", nil)
+		}
+		if existing.GoogleId == "" {
+			existing.GoogleId = googleLoginResponse.ID
+			existing.IsVerified = true
+			existing.UpdatedAt = us.clock.Now()
+			if _, err := us.repo.UpdateUser(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": bson.M{"google_id": existing.GoogleId, "is_verified": existing.IsVerified, "updated_at": existing.UpdatedAt}}); err != nil {
+				return nil, err
+			}
+		}
+		us.recorder.LoggedIn("google")
+		return existing, nil
 	}
 	user := &User{
 		ID:         googleLoginResponse.ID,
 		Name:       googleLoginResponse.Name,
 		Email:      googleLoginResponse.Email,
+		GoogleId:   googleLoginResponse.ID,
 		IsVerified: googleLoginResponse.VerifiedEmail,
-		Role:       role,
+		Role:       us.roleForEmail(googleLoginResponse.Email),
 		Picture:    googleLoginResponse.Picture,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		CreatedAt:  us.clock.Now(),
+		UpdatedAt:  us.clock.Now(),
 	}
-	exists, err := us.repo.IsExists(ctx, bson.M{"email": user.Email})
+	if _, err = us.repo.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	us.recorder.LoggedIn("google")
+	us.notifyUser(ctx, EventUserCreated, user)
+	return user, nil
+}
+
+func (us *UserService) RequestEmailLogin(ctx context.Context, email, ip string) error {
+	return us.emailLogin.SendLoginLink(ctx, email, ip)
+}
+
+// VerifyEmailLogin consumes a magic link and returns the user it logs in,
+// creating the account on first use.
+func (us *UserService) VerifyEmailLogin(ctx context.Context, token string) (*User, error) {
+	email, err := us.emailLogin.VerifyLoginToken(ctx, token)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if exists {
-		return nil
+	existing, err := us.repo.GetUser(ctx, emailFilter(email))
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
 	}
-	_, err = us.repo.CreateUser(ctx, user)
-	if err != nil {
-		return err
+	if existing != nil {
+		if !existing.IsVerified {
+			existing.IsVerified = true
+			if _, err := us.repo.UpdateUser(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": bson.M{"is_verified": true, "updated_at": us.clock.Now()}}); err != nil {
+				return nil, err
+			}
+		}
+		us.recorder.LoggedIn("email")
+		return existing, nil
 	}
-	return nil
+	user := &User{
+		ID:         uuid.New().String(),
+		Name:       strings.Split(email, "@")[0],
+		Email:      email,
+		IsVerified: true,
+		Role:       us.roleForEmail(email),
+		CreatedAt:  us.clock.Now(),
+		UpdatedAt:  us.clock.Now(),
+	}
+	if _, err = us.repo.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	us.recorder.LoggedIn("email")
+	us.notifyUser(ctx, EventUserCreated, user)
+	return user, nil
 }
 
 func (us *UserService) GetUsers(ctx context.Context) ([]*User, error) {
@@ -201,12 +460,244 @@ func (us *UserService) GetUsers(ctx context.Context) ([]*User, error) {
 	return users, nil
 }
 
+// EnrollTOTP starts TOTP enrollment and returns the secret with its
+// otpauth:// provisioning URI. The factor stays disabled until confirmed.
+func (us *UserService) EnrollTOTP(ctx context.Context, userId string) (string, string, error) {
+	user, err := us.repo.GetUser(ctx, bson.M{"_id": userId})
+	if err != nil {
+		return "", "", apperrors.NotFoundOr(err, CodeUserNotFound, "user not found")
+	}
+	if user.TOTP != nil && user.TOTP.Enabled {
+		return "", "", apperrors.Conflict(CodeTOTPAlreadyEnabled, "totp is already enabled", nil)
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := us.repo.UpdateUser(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"totp": &TOTPConfig{Secret: secret}, "updated_at": us.clock.Now()}}); err != nil {
+		return "", "", err
+	}
+	return secret, totpProvisioningURI("bloggy", user.Email, secret), nil
+}
+
+// ConfirmTOTP enables the pending factor once the user proves they can
+// generate codes, and returns the plaintext recovery codes.
+func (us *UserService) ConfirmTOTP(ctx context.Context, userId, code string) ([]string, error) {
+	user, err := us.repo.GetUser(ctx, bson.M{"_id": userId})
+	if err != nil {
+		return nil, apperrors.NotFoundOr(err, CodeUserNotFound, "user not found")
+	}
+	if user.TOTP == nil || user.TOTP.Secret == "" {
+		return nil, apperrors.BadRequest(CodeTOTPNotEnabled, "totp enrollment has not been started", nil)
+	}
+	if user.TOTP.Enabled {
+		return nil, apperrors.Conflict(CodeTOTPAlreadyEnabled, "totp is already enabled", nil)
+	}
+	step, ok := validateTOTP(user.TOTP.Secret, code, us.clock.Now())
+	if !ok {
+		return nil, apperrors.BadRequest(CodeInvalidMFACode, "invalid totp code", nil)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = us.repo.UpdateUser(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{
+		"totp.enabled":        true,
+		"totp.recovery_codes": hashes,
+		"totp.last_used_step": step,
+		"updated_at":          us.clock.Now(),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyMFA checks a TOTP code or a single-use recovery code.
+func (us *UserService) VerifyMFA(ctx context.Context, userId, code string) error {
+	user, err := us.repo.GetUser(ctx, bson.M{"_id": userId})
+	if err != nil {
+		return apperrors.NotFoundOr(err, CodeUserNotFound, "user not found")
+	}
+	if user.TOTP == nil || !user.TOTP.Enabled {
+		return apperrors.BadRequest(CodeTOTPNotEnabled, "totp is not enabled", nil)
+	}
+	// The checks live in the update filters so that two requests racing with
+	// the same code cannot both succeed.
+	if step, ok := validateTOTP(user.TOTP.Secret, code, us.clock.Now()); ok {
+		res, err := us.repo.UpdateUser(ctx, bson.M{"_id": userId, "totp.last_used_step": bson.M{"$lt": step}}, bson.M{"$set": bson.M{"totp.last_used_step": step}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			return apperrors.Unauthorized(CodeInvalidMFACode, "totp code has already been used", nil)
+		}
+		return nil
+	}
+	hash := hashRecoveryCode(code)
+	res, err := us.repo.UpdateUser(ctx, bson.M{"_id": userId, "totp.recovery_codes": hash}, bson.M{"$pull": bson.M{"totp.recovery_codes": hash}})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return apperrors.Unauthorized(CodeInvalidMFACode, "invalid mfa code", nil)
+	}
+	return nil
+}
+
+func (us *UserService) DisableTOTP(ctx context.Context, userId, code string) error {
+	if err := us.VerifyMFA(ctx, userId, code); err != nil {
+		return err
+	}
+	_, err := us.repo.UpdateUser(ctx, bson.M{"_id": userId}, bson.M{"$unset": bson.M{"totp": ""}, "$set": bson.M{"updated_at": us.clock.Now()}})
+	return err
+}
+
+func (us *UserService) AssignRole(ctx context.Context, userId string, role Role) (*User, error) {
+	res, err := us.repo.UpdateUser(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"role": role, "updated_at": us.clock.Now()}})
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, apperrors.NotFound(CodeUserNotFound, "user not found", nil)
+	}
+	us.cache.Invalidate(ctx, TagAuthors, TagAbout)
+	return us.repo.GetUser(ctx, bson.M{"_id": userId})
+}
+
+var authorRoles = []Role{Admin, Editor, Author}
+
+// UpdateAuthorProfile saves the public profile of userId. The slug is derived
+// from the requested slug or the user's name and made unique.
+func (us *UserService) UpdateAuthorProfile(ctx context.Context, userId string, profile *AuthorProfile) (*Byline, error) {
+	user, err := us.repo.GetUser(ctx, bson.M{"_id": userId})
+	if err != nil {
+		return nil, apperrors.NotFoundOr(err, CodeUserNotFound, "user not found")
+	}
+	base := profile.Slug
+	if base == "" && user.Profile != nil {
+		base = user.Profile.Slug
+	}
+	if base == "" {
+		base = user.Name
+	}
+	base = slug.Make(base)
+	if base == "" {
+		base = "author"
+	}
+	profile.Slug = base
+	for i := 2; ; i++ {
+		exists, err := us.repo.IsExists(ctx, bson.M{"profile.slug": profile.Slug, "_id": bson.M{"$ne": userId}})
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+		profile.Slug = base + "-" + strconv.Itoa(i)
+	}
+	if _, err := us.repo.UpdateUser(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"profile": profile, "updated_at": us.clock.Now()}}); err != nil {
+		return nil, err
+	}
+	us.cache.Invalidate(ctx, TagAuthors)
+	user.Profile = profile
+	return user.Byline(), nil
+}
+
+func (us *UserService) GetAuthors(ctx context.Context) ([]*Byline, error) {
+	users, err := us.repo.GetUsers(ctx, bson.M{"role": bson.M{"$in": authorRoles}, "profile.slug": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	authors := make([]*Byline, 0, len(users))
+	for _, u := range users {
+		authors = append(authors, u.Byline())
+	}
+	return authors, nil
+}
+
+func (us *UserService) GetAuthorBySlug(ctx context.Context, slug string) (*Byline, error) {
+	user, err := us.repo.GetUser(ctx, bson.M{"role": bson.M{"$in": authorRoles}, "profile.slug": slug})
+	if err != nil {
+		return nil, err
+	}
+	return user.Byline(), nil
+}
+
+// GetAuthorsByIds returns the authors for ids keyed by user id. Unknown ids
+// are left out.
+func (us *UserService) GetAuthorsByIds(ctx context.Context, ids []string) (map[string]*Byline, error) {
+	authors := make(map[string]*Byline, len(ids))
+	if len(ids) == 0 {
+		return authors, nil
+	}
+	users, err := us.repo.GetUsers(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		authors[u.ID] = u.Byline()
+	}
+	return authors, nil
+}
+
+func (us *UserService) CreateAPIKey(ctx context.Context, name, userId string, scopes []Scope, expiresAt *time.Time) (*APIKey, string, error) {
+	if expiresAt != nil && !expiresAt.After(us.clock.Now()) {
+		return nil, "", apperrors.BadRequest(apperrors.CodeValidation, "expiry must be in the future", nil)
+	}
+	return us.apiKeys.CreateAPIKey(ctx, name, userId, scopes, expiresAt)
+}
+
+func (us *UserService) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	return us.apiKeys.ListAPIKeys(ctx)
+}
+
+func (us *UserService) RevokeAPIKey(ctx context.Context, idStr string) error {
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return apperrors.BadRequest(apperrors.CodeInvalidID, "invalid id", err)
+	}
+	return apperrors.NotFoundOr(us.apiKeys.RevokeAPIKey(ctx, id), CodeAPIKeyNotFound, "api key not found")
+}
+
 func (us *UserService) Logout(ctx context.Context, accessUuid string) error {
 	return us.tokenMgr.DeleteToken(ctx, bson.M{"access_uuid": accessUuid})
 }
 
+// GetUserByEmail looks a user up by email, ignoring case.
+func (us *UserService) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := us.repo.GetUser(ctx, emailFilter(email))
+	if err != nil {
+		return nil, apperrors.NotFoundOr(err, CodeUserNotFound, "user not found")
+	}
+	return user, nil
+}
+
+// RevokeTokens deletes every stored access token of userId and returns how
+// many were removed.
+func (us *UserService) RevokeTokens(ctx context.Context, userId string) (int, error) {
+	revoked := 0
+	for {
+		exists, err := us.tokenMgr.IsExists(ctx, bson.M{"user_id": userId})
+		if err != nil {
+			return revoked, err
+		}
+		if !exists {
+			return revoked, nil
+		}
+		if err := us.tokenMgr.DeleteToken(ctx, bson.M{"user_id": userId}); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+}
+
 func (us *UserService) Profile(ctx context.Context, userId string) (*User, error) {
-	return us.repo.GetUser(ctx, bson.M{"_id": userId})
+	user, err := us.repo.GetUser(ctx, bson.M{"_id": userId})
+	if err != nil {
+		return nil, apperrors.NotFoundOr(err, CodeUserNotFound, "user not found")
+	}
+	return user, nil
 }
 
 func (us *UserService) UpdateAboutMe(ctx context.Context, userId, aboutMe, profilePicture string) error {
@@ -215,29 +706,26 @@ func (us *UserService) UpdateAboutMe(ctx context.Context, userId, aboutMe, profi
 		return err
 	}
 	if !exists {
-		_, err := us.repo.CreateAboutMe(ctx, bson.M{"_id": "profile_picture" + userId, "about_me": aboutMe, "profile_picture": profilePicture, "updated_at": time.Now()})
+		_, err := us.repo.CreateAboutMe(ctx, bson.M{"_id": "profile_picture" + userId, "about_me": aboutMe, "profile_picture": profilePicture, "updated_at": us.clock.Now()})
 		if err != nil {
 			return err
 		}
 	}
-	_, err = us.repo.UpdateUser(ctx, bson.M{"_id": "profile_picture" + userId}, bson.M{"$set": bson.M{"about_me": aboutMe, "profile_picture": profilePicture, "updated_at": time.Now()}})
-	return err
+	if _, err = us.repo.UpdateUser(ctx, bson.M{"_id": "profile_picture" + userId}, bson.M{"$set": bson.M{"about_me": aboutMe, "profile_picture": profilePicture, "updated_at": us.clock.Now()}}); err != nil {
+		return err
+	}
+	us.cache.Invalidate(ctx, TagAbout)
+	return nil
 }
 
 func (us *UserService) GetAboutMe(ctx context.Context) (*AboutMe, error) {
 	admin, err := us.repo.GetUser(ctx, bson.M{"role": Admin})
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil
-		}
-		return nil, err
+		return nil, apperrors.NotFoundOr(err, CodeAboutNotFound, "about me has not been set")
 	}
 	aboutMe, err := us.repo.GetAboutMe(ctx, bson.M{"_id": "profile_picture" + admin.ID})
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil
-		}
-		return nil, err
+		return nil, apperrors.NotFoundOr(err, CodeAboutNotFound, "about me has not been set")
 	}
 	return aboutMe, nil
 }
@@ -256,21 +744,28 @@ func (us *UserService) SubscribeToMailingList(ctx context.Context, id string) er
 			{
 				Email:     user.Email,
 				Name:      user.Name,
-				CreatedAt: time.Now(),
+				CreatedAt: us.clock.Now(),
 			},
 		}})
 		if err != nil {
 			return err
 		}
+		us.recorder.Subscription("subscribe")
+		us.notifyUser(ctx, EventUserSubscribed, user)
 		return nil
 	}
 	for _, subscriber := range mailingList.Subscribers {
 		if subscriber.Email == user.Email {
-			return errors.New("user is already subscribed to mailing list")
+			return apperrors.Conflict(CodeAlreadySubscribed, "user is already subscribed to mailing list", nil)
 		}
 	}
-	_, err = us.repo.UpdateMailingList(ctx, bson.M{"name": "mailing_list"}, bson.M{"$push": bson.M{"subscribers": bson.M{"email": user.Email, "name": user.Name, "created_at": time.Now()}}})
-	return err
+	_, err = us.repo.UpdateMailingList(ctx, bson.M{"name": "mailing_list"}, bson.M{"$push": bson.M{"subscribers": bson.M{"email": user.Email, "name": user.Name, "created_at": us.clock.Now()}}})
+	if err != nil {
+		return err
+	}
+	us.recorder.Subscription("subscribe")
+	us.notifyUser(ctx, EventUserSubscribed, user)
+	return nil
 }
 
 func (us *UserService) GetMailingList(ctx context.Context) (*MailingList, error) {
@@ -294,7 +789,7 @@ func (us *UserService) UnSubscribeFromMailingList(ctx context.Context, id string
 		return err
 	}
 	if mailingList == nil {
-		return errors.New("user is not subscribed to mailing list")
+		return apperrors.Conflict(CodeNotSubscribed, "user is not subscribed to mailing list", nil)
 	}
 
 	removeSubscriber := false
@@ -305,8 +800,17 @@ func (us *UserService) UnSubscribeFromMailingList(ctx context.Context, id string
 		}
 	}
 	if !removeSubscriber {
-		return errors.New("user is not subscribed to mailing list")
+		return apperrors.Conflict(CodeNotSubscribed, "user is not subscribed to mailing list", nil)
 	}
 	_, err = us.repo.UpdateMailingList(ctx, bson.M{"name": "mailing_list"}, bson.M{"$pull": bson.M{"subscribers": bson.M{"email": user.Email}}})
-	return err
+	if err != nil {
+		return err
+	}
+	us.recorder.Subscription("unsubscribe")
+	us.notifyUser(ctx, EventUserUnsubscribed, user)
+	return nil
+}
+
+func (us *UserService) notifyUser(ctx context.Context, event string, user *User) {
+	us.notifier.Notify(ctx, event, bson.M{"id": user.ID, "name": user.Name, "role": user.Role})
 }
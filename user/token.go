@@ -5,6 +5,8 @@ import (
 	"errors"
 	"time"
 
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,26 +19,30 @@ type TokenDetails struct {
 	AccessToken string `json:"access_token"`
 	AcessUuid   string `json:"-"`
 	AtExpires   int64  `json:"at_expires"`
+	Mfa         bool   `json:"mfa"`
 }
 
 type AccessDetails struct {
 	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	AccessUuid string             `json:"access_uuid" bson:"access_uuid"`
 	UserId     string             `json:"user_id" bson:"user_id"`
+	Mfa        bool               `json:"mfa" bson:"mfa"`
 	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
 }
 
 type TokenManager struct {
-	accessTokenSecret           string
-	accessTokenValidaityInHours int64
-	collection                  *mongo.Collection
+	accessTokenSecret   string
+	accessTokenValidity time.Duration
+	collection          db.Collection
+	clock               clock.Clock
 }
 
-func NewTokenManager(accessTokenSecret string, accessTokenValidaityInHours int64, collection *mongo.Collection) *TokenManager {
+func NewTokenManager(accessTokenSecret string, accessTokenValidity time.Duration, collection db.Collection, clk clock.Clock) *TokenManager {
 	tm := &TokenManager{
-		accessTokenSecret:           accessTokenSecret,
-		accessTokenValidaityInHours: accessTokenValidaityInHours,
-		collection:                  collection,
+		accessTokenSecret:   accessTokenSecret,
+		accessTokenValidity: accessTokenValidity,
+		collection:          collection,
+		clock:               clock.OrSystem(clk),
 	}
 
 	return tm
@@ -54,13 +60,15 @@ func InitTokenExpiryIndex(ctx context.Context, collection *mongo.Collection) err
 	return nil
 }
 
-func (tm *TokenManager) GenerateToken(userId string) (*TokenDetails, error) {
-	td := &TokenDetails{}
-	td.AtExpires = time.Now().Add(time.Hour * time.Duration(tm.accessTokenValidaityInHours)).Unix()
+// GenerateToken issues an access token for userId. mfa records whether the
+// user completed a second factor for this session.
+func (tm *TokenManager) GenerateToken(userId string, mfa bool) (*TokenDetails, error) {
+	td := &TokenDetails{Mfa: mfa}
+	td.AtExpires = tm.clock.Now().Add(tm.accessTokenValidity).Unix()
 	td.AcessUuid = uuid.New().String()
 
 	var err error
-	td.AccessToken, err = createToken(userId, td.AcessUuid, td.AtExpires, tm.accessTokenSecret)
+	td.AccessToken, err = createToken(userId, td.AcessUuid, td.AtExpires, mfa, tm.accessTokenSecret)
 	if err != nil {
 		return nil, err
 	}
@@ -70,12 +78,13 @@ func (tm *TokenManager) GenerateToken(userId string) (*TokenDetails, error) {
 	return td, nil
 }
 
-func createToken(userId string, uuid string, expires int64, secret string) (string, error) {
+func createToken(userId string, uuid string, expires int64, mfa bool, secret string) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = userId
 	claims["access_uuid"] = uuid
 	claims["exp"] = expires
+	claims["mfa"] = mfa
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return at.SignedString([]byte(secret))
 }
@@ -84,11 +93,18 @@ func (tm *TokenManager) SaveToken(ctx context.Context, userId string, td *TokenD
 	_, err := tm.collection.InsertOne(ctx, &AccessDetails{
 		AccessUuid: td.AcessUuid,
 		UserId:     userId,
+		Mfa:        td.Mfa,
 		ExpiresAt:  time.Unix(td.AtExpires, 0),
 	})
 	return err
 }
 
+// CountActiveTokens returns the number of stored access tokens that have not
+// expired yet.
+func (tm *TokenManager) CountActiveTokens(ctx context.Context) (int64, error) {
+	return tm.collection.CountDocuments(ctx, bson.M{"expires_at": bson.M{"$gt": tm.clock.Now()}})
+}
+
 func (tm *TokenManager) DeleteToken(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) error {
 	_, err := tm.collection.DeleteOne(ctx, filter, opts...)
 	return err
@@ -113,13 +129,15 @@ func (tm *TokenManager) FindToken(ctx context.Context, filter interface{}, opts
 	return &accessDetails, nil
 }
 
-func ValidateToken(token string, secret string) (*jwt.Token, error) {
+// ValidateToken parses token and checks its signature and expiry against
+// now.
+func ValidateToken(token string, secret string, now func() time.Time) (*jwt.Token, error) {
 	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
 		return []byte(secret), nil
-	})
+	}, jwt.WithTimeFunc(now))
 }
 
 func (tm *TokenManager) ExtractTokenMetadata(token *jwt.Token) (*AccessDetails, error) {
@@ -135,8 +153,10 @@ func (tm *TokenManager) ExtractTokenMetadata(token *jwt.Token) (*AccessDetails,
 	if !ok || userId == "" {
 		return nil, errors.New("unauthorized")
 	}
+	mfa, _ := claims["mfa"].(bool)
 	return &AccessDetails{
 		AccessUuid: accessUuid,
 		UserId:     userId,
+		Mfa:        mfa,
 	}, nil
 }
@@ -0,0 +1,174 @@
+package user
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists eight digit codes; these are their last six digits.
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := totpCode(rfc6238Secret, unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	for offset, want := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, err := totpCode(rfc6238Secret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := validateTOTP(rfc6238Secret, code, now)
+		if ok != want || (ok && got != step+offset) {
+			t.Errorf("offset %d: step %d, %v; want %v", offset, got, ok, want)
+		}
+	}
+	if _, ok := validateTOTP(rfc6238Secret, "12345", now); ok {
+		t.Error("a five digit code was accepted")
+	}
+}
+
+// mfaRepo holds one user and applies the conditional updates VerifyMFA
+// makes, the way Mongo would.
+type mfaRepo struct {
+	UserRepository
+	mu   sync.Mutex
+	user User
+}
+
+func (r *mfaRepo) GetUser(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u := r.user
+	totp := *r.user.TOTP
+	totp.RecoveryCodes = append([]string(nil), totp.RecoveryCodes...)
+	u.TOTP = &totp
+	return &u, nil
+}
+
+func (r *mfaRepo) UpdateUser(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, u := filter.(bson.M), update.(bson.M)
+	if lt, ok := f["totp.last_used_step"]; ok && r.user.TOTP.LastUsedStep >= lt.(bson.M)["$lt"].(int64) {
+		return &mongo.UpdateResult{}, nil
+	}
+	if hash, ok := f["totp.recovery_codes"]; ok {
+		i := indexOf(r.user.TOTP.RecoveryCodes, hash.(string))
+		if i < 0 {
+			return &mongo.UpdateResult{}, nil
+		}
+		if u["$pull"] != nil {
+			r.user.TOTP.RecoveryCodes = append(r.user.TOTP.RecoveryCodes[:i:i], r.user.TOTP.RecoveryCodes[i+1:]...)
+		}
+	}
+	if set, ok := u["$set"].(bson.M); ok {
+		if step, ok := set["totp.last_used_step"]; ok {
+			r.user.TOTP.LastUsedStep = step.(int64)
+		}
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func indexOf(s []string, v string) int {
+	for i := range s {
+		if s[i] == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestVerifyMFARejectsReusedCodes(t *testing.T) {
+	ctx := context.Background()
+	step := time.Now().Unix() / totpPeriod
+	recovery := []string{"aaaaa-11111", "bbbbb-22222"}
+	repo := &mfaRepo{user: User{ID: "u1", TOTP: &TOTPConfig{
+		Secret:        rfc6238Secret,
+		Enabled:       true,
+		RecoveryCodes: []string{hashRecoveryCode(recovery[0]), hashRecoveryCode(recovery[1])},
+		LastUsedStep:  step,
+	}}}
+	us := &UserService{repo: repo, clock: clock.System{}}
+
+	// The code that confirmed enrollment has been used up.
+	confirmed, _ := totpCode(rfc6238Secret, step)
+	if err := us.VerifyMFA(ctx, "u1", confirmed); err == nil {
+		t.Error("the code used to confirm enrollment was accepted again")
+	}
+
+	next, _ := totpCode(rfc6238Secret, step+1)
+	if err := us.VerifyMFA(ctx, "u1", next); err != nil {
+		t.Fatalf("fresh code: %v", err)
+	}
+	if err := us.VerifyMFA(ctx, "u1", next); err == nil {
+		t.Error("a totp code was accepted twice")
+	}
+
+	if err := us.VerifyMFA(ctx, "u1", recovery[0]); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := us.VerifyMFA(ctx, "u1", recovery[0]); err == nil {
+		t.Error("a recovery code was accepted twice")
+	}
+	if err := us.VerifyMFA(ctx, "u1", recovery[1]); err != nil {
+		t.Errorf("second recovery code: %v", err)
+	}
+	if err := us.VerifyMFA(ctx, "u1", "00000-00000"); err == nil {
+		t.Error("an unknown recovery code was accepted")
+	}
+}
+
+func TestVerifyMFAAcceptsARecoveryCodeOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := &mfaRepo{user: User{ID: "u1", TOTP: &TOTPConfig{
+		Secret:        rfc6238Secret,
+		Enabled:       true,
+		RecoveryCodes: []string{hashRecoveryCode("aaaaa-11111")},
+	}}}
+	us := &UserService{repo: repo, clock: clock.System{}}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if us.VerifyMFA(ctx, "u1", "aaaaa-11111") == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("recovery code accepted %d times by racing requests, want once", accepted)
+	}
+}
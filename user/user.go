@@ -3,23 +3,59 @@ package user
 import "time"
 
 type User struct {
-	ID         string    `json:"id" bson:"_id,omitempty"`
-	Name       string    `json:"name" bson:"name"`
-	Email      string    `json:"email" bson:"email"`
-	IsVerified bool      `json:"is_verified" bson:"is_verified"`
-	Role       Role      `json:"role" bson:"role"`
-	Picture    string    `json:"picture" bson:"picture"`
-	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+	ID         string         `json:"id" bson:"_id,omitempty"`
+	Name       string         `json:"name" bson:"name"`
+	Email      string         `json:"email" bson:"email"`
+	GoogleId   string         `json:"-" bson:"google_id,omitempty"`
+	IsVerified bool           `json:"is_verified" bson:"is_verified"`
+	Role       Role           `json:"role" bson:"role"`
+	Picture    string         `json:"picture" bson:"picture"`
+	TOTP       *TOTPConfig    `json:"-" bson:"totp,omitempty"`
+	Profile    *AuthorProfile `json:"profile,omitempty" bson:"profile,omitempty"`
+	CreatedAt  time.Time      `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at" bson:"updated_at"`
 }
 
 type Role string
 
 const (
-	Admin  Role = "admin"
-	Reader Role = "user"
+	Admin     Role = "admin"
+	Editor    Role = "editor"
+	Author    Role = "author"
+	Moderator Role = "moderator"
+	Reader    Role = "user"
 )
 
+type AuthorProfile struct {
+	Slug        string            `json:"slug" bson:"slug"`
+	Bio         string            `json:"bio" bson:"bio"`
+	Avatar      string            `json:"avatar" bson:"avatar"`
+	SocialLinks map[string]string `json:"social_links,omitempty" bson:"social_links,omitempty"`
+}
+
+// Byline is the public view of a user who writes posts.
+type Byline struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Slug        string            `json:"slug"`
+	Bio         string            `json:"bio,omitempty"`
+	Avatar      string            `json:"avatar,omitempty"`
+	SocialLinks map[string]string `json:"social_links,omitempty"`
+}
+
+func (u *User) Byline() *Byline {
+	a := &Byline{ID: u.ID, Name: u.Name, Avatar: u.Picture}
+	if u.Profile != nil {
+		a.Slug = u.Profile.Slug
+		a.Bio = u.Profile.Bio
+		a.SocialLinks = u.Profile.SocialLinks
+		if u.Profile.Avatar != "" {
+			a.Avatar = u.Profile.Avatar
+		}
+	}
+	return a
+}
+
 type MailingList struct {
 	Name        string       `json:"name" bson:"name"`
 	Subscribers []Subscriber `json:"subscribers" bson:"subscribers"`
@@ -0,0 +1,267 @@
+// Package web serves the blog as HTML pages rendered through a theme,
+// alongside the JSON API.
+package web
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/site"
+	"github.com/ayo-ajayi/bloggy/theme"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+type FrontendController struct {
+	posts PostServices
+	about AboutServices
+	theme *theme.Theme
+	site  theme.Site
+	links theme.Links
+}
+
+type PostServices interface {
+	GetBlogPosts(ctx context.Context) ([]*blog.BlogPost, error)
+	GetBlogPostBySlug(ctx context.Context, slug string) (*blog.BlogPost, error)
+	SearchBlogPosts(ctx context.Context, query string) ([]*blog.BlogPost, error)
+	GetAuthorBySlug(ctx context.Context, slug string) (*user.Byline, []*blog.BlogPost, error)
+}
+
+type AboutServices interface {
+	GetAboutMe(ctx context.Context) (*user.AboutMe, error)
+}
+
+// NewFrontendController serves pages under root, such as "/site".
+func NewFrontendController(posts PostServices, about AboutServices, th *theme.Theme, s theme.Site, root string) *FrontendController {
+	s.Dynamic = true
+	return &FrontendController{
+		posts: posts,
+		about: about,
+		theme: th,
+		site:  s,
+		links: theme.Links{Root: strings.TrimRight(root, "/") + "/"},
+	}
+}
+
+// render writes page with its theme template. Templates render into a
+// buffer, so a failure can still become an error response.
+func (fc *FrontendController) render(c *gin.Context, status int, page *theme.Page) {
+	page.Links = fc.links
+	page.Site = fc.site
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := fc.theme.Render(c.Writer, page.Kind, page); err != nil {
+		c.Error(apperrors.Internal(err))
+	}
+}
+
+// fail renders the error page for err. Unexpected errors are recorded on
+// the context so they are logged.
+func (fc *FrontendController) fail(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		status = appErr.StatusCode
+	}
+	if status >= http.StatusInternalServerError {
+		c.Error(err)
+	}
+	fc.renderError(c, status)
+}
+
+func (fc *FrontendController) renderError(c *gin.Context, status int) {
+	message := "Something went wrong. Please try again later."
+	if status == http.StatusNotFound {
+		message = "The page you were looking for does not exist."
+	}
+	fc.render(c, status, &theme.Page{
+		Kind:    "error",
+		Title:   http.StatusText(status),
+		Status:  status,
+		Message: message,
+		Robots:  "noindex",
+	})
+}
+
+// NotFound renders the error page for unknown paths under the frontend.
+func (fc *FrontendController) NotFound(c *gin.Context) {
+	fc.renderError(c, http.StatusNotFound)
+}
+
+// pageNumber reads the :n route parameter of paginated listings. Page 1
+// redirects to the listing itself so it has a single URL.
+func (fc *FrontendController) pageNumber(c *gin.Context, base string) (int, bool) {
+	v := c.Param("n")
+	if v == "" {
+		return 1, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		fc.renderError(c, http.StatusNotFound)
+		return 0, false
+	}
+	if n == 1 {
+		c.Redirect(http.StatusMovedPermanently, fc.links.Link(base))
+		return 0, false
+	}
+	return n, true
+}
+
+// listing renders page n of posts at base, or the error page past the last
+// page.
+func (fc *FrontendController) listing(c *gin.Context, base string, posts []*theme.Post, proto theme.Page) {
+	n, ok := fc.pageNumber(c, base)
+	if !ok {
+		return
+	}
+	page, ok := site.Paginate(base, posts, site.DefaultPerPage, n, proto)
+	if !ok {
+		fc.renderError(c, http.StatusNotFound)
+		return
+	}
+	fc.render(c, http.StatusOK, page)
+}
+
+func (fc *FrontendController) allPosts(c *gin.Context) ([]*theme.Post, bool) {
+	posts, err := fc.posts.GetBlogPosts(c)
+	if err != nil {
+		fc.fail(c, err)
+		return nil, false
+	}
+	return site.Views(posts), true
+}
+
+func (fc *FrontendController) Home(c *gin.Context) {
+	posts, ok := fc.allPosts(c)
+	if !ok {
+		return
+	}
+	fc.listing(c, "", posts, theme.Page{Kind: "index", Description: fc.site.Description, Tags: site.CollectTags(posts)})
+}
+
+func (fc *FrontendController) Post(c *gin.Context) {
+	post, err := fc.posts.GetBlogPostBySlug(c, c.Param("slug"))
+	if err != nil {
+		fc.fail(c, err)
+		return
+	}
+	view := theme.NewPost(post)
+	fc.render(c, http.StatusOK, &theme.Page{
+		Kind:        "post",
+		Title:       view.Title,
+		Description: view.Description,
+		Path:        view.Path,
+		Post:        view,
+		Image:       view.CoverImage,
+	})
+}
+
+func (fc *FrontendController) Tag(c *gin.Context) {
+	posts, ok := fc.allPosts(c)
+	if !ok {
+		return
+	}
+	tagSlug := c.Param("tag")
+	for _, t := range site.CollectTags(posts) {
+		if t.Slug == tagSlug {
+			fc.listing(c, t.Path, site.Tagged(posts, t.Slug), site.TagPage(fc.site, t))
+			return
+		}
+	}
+	fc.renderError(c, http.StatusNotFound)
+}
+
+func (fc *FrontendController) Author(c *gin.Context) {
+	author, posts, err := fc.posts.GetAuthorBySlug(c, c.Param("slug"))
+	if err != nil {
+		fc.fail(c, err)
+		return
+	}
+	fc.listing(c, theme.AuthorPath(author.Slug), site.Views(posts), site.AuthorPage(author))
+}
+
+// Search lists posts matching ?q in order of relevance.
+func (fc *FrontendController) Search(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	page := &theme.Page{Kind: "search", Title: "Search", Path: "search/", Query: query, Robots: "noindex"}
+	if query != "" {
+		posts, err := fc.posts.SearchBlogPosts(c, query)
+		if err != nil {
+			fc.fail(c, err)
+			return
+		}
+		page.Title = "Search: " + query
+		page.Posts = make([]*theme.Post, 0, len(posts))
+		for _, p := range posts {
+			page.Posts = append(page.Posts, theme.NewPost(p))
+		}
+	}
+	fc.render(c, http.StatusOK, page)
+}
+
+func (fc *FrontendController) About(c *gin.Context) {
+	about, err := fc.about.GetAboutMe(c)
+	if err != nil {
+		fc.fail(c, err)
+		return
+	}
+	fc.render(c, http.StatusOK, &theme.Page{
+		Kind:  "about",
+		Title: "About",
+		Path:  "about/",
+		About: &theme.About{Content: theme.Content(about.AboutMe), Picture: about.ProfilePicture},
+		Image: about.ProfilePicture,
+	})
+}
+
+// Asset serves a file from the theme's assets directory.
+func (fc *FrontendController) Asset(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("file"), "/")
+	if _, err := fs.Stat(fc.theme.Assets(), name); err != nil {
+		fc.renderError(c, http.StatusNotFound)
+		return
+	}
+	c.FileFromFS(name, http.FS(fc.theme.Assets()))
+}
+
+// feed serves one of the XML documents that need the site's base URL.
+func (fc *FrontendController) feed(c *gin.Context, contentType string, build func(posts []*theme.Post) ([]byte, error)) {
+	if fc.site.BaseURL == "" {
+		fc.renderError(c, http.StatusNotFound)
+		return
+	}
+	posts, ok := fc.allPosts(c)
+	if !ok {
+		return
+	}
+	data, err := build(posts)
+	if err != nil {
+		fc.fail(c, err)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (fc *FrontendController) RSS(c *gin.Context) {
+	fc.feed(c, "application/rss+xml; charset=utf-8", func(posts []*theme.Post) ([]byte, error) {
+		return site.RSS(fc.site, posts)
+	})
+}
+
+func (fc *FrontendController) Atom(c *gin.Context) {
+	fc.feed(c, "application/atom+xml; charset=utf-8", func(posts []*theme.Post) ([]byte, error) {
+		return site.Atom(fc.site, posts)
+	})
+}
+
+func (fc *FrontendController) Sitemap(c *gin.Context) {
+	fc.feed(c, "application/xml; charset=utf-8", func(posts []*theme.Post) ([]byte, error) {
+		return site.Sitemap(fc.site, posts)
+	})
+}
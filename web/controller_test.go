@@ -0,0 +1,185 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/blog"
+	"github.com/ayo-ajayi/bloggy/theme"
+	"github.com/ayo-ajayi/bloggy/user"
+	"github.com/gin-gonic/gin"
+)
+
+type fakePosts struct {
+	posts []*blog.BlogPost
+	err   error
+}
+
+func (f *fakePosts) GetBlogPosts(ctx context.Context) ([]*blog.BlogPost, error) {
+	return f.posts, f.err
+}
+
+func (f *fakePosts) GetBlogPostBySlug(ctx context.Context, slug string) (*blog.BlogPost, error) {
+	for _, p := range f.posts {
+		if p.Slug == slug {
+			return p, nil
+		}
+	}
+	return nil, apperrors.NotFound(apperrors.CodeNotFound, "post not found", nil)
+}
+
+func (f *fakePosts) SearchBlogPosts(ctx context.Context, query string) ([]*blog.BlogPost, error) {
+	var out []*blog.BlogPost
+	for _, p := range f.posts {
+		if strings.Contains(p.Title, query) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakePosts) GetAuthorBySlug(ctx context.Context, slug string) (*user.Byline, []*blog.BlogPost, error) {
+	if slug != "ada" {
+		return nil, nil, apperrors.NotFound(apperrors.CodeNotFound, "author not found", nil)
+	}
+	return &user.Byline{ID: "ada", Name: "Ada", Slug: "ada"}, f.posts, nil
+}
+
+type fakeAbout struct{}
+
+func (fakeAbout) GetAboutMe(ctx context.Context) (*user.AboutMe, error) {
+	return &user.AboutMe{AboutMe: "I write <things>."}, nil
+}
+
+func newFrontend(t *testing.T, posts *fakePosts, baseURL string) http.Handler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	th, err := theme.Load(theme.DefaultName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := NewFrontendController(posts, fakeAbout{}, th, theme.Site{Title: "Bloggy", Description: "Notes", BaseURL: baseURL}, "/site")
+	r := gin.New()
+	html := r.Group("/site")
+	html.GET("/", fc.Home)
+	html.GET("/page/:n/", fc.Home)
+	html.GET("/posts/:slug/", fc.Post)
+	html.GET("/tags/:tag/", fc.Tag)
+	html.GET("/authors/:slug/", fc.Author)
+	html.GET("/search/", fc.Search)
+	html.GET("/about/", fc.About)
+	html.GET("/assets/*file", fc.Asset)
+	html.GET("/feed.xml", fc.RSS)
+	html.GET("/sitemap.xml", fc.Sitemap)
+	r.NoRoute(fc.NotFound)
+	return r
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func samplePosts(n int) *fakePosts {
+	f := &fakePosts{}
+	for i := 1; i <= n; i++ {
+		at := time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC)
+		f.posts = append(f.posts, &blog.BlogPost{
+			Title: "Post " + string(rune('A'+i-1)), Slug: "post-" + string(rune('a'+i-1)),
+			Description: "About " + string(rune('A'+i-1)), Content: "Body", Tags: []string{"Go"},
+			Author: &user.Byline{Name: "Ada", Slug: "ada"}, CreatedAt: at, UpdatedAt: at,
+		})
+	}
+	return f
+}
+
+func TestPostPage(t *testing.T) {
+	h := newFrontend(t, samplePosts(1), "https://blog.example.com")
+	w := get(h, "/site/posts/post-a/")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<title>Post A · Bloggy</title>`,
+		`<meta name="description" content="About A">`,
+		`<link rel="canonical" href="https://blog.example.com/posts/post-a/">`,
+		`<meta property="og:type" content="article">`,
+		`<meta property="article:tag" content="Go">`,
+		`href="/site/tags/go/"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("post page lacks %s", want)
+		}
+	}
+}
+
+func TestMissingPagesRenderTheErrorPage(t *testing.T) {
+	h := newFrontend(t, samplePosts(1), "")
+	for _, path := range []string{"/site/posts/nope/", "/site/tags/nope/", "/site/authors/nope/", "/site/page/5/", "/site/page/x/", "/site/assets/nope.css", "/site/feed.xml", "/site/nowhere"} {
+		w := get(h, path)
+		if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: status %d, type %q; want an HTML 404", path, w.Code, w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(w.Body.String(), `<meta name="robots" content="noindex">`) {
+			t.Errorf("%s: error page is indexable", path)
+		}
+	}
+}
+
+func TestServiceFailuresAre500s(t *testing.T) {
+	h := newFrontend(t, &fakePosts{err: errors.New("db down")}, "")
+	if w := get(h, "/site/"); w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "db down") {
+		t.Errorf("status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPagination(t *testing.T) {
+	h := newFrontend(t, samplePosts(12), "")
+	first := get(h, "/site/").Body.String()
+	if !strings.Contains(first, "Post L") || strings.Contains(first, "Post B") || !strings.Contains(first, `/site/page/2/`) {
+		t.Error("the home page should list the newest posts and link to page 2")
+	}
+	second := get(h, "/site/page/2/")
+	if second.Code != http.StatusOK || !strings.Contains(second.Body.String(), "Post A") {
+		t.Errorf("page 2: status %d", second.Code)
+	}
+	if w := get(h, "/site/page/1/"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/site/" {
+		t.Errorf("page 1: status %d, location %q; want a redirect to /site/", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestSearchAboutAndAuthor(t *testing.T) {
+	h := newFrontend(t, samplePosts(2), "")
+	search := get(h, "/site/search/?q=Post+B").Body.String()
+	if !strings.Contains(search, "Post B") || strings.Contains(search, ">Post A<") || !strings.Contains(search, `content="noindex"`) {
+		t.Error("search should list matches only and not be indexed")
+	}
+	if about := get(h, "/site/about/").Body.String(); !strings.Contains(about, "I write &lt;things&gt;.") {
+		t.Error("about text should be escaped")
+	}
+	if w := get(h, "/site/authors/ada/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Ada") {
+		t.Errorf("author page: status %d", w.Code)
+	}
+}
+
+func TestAssetsAndFeeds(t *testing.T) {
+	h := newFrontend(t, samplePosts(1), "https://blog.example.com")
+	if w := get(h, "/site/assets/style.css"); w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("asset: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	w := get(h, "/site/feed.xml")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" || !strings.Contains(w.Body.String(), "Post A") {
+		t.Errorf("feed: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := get(h, "/site/sitemap.xml"); !strings.Contains(w.Body.String(), "https://blog.example.com/posts/post-a/") {
+		t.Errorf("sitemap = %s", w.Body.String())
+	}
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookController struct {
+	service WebhookServices
+}
+
+type WebhookServices interface {
+	Events() []string
+	CreateSubscription(ctx context.Context, sub *Subscription) error
+	ListSubscriptions(ctx context.Context) ([]*Subscription, error)
+	GetSubscription(ctx context.Context, idStr string) (*Subscription, error)
+	UpdateSubscription(ctx context.Context, idStr string, update *Subscription) (*Subscription, error)
+	DeleteSubscription(ctx context.Context, idStr string) error
+	ListDeliveries(ctx context.Context, idStr string, status Status) ([]*Delivery, error)
+	Redeliver(ctx context.Context, idStr, deliveryIdStr string) (*Delivery, error)
+	Ping(ctx context.Context, idStr string) (*Delivery, error)
+}
+
+func NewWebhookController(service WebhookServices) *WebhookController {
+	return &WebhookController{service}
+}
+
+type subscriptionRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Events      []string `json:"events" binding:"required,min=1"`
+	Secret      string   `json:"secret"`
+	Description string   `json:"description"`
+	Active      *bool    `json:"active"`
+}
+
+func (req *subscriptionRequest) subscription() *Subscription {
+	return &Subscription{
+		URL:         req.URL,
+		Events:      req.Events,
+		Secret:      req.Secret,
+		Description: req.Description,
+		Active:      req.Active == nil || *req.Active,
+	}
+}
+
+// Create adds a subscription. The secret deliveries are signed with is
+// generated unless one is given, and only shown in this response.
+func (wc *WebhookController) Create(c *gin.Context) {
+	var req subscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	sub := req.subscription()
+	if err := wc.service.CreateSubscription(c, sub); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"secret": sub.Secret, "webhook": sub}, "message": "Webhook created. Copy the secret now, it will not be shown again"})
+}
+
+func (wc *WebhookController) List(c *gin.Context) {
+	subs, err := wc.service.ListSubscriptions(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": subs, "events": wc.service.Events()})
+}
+
+func (wc *WebhookController) Get(c *gin.Context) {
+	sub, err := wc.service.GetSubscription(c, c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sub})
+}
+
+// Update replaces a subscription. Leaving out the secret keeps the current
+// one; leaving out active turns the subscription on.
+func (wc *WebhookController) Update(c *gin.Context) {
+	var req subscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperrors.BadRequest(apperrors.CodeValidation, "invalid request body", err))
+		return
+	}
+	sub, err := wc.service.UpdateSubscription(c, c.Param("id"), req.subscription())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sub, "message": "Webhook updated successfully"})
+}
+
+func (wc *WebhookController) Delete(c *gin.Context) {
+	if err := wc.service.DeleteSubscription(c, c.Param("id")); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// Deliveries lists the latest deliveries to a subscription; ?status= keeps
+// those that are pending, succeeded or failed.
+func (wc *WebhookController) Deliveries(c *gin.Context) {
+	deliveries, err := wc.service.ListDeliveries(c, c.Param("id"), Status(c.Query("status")))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": deliveries})
+}
+
+// Redeliver sends a delivery again and returns it with the new attempt.
+func (wc *WebhookController) Redeliver(c *gin.Context) {
+	delivery, err := wc.service.Redeliver(c, c.Param("id"), c.Param("deliveryId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": delivery})
+}
+
+// Ping sends a ping event and returns the delivery, whose attempt tells
+// whether the endpoint answered.
+func (wc *WebhookController) Ping(c *gin.Context) {
+	delivery, err := wc.service.Ping(c, c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": delivery})
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned for deliveries to an address that is not on
+// the public internet and not in the allowed networks.
+var ErrBlockedAddress = errors.New("destination address is not allowed")
+
+// reserved are ranges that are not public but that the net.IP predicates do
+// not cover.
+var reserved = mustParseNetworks("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96")
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// ParseNetworks parses IPs and CIDR ranges.
+func ParseNetworks(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR range", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// public reports whether ip is routable on the public internet.
+func public(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !contains(reserved, ip)
+}
+
+// newTransport returns a transport that refuses to connect to loopback,
+// private, link-local and other non-public addresses unless allowed holds
+// them. The check runs on the address actually dialled, after DNS, so a
+// hostname that resolves to an internal address is caught too.
+func newTransport(allowed []*net.IPNet) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || (!public(ip) && !contains(allowed, ip)) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialled instead of the destination, so none is used.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBackoff caps the wait between two retries.
+const maxBackoff = 12 * time.Hour
+
+// Options tune delivery. Failed deliveries are retried after RetryBackoff,
+// doubling each time, until MaxAttempts attempts have been made.
+type Options struct {
+	Timeout      time.Duration
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	PollInterval time.Duration
+	// AllowedNetworks are non-public ranges deliveries may still reach,
+	// such as an internal service. Other non-public addresses are refused.
+	AllowedNetworks []*net.IPNet
+}
+
+// Dispatcher sends deliveries and retries the failed ones. Its state lives
+// in the repository, so any number of replicas can run one: a delivery is
+// claimed before it is sent and only one of them gets it.
+type Dispatcher struct {
+	repo    WebhookRepository
+	client  *http.Client
+	options Options
+	logger  *logger.Logger
+	clock   clock.Clock
+}
+
+// NewDispatcher returns a Dispatcher over repo. l may be nil.
+func NewDispatcher(repo WebhookRepository, opts Options, l *logger.Logger, clk clock.Clock) *Dispatcher {
+	if l == nil {
+		l = &logger.Logger{Logger: slog.Default()}
+	}
+	client := &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: newTransport(opts.AllowedNetworks),
+		// A redirect is reported as the response rather than followed, so
+		// a subscription only ever reaches the URL an admin entered.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return &Dispatcher{repo: repo, client: client, options: opts, logger: l, clock: clock.OrSystem(clk)}
+}
+
+// Run retries due deliveries every PollInterval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.RetryDue(ctx); err != nil {
+				d.logger.Error("webhook retries failed", "error", err)
+			}
+		}
+	}
+}
+
+// RetryDue sends the pending deliveries whose next attempt is due.
+func (d *Dispatcher) RetryDue(ctx context.Context) error {
+	due, err := d.repo.GetDeliveries(ctx,
+		bson.M{"status": StatusPending, "next_attempt_at": bson.M{"$lte": d.clock.Now()}},
+		options.Find().SetSort(bson.M{"next_attempt_at": 1}).SetLimit(100))
+	if err != nil {
+		return err
+	}
+	for _, delivery := range due {
+		d.Deliver(ctx, delivery)
+	}
+	return nil
+}
+
+// Deliver claims a pending delivery and makes its next attempt. Failures
+// are recorded on the delivery and logged.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery *Delivery) {
+	now := d.clock.Now()
+	// Pushing the next attempt past the timeout claims the delivery; should
+	// this replica die, the delivery is picked up again once it passes.
+	res, err := d.repo.UpdateDelivery(ctx,
+		bson.M{"_id": delivery.Id, "status": StatusPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(2 * d.options.Timeout)}})
+	if err != nil {
+		d.logger.Error("failed to claim webhook delivery", "delivery", delivery.Id.Hex(), "error", err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		return
+	}
+	var attempt Attempt
+	retry := false
+	sub, err := d.repo.GetSubscription(ctx, bson.M{"_id": delivery.SubscriptionId})
+	switch {
+	case err != nil:
+		attempt = Attempt{At: now, Error: "subscription not found"}
+	case !sub.Active:
+		attempt = Attempt{At: now, Error: "subscription is inactive"}
+	default:
+		attempt, retry = d.send(ctx, sub, delivery), true
+	}
+	if _, err := d.record(ctx, delivery, attempt, retry); err != nil {
+		d.logger.Error("failed to record webhook attempt", "delivery", delivery.Id.Hex(), "error", err)
+	}
+}
+
+// sendNow makes an attempt an admin asked for, without claiming the
+// delivery, and returns the delivery updated.
+func (d *Dispatcher) sendNow(ctx context.Context, sub *Subscription, delivery *Delivery, retry bool) (*Delivery, error) {
+	attempt := d.send(ctx, sub, delivery)
+	attempt.Manual = true
+	return d.record(ctx, delivery, attempt, retry)
+}
+
+// send posts the delivery's payload to sub and reports how it went.
+func (d *Dispatcher) send(ctx context.Context, sub *Subscription, delivery *Delivery) Attempt {
+	start := d.clock.Now()
+	attempt := Attempt{At: start}
+	ctx, cancel := context.WithTimeout(ctx, d.options.Timeout)
+	defer cancel()
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bloggy-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.Id.Hex())
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, start, body))
+	resp, err := d.client.Do(req)
+	attempt.DurationMs = d.clock.Now().Sub(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	attempt.ResponseStatus = resp.StatusCode
+	return attempt
+}
+
+// record saves attempt and moves the delivery on: a success completes it,
+// and a failure schedules the next retry when retry is set and attempts
+// remain, or fails it otherwise.
+func (d *Dispatcher) record(ctx context.Context, delivery *Delivery, attempt Attempt, retry bool) (*Delivery, error) {
+	now := d.clock.Now()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.UpdatedAt = now
+	delivery.NextAttemptAt = nil
+	switch n := len(delivery.Attempts); {
+	case attempt.succeeded():
+		delivery.Status = StatusSucceeded
+	case retry && n < d.options.MaxAttempts:
+		next := now.Add(d.backoff(n))
+		delivery.Status = StatusPending
+		delivery.NextAttemptAt = &next
+	default:
+		delivery.Status = StatusFailed
+	}
+	set := bson.M{"status": delivery.Status, "updated_at": now}
+	update := bson.M{"$push": bson.M{"attempts": attempt}, "$set": set}
+	if delivery.NextAttemptAt != nil {
+		set["next_attempt_at"] = *delivery.NextAttemptAt
+	} else {
+		update["$unset"] = bson.M{"next_attempt_at": ""}
+	}
+	if _, err := d.repo.UpdateDelivery(ctx, bson.M{"_id": delivery.Id}, update); err != nil {
+		return nil, err
+	}
+	if !attempt.succeeded() {
+		d.logger.Warn("webhook delivery failed", "delivery", delivery.Id.Hex(), "event", delivery.Event,
+			"status", attempt.ResponseStatus, "error", attempt.Error, "next_attempt_at", delivery.NextAttemptAt)
+	}
+	return delivery, nil
+}
+
+// backoff is the wait after the nth failed attempt.
+func (d *Dispatcher) backoff(n int) time.Duration {
+	wait := d.options.RetryBackoff
+	for i := 1; i < n && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/db"
+)
+
+func newTestService(t *testing.T, allowed ...string) (*WebhookService, *clock.Manual) {
+	t.Helper()
+	nets, err := ParseNetworks(allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewManual(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	repo := NewWebhookRepo(db.NewMemoryCollection(), db.NewMemoryCollection())
+	dispatcher := NewDispatcher(repo, Options{Timeout: 5 * time.Second, MaxAttempts: 3, RetryBackoff: time.Minute, PollInterval: time.Minute, AllowedNetworks: nets}, nil, clk)
+	return NewWebhookService(repo, dispatcher, []string{"post.created"}, nil, clk), clk
+}
+
+func ping(t *testing.T, s *WebhookService, url string) Attempt {
+	t.Helper()
+	sub := &Subscription{URL: url, Events: []string{AllEvents}, Active: true}
+	if err := s.CreateSubscription(context.Background(), sub); err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := s.Ping(context.Background(), sub.Id.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery.Attempts[len(delivery.Attempts)-1]
+}
+
+func TestPingRefusesPrivateAddresses(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer srv.Close()
+
+	s, _ := newTestService(t)
+	attempt := ping(t, s, srv.URL)
+	if hit || attempt.ResponseStatus != 0 || !strings.Contains(attempt.Error, ErrBlockedAddress.Error()) {
+		t.Errorf("attempt = %+v, hit = %v; want the loopback server refused", attempt, hit)
+	}
+}
+
+func TestPingReachesAllowedNetworks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, _ := newTestService(t, "127.0.0.0/8", "::1")
+	attempt := ping(t, s, srv.URL)
+	if attempt.ResponseStatus != http.StatusNoContent || attempt.Error != "" {
+		t.Errorf("attempt = %+v, want a 204 from the allowed server", attempt)
+	}
+}
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := public(net.ParseIP(addr)); got != want {
+			t.Errorf("public(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"post.created"}`)
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000."))
+	mac.Write(body)
+	want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+	if got := Sign("whsec_test", at, body); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	for name, got := range map[string]string{
+		"secret": Sign("whsec_other", at, body),
+		"time":   Sign("whsec_test", at.Add(time.Second), body),
+		"body":   Sign("whsec_test", at, []byte(`{"type":"post.deleted"}`)),
+	} {
+		if got == want {
+			t.Errorf("changing the %s kept the signature", name)
+		}
+	}
+}
+
+// receiver is a webhook endpoint answering with statuses in turn.
+type receiver struct {
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, string(body))
+	w.WriteHeader(rc.statuses[min(len(rc.requests), len(rc.statuses))-1])
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, clk := newTestService(t, "127.0.0.0/8", "::1")
+	sub := &Subscription{URL: srv.URL, Events: []string{"post.created"}, Secret: "whsec_test", Active: true}
+	if err := s.CreateSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := s.queue(ctx, sub, "evt1", "post.created", map[string]string{"title": "Cats"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries := func() *Delivery {
+		t.Helper()
+		got, err := s.ListDeliveries(ctx, sub.Id.Hex(), "")
+		if err != nil || len(got) != 1 {
+			t.Fatalf("deliveries = %v, %v", got, err)
+		}
+		return got[0]
+	}
+
+	s.dispatcher.Deliver(ctx, delivery)
+	d := deliveries()
+	if d.Status != StatusPending || len(d.Attempts) != 1 || d.Attempts[0].ResponseStatus != http.StatusInternalServerError ||
+		d.NextAttemptAt == nil || !d.NextAttemptAt.Equal(clk.Now().Add(time.Minute)) {
+		t.Fatalf("after a 500: %+v", d)
+	}
+	req := rc.requests[0]
+	if req.Header.Get(EventHeader) != "post.created" || req.Header.Get(DeliveryHeader) != delivery.Id.Hex() ||
+		req.Header.Get(SignatureHeader) != Sign("whsec_test", clk.Now(), []byte(rc.bodies[0])) || rc.bodies[0] != delivery.Payload {
+		t.Errorf("request headers %v, body %s", req.Header, rc.bodies[0])
+	}
+
+	// Nothing is sent before the retry is due, and the wait then doubles.
+	clk.Advance(30 * time.Second)
+	if err := s.dispatcher.RetryDue(ctx); err != nil || len(rc.requests) != 1 {
+		t.Fatalf("early retry: %v, %d requests", err, len(rc.requests))
+	}
+	clk.Advance(30 * time.Second)
+	s.dispatcher.RetryDue(ctx)
+	if d := deliveries(); d.Status != StatusPending || len(d.Attempts) != 2 || !d.NextAttemptAt.Equal(clk.Now().Add(2*time.Minute)) {
+		t.Fatalf("after a 502: %+v", d)
+	}
+	clk.Advance(2 * time.Minute)
+	s.dispatcher.RetryDue(ctx)
+	if d := deliveries(); d.Status != StatusSucceeded || len(d.Attempts) != 3 || d.NextAttemptAt != nil {
+		t.Fatalf("after a 200: %+v", d)
+	}
+	if rc.bodies[2] != rc.bodies[0] {
+		t.Error("the retry sent a different payload")
+	}
+}
+
+func TestDeliveryGivesUp(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, clk := newTestService(t, "127.0.0.0/8", "::1")
+	sub := &Subscription{URL: srv.URL, Events: []string{AllEvents}, Active: true}
+	if err := s.CreateSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := s.queue(ctx, sub, "evt1", "post.created", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dispatcher.Deliver(ctx, delivery)
+	for i := 0; i < 5; i++ {
+		clk.Advance(time.Hour)
+		s.dispatcher.RetryDue(ctx)
+	}
+	got, err := s.ListDeliveries(ctx, sub.Id.Hex(), StatusFailed)
+	if err != nil || len(got) != 1 || len(got[0].Attempts) != 3 || len(rc.requests) != 3 {
+		t.Errorf("failed deliveries = %+v, %v after %d requests; want one with 3 attempts", got, err, len(rc.requests))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{options: Options{RetryBackoff: time.Minute}}
+	for n, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 20: maxBackoff} {
+		if got := d.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package webhook
+
+const (
+	CodeWebhookNotFound  = "webhook_not_found"
+	CodeDeliveryNotFound = "delivery_not_found"
+	CodeUnknownEvent     = "unknown_event"
+	CodeInvalidURL       = "invalid_webhook_url"
+)
@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ayo-ajayi/bloggy/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, s *Subscription) (*mongo.InsertOneResult, error)
+	GetSubscription(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*Subscription, error)
+	GetSubscriptions(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Subscription, error)
+	UpdateSubscription(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteSubscription(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CreateDelivery(ctx context.Context, d *Delivery) (*mongo.InsertOneResult, error)
+	GetDelivery(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*Delivery, error)
+	GetDeliveries(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Delivery, error)
+	UpdateDelivery(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteDeliveries(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+}
+
+type WebhookRepo struct {
+	subscriptionCollection db.Collection
+	deliveryCollection     db.Collection
+}
+
+func NewWebhookRepo(subscriptionCollection, deliveryCollection db.Collection) *WebhookRepo {
+	return &WebhookRepo{subscriptionCollection, deliveryCollection}
+}
+
+// InitDeliveryIndexes indexes deliveries by subscription, for the delivery
+// log, and pending ones by when they are due, for retries.
+func InitDeliveryIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("delivery_subscription_index")},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}, Options: options.Index().SetName("delivery_due_index")},
+	})
+	if err != nil {
+		return errors.New("Error creating indexes for webhook deliveries collection: " + err.Error())
+	}
+	return nil
+}
+
+func (repo *WebhookRepo) CreateSubscription(ctx context.Context, s *Subscription) (*mongo.InsertOneResult, error) {
+	return repo.subscriptionCollection.InsertOne(ctx, s)
+}
+
+func (repo *WebhookRepo) GetSubscription(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*Subscription, error) {
+	var s Subscription
+	if err := repo.subscriptionCollection.FindOne(ctx, filter, opts...).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (repo *WebhookRepo) GetSubscriptions(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Subscription, error) {
+	cur, err := repo.subscriptionCollection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	list := []*Subscription{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (repo *WebhookRepo) UpdateSubscription(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return repo.subscriptionCollection.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (repo *WebhookRepo) DeleteSubscription(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return repo.subscriptionCollection.DeleteOne(ctx, filter, opts...)
+}
+
+func (repo *WebhookRepo) CreateDelivery(ctx context.Context, d *Delivery) (*mongo.InsertOneResult, error) {
+	return repo.deliveryCollection.InsertOne(ctx, d)
+}
+
+func (repo *WebhookRepo) GetDelivery(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*Delivery, error) {
+	var d Delivery
+	if err := repo.deliveryCollection.FindOne(ctx, filter, opts...).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (repo *WebhookRepo) GetDeliveries(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*Delivery, error) {
+	cur, err := repo.deliveryCollection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	list := []*Delivery{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (repo *WebhookRepo) UpdateDelivery(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return repo.deliveryCollection.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (repo *WebhookRepo) DeleteDeliveries(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return repo.deliveryCollection.DeleteMany(ctx, filter, opts...)
+}
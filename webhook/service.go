@@ -0,0 +1,291 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ayo-ajayi/bloggy/apperrors"
+	"github.com/ayo-ajayi/bloggy/clock"
+	"github.com/ayo-ajayi/bloggy/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxDeliveries is how many deliveries the delivery log shows at once.
+const MaxDeliveries = 100
+
+const secretPrefix = "whsec_"
+
+type WebhookService struct {
+	repo       WebhookRepository
+	dispatcher *Dispatcher
+	events     []string
+	logger     *logger.Logger
+	clock      clock.Clock
+}
+
+// NewWebhookService returns a service over repo that sends deliveries
+// through dispatcher. events are the event types subscriptions may ask
+// for. l may be nil.
+func NewWebhookService(repo WebhookRepository, dispatcher *Dispatcher, events []string, l *logger.Logger, clk clock.Clock) *WebhookService {
+	if l == nil {
+		l = &logger.Logger{Logger: slog.Default()}
+	}
+	events = append([]string(nil), events...)
+	sort.Strings(events)
+	return &WebhookService{repo: repo, dispatcher: dispatcher, events: events, logger: l, clock: clock.OrSystem(clk)}
+}
+
+// Events lists the event types subscriptions may ask for.
+func (s *WebhookService) Events() []string {
+	return s.events
+}
+
+func (s *WebhookService) validate(sub *Subscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return apperrors.BadRequest(CodeInvalidURL, "url must be an absolute http or https URL", err)
+	}
+	seen := map[string]bool{}
+	events := sub.Events[:0]
+	for _, e := range sub.Events {
+		e = strings.TrimSpace(e)
+		if i := sort.SearchStrings(s.events, e); e != AllEvents && (i == len(s.events) || s.events[i] != e) {
+			return apperrors.BadRequest(CodeUnknownEvent, "unknown event "+e+"; expected * or one of "+strings.Join(s.events, ", "), nil)
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		return apperrors.BadRequest(apperrors.CodeValidation, "events must not be empty", nil)
+	}
+	sub.Events = events
+	return nil
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateSubscription stores sub, generating its secret when it has none.
+func (s *WebhookService) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	if err := s.validate(sub); err != nil {
+		return err
+	}
+	if sub.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return apperrors.Internal(err)
+		}
+		sub.Secret = secret
+	}
+	sub.CreatedAt = s.clock.Now()
+	sub.UpdatedAt = sub.CreatedAt
+	res, err := s.repo.CreateSubscription(ctx, sub)
+	if err != nil {
+		return apperrors.Internal(err)
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		sub.Id = id
+	}
+	return nil
+}
+
+func (s *WebhookService) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	subs, err := s.repo.GetSubscriptions(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return subs, nil
+}
+
+func (s *WebhookService) GetSubscription(ctx context.Context, idStr string) (*Subscription, error) {
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return nil, apperrors.BadRequest(apperrors.CodeInvalidID, "invalid webhook id", err)
+	}
+	sub, err := s.repo.GetSubscription(ctx, bson.M{"_id": id})
+	if err == mongo.ErrNoDocuments {
+		return nil, apperrors.NotFound(CodeWebhookNotFound, "webhook not found", err)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return sub, nil
+}
+
+// UpdateSubscription replaces the URL, events, description and active flag
+// of a subscription. Its secret is only replaced when update carries one.
+func (s *WebhookService) UpdateSubscription(ctx context.Context, idStr string, update *Subscription) (*Subscription, error) {
+	sub, err := s.GetSubscription(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validate(update); err != nil {
+		return nil, err
+	}
+	sub.URL = update.URL
+	sub.Events = update.Events
+	sub.Description = update.Description
+	sub.Active = update.Active
+	if update.Secret != "" {
+		sub.Secret = update.Secret
+	}
+	sub.UpdatedAt = s.clock.Now()
+	if _, err := s.repo.UpdateSubscription(ctx, bson.M{"_id": sub.Id}, bson.M{"$set": sub}); err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return sub, nil
+}
+
+// DeleteSubscription removes a subscription and its delivery log.
+func (s *WebhookService) DeleteSubscription(ctx context.Context, idStr string) error {
+	sub, err := s.GetSubscription(ctx, idStr)
+	if err != nil {
+		return err
+	}
+	if _, err := s.repo.DeleteSubscription(ctx, bson.M{"_id": sub.Id}); err != nil {
+		return apperrors.Internal(err)
+	}
+	if _, err := s.repo.DeleteDeliveries(ctx, bson.M{"subscription_id": sub.Id}); err != nil {
+		return apperrors.Internal(err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries to a subscription, newest
+// first, optionally only those with status.
+func (s *WebhookService) ListDeliveries(ctx context.Context, idStr string, status Status) ([]*Delivery, error) {
+	sub, err := s.GetSubscription(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"subscription_id": sub.Id}
+	switch status {
+	case "":
+	case StatusPending, StatusSucceeded, StatusFailed:
+		filter["status"] = status
+	default:
+		return nil, apperrors.BadRequest(apperrors.CodeValidation, "status must be pending, succeeded or failed", nil)
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(MaxDeliveries)
+	deliveries, err := s.repo.GetDeliveries(ctx, filter, opts)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return deliveries, nil
+}
+
+// Redeliver sends a delivery again right away, whatever its status. A
+// pending delivery keeps being retried if this attempt fails too.
+func (s *WebhookService) Redeliver(ctx context.Context, idStr, deliveryIdStr string) (*Delivery, error) {
+	sub, err := s.GetSubscription(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	deliveryId, err := primitive.ObjectIDFromHex(deliveryIdStr)
+	if err != nil {
+		return nil, apperrors.BadRequest(apperrors.CodeInvalidID, "invalid delivery id", err)
+	}
+	delivery, err := s.repo.GetDelivery(ctx, bson.M{"_id": deliveryId, "subscription_id": sub.Id})
+	if err == mongo.ErrNoDocuments {
+		return nil, apperrors.NotFound(CodeDeliveryNotFound, "delivery not found", err)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	delivery, err = s.dispatcher.sendNow(ctx, sub, delivery, delivery.Status == StatusPending)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return delivery, nil
+}
+
+// Ping sends a ping event to a subscription, active or not, and returns
+// the delivery. Pings are not retried.
+func (s *WebhookService) Ping(ctx context.Context, idStr string) (*Delivery, error) {
+	sub, err := s.GetSubscription(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{"subscription_id": sub.Id.Hex()}
+	delivery, err := s.queue(ctx, sub, primitive.NewObjectID().Hex(), EventPing, data, false)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	delivery, err = s.dispatcher.sendNow(ctx, sub, delivery, false)
+	if err != nil {
+		return nil, apperrors.Internal(err)
+	}
+	return delivery, nil
+}
+
+// Notify queues event for every active subscription that wants it and
+// starts sending it in the background. Failures are logged rather than
+// returned, so that they never fail the change that caused the event.
+func (s *WebhookService) Notify(ctx context.Context, event string, data interface{}) {
+	subs, err := s.repo.GetSubscriptions(ctx, bson.M{"active": true})
+	if err != nil {
+		s.logger.Error("failed to load webhook subscriptions", "event", event, "error", err)
+		return
+	}
+	eventId := primitive.NewObjectID().Hex()
+	for _, sub := range subs {
+		if !sub.wants(event) {
+			continue
+		}
+		delivery, err := s.queue(ctx, sub, eventId, event, data, true)
+		if err != nil {
+			s.logger.Error("failed to queue webhook delivery", "event", event, "webhook", sub.Id.Hex(), "error", err)
+			continue
+		}
+		go s.dispatcher.Deliver(context.Background(), delivery)
+	}
+}
+
+// queue stores a pending delivery of event to sub. Due deliveries are
+// picked up by the dispatcher; others are only sent when asked to.
+func (s *WebhookService) queue(ctx context.Context, sub *Subscription, eventId, event string, data interface{}, due bool) (*Delivery, error) {
+	now := s.clock.Now()
+	payload, err := json.Marshal(Event{Id: eventId, Type: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	delivery := &Delivery{
+		SubscriptionId: sub.Id,
+		EventId:        eventId,
+		Event:          event,
+		Payload:        string(payload),
+		Status:         StatusPending,
+		Attempts:       []Attempt{},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if due {
+		delivery.NextAttemptAt = &now
+	}
+	res, err := s.repo.CreateDelivery(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		delivery.Id = id
+	}
+	return delivery, nil
+}
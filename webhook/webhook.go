@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// EventPing is sent by Ping to check that an endpoint answers.
+	EventPing = "ping"
+	// AllEvents subscribes to every event.
+	AllEvents = "*"
+)
+
+// Headers sent with every delivery. The signature header carries
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of t.body>"; see Sign.
+const (
+	EventHeader     = "X-Bloggy-Event"
+	DeliveryHeader  = "X-Bloggy-Delivery"
+	SignatureHeader = "X-Bloggy-Signature"
+)
+
+type Subscription struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL         string             `json:"url" bson:"url"`
+	Events      []string           `json:"events" bson:"events"`
+	Secret      string             `json:"-" bson:"secret"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Active      bool               `json:"active" bson:"active"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+func (s *Subscription) wants(event string) bool {
+	for _, e := range s.Events {
+		if e == event || e == AllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Delivery is one event sent to one subscription, with every attempt made
+// to send it. Payload is kept as sent so that redeliveries are identical.
+type Delivery struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionId primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	EventId        string             `json:"event_id" bson:"event_id"`
+	Event          string             `json:"event" bson:"event"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         Status             `json:"status" bson:"status"`
+	Attempts       []Attempt          `json:"attempts" bson:"attempts"`
+	// NextAttemptAt is when a pending delivery is next tried.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+type Attempt struct {
+	At             time.Time `json:"at" bson:"at"`
+	ResponseStatus int       `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs     int64     `json:"duration_ms" bson:"duration_ms"`
+	// Manual is set on attempts an admin asked for.
+	Manual bool `json:"manual,omitempty" bson:"manual,omitempty"`
+}
+
+func (a Attempt) succeeded() bool {
+	return a.Error == "" && a.ResponseStatus >= 200 && a.ResponseStatus < 300
+}
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Sign returns the signature header value for body sent at t. Receivers
+// recompute it with their copy of the secret and should reject deliveries
+// whose timestamp is too old.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}